@@ -0,0 +1,210 @@
+// Package tslog provides duplicate-message suppression.
+// This file contains a Logger wrapper that collapses identical consecutive
+// entries into a single summary entry, in the manner of syslog's
+// "last message repeated N times".
+package tslog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Field keys added to the summary entry written for suppressed duplicates.
+const (
+	// RepeatCountKey holds the number of duplicates that were suppressed
+	RepeatCountKey = "repeat_count"
+	// FirstTimestampKey holds the time of the first occurrence of the entry
+	FirstTimestampKey = "first_timestamp"
+	// LastTimestampKey holds the time of the last suppressed duplicate
+	LastTimestampKey = "last_timestamp"
+)
+
+// dedupLogger wraps a Logger and suppresses identical consecutive entries.
+//
+// The first occurrence of an entry is written immediately. Identical entries
+// (same level, message and fields) that follow it within the window are
+// counted instead of written. When the window closes or a different entry
+// arrives, a single summary entry carrying the repeat count and the first
+// and last timestamps is written.
+type dedupLogger struct {
+	funcLogger
+
+	next       Logger
+	window     time.Duration
+	now        func() time.Time
+	caller     bool // Whether to record the caller of first occurrences
+	callerSkip int
+
+	mutex   sync.Mutex
+	pending *dedupEntry // Most recently written entry, nil if none
+	closed  bool
+}
+
+// dedupEntry tracks the most recently written entry and its duplicates.
+type dedupEntry struct {
+	key    string
+	lvl    Level
+	msg    string
+	fields T
+	caller Caller // Where the first occurrence was logged from
+	first  time.Time
+	last   time.Time
+	count  int         // Number of suppressed duplicates
+	timer  *time.Timer // Fires when the window closes
+}
+
+// NewDedupLogger wraps l so that identical consecutive entries written within
+// window are collapsed into a single summary entry. The summary has the same
+// level, message and fields as the original, plus RepeatCountKey,
+// FirstTimestampKey and LastTimestampKey. A non-positive window disables
+// suppression and returns l unchanged.
+//
+// The summary is written with the caller of the first occurrence, if the
+// wrapped logger reports callers, rather than the call site that closed
+// the window.
+//
+// The returned logger should be closed to flush a pending summary.
+//
+// Example:
+//
+//	logger := tslog.NewDedupLogger(tslog.NewLogger(), 10*time.Second)
+//	defer logger.(io.Closer).Close()
+func NewDedupLogger(l Logger, window time.Duration) Logger {
+	if l == nil || window <= 0 {
+		return l
+	}
+	_, caller := l.(callerLogger)
+	return newDedupLogger(l, window, caller, 0)
+}
+
+// newDedupLogger creates a dedupLogger around l. caller enables recording
+// the caller of first occurrences for their summaries, and outerSkip is the
+// number of stack frames added by loggers wrapping the dedupLogger.
+func newDedupLogger(l Logger, window time.Duration, caller bool, outerSkip int) *dedupLogger {
+	d := &dedupLogger{
+		next:       l,
+		window:     window,
+		now:        time.Now,
+		caller:     caller,
+		callerSkip: 3 + outerSkip,
+	}
+	d.funcLogger = funcLogger{log: d.log}
+	return d
+}
+
+// log writes the entry unless it duplicates the pending one.
+func (d *dedupLogger) log(lvl Level, msg string, fields T) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.closed {
+		logTo(d.next, lvl, msg, fields)
+		return
+	}
+
+	now := d.now()
+	key := dedupKey(lvl, msg, fields)
+	if p := d.pending; p != nil && p.key == key && now.Sub(p.first) < d.window {
+		p.count++
+		p.last = now
+		return
+	}
+
+	d.flushLocked()
+	p := &dedupEntry{
+		key:    key,
+		lvl:    lvl,
+		msg:    msg,
+		fields: copyFields(fields),
+		first:  now,
+		last:   now,
+	}
+	if d.caller {
+		p.caller = callerAt(d.callerSkip)
+	}
+	p.timer = time.AfterFunc(d.window, func() { d.expire(p) })
+	d.pending = p
+
+	logTo(d.next, lvl, msg, fields)
+}
+
+// expire closes the window of p if it is still the pending entry.
+func (d *dedupLogger) expire(p *dedupEntry) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.pending == p {
+		d.flushLocked()
+	}
+}
+
+// flushLocked writes the summary for the pending entry, if any duplicates
+// were suppressed, and clears it. The caller must hold d.mutex.
+func (d *dedupLogger) flushLocked() {
+	p := d.pending
+	if p == nil {
+		return
+	}
+	d.pending = nil
+	p.timer.Stop()
+
+	if p.count == 0 {
+		return
+	}
+
+	fields := make(T, len(p.fields)+3)
+	for k, v := range p.fields {
+		fields[k] = v
+	}
+	fields[RepeatCountKey] = p.count
+	fields[FirstTimestampKey] = p.first
+	fields[LastTimestampKey] = p.last
+	logCallerTo(d.next, p.lvl, p.msg, fields, p.caller)
+}
+
+// Close writes any pending summary and closes the wrapped logger if it
+// supports closing. Entries logged after Close are passed through unchanged.
+func (d *dedupLogger) Close() error {
+	d.mutex.Lock()
+	if d.closed {
+		d.mutex.Unlock()
+		return nil
+	}
+	d.flushLocked()
+	d.closed = true
+	d.mutex.Unlock()
+
+	return closeLogger(d.next)
+}
+
+// dedupKey builds a key that is equal for entries with the same level,
+// message and fields. Field order does not matter.
+func dedupKey(lvl Level, msg string, fields T) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d\x00%s", lvl, msg)
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "\x00%s=%#v", k, fields[k])
+	}
+	return b.String()
+}
+
+// copyFields returns a shallow copy of fields, or nil if fields is empty.
+func copyFields(fields T) T {
+	if len(fields) == 0 {
+		return nil
+	}
+	c := make(T, len(fields))
+	for k, v := range fields {
+		c[k] = v
+	}
+	return c
+}
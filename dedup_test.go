@@ -0,0 +1,247 @@
+package tslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordedEntry is a normalized log call captured by newRecordingLogger
+type recordedEntry struct {
+	lvl    Level
+	msg    string
+	fields T
+}
+
+// recordingLogger captures normalized log calls for assertions
+type recordingLogger struct {
+	funcLogger
+	mutex   sync.Mutex
+	entries []recordedEntry
+	closed  bool
+}
+
+func newRecordingLogger() *recordingLogger {
+	r := &recordingLogger{}
+	r.funcLogger = funcLogger{log: func(lvl Level, msg string, fields T) {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.entries = append(r.entries, recordedEntry{lvl: lvl, msg: msg, fields: fields})
+	}}
+	return r
+}
+
+func (r *recordingLogger) Close() error {
+	r.closed = true
+	return nil
+}
+
+func (r *recordingLogger) all() []recordedEntry {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]recordedEntry(nil), r.entries...)
+}
+
+// lockedBuffer is a bytes.Buffer safe for concurrent use
+type lockedBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+// TestNewDedupLogger tests wrapping behavior of NewDedupLogger
+func TestNewDedupLogger(t *testing.T) {
+	t.Run("NonPositiveWindow", func(t *testing.T) {
+		rec := newRecordingLogger()
+		assert.Same(t, rec, NewDedupLogger(rec, 0))
+	})
+
+	t.Run("NilLogger", func(t *testing.T) {
+		assert.Nil(t, NewDedupLogger(nil, time.Second))
+	})
+}
+
+// TestDedupLoggerCollapsesDuplicates tests suppression of identical entries
+func TestDedupLoggerCollapsesDuplicates(t *testing.T) {
+	rec := newRecordingLogger()
+	d := newDedupLogger(rec, time.Hour, false, 0)
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := base
+	d.now = func() time.Time { return now }
+
+	d.Infot("disk full", T{"disk": "sda"})
+	now = base.Add(time.Second)
+	d.Infot("disk full", T{"disk": "sda"})
+	now = base.Add(2 * time.Second)
+	d.Infot("disk full", T{"disk": "sda"})
+
+	// Only the first occurrence has been written so far
+	require.Len(t, rec.all(), 1)
+
+	// A different entry flushes the summary before being written
+	d.Warn("something else")
+
+	entries := rec.all()
+	require.Len(t, entries, 3)
+	assert.Equal(t, "disk full", entries[1].msg)
+	assert.Equal(t, InfoLevel, entries[1].lvl)
+	assert.Equal(t, "sda", entries[1].fields["disk"])
+	assert.Equal(t, 2, entries[1].fields[RepeatCountKey])
+	assert.Equal(t, base, entries[1].fields[FirstTimestampKey])
+	assert.Equal(t, base.Add(2*time.Second), entries[1].fields[LastTimestampKey])
+	assert.Equal(t, "something else", entries[2].msg)
+	assert.Equal(t, WarnLevel, entries[2].lvl)
+}
+
+// TestDedupLoggerDistinguishesEntries tests that level, message and fields form the key
+func TestDedupLoggerDistinguishesEntries(t *testing.T) {
+	rec := newRecordingLogger()
+	d := newDedupLogger(rec, time.Hour, false, 0)
+
+	d.Info("msg")
+	d.Warn("msg")
+	d.Warnt("msg", T{"a": 1})
+	d.Warnt("msg", T{"a": 2})
+	d.Warnf("%s", "other")
+
+	assert.Len(t, rec.all(), 5)
+	for _, e := range rec.all() {
+		assert.NotContains(t, e.fields, RepeatCountKey)
+	}
+}
+
+// TestDedupLoggerWindow tests that the summary is written when the window closes
+func TestDedupLoggerWindow(t *testing.T) {
+	rec := newRecordingLogger()
+	d := newDedupLogger(rec, 20*time.Millisecond, false, 0)
+
+	d.Error("boom")
+	d.Error("boom")
+
+	assert.Eventually(t, func() bool {
+		return len(rec.all()) == 2
+	}, time.Second, 5*time.Millisecond)
+
+	entries := rec.all()
+	assert.Equal(t, 1, entries[1].fields[RepeatCountKey])
+
+	// After the window closed the same entry is written again
+	d.Error("boom")
+	assert.Len(t, rec.all(), 3)
+}
+
+// TestDedupLoggerClose tests flushing on Close
+func TestDedupLoggerClose(t *testing.T) {
+	rec := newRecordingLogger()
+	d := newDedupLogger(rec, time.Hour, false, 0)
+
+	d.Debug("tick")
+	d.Debug("tick")
+	d.Debug("tick")
+
+	assert.NoError(t, d.Close())
+	assert.True(t, rec.closed)
+
+	entries := rec.all()
+	require.Len(t, entries, 2)
+	assert.Equal(t, 2, entries[1].fields[RepeatCountKey])
+
+	// Closing twice is a no-op and logging still passes through
+	assert.NoError(t, d.Close())
+	d.Debug("tick")
+	assert.Len(t, rec.all(), 3)
+}
+
+// TestDedupKey tests that field order doesn't affect the key
+func TestDedupKey(t *testing.T) {
+	a := dedupKey(InfoLevel, "msg", T{"a": 1, "b": "x", "c": true})
+	b := dedupKey(InfoLevel, "msg", T{"c": true, "b": "x", "a": 1})
+	assert.Equal(t, a, b)
+	assert.NotEqual(t, a, dedupKey(InfoLevel, "msg", T{"a": "1", "b": "x", "c": true}))
+	assert.NotEqual(t, dedupKey(InfoLevel, "msg", nil), dedupKey(DebugLevel, "msg", nil))
+}
+
+// TestWithDedup tests the WithDedup option with the Zap driver
+func TestWithDedup(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(
+		WithWriter(&buf),
+		WithDedup(time.Hour),
+		WithCaller(true),
+	)
+
+	for i := 0; i < 5; i++ {
+		logger.Infot("retrying", T{"attempt": "same"})
+	}
+	require.NoError(t, logger.(interface{ Close() error }).Close())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+
+	var summary map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &summary))
+	assert.Equal(t, "retrying", summary["msg"])
+	assert.Equal(t, float64(4), summary[RepeatCountKey])
+	assert.Contains(t, summary, FirstTimestampKey)
+	assert.Contains(t, summary, LastTimestampKey)
+
+	// The caller should point at this file rather than the wrapper
+	var first map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	assert.NotContains(t, first["caller"], "wrapper.go")
+	assert.NotContains(t, first["caller"], "dedup.go")
+	assert.Equal(t, first["caller"], summary["caller"])
+}
+
+// TestWithDedupExpiredCaller tests that a summary written when the window
+// closes reports the caller of the first occurrence
+func TestWithDedupExpiredCaller(t *testing.T) {
+	buf := &lockedBuffer{}
+	hook := &testHook{levels: []Level{InfoLevel}}
+	logger := NewLogger(
+		WithWriter(buf),
+		WithDedup(20*time.Millisecond),
+		WithHooks(hook),
+		WithCaller(true),
+	)
+	UpdateDefaultLogger(logger)
+	defer UpdateDefaultLogger(NewLogger(WithWriter(&bytes.Buffer{})))
+
+	for i := 0; i < 3; i++ {
+		Info("retrying")
+	}
+	require.Eventually(t, func() bool {
+		return strings.Count(buf.String(), "\n") == 2
+	}, time.Second, 5*time.Millisecond)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	var first, summary map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &summary))
+	assert.Equal(t, float64(2), summary[RepeatCountKey])
+	assert.Contains(t, first["caller"], "dedup_test.go")
+	assert.Equal(t, first["caller"], summary["caller"])
+
+	// Hooks see the same caller for the summary
+	entries := hook.all()
+	require.Len(t, entries, 2)
+	assert.Equal(t, entries[0].Caller, entries[1].Caller)
+}
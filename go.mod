@@ -3,9 +3,12 @@ module github.com/tinystack/tslog
 go 1.18
 
 require (
+	github.com/klauspost/compress v1.16.7
 	github.com/natefinch/lumberjack v2.0.0+incompatible
 	github.com/stretchr/testify v1.8.1
+	github.com/ulikunitz/xz v0.5.12
 	go.uber.org/zap v1.27.0
+	golang.org/x/sys v0.15.0
 )
 
 require (
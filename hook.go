@@ -0,0 +1,232 @@
+// Package tslog provides hooks invoked for every emitted log entry.
+// This file contains the Entry and Hook types and the Logger wrapper that
+// runs hooks before passing entries on to the driver.
+package tslog
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/tinystack/tslog/internal/callsite"
+)
+
+// Entry represents a single log entry as seen by hooks.
+type Entry struct {
+	// Level is the level the entry was logged at
+	Level Level
+	// Time is when the entry was logged
+	Time time.Time
+	// Message is the rendered log message
+	Message string
+	// Caller is where the entry was logged from. It's only defined when
+	// caller information is enabled with WithCaller.
+	Caller Caller
+	// Fields holds the structured fields of the entry. It's never nil when
+	// passed to a hook, and fields added by a hook are written with the entry.
+	Fields T
+}
+
+// Caller describes the source location an entry was logged from.
+type Caller struct {
+	// Defined is false if the caller information isn't available
+	Defined bool
+	// File is the full path of the source file
+	File string
+	// Line is the line number in File
+	Line int
+	// Function is the fully qualified function name
+	Function string
+}
+
+// String returns the caller as "file:line", or "undefined".
+func (c Caller) String() string {
+	if !c.Defined {
+		return "undefined"
+	}
+	return c.File + ":" + strconv.Itoa(c.Line)
+}
+
+// Hook is called for every entry logged at one of its levels, before the
+// entry is written.
+//
+// Hooks are called synchronously from the logging goroutine, so they should
+// be fast and safe for concurrent use. An error returned by Fire is reported
+// to the error output (see WithErrorOutput) and doesn't stop the entry from
+// being written.
+type Hook interface {
+	// Levels returns the levels the hook fires for
+	Levels() []Level
+	// Fire is called with each entry at one of the hook's levels
+	Fire(Entry) error
+}
+
+// WithHooks adds hooks that are called for every entry the logger emits.
+// Hooks work with any driver and run in the order they were added.
+//
+// Example:
+//
+//	logger := tslog.NewLogger(tslog.WithHooks(metricsHook, alertHook))
+func WithHooks(hooks ...Hook) FuncOption {
+	return func(o *Options) {
+		for _, h := range hooks {
+			if h != nil {
+				o.hooks = append(o.hooks, h)
+			}
+		}
+	}
+}
+
+// WithErrorOutput sets where internal errors of the logger, such as
+// failing hooks, are reported. Defaults to os.Stderr.
+//
+// Example:
+//
+//	logger := tslog.NewLogger(tslog.WithErrorOutput(io.Discard))
+func WithErrorOutput(w io.Writer) FuncOption {
+	return func(o *Options) {
+		o.errOutput = w
+	}
+}
+
+// hookLogger wraps a Logger and runs hooks for every entry before passing
+// it on.
+type hookLogger struct {
+	funcLogger
+
+	next       Logger
+	hooks      map[Level][]Hook
+	lvl        Level
+	caller     bool
+	callerSkip int
+	errOutput  io.Writer
+	redactor   *redactor // Redacts the fields hooks add, nil without redaction
+}
+
+// newHookLogger creates a hookLogger around l for the hooks in opts.
+// outerSkip is the number of stack frames added by loggers wrapping the
+// hookLogger.
+func newHookLogger(l Logger, opts *Options, outerSkip int) *hookLogger {
+	h := &hookLogger{
+		next:       l,
+		hooks:      make(map[Level][]Hook),
+		lvl:        opts.lvl,
+		caller:     opts.caller,
+		callerSkip: 3 + outerSkip,
+		errOutput:  opts.errOutput,
+	}
+	h.funcLogger = funcLogger{log: h.log}
+	if opts.redaction != nil {
+		h.redactor = newRedactor(opts.redaction)
+	}
+
+	for _, hook := range opts.hooks {
+		for _, lvl := range hook.Levels() {
+			h.hooks[lvl] = append(h.hooks[lvl], hook)
+		}
+	}
+	return h
+}
+
+// log runs the hooks registered for lvl and writes the entry.
+func (h *hookLogger) log(lvl Level, msg string, fields T) {
+	if !h.enabled(lvl) {
+		return
+	}
+	if len(h.hooks[lvl]) == 0 {
+		logTo(h.next, lvl, msg, fields)
+		return
+	}
+
+	var caller Caller
+	if h.caller {
+		caller = callerAt(h.callerSkip)
+	}
+	logTo(h.next, lvl, msg, h.fire(lvl, msg, fields, caller))
+}
+
+// logCaller runs the hooks registered for lvl and writes the entry as if it
+// was logged at caller.
+func (h *hookLogger) logCaller(lvl Level, msg string, fields T, caller Caller) {
+	if !h.enabled(lvl) {
+		return
+	}
+	if len(h.hooks[lvl]) > 0 {
+		if !h.caller {
+			caller = Caller{}
+		}
+		fields = h.fire(lvl, msg, fields, caller)
+	}
+	logCallerTo(h.next, lvl, msg, fields, caller)
+}
+
+// enabled reports whether entries at lvl pass the logger's level.
+func (h *hookLogger) enabled(lvl Level) bool {
+	return h.lvl != NoneLevel && lvl >= h.lvl
+}
+
+// fire runs the hooks registered for lvl and returns the fields of the
+// entry, including those the hooks added, redacted if redaction is enabled.
+func (h *hookLogger) fire(lvl Level, msg string, fields T, caller Caller) T {
+	entry := Entry{
+		Level:   lvl,
+		Time:    time.Now(),
+		Message: msg,
+		Caller:  caller,
+		Fields:  make(T, len(fields)),
+	}
+	for k, v := range fields {
+		entry.Fields[k] = v
+	}
+
+	for _, hook := range h.hooks[lvl] {
+		if err := fireHook(hook, entry); err != nil {
+			h.reportError(err)
+		}
+	}
+	if h.redactor != nil {
+		h.redactor.changed(fields, entry.Fields)
+	}
+	return entry.Fields
+}
+
+// reportError writes err to the error output.
+func (h *hookLogger) reportError(err error) {
+	if h.errOutput != nil {
+		fmt.Fprintf(h.errOutput, "%s tslog: %v\n", time.Now().Format(time.RFC3339), err)
+	}
+}
+
+// Close closes the wrapped logger if it supports closing.
+func (h *hookLogger) Close() error {
+	return closeLogger(h.next)
+}
+
+// fireHook calls hook.Fire, turning a panic into an error.
+func fireHook(hook Hook, entry Entry) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("hook %T panicked: %v", hook, r)
+		}
+	}()
+
+	if err := hook.Fire(entry); err != nil {
+		return fmt.Errorf("hook %T failed: %w", hook, err)
+	}
+	return nil
+}
+
+// callerAt returns the caller skip frames above its own caller.
+func callerAt(skip int) Caller {
+	frame, ok := callsite.Frame(skip + 1)
+	if !ok {
+		return Caller{}
+	}
+	return Caller{
+		Defined:  true,
+		File:     frame.File,
+		Line:     frame.Line,
+		Function: frame.Function,
+	}
+}
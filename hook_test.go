@@ -0,0 +1,179 @@
+package tslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testHook records the entries it fires for
+type testHook struct {
+	levels  []Level
+	mutex   sync.Mutex
+	entries []Entry
+	fire    func(e Entry) error
+}
+
+func (h *testHook) Levels() []Level {
+	return h.levels
+}
+
+func (h *testHook) Fire(e Entry) error {
+	h.mutex.Lock()
+	h.entries = append(h.entries, e)
+	h.mutex.Unlock()
+	if h.fire != nil {
+		return h.fire(e)
+	}
+	return nil
+}
+
+func (h *testHook) all() []Entry {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]Entry(nil), h.entries...)
+}
+
+// TestCaller tests the Caller type
+func TestCaller(t *testing.T) {
+	assert.Equal(t, "undefined", Caller{}.String())
+	assert.Equal(t, "/src/app/main.go:42", Caller{Defined: true, File: "/src/app/main.go", Line: 42}.String())
+}
+
+// TestWithHooks tests the WithHooks option function
+func TestWithHooks(t *testing.T) {
+	h1 := &testHook{}
+	h2 := &testHook{}
+	opt := &Options{}
+
+	WithHooks(h1, nil)(opt)
+	WithHooks(h2)(opt)
+
+	assert.Equal(t, []Hook{h1, h2}, opt.hooks)
+}
+
+// TestHooks tests that hooks are called for emitted entries
+func TestHooks(t *testing.T) {
+	t.Run("LevelsAndEntry", func(t *testing.T) {
+		var buf bytes.Buffer
+		errHook := &testHook{levels: []Level{ErrorLevel}}
+		allHook := &testHook{levels: []Level{DebugLevel, InfoLevel, WarnLevel, ErrorLevel}}
+
+		logger := NewLogger(
+			WithLevel(InfoLevel),
+			WithWriter(&buf),
+			WithHooks(errHook, allHook),
+		)
+
+		before := time.Now()
+		logger.Debug("filtered by level")
+		logger.Infof("user %d", 42)
+		logger.Errort("failed", T{"code": 500})
+
+		require.Len(t, allHook.all(), 2)
+		info := allHook.all()[0]
+		assert.Equal(t, InfoLevel, info.Level)
+		assert.Equal(t, "user 42", info.Message)
+		assert.NotNil(t, info.Fields)
+		assert.False(t, info.Time.Before(before))
+		assert.False(t, info.Caller.Defined)
+
+		require.Len(t, errHook.all(), 1)
+		e := errHook.all()[0]
+		assert.Equal(t, ErrorLevel, e.Level)
+		assert.Equal(t, "failed", e.Message)
+		assert.Equal(t, T{"code": 500}, e.Fields)
+
+		assert.NotContains(t, buf.String(), "filtered by level")
+		assert.Contains(t, buf.String(), "user 42")
+	})
+
+	t.Run("AddFields", func(t *testing.T) {
+		var buf bytes.Buffer
+		hook := &testHook{
+			levels: []Level{InfoLevel},
+			fire: func(e Entry) error {
+				e.Fields["hostname"] = "web-1"
+				return nil
+			},
+		}
+		logger := NewLogger(WithWriter(&buf), WithHooks(hook))
+
+		fields := T{"user": "john"}
+		logger.Infot("login", fields)
+		logger.Info("plain")
+
+		assert.Equal(t, 2, strings.Count(buf.String(), `"hostname":"web-1"`))
+		assert.Contains(t, buf.String(), `"user":"john"`)
+		// The caller's map is left untouched
+		assert.Equal(t, T{"user": "john"}, fields)
+	})
+
+	t.Run("ErrorsAreReported", func(t *testing.T) {
+		var buf, errBuf bytes.Buffer
+		failing := &testHook{
+			levels: []Level{WarnLevel},
+			fire:   func(e Entry) error { return errors.New("incident system down") },
+		}
+		panicking := &testHook{
+			levels: []Level{WarnLevel},
+			fire:   func(e Entry) error { panic("boom") },
+		}
+		logger := NewLogger(
+			WithWriter(&buf),
+			WithHooks(failing, panicking),
+			WithErrorOutput(&errBuf),
+		)
+
+		assert.NotPanics(t, func() {
+			logger.Warn("still written")
+		})
+
+		assert.Contains(t, buf.String(), "still written")
+		assert.Contains(t, errBuf.String(), "incident system down")
+		assert.Contains(t, errBuf.String(), "panicked: boom")
+	})
+
+	t.Run("Caller", func(t *testing.T) {
+		var buf bytes.Buffer
+		hook := &testHook{levels: []Level{InfoLevel}}
+		logger := NewLogger(WithWriter(&buf), WithHooks(hook), WithCaller(true))
+		UpdateDefaultLogger(logger)
+		defer UpdateDefaultLogger(NewLogger(WithWriter(&bytes.Buffer{})))
+
+		Info("from package level")
+
+		require.Len(t, hook.all(), 1)
+		caller := hook.all()[0].Caller
+		require.True(t, caller.Defined)
+		assert.True(t, strings.HasSuffix(caller.File, "hook_test.go"), caller.File)
+		assert.Contains(t, caller.Function, "TestHooks")
+
+		// The driver reports the same caller
+		var out map[string]any
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &out))
+		assert.True(t, strings.HasSuffix(caller.String(), "/"+out["caller"].(string)), out["caller"])
+	})
+
+	t.Run("WithDedup", func(t *testing.T) {
+		var buf bytes.Buffer
+		hook := &testHook{levels: []Level{InfoLevel}}
+		logger := NewLogger(WithWriter(&buf), WithHooks(hook), WithDedup(time.Hour))
+
+		for i := 0; i < 3; i++ {
+			logger.Info("repeated")
+		}
+		require.NoError(t, logger.(interface{ Close() error }).Close())
+
+		// Hooks see the first entry and the summary, not the suppressed duplicates
+		require.Len(t, hook.all(), 2)
+		assert.Equal(t, 2, hook.all()[1].Fields[RepeatCountKey])
+	})
+}
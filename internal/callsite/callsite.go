@@ -0,0 +1,18 @@
+// Package callsite looks up the source location of log calls. It is shared
+// by tslog and tslogtest so that both report callers the same way.
+package callsite
+
+import "runtime"
+
+// Frame returns the stack frame skip frames above the caller of Frame, and
+// false if the stack isn't that deep.
+func Frame(skip int) (runtime.Frame, bool) {
+	var pcs [1]uintptr
+	// Skip runtime.Callers and Frame itself
+	if runtime.Callers(skip+2, pcs[:]) == 0 {
+		return runtime.Frame{}, false
+	}
+
+	frame, _ := runtime.CallersFrames(pcs[:]).Next()
+	return frame, true
+}
@@ -0,0 +1,35 @@
+package callsite
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// frameOfCaller returns the frame of its caller
+func frameOfCaller() (string, bool) {
+	frame, ok := Frame(1)
+	return frame.Function, ok
+}
+
+// TestFrame tests looking up frames above the caller
+func TestFrame(t *testing.T) {
+	t.Run("Caller", func(t *testing.T) {
+		frame, ok := Frame(0)
+		assert.True(t, ok)
+		assert.True(t, strings.HasSuffix(frame.File, "callsite_test.go"), frame.File)
+		assert.Contains(t, frame.Function, "TestFrame")
+	})
+
+	t.Run("Skip", func(t *testing.T) {
+		function, ok := frameOfCaller()
+		assert.True(t, ok)
+		assert.Contains(t, function, "TestFrame")
+	})
+
+	t.Run("TooDeep", func(t *testing.T) {
+		_, ok := Frame(1000)
+		assert.False(t, ok)
+	})
+}
@@ -22,7 +22,9 @@ package tslog
 import (
 	"fmt"
 	"io"
+	"os"
 	"strings"
+	"time"
 )
 
 // Log levels define the severity of log messages.
@@ -136,6 +138,46 @@ type Options struct {
 	caller bool
 	// driver is the factory function used to create the actual logger implementation
 	driver Driver
+	// outputs holds writers with their own level threshold and encoder
+	outputs []output
+	// hooks are called for every entry before it's written
+	hooks []Hook
+	// errOutput receives internal errors such as failing hooks
+	errOutput io.Writer
+	// redaction holds the rules for redacting sensitive data, nil if disabled
+	redaction *RedactionRules
+	// dedupWindow is the window within which identical consecutive entries are collapsed
+	dedupWindow time.Duration
+	// callerSkip is the number of extra stack frames added by wrapping loggers
+	callerSkip int
+}
+
+// Level returns the minimum log level. It's meant for drivers implemented
+// outside this package.
+func (o *Options) Level() Level {
+	return o.lvl
+}
+
+// Caller reports whether caller information should be included in entries.
+// It's meant for drivers implemented outside this package.
+func (o *Options) Caller() bool {
+	return o.caller
+}
+
+// CallerSkip returns the number of stack frames that loggers wrapping the
+// driver, such as those enabled by WithHooks or WithDedup, add between the
+// caller and the driver. Drivers that report caller information should skip
+// these frames in addition to their own.
+func (o *Options) CallerSkip() int {
+	return o.callerSkip
+}
+
+// output is a writer added with WithOutput that has its own level
+// threshold and encoder.
+type output struct {
+	w       io.Writer
+	lvl     Level
+	encoder string
 }
 
 // Validate checks if the options are valid and returns an error if not.
@@ -146,9 +188,22 @@ func (o *Options) Validate() error {
 	if o.encoder != EncoderJSON && o.encoder != EncoderConsole {
 		return fmt.Errorf("encoder must be either %q or %q", EncoderJSON, EncoderConsole)
 	}
-	if len(o.w) == 0 {
+	if len(o.w) == 0 && len(o.outputs) == 0 {
 		return fmt.Errorf("at least one writer must be specified")
 	}
+	for _, out := range o.outputs {
+		if out.w == nil {
+			return fmt.Errorf("output writer cannot be nil")
+		}
+		if out.encoder != EncoderJSON && out.encoder != EncoderConsole {
+			return fmt.Errorf("output encoder must be either %q or %q", EncoderJSON, EncoderConsole)
+		}
+	}
+	if o.redaction != nil {
+		if err := o.redaction.Validate(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -178,10 +233,11 @@ var unmarshalLevelText = map[string]Level{
 // and will work out of the box.
 func defaultOptions() *Options {
 	return &Options{
-		lvl:     DebugLevel,
-		encoder: EncoderJSON,
-		caller:  false,
-		driver:  NewZapDriver,
+		lvl:       DebugLevel,
+		encoder:   EncoderJSON,
+		caller:    false,
+		driver:    NewZapDriver,
+		errOutput: os.Stderr,
 	}
 }
 
@@ -225,6 +281,24 @@ func WithWriter(w ...io.Writer) FuncOption {
 	}
 }
 
+// WithOutput adds an output writer with its own minimum level and encoder.
+// It can be used multiple times, and together with WithWriter, to send
+// entries to several destinations in different formats. An entry is written
+// to the output if its level is at least lvl and the level set by WithLevel.
+// Setting lvl to NoneLevel disables the output.
+//
+// Example:
+//
+//	logger := tslog.NewLogger(
+//	    tslog.WithOutput(os.Stdout, tslog.DebugLevel, tslog.EncoderConsole),
+//	    tslog.WithOutput(file, tslog.WarnLevel, tslog.EncoderJSON),
+//	)
+func WithOutput(w io.Writer, lvl Level, encoder string) FuncOption {
+	return func(o *Options) {
+		o.outputs = append(o.outputs, output{w: w, lvl: lvl, encoder: encoder})
+	}
+}
+
 // WithCaller enables or disables the inclusion of caller information
 // (file name and line number) in log messages.
 //
@@ -261,6 +335,58 @@ func WithDriver(d Driver) FuncOption {
 	}
 }
 
+// WithDedup collapses identical consecutive entries (same level, message and
+// fields) written within window into a single summary entry carrying a repeat
+// count and the first and last timestamps. It works with any driver.
+// See NewDedupLogger for details.
+//
+// Example:
+//
+//	logger := tslog.NewLogger(tslog.WithDedup(10 * time.Second))
+func WithDedup(window time.Duration) FuncOption {
+	return func(o *Options) {
+		o.dedupWindow = window
+	}
+}
+
+// wrapperCallerSkip is the number of stack frames a wrapping logger adds
+// between the caller and the logger it wraps.
+const wrapperCallerSkip = 3
+
+// build creates the logger using the configured driver and wraps it with
+// the loggers enabled by the options. From the caller's side, entries are
+// redacted first, then deduplicated, and pass through hooks last, so hooks
+// only see emitted and redacted entries. The hook logger redacts the fields
+// hooks add itself, as they're added after the redaction layer.
+func (o *Options) build() Logger {
+	hooks := len(o.hooks) > 0
+	dedup := o.dedupWindow > 0
+	redact := o.redaction != nil
+
+	layers := 0
+	for _, enabled := range []bool{hooks, dedup, redact} {
+		if enabled {
+			layers++
+		}
+	}
+	o.callerSkip += layers * wrapperCallerSkip
+
+	l := o.driver(o)
+	if hooks {
+		layers--
+		l = newHookLogger(l, o, layers*wrapperCallerSkip)
+	}
+	if dedup {
+		layers--
+		l = newDedupLogger(l, o.dedupWindow, o.caller, layers*wrapperCallerSkip)
+	}
+	if redact {
+		layers--
+		l = newRedactLogger(l, o.redaction)
+	}
+	return l
+}
+
 // NewLogger creates a new Logger instance with the specified options.
 // If no options are provided, default options will be used.
 // The function applies all options in order and then creates the logger
@@ -283,9 +409,12 @@ func NewLogger(funcOpts ...FuncOption) Logger {
 
 	// Validate options before creating logger
 	if err := opts.Validate(); err != nil {
-		// Fall back to a safe default if validation fails
+		// Fall back to a safe default if validation fails, but keep
+		// redacting: invalid redaction rules redact everything
+		redaction := opts.redaction
 		opts = defaultOptions()
+		opts.redaction = redaction
 	}
 
-	return opts.driver(opts)
+	return opts.build()
 }
@@ -111,6 +111,46 @@ func TestOptions(t *testing.T) {
 		assert.Error(t, opts.Validate())
 		assert.Contains(t, opts.Validate().Error(), "at least one writer must be specified")
 	})
+
+	t.Run("OutputsOnly", func(t *testing.T) {
+		opts := &Options{
+			lvl:     InfoLevel,
+			outputs: []output{{w: os.Stdout, lvl: InfoLevel, encoder: EncoderConsole}},
+			encoder: EncoderJSON,
+			driver:  NewZapDriver,
+		}
+		assert.NoError(t, opts.Validate())
+	})
+
+	t.Run("InvalidOutputEncoder", func(t *testing.T) {
+		opts := &Options{
+			lvl:     InfoLevel,
+			outputs: []output{{w: os.Stdout, lvl: InfoLevel, encoder: "xml"}},
+			encoder: EncoderJSON,
+			driver:  NewZapDriver,
+		}
+		assert.Error(t, opts.Validate())
+		assert.Contains(t, opts.Validate().Error(), "output encoder must be either")
+	})
+
+	t.Run("NilOutputWriter", func(t *testing.T) {
+		opts := &Options{
+			lvl:     InfoLevel,
+			outputs: []output{{w: nil, lvl: InfoLevel, encoder: EncoderJSON}},
+			encoder: EncoderJSON,
+			driver:  NewZapDriver,
+		}
+		assert.Error(t, opts.Validate())
+		assert.Contains(t, opts.Validate().Error(), "output writer cannot be nil")
+	})
+}
+
+// TestOptionsAccessors tests the getters available to external drivers
+func TestOptionsAccessors(t *testing.T) {
+	opts := &Options{lvl: WarnLevel, caller: true, callerSkip: 3}
+	assert.Equal(t, WarnLevel, opts.Level())
+	assert.True(t, opts.Caller())
+	assert.Equal(t, 3, opts.CallerSkip())
 }
 
 // TestWithLevel tests the WithLevel option function
@@ -153,6 +193,20 @@ func TestWithWriter(t *testing.T) {
 	})
 }
 
+// TestWithOutput tests the WithOutput option function
+func TestWithOutput(t *testing.T) {
+	var buf1, buf2 bytes.Buffer
+	opt := &Options{}
+
+	WithOutput(&buf1, DebugLevel, EncoderConsole)(opt)
+	WithOutput(&buf2, WarnLevel, EncoderJSON)(opt)
+
+	assert.Equal(t, []output{
+		{w: &buf1, lvl: DebugLevel, encoder: EncoderConsole},
+		{w: &buf2, lvl: WarnLevel, encoder: EncoderJSON},
+	}, opt.outputs)
+}
+
 // TestWithCaller tests the WithCaller option function
 func TestWithCaller(t *testing.T) {
 	opt := &Options{
@@ -0,0 +1,338 @@
+// Package tslog provides redaction of sensitive data in log entries.
+// This file contains the redaction rules, masking strategies and the Logger
+// wrapper that applies them before entries reach the driver.
+package tslog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// DefaultRedactionMask is the replacement used by rules without a Mask.
+const DefaultRedactionMask = "[REDACTED]"
+
+// Common patterns for use with value redaction rules.
+var (
+	// CreditCardPattern matches 13 to 19 digit card numbers, optionally
+	// grouped with spaces or dashes
+	CreditCardPattern = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+	// JWTPattern matches JSON Web Tokens
+	JWTPattern = regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	// EmailPattern matches email addresses
+	EmailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+)
+
+// Mask replaces a sensitive value with its redacted form.
+type Mask func(value string) string
+
+// FixedMask returns a Mask that replaces values with replacement.
+func FixedMask(replacement string) Mask {
+	return func(string) string {
+		return replacement
+	}
+}
+
+// PartialMask returns a Mask that keeps the first keepFirst and the last
+// keepLast characters of a value and replaces the others with '*'. Values
+// too short to hide anything are masked entirely.
+//
+// Example:
+//
+//	tslog.PartialMask(0, 4)("4111111111111111") // "************1111"
+func PartialMask(keepFirst, keepLast int) Mask {
+	if keepFirst < 0 {
+		keepFirst = 0
+	}
+	if keepLast < 0 {
+		keepLast = 0
+	}
+
+	return func(value string) string {
+		r := []rune(value)
+		if len(r) <= keepFirst+keepLast {
+			return strings.Repeat("*", len(r))
+		}
+		for i := keepFirst; i < len(r)-keepLast; i++ {
+			r[i] = '*'
+		}
+		return string(r)
+	}
+}
+
+// HashMask returns a Mask that replaces values with the hex encoded
+// SHA-256 hash of the value, prefixed with "sha256:". Equal values produce
+// equal hashes, so entries can still be correlated.
+func HashMask() Mask {
+	return func(value string) string {
+		sum := sha256.Sum256([]byte(value))
+		return "sha256:" + hex.EncodeToString(sum[:])
+	}
+}
+
+// RedactionRule describes what to redact and how. Exactly one of Key,
+// KeyGlob and Value should be set.
+type RedactionRule struct {
+	// Key redacts the whole value of fields with this name.
+	// Names are compared case-insensitively.
+	Key string
+	// KeyGlob redacts the whole value of fields whose name matches this
+	// pattern, such as "*token*". Patterns use path.Match syntax and are
+	// matched case-insensitively.
+	KeyGlob string
+	// Value redacts the parts of string values that match this expression.
+	Value *regexp.Regexp
+	// Mask produces the replacement. Defaults to FixedMask(DefaultRedactionMask).
+	Mask Mask
+}
+
+// RedactionRules configures redaction for WithRedaction.
+//
+// Field values are inspected if they are strings, errors, fmt.Stringers, T
+// or map[string]any, the maps recursively. Other values, such as structs,
+// slices and arrays, are passed through as they are: only Key and KeyGlob
+// rules apply to them, replacing the whole value. Log sensitive data in
+// such values under a key covered by a rule, or as a nested map.
+type RedactionRules struct {
+	// Rules are applied in order to every field
+	Rules []RedactionRule
+	// Messages applies the Value rules to log messages as well, including
+	// messages rendered by the formatted methods such as Infof
+	Messages bool
+}
+
+// Validate checks if the rules are valid and returns an error if not.
+func (r *RedactionRules) Validate() error {
+	for i, rule := range r.Rules {
+		set := 0
+		for _, ok := range []bool{rule.Key != "", rule.KeyGlob != "", rule.Value != nil} {
+			if ok {
+				set++
+			}
+		}
+		if set != 1 {
+			return fmt.Errorf("redaction rule %d must set exactly one of Key, KeyGlob and Value", i)
+		}
+		if rule.KeyGlob != "" {
+			if _, err := path.Match(rule.KeyGlob, ""); err != nil {
+				return fmt.Errorf("redaction rule %d has invalid KeyGlob %q: %w", i, rule.KeyGlob, err)
+			}
+		}
+	}
+	return nil
+}
+
+// WithRedaction redacts sensitive data in every entry before it reaches the
+// driver, so no writer ever sees the original values. Rules apply to the
+// fields of the structured methods (Infot, etc.), including nested T and
+// map[string]any values, and optionally to messages. Hooks see redacted
+// entries, and the fields they add or change are redacted as well.
+//
+// Redaction fails closed: if the rules are invalid, NewLogger falls back to
+// its defaults like for any invalid option, but keeps redacting, replacing
+// every field value, and every message if Messages is set, with
+// DefaultRedactionMask.
+//
+// Example:
+//
+//	logger := tslog.NewLogger(tslog.WithRedaction(tslog.RedactionRules{
+//	    Rules: []tslog.RedactionRule{
+//	        {Key: "password"},
+//	        {KeyGlob: "*token*", Mask: tslog.HashMask()},
+//	        {Value: tslog.CreditCardPattern, Mask: tslog.PartialMask(0, 4)},
+//	        {Value: tslog.EmailPattern},
+//	    },
+//	    Messages: true,
+//	}))
+func WithRedaction(rules RedactionRules) FuncOption {
+	return func(o *Options) {
+		o.redaction = &rules
+	}
+}
+
+// redactor applies redaction rules to messages and fields.
+type redactor struct {
+	keys     map[string]Mask
+	keyGlobs []RedactionRule
+	values   []RedactionRule
+	messages bool
+	all      bool // The rules are invalid, so everything is redacted
+}
+
+// newRedactor prepares rules for matching. The rules must be valid.
+func newRedactor(rules *RedactionRules) *redactor {
+	r := &redactor{
+		keys:     make(map[string]Mask),
+		messages: rules.Messages,
+	}
+	if rules.Validate() != nil {
+		// Rules that can't be trusted mustn't let anything through
+		r.all = true
+		return r
+	}
+
+	for _, rule := range rules.Rules {
+		if rule.Mask == nil {
+			rule.Mask = FixedMask(DefaultRedactionMask)
+		}
+		switch {
+		case rule.Key != "":
+			key := strings.ToLower(rule.Key)
+			if _, ok := r.keys[key]; !ok {
+				r.keys[key] = rule.Mask
+			}
+		case rule.KeyGlob != "":
+			rule.KeyGlob = strings.ToLower(rule.KeyGlob)
+			r.keyGlobs = append(r.keyGlobs, rule)
+		case rule.Value != nil:
+			r.values = append(r.values, rule)
+		}
+	}
+	return r
+}
+
+// message redacts msg if message redaction is enabled.
+func (r *redactor) message(msg string) string {
+	if !r.messages {
+		return msg
+	}
+	return r.string(msg)
+}
+
+// fields returns fields with sensitive values redacted. The original map is
+// never modified; a copy is made only if something was redacted.
+func (r *redactor) fields(fields T) T {
+	out, _ := r.fieldsMap(fields)
+	return out
+}
+
+// changed redacts the fields of after, in place, that aren't in before with
+// the same value, such as the fields added by hooks. The fields of before
+// are already redacted and are left as they are, so masks such as HashMask
+// aren't applied twice.
+func (r *redactor) changed(before, after T) {
+	for k, v := range after {
+		if bv, ok := before[k]; ok && reflect.DeepEqual(bv, v) {
+			continue
+		}
+		if nv, changed := r.field(k, v); changed {
+			after[k] = nv
+		}
+	}
+}
+
+// fieldsMap redacts a map of fields and reports whether anything changed.
+func (r *redactor) fieldsMap(fields map[string]any) (map[string]any, bool) {
+	var out map[string]any
+	for k, v := range fields {
+		nv, changed := r.field(k, v)
+		if !changed {
+			continue
+		}
+		if out == nil {
+			out = make(map[string]any, len(fields))
+			for k2, v2 := range fields {
+				out[k2] = v2
+			}
+		}
+		out[k] = nv
+	}
+
+	if out == nil {
+		return fields, false
+	}
+	return out, true
+}
+
+// field redacts a single field value.
+func (r *redactor) field(key string, value any) (any, bool) {
+	if mask := r.keyMask(key); mask != nil {
+		return mask(fmt.Sprint(value)), true
+	}
+
+	switch v := value.(type) {
+	case string:
+		s := r.string(v)
+		return s, s != v
+	case T:
+		m, changed := r.fieldsMap(v)
+		return T(m), changed
+	case map[string]any:
+		return r.fieldsMap(v)
+	case error:
+		return r.stringer(v.Error(), value)
+	case fmt.Stringer:
+		return r.stringer(v.String(), value)
+	default:
+		return value, false
+	}
+}
+
+// stringer redacts the string form s of value, returning value unchanged
+// if nothing matched so its type is preserved.
+func (r *redactor) stringer(s string, value any) (any, bool) {
+	if rs := r.string(s); rs != s {
+		return rs, true
+	}
+	return value, false
+}
+
+// keyMask returns the mask of the first key rule matching key, or nil.
+func (r *redactor) keyMask(key string) Mask {
+	if r.all {
+		return FixedMask(DefaultRedactionMask)
+	}
+	key = strings.ToLower(key)
+	if mask, ok := r.keys[key]; ok {
+		return mask
+	}
+	for _, rule := range r.keyGlobs {
+		if ok, _ := path.Match(rule.KeyGlob, key); ok {
+			return rule.Mask
+		}
+	}
+	return nil
+}
+
+// string applies the value rules to s.
+func (r *redactor) string(s string) string {
+	if r.all {
+		return DefaultRedactionMask
+	}
+	for _, rule := range r.values {
+		s = rule.Value.ReplaceAllStringFunc(s, rule.Mask)
+	}
+	return s
+}
+
+// redactLogger wraps a Logger and redacts every entry before passing it on.
+type redactLogger struct {
+	funcLogger
+
+	next     Logger
+	redactor *redactor
+}
+
+// newRedactLogger creates a redactLogger around l.
+func newRedactLogger(l Logger, rules *RedactionRules) *redactLogger {
+	r := &redactLogger{
+		next:     l,
+		redactor: newRedactor(rules),
+	}
+	r.funcLogger = funcLogger{log: r.log}
+	return r
+}
+
+// log redacts the entry and writes it.
+func (r *redactLogger) log(lvl Level, msg string, fields T) {
+	logTo(r.next, lvl, r.redactor.message(msg), r.redactor.fields(fields))
+}
+
+// Close closes the wrapped logger if it supports closing.
+func (r *redactLogger) Close() error {
+	return closeLogger(r.next)
+}
@@ -0,0 +1,248 @@
+// Package tslogtest provides loggers for testing code that logs through tslog.
+// This file contains an in-memory logger that records structured entries and
+// helpers for asserting on them without parsing encoded output.
+package tslogtest
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tinystack/tslog"
+	"github.com/tinystack/tslog/internal/callsite"
+)
+
+// TestingT is the subset of testing.TB used by the assertion helpers.
+type TestingT interface {
+	Errorf(format string, args ...any)
+}
+
+// recorder holds the entries shared by a Logger and the loggers created by
+// its Driver.
+type recorder struct {
+	mutex   sync.Mutex
+	entries []tslog.Entry
+}
+
+// Logger is a tslog.Logger that records every entry in memory instead of
+// writing it. It is safe for concurrent use.
+//
+// Example:
+//
+//	logs := tslogtest.NewLogger(tslog.DebugLevel)
+//	svc := NewService(logs)
+//	svc.Run()
+//	logs.AssertLogged(t, tslog.ErrorLevel, "connection refused", tslog.T{"host": "db"})
+type Logger struct {
+	rec        *recorder
+	lvl        tslog.Level
+	callerSkip int
+}
+
+// NewLogger creates a Logger that records entries logged at lvl or above.
+func NewLogger(lvl tslog.Level) *Logger {
+	return &Logger{
+		rec: &recorder{},
+		lvl: lvl,
+	}
+}
+
+// Driver returns a tslog.Driver whose loggers record into l. Use it to test
+// loggers built with tslog.NewLogger, including options such as
+// tslog.WithRedaction or tslog.WithHooks. The level set with tslog.WithLevel
+// applies instead of the level l was created with.
+//
+// tslog.NewLogger requires a writer even though it isn't used.
+//
+// Example:
+//
+//	logs := tslogtest.NewLogger(tslog.DebugLevel)
+//	logger := tslog.NewLogger(
+//	    tslog.WithDriver(logs.Driver()),
+//	    tslog.WithWriter(io.Discard),
+//	    tslog.WithRedaction(rules),
+//	)
+func (l *Logger) Driver() tslog.Driver {
+	return func(opts *tslog.Options) tslog.Logger {
+		return &Logger{
+			rec:        l.rec,
+			lvl:        opts.Level(),
+			callerSkip: opts.CallerSkip(),
+		}
+	}
+}
+
+// Debug records a message at Debug level.
+func (l *Logger) Debug(args ...any) { l.log(tslog.DebugLevel, fmt.Sprint(args...), nil) }
+
+// Info records a message at Info level.
+func (l *Logger) Info(args ...any) { l.log(tslog.InfoLevel, fmt.Sprint(args...), nil) }
+
+// Warn records a message at Warn level.
+func (l *Logger) Warn(args ...any) { l.log(tslog.WarnLevel, fmt.Sprint(args...), nil) }
+
+// Error records a message at Error level.
+func (l *Logger) Error(args ...any) { l.log(tslog.ErrorLevel, fmt.Sprint(args...), nil) }
+
+// Debugf records a formatted message at Debug level.
+func (l *Logger) Debugf(format string, args ...any) {
+	l.log(tslog.DebugLevel, fmt.Sprintf(format, args...), nil)
+}
+
+// Infof records a formatted message at Info level.
+func (l *Logger) Infof(format string, args ...any) {
+	l.log(tslog.InfoLevel, fmt.Sprintf(format, args...), nil)
+}
+
+// Warnf records a formatted message at Warn level.
+func (l *Logger) Warnf(format string, args ...any) {
+	l.log(tslog.WarnLevel, fmt.Sprintf(format, args...), nil)
+}
+
+// Errorf records a formatted message at Error level.
+func (l *Logger) Errorf(format string, args ...any) {
+	l.log(tslog.ErrorLevel, fmt.Sprintf(format, args...), nil)
+}
+
+// Debugt records a message with structured fields at Debug level.
+func (l *Logger) Debugt(msg string, args tslog.T) { l.log(tslog.DebugLevel, msg, args) }
+
+// Infot records a message with structured fields at Info level.
+func (l *Logger) Infot(msg string, args tslog.T) { l.log(tslog.InfoLevel, msg, args) }
+
+// Warnt records a message with structured fields at Warn level.
+func (l *Logger) Warnt(msg string, args tslog.T) { l.log(tslog.WarnLevel, msg, args) }
+
+// Errort records a message with structured fields at Error level.
+func (l *Logger) Errort(msg string, args tslog.T) { l.log(tslog.ErrorLevel, msg, args) }
+
+// log records an entry if lvl is enabled.
+func (l *Logger) log(lvl tslog.Level, msg string, fields tslog.T) {
+	if !enabled(l.lvl, lvl) {
+		return
+	}
+
+	entry := tslog.Entry{
+		Level:   lvl,
+		Time:    time.Now(),
+		Message: msg,
+		Fields:  make(tslog.T, len(fields)),
+	}
+	for k, v := range fields {
+		entry.Fields[k] = v
+	}
+	if frame, ok := callsite.Frame(l.callerSkip + 2); ok {
+		entry.Caller = tslog.Caller{
+			Defined:  true,
+			File:     frame.File,
+			Line:     frame.Line,
+			Function: frame.Function,
+		}
+	}
+
+	l.rec.mutex.Lock()
+	l.rec.entries = append(l.rec.entries, entry)
+	l.rec.mutex.Unlock()
+}
+
+// Len returns the number of recorded entries.
+func (l *Logger) Len() int {
+	l.rec.mutex.Lock()
+	defer l.rec.mutex.Unlock()
+	return len(l.rec.entries)
+}
+
+// All returns a copy of the recorded entries in the order they were logged.
+func (l *Logger) All() []tslog.Entry {
+	l.rec.mutex.Lock()
+	defer l.rec.mutex.Unlock()
+	return append([]tslog.Entry(nil), l.rec.entries...)
+}
+
+// TakeAll returns the recorded entries and removes them from the logger.
+func (l *Logger) TakeAll() []tslog.Entry {
+	l.rec.mutex.Lock()
+	defer l.rec.mutex.Unlock()
+	entries := l.rec.entries
+	l.rec.entries = nil
+	return entries
+}
+
+// Reset removes all recorded entries.
+func (l *Logger) Reset() {
+	l.rec.mutex.Lock()
+	defer l.rec.mutex.Unlock()
+	l.rec.entries = nil
+}
+
+// FilterByLevel returns the recorded entries logged at lvl.
+func (l *Logger) FilterByLevel(lvl tslog.Level) []tslog.Entry {
+	return l.filter(func(e tslog.Entry) bool {
+		return e.Level == lvl
+	})
+}
+
+// FilterMessage returns the recorded entries whose message contains substr.
+func (l *Logger) FilterMessage(substr string) []tslog.Entry {
+	return l.filter(func(e tslog.Entry) bool {
+		return strings.Contains(e.Message, substr)
+	})
+}
+
+// filter returns the recorded entries matching keep.
+func (l *Logger) filter(keep func(tslog.Entry) bool) []tslog.Entry {
+	var out []tslog.Entry
+	for _, e := range l.All() {
+		if keep(e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// AssertLogged checks that an entry was recorded at lvl with a message
+// containing msgSubstring and with all of the given fields. Recorded entries
+// may have more fields than those given; field values are compared with
+// reflect.DeepEqual. It reports a failure through t and returns false if no
+// entry matches.
+func (l *Logger) AssertLogged(t TestingT, lvl tslog.Level, msgSubstring string, fields tslog.T) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	entries := l.All()
+	for _, e := range entries {
+		if e.Level == lvl && strings.Contains(e.Message, msgSubstring) && hasFields(e.Fields, fields) {
+			return true
+		}
+	}
+
+	var b strings.Builder
+	for _, e := range entries {
+		fmt.Fprintf(&b, "\n\t%s\t%q\t%v", e.Level, e.Message, e.Fields)
+	}
+	if b.Len() == 0 {
+		b.WriteString(" none")
+	}
+	t.Errorf("no %s entry containing %q with fields %v was logged; logged entries:%s",
+		lvl, msgSubstring, fields, b.String())
+	return false
+}
+
+// hasFields reports whether got contains every field of want.
+func hasFields(got, want tslog.T) bool {
+	for k, v := range want {
+		gv, ok := got[k]
+		if !ok || !reflect.DeepEqual(gv, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// enabled reports whether an entry at lvl passes the minimum level min.
+func enabled(min, lvl tslog.Level) bool {
+	return min.Enabled() && lvl >= min
+}
@@ -0,0 +1,178 @@
+package tslogtest
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tinystack/tslog"
+)
+
+// fakeT records failures reported by the assertion helpers
+type fakeT struct {
+	errors []string
+}
+
+func (f *fakeT) Errorf(format string, args ...any) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+// TestLoggerInterface tests that Logger implements tslog.Logger
+func TestLoggerInterface(t *testing.T) {
+	var logger tslog.Logger = NewLogger(tslog.DebugLevel)
+	assert.NotNil(t, logger)
+}
+
+// TestLoggerRecords tests that all methods record entries
+func TestLoggerRecords(t *testing.T) {
+	logs := NewLogger(tslog.DebugLevel)
+
+	logs.Debug("debug ", 1)
+	logs.Info("info")
+	logs.Warn("warn")
+	logs.Error("error")
+	logs.Debugf("debugf %d", 2)
+	logs.Infof("infof %d", 3)
+	logs.Warnf("warnf %d", 4)
+	logs.Errorf("errorf %d", 5)
+	logs.Debugt("debugt", tslog.T{"k": 1})
+	logs.Infot("infot", tslog.T{"k": 2})
+	logs.Warnt("warnt", nil)
+	logs.Errort("errort", tslog.T{"k": 3})
+
+	entries := logs.All()
+	require.Len(t, entries, 12)
+	assert.Equal(t, 12, logs.Len())
+
+	assert.Equal(t, tslog.DebugLevel, entries[0].Level)
+	assert.Equal(t, "debug 1", entries[0].Message)
+	assert.Equal(t, "infof 3", entries[5].Message)
+	assert.Equal(t, tslog.T{"k": 2}, entries[9].Fields)
+	assert.NotNil(t, entries[10].Fields)
+	assert.Equal(t, tslog.ErrorLevel, entries[11].Level)
+	assert.False(t, entries[0].Time.IsZero())
+
+	// Caller points at this test
+	require.True(t, entries[0].Caller.Defined)
+	assert.True(t, strings.HasSuffix(entries[0].Caller.File, "observer_test.go"), entries[0].Caller.File)
+	assert.Contains(t, entries[0].Caller.Function, "TestLoggerRecords")
+}
+
+// TestLoggerLevel tests level filtering
+func TestLoggerLevel(t *testing.T) {
+	logs := NewLogger(tslog.WarnLevel)
+	logs.Debug("no")
+	logs.Info("no")
+	logs.Warn("yes")
+	logs.Error("yes")
+	assert.Equal(t, 2, logs.Len())
+
+	none := NewLogger(tslog.NoneLevel)
+	none.Error("no")
+	assert.Equal(t, 0, none.Len())
+}
+
+// TestLoggerFilters tests filtering, taking and resetting entries
+func TestLoggerFilters(t *testing.T) {
+	logs := NewLogger(tslog.DebugLevel)
+	logs.Info("request started")
+	logs.Warn("slow request")
+	logs.Info("request finished")
+
+	assert.Len(t, logs.FilterByLevel(tslog.InfoLevel), 2)
+	assert.Len(t, logs.FilterByLevel(tslog.ErrorLevel), 0)
+	assert.Len(t, logs.FilterMessage("request"), 3)
+	assert.Len(t, logs.FilterMessage("slow"), 1)
+
+	taken := logs.TakeAll()
+	assert.Len(t, taken, 3)
+	assert.Equal(t, 0, logs.Len())
+
+	logs.Info("again")
+	logs.Reset()
+	assert.Empty(t, logs.All())
+}
+
+// TestAssertLogged tests the AssertLogged helper
+func TestAssertLogged(t *testing.T) {
+	logs := NewLogger(tslog.DebugLevel)
+	logs.Errort("connection refused by db", tslog.T{"host": "db", "port": 5432})
+
+	t.Run("Match", func(t *testing.T) {
+		ft := &fakeT{}
+		assert.True(t, logs.AssertLogged(ft, tslog.ErrorLevel, "connection refused", tslog.T{"host": "db"}))
+		assert.True(t, logs.AssertLogged(ft, tslog.ErrorLevel, "", nil))
+		assert.Empty(t, ft.errors)
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			lvl    tslog.Level
+			msg    string
+			fields tslog.T
+		}{
+			{"Level", tslog.WarnLevel, "connection refused", nil},
+			{"Message", tslog.ErrorLevel, "timeout", nil},
+			{"FieldValue", tslog.ErrorLevel, "", tslog.T{"port": "5432"}},
+			{"MissingField", tslog.ErrorLevel, "", tslog.T{"user": "x"}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				ft := &fakeT{}
+				assert.False(t, logs.AssertLogged(ft, tt.lvl, tt.msg, tt.fields))
+				require.Len(t, ft.errors, 1)
+				assert.Contains(t, ft.errors[0], "connection refused by db")
+			})
+		}
+	})
+}
+
+// TestDriver tests using the Logger as a tslog driver
+func TestDriver(t *testing.T) {
+	logs := NewLogger(tslog.DebugLevel)
+	logger := tslog.NewLogger(
+		tslog.WithDriver(logs.Driver()),
+		tslog.WithWriter(io.Discard),
+		tslog.WithLevel(tslog.InfoLevel),
+		tslog.WithRedaction(tslog.RedactionRules{
+			Rules: []tslog.RedactionRule{{Key: "password"}},
+		}),
+	)
+
+	logger.Debug("filtered")
+	logger.Infot("login", tslog.T{"user": "john", "password": "hunter2"})
+
+	entries := logs.All()
+	require.Len(t, entries, 1)
+	logs.AssertLogged(t, tslog.InfoLevel, "login", tslog.T{"password": tslog.DefaultRedactionMask})
+
+	// Caller skips the wrapping loggers
+	assert.True(t, strings.HasSuffix(entries[0].Caller.File, "observer_test.go"), entries[0].Caller.File)
+}
+
+// TestLoggerConcurrency tests concurrent logging and reading
+func TestLoggerConcurrency(t *testing.T) {
+	logs := NewLogger(tslog.DebugLevel)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				logs.Infot("message", tslog.T{"goroutine": id})
+				_ = logs.FilterByLevel(tslog.InfoLevel)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1000, logs.Len())
+}
@@ -0,0 +1,189 @@
+// Package tslogtest provides loggers for testing code that logs through tslog.
+// This file contains a logger that writes entries through testing.TB, so that
+// they show up under the test that produced them.
+package tslogtest
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/tinystack/tslog"
+)
+
+// TBOption configures a logger created by NewTBLogger.
+type TBOption func(*tbLogger)
+
+// WithLevel sets the minimum level of entries written to the test log.
+// Defaults to tslog.DebugLevel.
+func WithLevel(lvl tslog.Level) TBOption {
+	return func(l *tbLogger) {
+		l.lvl = lvl
+	}
+}
+
+// FailOnError makes Error level entries fail the test, as if they were
+// reported with t.Error. Disabled by default.
+func FailOnError(fail bool) TBOption {
+	return func(l *tbLogger) {
+		l.failOnError = fail
+	}
+}
+
+// tbLogger is a tslog.Logger writing entries with t.Log.
+type tbLogger struct {
+	t           testing.TB
+	lvl         tslog.Level
+	failOnError bool
+
+	mutex sync.Mutex
+	done  bool // Set once the test has finished
+}
+
+// NewTBLogger creates a logger that writes each entry with t.Log, so the
+// output is attributed to the test and only shown when it fails or runs
+// with -v. The file:line reported with each entry is that of the code
+// calling the logger.
+//
+// Entries logged after the test has finished, for example by goroutines
+// that outlive it, are discarded instead of causing a panic.
+//
+// Example:
+//
+//	func TestServer(t *testing.T) {
+//	    srv := NewServer(tslogtest.NewTBLogger(t, tslogtest.FailOnError(true)))
+//	    ...
+//	}
+func NewTBLogger(t testing.TB, opts ...TBOption) tslog.Logger {
+	l := &tbLogger{
+		t:   t,
+		lvl: tslog.DebugLevel,
+	}
+	for _, f := range opts {
+		if f != nil {
+			f(l)
+		}
+	}
+
+	t.Cleanup(func() {
+		l.mutex.Lock()
+		l.done = true
+		l.mutex.Unlock()
+	})
+	return l
+}
+
+// Debug logs a message at Debug level.
+func (l *tbLogger) Debug(args ...any) {
+	l.t.Helper()
+	l.log(tslog.DebugLevel, fmt.Sprint(args...), nil)
+}
+
+// Info logs a message at Info level.
+func (l *tbLogger) Info(args ...any) {
+	l.t.Helper()
+	l.log(tslog.InfoLevel, fmt.Sprint(args...), nil)
+}
+
+// Warn logs a message at Warn level.
+func (l *tbLogger) Warn(args ...any) {
+	l.t.Helper()
+	l.log(tslog.WarnLevel, fmt.Sprint(args...), nil)
+}
+
+// Error logs a message at Error level.
+func (l *tbLogger) Error(args ...any) {
+	l.t.Helper()
+	l.log(tslog.ErrorLevel, fmt.Sprint(args...), nil)
+}
+
+// Debugf logs a formatted message at Debug level.
+func (l *tbLogger) Debugf(format string, args ...any) {
+	l.t.Helper()
+	l.log(tslog.DebugLevel, fmt.Sprintf(format, args...), nil)
+}
+
+// Infof logs a formatted message at Info level.
+func (l *tbLogger) Infof(format string, args ...any) {
+	l.t.Helper()
+	l.log(tslog.InfoLevel, fmt.Sprintf(format, args...), nil)
+}
+
+// Warnf logs a formatted message at Warn level.
+func (l *tbLogger) Warnf(format string, args ...any) {
+	l.t.Helper()
+	l.log(tslog.WarnLevel, fmt.Sprintf(format, args...), nil)
+}
+
+// Errorf logs a formatted message at Error level.
+func (l *tbLogger) Errorf(format string, args ...any) {
+	l.t.Helper()
+	l.log(tslog.ErrorLevel, fmt.Sprintf(format, args...), nil)
+}
+
+// Debugt logs a message with structured fields at Debug level.
+func (l *tbLogger) Debugt(msg string, args tslog.T) {
+	l.t.Helper()
+	l.log(tslog.DebugLevel, msg, args)
+}
+
+// Infot logs a message with structured fields at Info level.
+func (l *tbLogger) Infot(msg string, args tslog.T) {
+	l.t.Helper()
+	l.log(tslog.InfoLevel, msg, args)
+}
+
+// Warnt logs a message with structured fields at Warn level.
+func (l *tbLogger) Warnt(msg string, args tslog.T) {
+	l.t.Helper()
+	l.log(tslog.WarnLevel, msg, args)
+}
+
+// Errort logs a message with structured fields at Error level.
+func (l *tbLogger) Errort(msg string, args tslog.T) {
+	l.t.Helper()
+	l.log(tslog.ErrorLevel, msg, args)
+}
+
+// log writes the entry to the test log unless the test has finished.
+func (l *tbLogger) log(lvl tslog.Level, msg string, fields tslog.T) {
+	l.t.Helper()
+	if !enabled(l.lvl, lvl) {
+		return
+	}
+
+	line := formatEntry(lvl, msg, fields)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.done {
+		return
+	}
+
+	if l.failOnError && lvl == tslog.ErrorLevel {
+		l.t.Error(line)
+		return
+	}
+	l.t.Log(line)
+}
+
+// formatEntry renders an entry as "LEVEL message key=value ...", with the
+// fields sorted by key.
+func formatEntry(lvl tslog.Level, msg string, fields tslog.T) string {
+	var b strings.Builder
+	b.WriteString(strings.ToUpper(lvl.String()))
+	b.WriteByte('\t')
+	b.WriteString(msg)
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "\t%s=%v", k, fields[k])
+	}
+	return b.String()
+}
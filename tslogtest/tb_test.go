@@ -0,0 +1,130 @@
+package tslogtest
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tinystack/tslog"
+)
+
+// fakeTB captures what a logger writes through testing.TB
+type fakeTB struct {
+	testing.TB // Only to satisfy the interface; calling other methods panics
+
+	mutex    sync.Mutex
+	logs     []string
+	errors   []string
+	helpers  int
+	cleanups []func()
+}
+
+func (f *fakeTB) Log(args ...any) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.logs = append(f.logs, fmt.Sprint(args...))
+}
+
+func (f *fakeTB) Error(args ...any) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.errors = append(f.errors, fmt.Sprint(args...))
+}
+
+func (f *fakeTB) Helper() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.helpers++
+}
+
+func (f *fakeTB) Cleanup(fn func()) {
+	f.cleanups = append(f.cleanups, fn)
+}
+
+// finish runs the registered cleanups like the testing package does
+func (f *fakeTB) finish() {
+	for i := len(f.cleanups) - 1; i >= 0; i-- {
+		f.cleanups[i]()
+	}
+}
+
+// TestNewTBLogger tests writing entries through testing.TB
+func TestNewTBLogger(t *testing.T) {
+	t.Run("Writes", func(t *testing.T) {
+		tb := &fakeTB{}
+		logger := NewTBLogger(tb)
+
+		logger.Debug("debug")
+		logger.Infof("user %d", 42)
+		logger.Warnt("slow", tslog.T{"b": 2, "a": "x"})
+		logger.Error("failed")
+
+		require.Len(t, tb.logs, 4)
+		assert.Equal(t, "DEBUG\tdebug", tb.logs[0])
+		assert.Equal(t, "INFO\tuser 42", tb.logs[1])
+		assert.Equal(t, "WARN\tslow\ta=x\tb=2", tb.logs[2])
+		assert.Equal(t, "ERROR\tfailed", tb.logs[3])
+		assert.Empty(t, tb.errors)
+
+		// Every frame between the caller and t.Log is marked as a helper
+		assert.Equal(t, 8, tb.helpers)
+	})
+
+	t.Run("Level", func(t *testing.T) {
+		tb := &fakeTB{}
+		logger := NewTBLogger(tb, WithLevel(tslog.WarnLevel))
+
+		logger.Info("dropped")
+		logger.Warn("kept")
+
+		assert.Equal(t, []string{"WARN\tkept"}, tb.logs)
+	})
+
+	t.Run("FailOnError", func(t *testing.T) {
+		tb := &fakeTB{}
+		logger := NewTBLogger(tb, FailOnError(true))
+
+		logger.Warn("warning")
+		logger.Errort("failed", tslog.T{"code": 500})
+
+		assert.Equal(t, []string{"WARN\twarning"}, tb.logs)
+		assert.Equal(t, []string{"ERROR\tfailed\tcode=500"}, tb.errors)
+	})
+
+	t.Run("AfterTestFinished", func(t *testing.T) {
+		tb := &fakeTB{}
+		logger := NewTBLogger(tb, FailOnError(true))
+
+		logger.Info("during")
+		tb.finish()
+		logger.Info("after")
+		logger.Error("after")
+
+		assert.Equal(t, []string{"INFO\tduring"}, tb.logs)
+		assert.Empty(t, tb.errors)
+	})
+}
+
+// TestTBLoggerRealTest tests the logger against a real test, including
+// a goroutine that outlives it
+func TestTBLoggerRealTest(t *testing.T) {
+	release := make(chan struct{})
+	done := make(chan struct{})
+
+	t.Run("Inner", func(t *testing.T) {
+		logger := NewTBLogger(t)
+		logger.Infot("from the test", tslog.T{"key": "value"})
+
+		go func() {
+			defer close(done)
+			<-release
+			logger.Info("after the test has completed")
+		}()
+	})
+
+	close(release)
+	<-done
+}
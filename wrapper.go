@@ -0,0 +1,103 @@
+// Package tslog provides the building blocks for loggers that wrap other loggers.
+// This file contains the adapter used by wrapping loggers to receive every
+// log call as a single normalized (level, message, fields) tuple.
+package tslog
+
+import "fmt"
+
+// logFunc handles a single log call that has been normalized to its level,
+// rendered message and structured fields.
+type logFunc func(lvl Level, msg string, fields T)
+
+// funcLogger implements the Logger interface by normalizing every call and
+// passing it to a logFunc. Messages are rendered the same way the drivers
+// render them: fmt.Sprint for the plain methods and fmt.Sprintf for the
+// formatted ones.
+type funcLogger struct {
+	log logFunc
+}
+
+// Debug logs a message at Debug level.
+func (l funcLogger) Debug(args ...any) { l.log(DebugLevel, fmt.Sprint(args...), nil) }
+
+// Info logs a message at Info level.
+func (l funcLogger) Info(args ...any) { l.log(InfoLevel, fmt.Sprint(args...), nil) }
+
+// Warn logs a message at Warn level.
+func (l funcLogger) Warn(args ...any) { l.log(WarnLevel, fmt.Sprint(args...), nil) }
+
+// Error logs a message at Error level.
+func (l funcLogger) Error(args ...any) { l.log(ErrorLevel, fmt.Sprint(args...), nil) }
+
+// Debugf logs a formatted message at Debug level.
+func (l funcLogger) Debugf(format string, args ...any) {
+	l.log(DebugLevel, fmt.Sprintf(format, args...), nil)
+}
+
+// Infof logs a formatted message at Info level.
+func (l funcLogger) Infof(format string, args ...any) {
+	l.log(InfoLevel, fmt.Sprintf(format, args...), nil)
+}
+
+// Warnf logs a formatted message at Warn level.
+func (l funcLogger) Warnf(format string, args ...any) {
+	l.log(WarnLevel, fmt.Sprintf(format, args...), nil)
+}
+
+// Errorf logs a formatted message at Error level.
+func (l funcLogger) Errorf(format string, args ...any) {
+	l.log(ErrorLevel, fmt.Sprintf(format, args...), nil)
+}
+
+// Debugt logs a message with structured fields at Debug level.
+func (l funcLogger) Debugt(msg string, args T) { l.log(DebugLevel, msg, args) }
+
+// Infot logs a message with structured fields at Info level.
+func (l funcLogger) Infot(msg string, args T) { l.log(InfoLevel, msg, args) }
+
+// Warnt logs a message with structured fields at Warn level.
+func (l funcLogger) Warnt(msg string, args T) { l.log(WarnLevel, msg, args) }
+
+// Errort logs a message with structured fields at Error level.
+func (l funcLogger) Errort(msg string, args T) { l.log(ErrorLevel, msg, args) }
+
+// logTo writes a normalized entry to l using the structured method that
+// matches lvl. Entries with an unknown level are dropped.
+func logTo(l Logger, lvl Level, msg string, fields T) {
+	switch lvl {
+	case DebugLevel:
+		l.Debugt(msg, fields)
+	case InfoLevel:
+		l.Infot(msg, fields)
+	case WarnLevel:
+		l.Warnt(msg, fields)
+	case ErrorLevel:
+		l.Errort(msg, fields)
+	}
+}
+
+// callerLogger is implemented by loggers that can write an entry on behalf
+// of a call site other than their own caller, such as the summaries the
+// dedupLogger writes when a window closes.
+type callerLogger interface {
+	logCaller(lvl Level, msg string, fields T, caller Caller)
+}
+
+// logCallerTo writes a normalized entry to l as if it was logged at caller.
+// An undefined caller omits the caller information. Loggers that don't
+// implement callerLogger report their own caller as usual.
+func logCallerTo(l Logger, lvl Level, msg string, fields T, caller Caller) {
+	if cl, ok := l.(callerLogger); ok {
+		cl.logCaller(lvl, msg, fields, caller)
+		return
+	}
+	logTo(l, lvl, msg, fields)
+}
+
+// closeLogger closes l if it supports closing.
+func closeLogger(l Logger) error {
+	if c, ok := l.(interface{ Close() error }); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,493 @@
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains an asynchronous writer that moves writes to a
+// background goroutine behind a bounded queue.
+package writer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// OverflowPolicy decides what an AsyncWriter does with an entry when its
+// queue is full.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock blocks the caller until there is room in the queue
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropNewest discards the entry being written
+	OverflowDropNewest
+	// OverflowDropOldest discards the oldest queued entry to make room
+	OverflowDropOldest
+	// OverflowDropBelowLevel discards the entry being written if its level is
+	// below AsyncConfig.MinLevel, and blocks otherwise
+	OverflowDropBelowLevel
+)
+
+// String returns the name of the overflow policy.
+func (p OverflowPolicy) String() string {
+	switch p {
+	case OverflowBlock:
+		return "block"
+	case OverflowDropNewest:
+		return "drop-newest"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowDropBelowLevel:
+		return "drop-below-level"
+	default:
+		return fmt.Sprintf("OverflowPolicy(%d)", int(p))
+	}
+}
+
+// ErrAsyncWriterClosed is returned when writing to a closed AsyncWriter.
+var ErrAsyncWriterClosed = errors.New("writer: async writer is closed")
+
+// ErrCloseTimeout is returned by AsyncWriter.Close and WebhookWriter.Close
+// when the pending entries could not be written within their CloseTimeout.
+var ErrCloseTimeout = errors.New("writer: timed out flushing on close")
+
+// AsyncConfig holds configuration for an asynchronous writer.
+type AsyncConfig struct {
+	// QueueSize is the maximum number of entries waiting to be written.
+	// Defaults to 1024 if not specified.
+	QueueSize int
+
+	// BatchSize is the maximum number of entries written to the underlying
+	// writer with a single Write call. Defaults to 64 if not specified.
+	BatchSize int
+
+	// WriteEntries writes each entry of a batch with its own Write call,
+	// for underlying writers that treat each Write call as one entry. It is
+	// always on for such writers of this package: NetWriter, SyslogWriter,
+	// JournaldWriter, FailoverWriter, SpoolWriter, WebhookWriter and the
+	// AsyncWriter based ones, unless they are wrapped in another writer
+	// such as io.MultiWriter.
+	WriteEntries bool
+
+	// FlushInterval is the maximum time an entry waits for its batch to fill
+	// up before being written. Defaults to 100 milliseconds if not specified.
+	FlushInterval time.Duration
+
+	// Overflow decides what happens when the queue is full.
+	// Defaults to OverflowBlock.
+	Overflow OverflowPolicy
+
+	// MinLevel is the lowest level that is never dropped when Overflow is
+	// OverflowDropBelowLevel. Defaults to LevelWarn if not specified.
+	MinLevel string
+
+	// CloseTimeout is the maximum time Close waits for queued entries to be
+	// written. Defaults to 5 seconds if not specified.
+	CloseTimeout time.Duration
+
+	// OnError is called from the background goroutine when the underlying
+	// writer returns an error. Errors are discarded if it is nil.
+	OnError func(err error)
+}
+
+// Validate checks if the configuration is valid and returns an error if not.
+func (c *AsyncConfig) Validate() error {
+	if c.QueueSize < 0 {
+		return fmt.Errorf("QueueSize cannot be negative")
+	}
+
+	if c.BatchSize < 0 {
+		return fmt.Errorf("BatchSize cannot be negative")
+	}
+
+	if c.FlushInterval < 0 {
+		return fmt.Errorf("FlushInterval cannot be negative")
+	}
+
+	if c.CloseTimeout < 0 {
+		return fmt.Errorf("CloseTimeout cannot be negative")
+	}
+
+	if c.Overflow < OverflowBlock || c.Overflow > OverflowDropBelowLevel {
+		return fmt.Errorf("unknown overflow policy %v", c.Overflow)
+	}
+
+	if c.MinLevel != "" && rankOf(c.MinLevel) == 0 {
+		return fmt.Errorf("unknown MinLevel %q", c.MinLevel)
+	}
+
+	return nil
+}
+
+// setDefaults sets default values for unspecified configuration fields.
+func (c *AsyncConfig) setDefaults() {
+	if c.QueueSize == 0 {
+		c.QueueSize = 1024
+	}
+
+	if c.BatchSize == 0 {
+		c.BatchSize = 64
+	}
+
+	if c.FlushInterval == 0 {
+		c.FlushInterval = 100 * time.Millisecond
+	}
+
+	if c.MinLevel == "" {
+		c.MinLevel = LevelWarn
+	}
+
+	if c.CloseTimeout == 0 {
+		c.CloseTimeout = 5 * time.Second
+	}
+}
+
+// AsyncStats holds counters of an AsyncWriter.
+type AsyncStats struct {
+	// Written is the number of entries passed to the underlying writer
+	Written uint64
+	// Dropped is the number of entries discarded because the queue was full
+	Dropped uint64
+	// Failed is the number of entries the underlying writer returned an error for
+	Failed uint64
+	// Queued is the number of entries currently waiting to be written
+	Queued int
+}
+
+// AsyncWriter is an io.Writer that queues entries and writes them to an
+// underlying writer from a background goroutine, so that a slow destination
+// doesn't stall the logging caller.
+type AsyncWriter struct {
+	w          io.Writer
+	conf       AsyncConfig
+	writeBatch func(batch [][]byte) error // Writes a batch, which it must not retain
+	buf        bytes.Buffer               // Used by writeBatch to concatenate entries
+
+	queue   chan []byte
+	flushCh chan chan struct{}
+	closing chan struct{} // Closed when Close starts, releases blocked writers
+	quit    chan struct{} // Closed once no more entries can be queued
+	done    chan struct{} // Closed when the background goroutine exits
+	expired chan struct{} // Closed when Close times out, stops retries
+
+	mutex     sync.RWMutex // Held for reading while enqueuing, for writing while closing
+	closed    bool
+	closeOnce sync.Once
+
+	written uint64
+	dropped uint64
+	failed  uint64
+}
+
+// NewAsyncWriter creates a writer that queues entries and writes them to w in
+// batches from a background goroutine. When the queue is full, the configured
+// overflow policy decides whether the caller blocks or an entry is dropped.
+//
+// Each Write call is treated as one entry, which is how the tslog drivers
+// write. The data is copied, so callers may reuse their buffers. Batches
+// are written to w with a single Write call, which suits files, pipes and
+// other byte streams. Entries are written one by one instead if
+// AsyncConfig.WriteEntries is set or w is one of the writers of this
+// package that treat each Write call as one entry.
+//
+// Close must be called to drain the queue and stop the background goroutine.
+// It doesn't close w.
+//
+// Example:
+//
+//	file := writer.MustNewLumberJackWriter(writer.LumberJackConfig{FilePath: "/var/log/app.log"})
+//	async, err := writer.NewAsyncWriter(file, writer.AsyncConfig{
+//	    QueueSize: 4096,
+//	    Overflow:  writer.OverflowDropBelowLevel,
+//	    MinLevel:  writer.LevelWarn,
+//	})
+//	defer async.Close()
+//	logger := tslog.NewLogger(tslog.WithWriter(async))
+func NewAsyncWriter(w io.Writer, conf AsyncConfig) (*AsyncWriter, error) {
+	if w == nil {
+		return nil, fmt.Errorf("invalid async config: writer cannot be nil")
+	}
+
+	// Validate configuration
+	if err := conf.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid async config: %w", err)
+	}
+
+	a := newBatchWriter(conf, nil)
+	a.w = w
+	a.writeBatch = a.writeConcatenated
+	if _, ok := w.(entryWriter); ok || conf.WriteEntries {
+		a.writeBatch = a.writeEntries
+	}
+	go a.run()
+
+	return a, nil
+}
+
+// newBatchWriter creates an AsyncWriter passing its batches to writeBatch,
+// for writers sending entries in batches of their own format. The
+// configuration must be valid. The background goroutine isn't started.
+func newBatchWriter(conf AsyncConfig, writeBatch func(batch [][]byte) error) *AsyncWriter {
+	// Apply defaults
+	conf.setDefaults()
+
+	return &AsyncWriter{
+		conf:       conf,
+		writeBatch: writeBatch,
+		queue:      make(chan []byte, conf.QueueSize),
+		flushCh:    make(chan chan struct{}),
+		closing:    make(chan struct{}),
+		quit:       make(chan struct{}),
+		done:       make(chan struct{}),
+		expired:    make(chan struct{}),
+	}
+}
+
+// writesEntries marks the writer as treating each Write call as one entry.
+func (a *AsyncWriter) writesEntries() {}
+
+// Write queues a copy of p to be written by the background goroutine.
+// It never returns an error from the underlying writer; use
+// AsyncConfig.OnError to observe those.
+func (a *AsyncWriter) Write(p []byte) (int, error) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	if a.closed {
+		return 0, ErrAsyncWriterClosed
+	}
+
+	entry := make([]byte, len(p))
+	copy(entry, p)
+
+	// Fast path: there is room in the queue
+	select {
+	case a.queue <- entry:
+		return len(p), nil
+	default:
+	}
+
+	switch a.conf.Overflow {
+	case OverflowDropNewest:
+		atomic.AddUint64(&a.dropped, 1)
+
+	case OverflowDropOldest:
+		for {
+			select {
+			case a.queue <- entry:
+				return len(p), nil
+			default:
+			}
+			select {
+			case <-a.queue:
+				atomic.AddUint64(&a.dropped, 1)
+			default:
+			}
+		}
+
+	case OverflowDropBelowLevel:
+		if rankOf(parseLevel(entry)) < rankOf(a.conf.MinLevel) {
+			atomic.AddUint64(&a.dropped, 1)
+			break
+		}
+		a.enqueue(entry)
+
+	default:
+		a.enqueue(entry)
+	}
+
+	return len(p), nil
+}
+
+// enqueue blocks until entry is queued or the writer starts closing, in
+// which case the entry is dropped.
+func (a *AsyncWriter) enqueue(entry []byte) {
+	select {
+	case a.queue <- entry:
+	case <-a.closing:
+		atomic.AddUint64(&a.dropped, 1)
+	}
+}
+
+// Sync writes all queued entries and syncs the underlying writer if it
+// supports syncing.
+func (a *AsyncWriter) Sync() error {
+	ch := make(chan struct{})
+	select {
+	case a.flushCh <- ch:
+	case <-a.done:
+		return nil
+	}
+	<-ch
+
+	if s, ok := a.w.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+// Close stops accepting entries and waits up to AsyncConfig.CloseTimeout for
+// the queued entries to be written. It returns ErrCloseTimeout if the queue
+// could not be drained in time; the remaining entries are then written in the
+// background for as long as the underlying writer accepts them.
+//
+// Writers blocked on a full queue when Close is called have their entries
+// dropped.
+func (a *AsyncWriter) Close() error {
+	a.closeOnce.Do(func() { close(a.closing) })
+
+	a.mutex.Lock()
+	if a.closed {
+		a.mutex.Unlock()
+		return nil
+	}
+	a.closed = true
+	close(a.quit)
+	a.mutex.Unlock()
+
+	timer := time.NewTimer(a.conf.CloseTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-a.done:
+		return nil
+	case <-timer.C:
+		close(a.expired)
+		return ErrCloseTimeout
+	}
+}
+
+// Stats returns a snapshot of the writer's counters.
+func (a *AsyncWriter) Stats() AsyncStats {
+	return AsyncStats{
+		Written: atomic.LoadUint64(&a.written),
+		Dropped: atomic.LoadUint64(&a.dropped),
+		Failed:  atomic.LoadUint64(&a.failed),
+		Queued:  len(a.queue),
+	}
+}
+
+// run is the background goroutine writing queued entries in batches.
+func (a *AsyncWriter) run() {
+	defer close(a.done)
+
+	var (
+		batch  = make([][]byte, 0, a.conf.BatchSize)
+		timer  *time.Timer
+		timerC <-chan time.Time
+	)
+
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, timerC = nil, nil
+		}
+		if len(batch) == 0 {
+			return
+		}
+
+		if err := a.writeBatch(batch); err != nil {
+			failed := len(batch)
+			var entriesErr *entriesError
+			if errors.As(err, &entriesErr) {
+				failed, err = entriesErr.failed, entriesErr.err
+			}
+			atomic.AddUint64(&a.failed, uint64(failed))
+			atomic.AddUint64(&a.written, uint64(len(batch)-failed))
+			if a.conf.OnError != nil {
+				a.conf.OnError(err)
+			}
+		} else {
+			atomic.AddUint64(&a.written, uint64(len(batch)))
+		}
+		batch = batch[:0]
+	}
+
+	add := func(entry []byte) {
+		batch = append(batch, entry)
+		if len(batch) >= a.conf.BatchSize {
+			flush()
+		} else if timer == nil {
+			timer = time.NewTimer(a.conf.FlushInterval)
+			timerC = timer.C
+		}
+	}
+
+	// drain moves every entry currently queued into batches
+	drain := func() {
+		for {
+			select {
+			case entry := <-a.queue:
+				add(entry)
+			default:
+				flush()
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case entry := <-a.queue:
+			add(entry)
+		case <-timerC:
+			timer, timerC = nil, nil
+			flush()
+		case ch := <-a.flushCh:
+			drain()
+			close(ch)
+		case <-a.quit:
+			drain()
+			return
+		}
+	}
+}
+
+// writeConcatenated writes the entries of batch to the underlying writer
+// with a single Write call.
+func (a *AsyncWriter) writeConcatenated(batch [][]byte) error {
+	a.buf.Reset()
+	for _, entry := range batch {
+		a.buf.Write(entry)
+	}
+
+	_, err := a.w.Write(a.buf.Bytes())
+	return err
+}
+
+// writeEntries writes the entries of batch to the underlying writer with a
+// Write call each. A failed entry doesn't stop the others from being
+// written.
+func (a *AsyncWriter) writeEntries(batch [][]byte) error {
+	var entriesErr *entriesError
+	for _, entry := range batch {
+		if _, err := a.w.Write(entry); err != nil {
+			if entriesErr == nil {
+				entriesErr = &entriesError{err: err}
+			}
+			entriesErr.failed++
+		}
+	}
+	if entriesErr == nil {
+		return nil
+	}
+	return entriesErr
+}
+
+// entriesError is returned by writeEntries when some entries of a batch
+// failed.
+type entriesError struct {
+	failed int   // Number of failed entries
+	err    error // Error of the first failed entry
+}
+
+// Error returns the error of the first failed entry.
+func (e *entriesError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the error of the first failed entry.
+func (e *entriesError) Unwrap() error {
+	return e.err
+}
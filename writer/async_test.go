@@ -0,0 +1,393 @@
+package writer
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use that counts writes
+type syncBuffer struct {
+	mutex  sync.Mutex
+	buf    bytes.Buffer
+	writes int
+	synced int
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.writes++
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Sync() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.synced++
+	return nil
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+// blockingWriter blocks every write until release is closed
+type blockingWriter struct {
+	syncBuffer
+	release chan struct{}
+}
+
+func newBlockingWriter() *blockingWriter {
+	return &blockingWriter{release: make(chan struct{})}
+}
+
+func (b *blockingWriter) Write(p []byte) (int, error) {
+	<-b.release
+	return b.syncBuffer.Write(p)
+}
+
+// TestAsyncConfig tests validation and defaults of AsyncConfig
+func TestAsyncConfig(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		var config AsyncConfig
+		require.NoError(t, config.Validate())
+		config.setDefaults()
+
+		assert.Equal(t, 1024, config.QueueSize)
+		assert.Equal(t, 64, config.BatchSize)
+		assert.Equal(t, 100*time.Millisecond, config.FlushInterval)
+		assert.Equal(t, LevelWarn, config.MinLevel)
+		assert.Equal(t, 5*time.Second, config.CloseTimeout)
+		assert.Equal(t, OverflowBlock, config.Overflow)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			config AsyncConfig
+			errMsg string
+		}{
+			{"NegativeQueueSize", AsyncConfig{QueueSize: -1}, "QueueSize cannot be negative"},
+			{"NegativeBatchSize", AsyncConfig{BatchSize: -1}, "BatchSize cannot be negative"},
+			{"NegativeFlushInterval", AsyncConfig{FlushInterval: -1}, "FlushInterval cannot be negative"},
+			{"NegativeCloseTimeout", AsyncConfig{CloseTimeout: -1}, "CloseTimeout cannot be negative"},
+			{"UnknownPolicy", AsyncConfig{Overflow: 42}, "unknown overflow policy"},
+			{"UnknownLevel", AsyncConfig{MinLevel: "fatalish"}, "unknown MinLevel"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := tt.config.Validate()
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errMsg)
+			})
+		}
+	})
+}
+
+// TestNewAsyncWriter tests creating an async writer
+func TestNewAsyncWriter(t *testing.T) {
+	t.Run("NilWriter", func(t *testing.T) {
+		w, err := NewAsyncWriter(nil, AsyncConfig{})
+		assert.Error(t, err)
+		assert.Nil(t, w)
+	})
+
+	t.Run("InvalidConfig", func(t *testing.T) {
+		w, err := NewAsyncWriter(&syncBuffer{}, AsyncConfig{QueueSize: -1})
+		assert.Error(t, err)
+		assert.Nil(t, w)
+		assert.Contains(t, err.Error(), "invalid async config")
+	})
+}
+
+// TestAsyncWriterWrite tests batching and draining
+func TestAsyncWriterWrite(t *testing.T) {
+	t.Run("CloseDrains", func(t *testing.T) {
+		out := &syncBuffer{}
+		w, err := NewAsyncWriter(out, AsyncConfig{BatchSize: 10, FlushInterval: time.Hour})
+		require.NoError(t, err)
+
+		buf := []byte("line 0\n")
+		for i := 0; i < 25; i++ {
+			buf[5] = byte('0' + i%10)
+			n, err := w.Write(buf)
+			assert.NoError(t, err)
+			assert.Equal(t, len(buf), n)
+		}
+
+		require.NoError(t, w.Close())
+		assert.Equal(t, 25, strings.Count(out.String(), "\n"))
+		// Buffers are copied, so reuse by the caller is safe
+		assert.True(t, strings.HasPrefix(out.String(), "line 0\nline 1\n"))
+		assert.Equal(t, uint64(25), w.Stats().Written)
+		// 25 entries with a batch size of 10 take at least 3 writes
+		assert.LessOrEqual(t, out.writes, 25)
+		assert.GreaterOrEqual(t, out.writes, 3)
+
+		_, err = w.Write(buf)
+		assert.ErrorIs(t, err, ErrAsyncWriterClosed)
+		assert.NoError(t, w.Close())
+	})
+
+	t.Run("FlushInterval", func(t *testing.T) {
+		out := &syncBuffer{}
+		w, err := NewAsyncWriter(out, AsyncConfig{BatchSize: 100, FlushInterval: 10 * time.Millisecond})
+		require.NoError(t, err)
+		defer w.Close()
+
+		_, _ = w.Write([]byte("hello\n"))
+		assert.Eventually(t, func() bool {
+			return out.String() == "hello\n"
+		}, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("Sync", func(t *testing.T) {
+		out := &syncBuffer{}
+		w, err := NewAsyncWriter(out, AsyncConfig{BatchSize: 100, FlushInterval: time.Hour})
+		require.NoError(t, err)
+		defer w.Close()
+
+		_, _ = w.Write([]byte("hello\n"))
+		require.NoError(t, w.Sync())
+		assert.Equal(t, "hello\n", out.String())
+		assert.Equal(t, 1, out.synced)
+	})
+
+	t.Run("EntryWriters", func(t *testing.T) {
+		inner := &flakyWriter{}
+		w, err := NewAsyncWriter(MustNewFailoverWriter(inner), AsyncConfig{BatchSize: 10, FlushInterval: time.Hour})
+		require.NoError(t, err)
+
+		for _, line := range entryLines(1, 3) {
+			_, err := w.Write([]byte(line))
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.Close())
+		// The failover writer gets one entry per Write call
+		assert.Equal(t, entryLines(1, 3), inner.received())
+	})
+
+	t.Run("WriteEntries", func(t *testing.T) {
+		inner := &pickyWriter{}
+		var errs []error
+		w, err := NewAsyncWriter(inner, AsyncConfig{
+			BatchSize:     10,
+			FlushInterval: time.Hour,
+			WriteEntries:  true,
+			OnError:       func(err error) { errs = append(errs, err) },
+		})
+		require.NoError(t, err)
+
+		for _, line := range []string{"entry 1\n", "bad\n", "entry 2\n"} {
+			_, err := w.Write([]byte(line))
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.Close())
+		assert.Equal(t, []string{"entry 1\n", "entry 2\n"}, inner.received())
+		assert.Equal(t, AsyncStats{Written: 2, Failed: 1}, w.Stats())
+		require.Len(t, errs, 1)
+		assert.EqualError(t, errs[0], "bad entry")
+	})
+
+	t.Run("OnError", func(t *testing.T) {
+		errCh := make(chan error, 1)
+		w, err := NewAsyncWriter(errorWriter{}, AsyncConfig{
+			OnError: func(err error) { errCh <- err },
+		})
+		require.NoError(t, err)
+
+		_, _ = w.Write([]byte("hello\n"))
+		require.NoError(t, w.Close())
+		assert.EqualError(t, <-errCh, "write failed")
+		assert.Equal(t, uint64(1), w.Stats().Failed)
+	})
+}
+
+// errorWriter fails every write
+type errorWriter struct{}
+
+func (errorWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+// pickyWriter records entries, and fails those containing "bad"
+type pickyWriter struct {
+	flakyWriter
+}
+
+// Write fails p if it contains "bad", and records it otherwise
+func (w *pickyWriter) Write(p []byte) (int, error) {
+	if strings.Contains(string(p), "bad") {
+		return 0, errors.New("bad entry")
+	}
+	return w.flakyWriter.Write(p)
+}
+
+// TestAsyncWriterOverflow tests the overflow policies
+func TestAsyncWriterOverflow(t *testing.T) {
+	// fill writes entries until the queue of w is full and the background
+	// goroutine is stuck writing the first one
+	fill := func(t *testing.T, w *AsyncWriter, size int) {
+		_, _ = w.Write([]byte(`{"level":"INFO","msg":"stuck"}` + "\n"))
+		require.Eventually(t, func() bool { return w.Stats().Queued == 0 }, time.Second, time.Millisecond)
+		for i := 0; i < size; i++ {
+			_, _ = w.Write([]byte(`{"level":"INFO","msg":"queued"}` + "\n"))
+		}
+		require.Equal(t, size, w.Stats().Queued)
+	}
+
+	t.Run("DropNewest", func(t *testing.T) {
+		out := newBlockingWriter()
+		w, err := NewAsyncWriter(out, AsyncConfig{QueueSize: 2, BatchSize: 1, Overflow: OverflowDropNewest})
+		require.NoError(t, err)
+		fill(t, w, 2)
+
+		_, err = w.Write([]byte(`{"level":"ERROR","msg":"newest"}` + "\n"))
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(1), w.Stats().Dropped)
+
+		close(out.release)
+		require.NoError(t, w.Close())
+		assert.NotContains(t, out.String(), "newest")
+		assert.Equal(t, 3, strings.Count(out.String(), "\n"))
+	})
+
+	t.Run("DropOldest", func(t *testing.T) {
+		out := newBlockingWriter()
+		w, err := NewAsyncWriter(out, AsyncConfig{QueueSize: 2, BatchSize: 1, Overflow: OverflowDropOldest})
+		require.NoError(t, err)
+		fill(t, w, 2)
+
+		_, err = w.Write([]byte(`{"level":"ERROR","msg":"newest"}` + "\n"))
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(1), w.Stats().Dropped)
+
+		close(out.release)
+		require.NoError(t, w.Close())
+		assert.Contains(t, out.String(), "newest")
+		assert.Equal(t, 3, strings.Count(out.String(), "\n"))
+	})
+
+	t.Run("DropBelowLevel", func(t *testing.T) {
+		out := newBlockingWriter()
+		w, err := NewAsyncWriter(out, AsyncConfig{
+			QueueSize: 2,
+			BatchSize: 1,
+			Overflow:  OverflowDropBelowLevel,
+			MinLevel:  LevelWarn,
+		})
+		require.NoError(t, err)
+		fill(t, w, 2)
+
+		// Below MinLevel: dropped
+		_, err = w.Write([]byte(`{"level":"INFO","msg":"dropped"}` + "\n"))
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(1), w.Stats().Dropped)
+
+		// At MinLevel: blocks until there is room
+		written := make(chan struct{})
+		go func() {
+			_, _ = w.Write([]byte(`{"level":"ERROR","msg":"kept"}` + "\n"))
+			close(written)
+		}()
+
+		select {
+		case <-written:
+			t.Fatal("write should block while the queue is full")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		close(out.release)
+		<-written
+		require.NoError(t, w.Close())
+		assert.Contains(t, out.String(), "kept")
+		assert.NotContains(t, out.String(), "dropped")
+	})
+
+	t.Run("CloseTimeout", func(t *testing.T) {
+		out := newBlockingWriter()
+		w, err := NewAsyncWriter(out, AsyncConfig{
+			QueueSize:    1,
+			BatchSize:    1,
+			CloseTimeout: 10 * time.Millisecond,
+		})
+		require.NoError(t, err)
+		fill(t, w, 1)
+
+		// A writer blocked on the full queue is released by Close
+		blocked := make(chan struct{})
+		go func() {
+			_, _ = w.Write([]byte("blocked\n"))
+			close(blocked)
+		}()
+		time.Sleep(10 * time.Millisecond)
+
+		assert.ErrorIs(t, w.Close(), ErrCloseTimeout)
+		<-blocked
+		assert.Equal(t, uint64(1), w.Stats().Dropped)
+		close(out.release)
+	})
+}
+
+// TestParseLevel tests level detection in encoded entries
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name     string
+		entry    string
+		expected string
+	}{
+		{"JSON", `{"level":"WARN","msg":"x"}`, LevelWarn},
+		{"JSONLower", `{"level":"debug","msg":"x"}`, LevelDebug},
+		{"JSONNoLevel", `{"msg":"x"}`, ""},
+		{"Console", "2024-01-01T00:00:00Z\tERROR\tmsg\n", LevelError},
+		{"ConsoleColor", "2024-01-01T00:00:00Z\t\x1b[34mINFO\x1b[0m\tmsg\n", LevelInfo},
+		{"Garbage", "hello world", ""},
+		{"Empty", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, parseLevel([]byte(tt.entry)))
+		})
+	}
+}
+
+// TestSplitEntries tests splitting batches of encoded entries
+func TestSplitEntries(t *testing.T) {
+	tests := []struct {
+		name     string
+		batch    string
+		expected []string
+	}{
+		{"Single", `{"msg":"a"}` + "\n", []string{`{"msg":"a"}` + "\n"}},
+		{"JSON", `{"msg":"a"}` + "\n" + `{"msg":"b"}`, []string{`{"msg":"a"}` + "\n", `{"msg":"b"}`}},
+		{
+			"ConsoleStacktrace",
+			"t\tERROR\tfailed\nmain.main()\n\tmain.go:10\nt\tINFO\tdone\n",
+			[]string{"t\tERROR\tfailed\nmain.main()\n\tmain.go:10\n", "t\tINFO\tdone\n"},
+		},
+		{"NoLevel", "first\nsecond\n", []string{"first\nsecond\n"}},
+		{"EmptyLines", "\n" + `{"msg":"a"}` + "\n\n", []string{`{"msg":"a"}` + "\n\n"}},
+		{"Empty", "\n", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var entries []string
+			for _, e := range splitEntries([]byte(tt.batch)) {
+				entries = append(entries, string(e))
+			}
+			assert.Equal(t, tt.expected, entries)
+		})
+	}
+}
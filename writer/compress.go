@@ -0,0 +1,154 @@
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains the pluggable compression of rotated log files.
+package writer
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// compressionExtensions are the extensions of compressed log files that
+// retention recognizes in addition to the configured one, so files written
+// before switching formats, or by a custom Compressor, are still cleaned up.
+var compressionExtensions = []string{".gz", ".zst", ".xz", ".bz2", ".lz4"}
+
+// Compressor compresses rotated log files. GzipCompressor, ZstdCompressor
+// and XzCompressor return the built-in formats; other formats can be
+// plugged in with a Compressor wrapping their encoder:
+//
+//	writer.Compressor{
+//	    Extension: ".zz",
+//	    NewWriter: func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) },
+//	}
+type Compressor struct {
+	// Extension is appended to the names of compressed files, including
+	// the leading dot, such as ".zst".
+	Extension string
+
+	// NewWriter returns a writer that compresses into w. Closing it must
+	// flush all compressed data to w without closing w.
+	NewWriter func(w io.Writer) io.WriteCloser
+
+	err error // Invalid arguments of the constructor
+}
+
+// GzipCompressor returns a Compressor producing ".gz" files with the given
+// compression level, from gzip.HuffmanOnly to gzip.BestCompression.
+func GzipCompressor(level int) Compressor {
+	if _, err := gzip.NewWriterLevel(io.Discard, level); err != nil {
+		return Compressor{err: err}
+	}
+
+	return Compressor{
+		Extension: ".gz",
+		NewWriter: func(w io.Writer) io.WriteCloser {
+			gz, _ := gzip.NewWriterLevel(w, level)
+			return gz
+		},
+	}
+}
+
+// ZstdCompressor returns a Compressor producing ".zst" files with the given
+// zstd compression level, from 1 (fastest) to 22 (best compression). The
+// levels are mapped to the closest level of the encoder, which supports
+// fewer of them.
+func ZstdCompressor(level int) Compressor {
+	if level < 1 || level > 22 {
+		return Compressor{err: fmt.Errorf("zstd: invalid compression level: %d", level)}
+	}
+
+	return Compressor{
+		Extension: ".zst",
+		NewWriter: func(w io.Writer) io.WriteCloser {
+			// One goroutine is enough for compressing in the background
+			enc, _ := zstd.NewWriter(w,
+				zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+				zstd.WithEncoderConcurrency(1))
+			return enc
+		},
+	}
+}
+
+// XzCompressor returns a Compressor producing ".xz" files with the default
+// settings of the xz format.
+func XzCompressor() Compressor {
+	return Compressor{
+		Extension: ".xz",
+		NewWriter: func(w io.Writer) io.WriteCloser {
+			xw, _ := xz.NewWriter(w)
+			return xw
+		},
+	}
+}
+
+// isZero reports whether no compressor has been configured.
+func (c Compressor) isZero() bool {
+	return c.Extension == "" && c.NewWriter == nil && c.err == nil
+}
+
+// orDefault returns c, or gzip with the default level if c is zero.
+func (c Compressor) orDefault() Compressor {
+	if c.isZero() {
+		return GzipCompressor(gzip.DefaultCompression)
+	}
+	return c
+}
+
+// validate checks that a configured compressor is usable.
+func (c Compressor) validate() error {
+	if c.isZero() {
+		return nil
+	}
+
+	if c.err != nil {
+		return c.err
+	}
+
+	if len(c.Extension) < 2 || c.Extension[0] != '.' || strings.ContainsAny(c.Extension, `/\`) {
+		return fmt.Errorf("compressor extension %q must be a dot followed by a name", c.Extension)
+	}
+
+	if c.NewWriter == nil {
+		return fmt.Errorf("compressor NewWriter cannot be nil")
+	}
+
+	return nil
+}
+
+// extensions returns the compression extensions recognized with c,
+// starting with its own.
+func (c Compressor) extensions() []string {
+	exts := []string{c.Extension}
+	for _, ext := range compressionExtensions {
+		if ext != c.Extension {
+			exts = append(exts, ext)
+		}
+	}
+	return exts
+}
+
+// compressorOf returns the compressor to use for rotated files, or nil if
+// compress is false.
+func compressorOf(compress bool, c Compressor) *Compressor {
+	if !compress {
+		return nil
+	}
+	c = c.orDefault()
+	return &c
+}
+
+// compressionExt returns the compression extension name ends with, or an
+// empty string if it isn't compressed.
+func compressionExt(name string, exts []string) string {
+	for _, ext := range exts {
+		if ext != "" && strings.HasSuffix(name, ext) {
+			return ext
+		}
+	}
+	return ""
+}
@@ -0,0 +1,210 @@
+package writer
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// zlibCompressor is a user-supplied compressor for tests
+var zlibCompressor = Compressor{
+	Extension: ".zz",
+	NewWriter: func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) },
+}
+
+// TestCompressor tests compressor construction and validation
+func TestCompressor(t *testing.T) {
+	t.Run("GzipLevels", func(t *testing.T) {
+		for _, level := range []int{gzip.HuffmanOnly, gzip.DefaultCompression, gzip.BestSpeed, gzip.BestCompression} {
+			c := GzipCompressor(level)
+			assert.NoError(t, c.validate())
+			assert.Equal(t, ".gz", c.Extension)
+		}
+
+		c := GzipCompressor(42)
+		assert.Error(t, c.validate())
+	})
+
+	t.Run("ZstdLevels", func(t *testing.T) {
+		for _, level := range []int{1, 3, 19, 22} {
+			c := ZstdCompressor(level)
+			assert.NoError(t, c.validate())
+			assert.Equal(t, ".zst", c.Extension)
+		}
+
+		assert.Error(t, ZstdCompressor(0).validate())
+		assert.Error(t, ZstdCompressor(23).validate())
+	})
+
+	t.Run("Xz", func(t *testing.T) {
+		c := XzCompressor()
+		assert.NoError(t, c.validate())
+		assert.Equal(t, ".xz", c.Extension)
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			compressor Compressor
+			errMsg     string
+		}{
+			{"NoDot", Compressor{Extension: "zst", NewWriter: zlibCompressor.NewWriter}, "must be a dot followed by a name"},
+			{"OnlyDot", Compressor{Extension: ".", NewWriter: zlibCompressor.NewWriter}, "must be a dot followed by a name"},
+			{"Separator", Compressor{Extension: ".a/b", NewWriter: zlibCompressor.NewWriter}, "must be a dot followed by a name"},
+			{"NilWriter", Compressor{Extension: ".zst"}, "NewWriter cannot be nil"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := tt.compressor.validate()
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errMsg)
+			})
+		}
+
+		assert.NoError(t, Compressor{}.validate())
+		assert.NoError(t, zlibCompressor.validate())
+	})
+
+	t.Run("Config", func(t *testing.T) {
+		config := TimeRotatingConfig{FilePattern: "a.log", Compression: GzipCompressor(42)}
+		assert.Contains(t, config.Validate().Error(), "invalid Compression")
+
+		ljConfig := LumberJackConfig{FilePath: "a.log", Compression: Compressor{Extension: ".zst"}}
+		assert.Contains(t, ljConfig.Validate().Error(), "invalid Compression")
+	})
+
+	t.Run("Extensions", func(t *testing.T) {
+		assert.Equal(t, []string{".gz", ".zst", ".xz", ".bz2", ".lz4"}, Compressor{}.orDefault().extensions())
+		assert.Equal(t, []string{".zz", ".gz", ".zst", ".xz", ".bz2", ".lz4"}, zlibCompressor.extensions())
+
+		exts := zlibCompressor.extensions()
+		assert.Equal(t, ".zz", compressionExt("app.log.zz", exts))
+		assert.Equal(t, ".xz", compressionExt("app.log.xz", exts))
+		assert.Equal(t, "", compressionExt("app.log", exts))
+	})
+}
+
+// TestBuiltinCompression tests rotated files compressed with the built-in
+// formats by both rotating writers
+func TestBuiltinCompression(t *testing.T) {
+	compressors := []Compressor{
+		GzipCompressor(gzip.BestCompression),
+		ZstdCompressor(3),
+		ZstdCompressor(19),
+		XzCompressor(),
+	}
+
+	for _, compressor := range compressors {
+		compressor := compressor
+		t.Run("TimeRotatingWriter"+compressor.Extension, func(t *testing.T) {
+			w, c, dir := newTestTimeWriter(t, TimeRotatingConfig{
+				FilePattern: "app-%Y%m%d.log",
+				Compress:    true,
+				Compression: compressor,
+			})
+
+			_, _ = w.Write([]byte("day 1\n"))
+			c.t = c.t.Add(RotateDaily)
+			_, _ = w.Write([]byte("day 2\n"))
+			require.NoError(t, w.Close())
+
+			archive := "app-20240101.log" + compressor.Extension
+			assert.Equal(t, []string{archive, "app-20240102.log"}, logFiles(t, dir))
+			assert.Equal(t, "day 1\n", readFile(t, filepath.Join(dir, archive)))
+		})
+
+		t.Run("LumberJackWriter"+compressor.Extension, func(t *testing.T) {
+			dir := t.TempDir()
+			writer, err := NewLumberJackWriter(LumberJackConfig{
+				FilePath:    filepath.Join(dir, "app.log"),
+				Compress:    true,
+				Compression: compressor,
+			})
+			require.NoError(t, err)
+
+			line := strings.Repeat("x", 64*1024) + "\n"
+			_, err = writer.Write([]byte(line))
+			require.NoError(t, err)
+			require.NoError(t, writer.Rotate())
+			require.NoError(t, writer.Close())
+
+			files, err := filepath.Glob(filepath.Join(dir, "app-*.log"+compressor.Extension))
+			require.NoError(t, err)
+			require.Len(t, files, 1)
+			assert.Equal(t, line, readFile(t, files[0]))
+		})
+	}
+}
+
+// TestCustomCompression tests rotated files compressed with a user-supplied
+// compressor alongside files in other formats
+func TestCustomCompression(t *testing.T) {
+	t.Run("TimeRotatingWriter", func(t *testing.T) {
+		w, c, dir := newTestTimeWriter(t, TimeRotatingConfig{
+			FilePattern:    "app-%Y%m%d.log",
+			MaxRetainFiles: 3,
+			Compress:       true,
+			Compression:    zlibCompressor,
+		})
+
+		// Files compressed before switching formats
+		old := []string{"app-20231229.log.gz", "app-20231230.log.zst", "app-20231231.log.xz"}
+		for i, name := range old {
+			path := filepath.Join(dir, name)
+			require.NoError(t, os.WriteFile(path, nil, 0644))
+			mtime := time.Now().Add(time.Duration(i-10) * time.Hour)
+			require.NoError(t, os.Chtimes(path, mtime, mtime))
+		}
+
+		_, _ = w.Write([]byte("day 1\n"))
+		c.t = c.t.Add(RotateDaily)
+		_, _ = w.Write([]byte("day 2\n"))
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, []string{
+			"app-20231230.log.zst",
+			"app-20231231.log.xz",
+			"app-20240101.log.zz",
+			"app-20240102.log",
+		}, logFiles(t, dir))
+
+		f, err := os.Open(filepath.Join(dir, "app-20240101.log.zz"))
+		require.NoError(t, err)
+		defer f.Close()
+		zr, err := zlib.NewReader(f)
+		require.NoError(t, err)
+		data, err := io.ReadAll(zr)
+		require.NoError(t, err)
+		assert.Equal(t, "day 1\n", string(data))
+	})
+
+	t.Run("LumberJackWriter", func(t *testing.T) {
+		dir := t.TempDir()
+		writer, err := NewLumberJackWriter(LumberJackConfig{
+			FilePath:    filepath.Join(dir, "app.log"),
+			Compress:    true,
+			Compression: GzipCompressor(gzip.BestSpeed),
+		})
+		require.NoError(t, err)
+
+		line := strings.Repeat("x", 1024) + "\n"
+		_, err = writer.Write([]byte(line))
+		require.NoError(t, err)
+		require.NoError(t, writer.Rotate())
+		require.NoError(t, writer.Close())
+
+		files, err := filepath.Glob(filepath.Join(dir, "app-*.log.gz"))
+		require.NoError(t, err)
+		require.Len(t, files, 1)
+		assert.Equal(t, line, readFile(t, files[0]))
+	})
+}
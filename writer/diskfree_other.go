@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd
+
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains the free disk space lookup for systems without statfs.
+package writer
+
+// diskFree always fails with errDiskFreeUnsupported, which disables the
+// free disk space guard.
+func diskFree(dir string) (uint64, error) {
+	return 0, errDiskFreeUnsupported
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin || freebsd
+
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains the free disk space lookup for systems with statfs.
+package writer
+
+import "syscall"
+
+// diskFree returns the number of bytes available to unprivileged users on
+// the file system containing dir.
+func diskFree(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
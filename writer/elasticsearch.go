@@ -0,0 +1,344 @@
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains a writer indexing entries through the bulk API of
+// Elasticsearch or OpenSearch.
+package writer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// elasticsearchBulkPath is the path of the bulk endpoint.
+const elasticsearchBulkPath = "/_bulk"
+
+// elasticsearchIndexChars are the characters not allowed in index names.
+const elasticsearchIndexChars = ` "*\<|,>/?#:`
+
+// ElasticsearchConfig holds configuration for indexing entries in
+// Elasticsearch or OpenSearch.
+type ElasticsearchConfig struct {
+	// URL is the base URL of the cluster, such as "http://es:9200".
+	// Entries are sent to its /_bulk endpoint.
+	URL string
+
+	// Index is the name pattern of the index entries are written to, such
+	// as "logs-app-%Y.%m.%d". It supports the conversions of
+	// TimeRotatingConfig.FilePattern, applied to the time of each entry.
+	// Defaults to "tslog-%Y.%m.%d".
+	Index string
+
+	// LocalTime formats index names in the computer's local time. Defaults
+	// to UTC time.
+	LocalTime bool
+
+	// Create indexes entries with the "create" action instead of "index",
+	// as data streams require.
+	Create bool
+
+	// Username and Password are sent with basic authentication if Username
+	// is set.
+	Username string
+	Password string
+
+	// APIKey is sent in an "ApiKey" Authorization header if set, as the
+	// base64 encoding of "id:key".
+	APIKey string
+
+	// Headers are added to every request.
+	Headers map[string]string
+
+	// Gzip compresses request bodies. Defaults to false.
+	Gzip bool
+
+	// Timeout is the maximum time of a request. Defaults to 30 seconds if
+	// not specified. It is ignored if Client is set.
+	Timeout time.Duration
+
+	// Client makes the requests. Defaults to a client with Timeout.
+	Client *http.Client
+
+	// Batch configures the batching of entries: QueueSize, BatchSize,
+	// FlushInterval, Overflow and OnError, which is called when entries
+	// can't be indexed.
+	Batch AsyncConfig
+
+	// Retry configures the retrying of requests failing with a network
+	// error, a 429 or a 5xx response, and of the entries the cluster
+	// rejects with such a status.
+	Retry RetryConfig
+}
+
+// Validate checks if the configuration is valid and returns an error if not.
+func (c *ElasticsearchConfig) Validate() error {
+	if c.URL == "" {
+		return fmt.Errorf("URL cannot be empty")
+	}
+
+	if u, err := url.Parse(c.URL); err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("URL must be an http or https URL")
+	}
+
+	if c.Index != "" {
+		if err := validateStrftime(c.Index); err != nil {
+			return fmt.Errorf("invalid Index: %w", err)
+		}
+
+		name := strftime(c.Index, time.Now())
+		if name != strings.ToLower(name) || strings.ContainsAny(name, elasticsearchIndexChars) ||
+			strings.HasPrefix(name, "_") || strings.HasPrefix(name, "-") || strings.HasPrefix(name, "+") {
+			return fmt.Errorf("Index %q doesn't make valid index names", c.Index)
+		}
+	}
+
+	if c.APIKey != "" && c.Username != "" {
+		return fmt.Errorf("only one of APIKey and Username can be set")
+	}
+
+	if c.Timeout < 0 {
+		return fmt.Errorf("Timeout cannot be negative")
+	}
+
+	if err := c.Batch.Validate(); err != nil {
+		return fmt.Errorf("invalid Batch: %w", err)
+	}
+
+	if err := c.Retry.Validate(); err != nil {
+		return fmt.Errorf("invalid Retry: %w", err)
+	}
+
+	return nil
+}
+
+// setDefaults sets default values for unspecified configuration fields.
+func (c *ElasticsearchConfig) setDefaults() {
+	if c.Index == "" {
+		c.Index = "tslog-%Y.%m.%d"
+	}
+
+	if c.Timeout == 0 {
+		c.Timeout = 30 * time.Second
+	}
+
+	if c.Client == nil {
+		c.Client = &http.Client{Timeout: c.Timeout}
+	}
+
+	c.Retry.setDefaults()
+}
+
+// elasticsearchBulkResponse is the part of a bulk response needed to find
+// the failed items.
+type elasticsearchBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int `json:"status"`
+		Error  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// elasticsearchItem is an entry of a bulk request.
+type elasticsearchItem struct {
+	action []byte // Action line, including the newline
+	doc    []byte // Document line, including the newline
+}
+
+// ElasticsearchWriter is an io.Writer that indexes entries in Elasticsearch
+// or OpenSearch through the bulk API, in batches from a background
+// goroutine. It is safe for concurrent use.
+//
+// JSON entries are indexed as they are; other entries are indexed as a
+// document with "timestamp" and "msg" fields. Each entry goes to the index
+// named after its "timestamp" field, or the time it is sent if it has none.
+//
+// Items the cluster rejects with 429 or a 5xx status are retried on their
+// own, while the rest of the batch is kept. A cluster rejecting entries thus
+// holds up the background goroutine, and the queue fills up until
+// Batch.Overflow applies: by default Write blocks, slowing logging down to
+// the pace of the cluster.
+type ElasticsearchWriter struct {
+	*AsyncWriter
+	conf    ElasticsearchConfig
+	request httpRequest
+	now     func() time.Time
+}
+
+// NewElasticsearchWriter creates a new writer indexing entries in
+// Elasticsearch or OpenSearch.
+//
+// Close must be called to send the queued entries and stop the background
+// goroutine.
+//
+// Example:
+//
+//	w, err := writer.NewElasticsearchWriter(writer.ElasticsearchConfig{
+//	    URL:    "https://es.internal:9200",
+//	    Index:  "logs-billing-%Y.%m.%d",
+//	    APIKey: os.Getenv("ES_API_KEY"),
+//	})
+//	defer w.Close()
+//	logger := tslog.NewLogger(tslog.WithWriter(w), tslog.WithEncoder(tslog.EncoderJSON))
+func NewElasticsearchWriter(conf ElasticsearchConfig) (*ElasticsearchWriter, error) {
+	// Validate configuration
+	if err := conf.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid elasticsearch config: %w", err)
+	}
+
+	// Apply defaults
+	conf.setDefaults()
+
+	headers := map[string]string{}
+	for name, value := range conf.Headers {
+		headers[name] = value
+	}
+	switch {
+	case conf.APIKey != "":
+		headers["Authorization"] = "ApiKey " + conf.APIKey
+	case conf.Username != "":
+		credentials := base64.StdEncoding.EncodeToString([]byte(conf.Username + ":" + conf.Password))
+		headers["Authorization"] = "Basic " + credentials
+	}
+
+	w := &ElasticsearchWriter{
+		conf: conf,
+		request: httpRequest{
+			client:      conf.Client,
+			method:      http.MethodPost,
+			url:         strings.TrimSuffix(strings.TrimSuffix(conf.URL, "/"), elasticsearchBulkPath) + elasticsearchBulkPath,
+			contentType: "application/x-ndjson",
+			headers:     headers,
+			gzip:        conf.Gzip,
+		},
+		now: time.Now,
+	}
+	w.AsyncWriter = newBatchWriter(conf.Batch, w.bulk)
+	go w.run()
+
+	return w, nil
+}
+
+// MustNewElasticsearchWriter is like NewElasticsearchWriter but panics if
+// the configuration is invalid.
+func MustNewElasticsearchWriter(conf ElasticsearchConfig) *ElasticsearchWriter {
+	writer, err := NewElasticsearchWriter(conf)
+	if err != nil {
+		panic(err)
+	}
+	return writer
+}
+
+// bulk indexes a batch of entries, retrying the items rejected with a
+// retryable status.
+func (w *ElasticsearchWriter) bulk(batch [][]byte) error {
+	now := w.now()
+	items := make([]elasticsearchItem, 0, len(batch))
+	for _, entry := range batch {
+		items = append(items, w.item(entry, now))
+	}
+
+	var rejected []string // Reasons of the items that won't be retried
+	err := w.conf.Retry.do(w.expired, func() error {
+		var body bytes.Buffer
+		for _, item := range items {
+			body.Write(item.action)
+			body.Write(item.doc)
+		}
+
+		respBody, err := w.request.send(context.Background(), body.Bytes())
+		if err != nil {
+			return err
+		}
+
+		var resp elasticsearchBulkResponse
+		if err := json.Unmarshal(respBody, &resp); err != nil {
+			return &permanentError{fmt.Errorf("invalid bulk response: %w", err)}
+		}
+		if !resp.Errors {
+			items = nil
+			return nil
+		}
+		if len(resp.Items) != len(items) {
+			return &permanentError{fmt.Errorf("bulk response has %d items, expected %d", len(resp.Items), len(items))}
+		}
+
+		var retry []elasticsearchItem
+		var reason string
+		for i, result := range resp.Items {
+			for _, r := range result {
+				switch {
+				case r.Status >= 200 && r.Status <= 299:
+				case r.Status == http.StatusTooManyRequests || r.Status >= 500:
+					retry = append(retry, items[i])
+					reason = fmt.Sprintf("%d: %s: %s", r.Status, r.Error.Type, r.Error.Reason)
+				default:
+					rejected = append(rejected, fmt.Sprintf("%d: %s: %s", r.Status, r.Error.Type, r.Error.Reason))
+				}
+			}
+		}
+
+		items = retry
+		if len(retry) > 0 {
+			return fmt.Errorf("%d items rejected, last with %s", len(retry), reason)
+		}
+		return nil
+	})
+
+	// Items still holds the entries that kept failing
+	if err != nil {
+		return fmt.Errorf("failed to index %d of %d entries: %w", len(items)+len(rejected), len(batch), err)
+	}
+	if len(rejected) > 0 {
+		return fmt.Errorf("failed to index %d of %d entries: %s", len(rejected), len(batch), rejected[0])
+	}
+	return nil
+}
+
+// item returns the bulk request item indexing entry.
+func (w *ElasticsearchWriter) item(entry []byte, now time.Time) elasticsearchItem {
+	t := now
+	doc := bytes.TrimSpace(entry)
+	if fields, err := decodeEntry(doc); err == nil {
+		if s, ok := fields[entryTimeKey].(string); ok {
+			if parsed, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				t = parsed
+			}
+		}
+		// Documents must be on a single line
+		if bytes.IndexByte(doc, '\n') >= 0 {
+			doc, _ = json.Marshal(fields)
+		}
+	} else {
+		doc, _ = json.Marshal(map[string]string{
+			entryTimeKey:    now.Format(time.RFC3339Nano),
+			entryMessageKey: string(doc),
+		})
+	}
+
+	if w.conf.LocalTime {
+		t = t.Local()
+	} else {
+		t = t.UTC()
+	}
+
+	action := "index"
+	if w.conf.Create {
+		action = "create"
+	}
+	meta, _ := json.Marshal(map[string]map[string]string{
+		action: {"_index": strftime(w.conf.Index, t)},
+	})
+
+	return elasticsearchItem{
+		action: append(meta, '\n'),
+		doc:    append(doc[:len(doc):len(doc)], '\n'),
+	}
+}
@@ -0,0 +1,274 @@
+package writer
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bulkItem is an item of a bulk request received by a stand-in cluster
+type bulkItem struct {
+	action string
+	index  string
+	doc    map[string]interface{}
+}
+
+// bulkServer is a stand-in for the bulk endpoint. The statuses of the items
+// of each request are decided by respond.
+type bulkServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	respond  func(request int, item bulkItem) int
+	requests []*http.Request
+	items    [][]bulkItem
+}
+
+// newBulkServer starts a stand-in cluster
+func newBulkServer(t *testing.T, respond func(request int, item bulkItem) int) *bulkServer {
+	s := &bulkServer{respond: respond}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// handle parses a bulk request and responds with the status of each item
+func (s *bulkServer) handle(w http.ResponseWriter, r *http.Request) {
+	var items []bulkItem
+	scanner := bufio.NewScanner(r.Body)
+	for scanner.Scan() {
+		var action map[string]map[string]string
+		if err := json.Unmarshal(scanner.Bytes(), &action); err != nil || !scanner.Scan() {
+			http.Error(w, "malformed action", http.StatusBadRequest)
+			return
+		}
+		item := bulkItem{}
+		for name, meta := range action {
+			item.action, item.index = name, meta["_index"]
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &item.doc); err != nil {
+			http.Error(w, "malformed document", http.StatusBadRequest)
+			return
+		}
+		items = append(items, item)
+	}
+
+	s.mutex.Lock()
+	request := len(s.requests)
+	s.requests = append(s.requests, r)
+	s.items = append(s.items, items)
+	s.mutex.Unlock()
+
+	resp := map[string]interface{}{"took": 1, "errors": false}
+	var results []interface{}
+	for _, item := range items {
+		status := s.respond(request, item)
+		result := map[string]interface{}{"_index": item.index, "status": status}
+		if status >= 300 {
+			resp["errors"] = true
+			result["error"] = map[string]string{"type": "test_exception", "reason": fmt.Sprintf("status %d", status)}
+		}
+		results = append(results, map[string]interface{}{item.action: result})
+	}
+	resp["items"] = results
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// received returns the requests and their items
+func (s *bulkServer) received() ([]*http.Request, [][]bulkItem) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests, s.items
+}
+
+// TestElasticsearchConfig tests bulk writer configuration validation
+func TestElasticsearchConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ElasticsearchConfig
+		errMsg string
+	}{
+		{"Valid", ElasticsearchConfig{URL: "http://es:9200", Index: "logs-app-%Y.%m.%d"}, ""},
+		{"EmptyURL", ElasticsearchConfig{}, "URL cannot be empty"},
+		{"Scheme", ElasticsearchConfig{URL: "es:9200"}, "must be an http or https URL"},
+		{"Conversion", ElasticsearchConfig{URL: "http://es", Index: "logs-%Q"}, "unsupported conversion"},
+		{"UpperCase", ElasticsearchConfig{URL: "http://es", Index: "Logs-%Y"}, "doesn't make valid index names"},
+		{"Characters", ElasticsearchConfig{URL: "http://es", Index: "logs app"}, "doesn't make valid index names"},
+		{"Prefix", ElasticsearchConfig{URL: "http://es", Index: "_logs"}, "doesn't make valid index names"},
+		{"Auth", ElasticsearchConfig{URL: "http://es", APIKey: "k", Username: "u"}, "only one of APIKey and Username"},
+		{"Timeout", ElasticsearchConfig{URL: "http://es", Timeout: -1}, "Timeout cannot be negative"},
+		{"Batch", ElasticsearchConfig{URL: "http://es", Batch: AsyncConfig{BatchSize: -1}}, "invalid Batch"},
+		{"Retry", ElasticsearchConfig{URL: "http://es", Retry: RetryConfig{MinBackoff: -1}}, "invalid Retry"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.errMsg == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+
+	t.Run("Defaults", func(t *testing.T) {
+		config := ElasticsearchConfig{URL: "http://es"}
+		config.setDefaults()
+		assert.Equal(t, "tslog-%Y.%m.%d", config.Index)
+		assert.Equal(t, 30*time.Second, config.Client.Timeout)
+	})
+
+	t.Run("Constructor", func(t *testing.T) {
+		_, err := NewElasticsearchWriter(ElasticsearchConfig{})
+		assert.Contains(t, err.Error(), "invalid elasticsearch config")
+		assert.Panics(t, func() { MustNewElasticsearchWriter(ElasticsearchConfig{}) })
+	})
+}
+
+// TestElasticsearchWriter tests indexing entries in a stand-in cluster
+func TestElasticsearchWriter(t *testing.T) {
+	fastRetry := RetryConfig{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	t.Run("Indexes", func(t *testing.T) {
+		server := newBulkServer(t, func(int, bulkItem) int { return http.StatusCreated })
+		w, err := NewElasticsearchWriter(ElasticsearchConfig{
+			URL:      server.URL,
+			Index:    "logs-app-%Y.%m.%d",
+			Create:   true,
+			Username: "elastic",
+			Password: "secret",
+		})
+		require.NoError(t, err)
+		w.now = func() time.Time { return time.Date(2024, 3, 4, 5, 6, 7, 0, time.UTC) }
+
+		entries := []string{
+			`{"level":"INFO","timestamp":"2024-01-01T23:30:00-02:00","msg":"a","user_id":42}` + "\n",
+			`{"level":"ERROR","timestamp":"2024-01-02T00:00:00Z","msg":"b","stacktrace":"main.main()\n\tmain.go:1"}` + "\n",
+			"2024-01-01T00:00:00.000Z\tINFO\tconsole\n",
+		}
+		for _, entry := range entries {
+			_, err := w.Write([]byte(entry))
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.Close())
+
+		requests, items := server.received()
+		require.Len(t, requests, 1)
+		assert.Equal(t, "/_bulk", requests[0].URL.Path)
+		assert.Equal(t, "application/x-ndjson", requests[0].Header.Get("Content-Type"))
+		username, password, ok := requests[0].BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "elastic", username)
+		assert.Equal(t, "secret", password)
+
+		require.Len(t, items[0], 3)
+		assert.Equal(t, "create", items[0][0].action)
+		assert.Equal(t, "logs-app-2024.01.02", items[0][0].index)
+		assert.Equal(t, float64(42), items[0][0].doc["user_id"])
+		assert.Equal(t, "logs-app-2024.01.02", items[0][1].index)
+		assert.Equal(t, "main.main()\n\tmain.go:1", items[0][1].doc["stacktrace"])
+		assert.Equal(t, "logs-app-2024.03.04", items[0][2].index)
+		assert.Equal(t, map[string]interface{}{
+			"timestamp": "2024-03-04T05:06:07Z",
+			"msg":       "2024-01-01T00:00:00.000Z\tINFO\tconsole",
+		}, items[0][2].doc)
+	})
+
+	t.Run("RetryRejectedItems", func(t *testing.T) {
+		// The first request rejects the second entry as overloaded and the
+		// third as malformed
+		server := newBulkServer(t, func(request int, item bulkItem) int {
+			switch {
+			case request == 0 && item.doc["msg"] == "b":
+				return http.StatusTooManyRequests
+			case item.doc["msg"] == "c":
+				return http.StatusBadRequest
+			}
+			return http.StatusCreated
+		})
+		var errs []error
+		w, err := NewElasticsearchWriter(ElasticsearchConfig{
+			URL:    server.URL,
+			APIKey: "a2V5",
+			Batch:  AsyncConfig{OnError: func(err error) { errs = append(errs, err) }},
+			Retry:  fastRetry,
+		})
+		require.NoError(t, err)
+
+		for _, msg := range []string{"a", "b", "c"} {
+			_, err := w.Write([]byte(`{"msg":"` + msg + `"}` + "\n"))
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.Close())
+
+		requests, items := server.received()
+		require.Len(t, requests, 2)
+		assert.Equal(t, "ApiKey a2V5", requests[0].Header.Get("Authorization"))
+		assert.Len(t, items[0], 3)
+		// Only the overloaded entry is sent again
+		require.Len(t, items[1], 1)
+		assert.Equal(t, "b", items[1][0].doc["msg"])
+
+		require.Len(t, errs, 1)
+		assert.Contains(t, errs[0].Error(), "failed to index 1 of 3 entries: 400: test_exception: status 400")
+	})
+
+	t.Run("RetriesExhausted", func(t *testing.T) {
+		server := newBulkServer(t, func(int, bulkItem) int { return http.StatusServiceUnavailable })
+		var errs []error
+		w, err := NewElasticsearchWriter(ElasticsearchConfig{
+			URL:   server.URL,
+			Batch: AsyncConfig{OnError: func(err error) { errs = append(errs, err) }},
+			Retry: RetryConfig{MaxRetries: 2, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		})
+		require.NoError(t, err)
+
+		_, err = w.Write([]byte(`{"msg":"lost"}` + "\n"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		requests, _ := server.received()
+		assert.Len(t, requests, 3)
+		require.Len(t, errs, 1)
+		assert.Contains(t, errs[0].Error(), "failed to index 1 of 1 entries: 1 items rejected")
+	})
+
+	t.Run("RequestRejected", func(t *testing.T) {
+		attempts := 0
+		var mutex sync.Mutex
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			attempts++
+			mutex.Unlock()
+			http.Error(w, `{"error":"circuit_breaking_exception"}`, http.StatusTooManyRequests)
+		}))
+		defer server.Close()
+
+		var errs []error
+		w, err := NewElasticsearchWriter(ElasticsearchConfig{
+			URL:   server.URL,
+			Batch: AsyncConfig{OnError: func(err error) { errs = append(errs, err) }},
+			Retry: RetryConfig{MaxRetries: 1, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		})
+		require.NoError(t, err)
+
+		_, err = w.Write([]byte(`{"msg":"lost"}` + "\n"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, 2, attempts)
+		require.Len(t, errs, 1)
+		assert.True(t, strings.Contains(errs[0].Error(), "unexpected response status 429"), errs[0].Error())
+	})
+}
@@ -0,0 +1,215 @@
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains helpers for inspecting encoded log entries, used by
+// writers that need to know an entry's level.
+package writer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Level names as understood by the writers in this package. They match the
+// names of tslog's levels and are compared case-insensitively.
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+// Keys of the fields of JSON entries, as the tslog zap driver writes them.
+// Any other key holds a field of the entry's tslog.T.
+const (
+	entryTimeKey       = "timestamp"
+	entryLevelKey      = "level"
+	entryLoggerKey     = "logger"
+	entryCallerKey     = "caller"
+	entryFuncKey       = "func"
+	entryMessageKey    = "msg"
+	entryStacktraceKey = "stacktrace"
+)
+
+// entryWriter is implemented by the writers of this package that treat
+// each Write call as one entry, so that an AsyncWriter in front of them
+// doesn't pass them several entries at once.
+type entryWriter interface {
+	writesEntries()
+}
+
+// levelRank orders level names from least to most severe.
+// Unknown levels rank as 0.
+var levelRank = map[string]int{
+	LevelDebug: 1,
+	LevelInfo:  2,
+	LevelWarn:  3,
+	LevelError: 4,
+}
+
+// rankOf returns the severity rank of a level name, or 0 if it is unknown.
+func rankOf(level string) int {
+	return levelRank[strings.ToLower(strings.TrimSpace(level))]
+}
+
+// ansiEscape is the start of an ANSI color sequence, as written by the
+// console encoder's colored level encoder.
+const ansiEscape = '\x1b'
+
+// parseLevel returns the lower-case level name of an encoded entry, or an
+// empty string if it can't be determined.
+//
+// JSON entries are expected to carry the level under the "level" key, as the
+// tslog zap driver writes them. Console entries are expected to be
+// tab-separated with the level in the second column, optionally wrapped in
+// ANSI color codes.
+func parseLevel(p []byte) string {
+	p = bytes.TrimSpace(p)
+	if len(p) == 0 {
+		return ""
+	}
+
+	if p[0] == '{' {
+		var e struct {
+			Level string `json:"level"`
+		}
+		if err := json.Unmarshal(firstLine(p), &e); err != nil {
+			return ""
+		}
+		return normalizeLevel(e.Level)
+	}
+
+	cols := bytes.SplitN(firstLine(p), []byte{'\t'}, 3)
+	if len(cols) < 2 {
+		return ""
+	}
+	return normalizeLevel(string(stripANSI(cols[1])))
+}
+
+// normalizeLevel maps the level names written by encoders, such as "INFO" or
+// "warning", to the names used by this package. Unknown names yield an empty
+// string.
+func normalizeLevel(s string) string {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug
+	case "info":
+		return LevelInfo
+	case "warn", "warning":
+		return LevelWarn
+	case "error", "dpanic", "panic", "fatal":
+		return LevelError
+	default:
+		return ""
+	}
+}
+
+// firstLine returns p up to, but not including, the first newline.
+func firstLine(p []byte) []byte {
+	if i := bytes.IndexByte(p, '\n'); i >= 0 {
+		return p[:i]
+	}
+	return p
+}
+
+// splitEntries splits p into the entries it holds. A line with a JSON object
+// or a console line with a level starts an entry, and other lines, such as
+// the stacktrace of a console entry, belong to the entry before them. Each
+// entry keeps its trailing newline, and empty lines are dropped.
+func splitEntries(p []byte) [][]byte {
+	var entries [][]byte
+	start, end := 0, 0
+	for end < len(p) {
+		next := len(p)
+		if i := bytes.IndexByte(p[end:], '\n'); i >= 0 {
+			next = end + i + 1
+		}
+
+		line := bytes.TrimSpace(p[end:next])
+		switch {
+		case len(line) == 0:
+			if start == end {
+				start = next // Drop empty lines between entries
+			}
+		case end > start && (line[0] == '{' || parseLevel(line) != ""):
+			entries = append(entries, p[start:end])
+			start = end
+		}
+		end = next
+	}
+	if start < len(p) && len(bytes.TrimSpace(p[start:])) > 0 {
+		entries = append(entries, p[start:])
+	}
+	return entries
+}
+
+// stripANSI removes ANSI color sequences from p.
+func stripANSI(p []byte) []byte {
+	if bytes.IndexByte(p, ansiEscape) < 0 {
+		return p
+	}
+
+	out := make([]byte, 0, len(p))
+	for i := 0; i < len(p); i++ {
+		if p[i] == ansiEscape && i+1 < len(p) && p[i+1] == '[' {
+			// Skip to the final byte of the sequence
+			j := i + 2
+			for j < len(p) && (p[j] < 0x40 || p[j] > 0x7e) {
+				j++
+			}
+			i = j
+			continue
+		}
+		out = append(out, p[i])
+	}
+	return out
+}
+
+// decodeEntry decodes a JSON entry into its fields. Numbers are kept as
+// json.Number so they are passed on exactly as written.
+func decodeEntry(p []byte) (map[string]interface{}, error) {
+	p = bytes.TrimSpace(p)
+	if len(p) == 0 || p[0] != '{' {
+		return nil, fmt.Errorf("entry is not a JSON object")
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber()
+	var fields map[string]interface{}
+	if err := dec.Decode(&fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// fieldString returns the text of a decoded field value: strings as they
+// are, and other values as JSON.
+func fieldString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(b)
+	}
+}
+
+// splitCaller splits a caller such as "app/main.go:42" into its file and
+// line. The line is empty if caller has none.
+func splitCaller(caller string) (file, line string) {
+	i := strings.LastIndexByte(caller, ':')
+	if i < 0 {
+		return caller, ""
+	}
+	for _, c := range caller[i+1:] {
+		if c < '0' || c > '9' {
+			return caller, ""
+		}
+	}
+	return caller[:i], caller[i+1:]
+}
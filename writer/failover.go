@@ -0,0 +1,293 @@
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains a writer falling back to secondary outputs while its
+// primary output fails.
+package writer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrWriteTimeout is returned when a write to a FailoverWriter's output
+// doesn't complete within FailoverConfig.WriteTimeout.
+var ErrWriteTimeout = errors.New("writer: write timed out")
+
+// FailoverConfig holds configuration for a failover writer.
+type FailoverConfig struct {
+	// WriteTimeout is the maximum time a write to an output may take before
+	// the writer switches to the next output. Set it to -1 to wait for
+	// writes however long they take. Defaults to 5 seconds if not specified.
+	WriteTimeout time.Duration
+
+	// ProbeInterval is the minimum time between attempts to switch back to
+	// the primary output while a fallback is in use. Defaults to 30 seconds
+	// if not specified.
+	ProbeInterval time.Duration
+
+	// OnSwitch is called when the writer switches from one output to
+	// another, with their indexes, 0 being the primary, and the error of
+	// the output before the new one, nil when switching back to the primary.
+	OnSwitch func(from, to int, err error)
+}
+
+// Validate checks if the configuration is valid and returns an error if not.
+func (c *FailoverConfig) Validate() error {
+	if c.WriteTimeout < -1 {
+		return fmt.Errorf("WriteTimeout must be -1 or greater")
+	}
+
+	if c.ProbeInterval < 0 {
+		return fmt.Errorf("ProbeInterval cannot be negative")
+	}
+
+	return nil
+}
+
+// setDefaults sets default values for unspecified configuration fields.
+func (c *FailoverConfig) setDefaults() {
+	if c.WriteTimeout == 0 {
+		c.WriteTimeout = 5 * time.Second
+	}
+
+	if c.ProbeInterval == 0 {
+		c.ProbeInterval = 30 * time.Second
+	}
+}
+
+// FailoverStats holds counters of a FailoverWriter.
+type FailoverStats struct {
+	// Current is the index of the output in use, 0 being the primary
+	Current int
+	// Switches is the number of times the output in use changed
+	Switches uint64
+}
+
+// FailoverWriter is an io.Writer that writes to a primary output and falls
+// back to the next output when a write fails or times out. While a fallback
+// is in use, every ProbeInterval an entry is first written to the primary
+// again, and the writer switches back if it succeeds. It is safe for
+// concurrent use.
+//
+// On each switch, a notice entry at warn level is written to the new output
+// after the entry, as JSON or console text like the entry. It is only
+// written once the entry has been accepted, so an output that fails after
+// the notice never holds a notice without the entry. An entry is
+// written to the next output when its write times out, so it may end up in
+// both if the slow write completes later. Until it does, the output is
+// treated as failing rather than written to again, so an output is never
+// written to concurrently. Probing an output that hangs delays the entry by
+// up to WriteTimeout.
+type FailoverWriter struct {
+	outputs []io.Writer
+	conf    FailoverConfig
+	now     func() time.Time
+
+	mutex     sync.Mutex
+	pending   []chan struct{} // Per output, closed when its timed out write returns
+	current   int
+	lastProbe time.Time
+	switches  uint64
+}
+
+// NewFailoverWriter creates a new writer writing to primary, and to the
+// fallbacks in order while the outputs before them fail, with the default
+// configuration.
+//
+// Example:
+//
+//	remote := writer.MustNewNetWriter("tcp", "logs.internal:5170", writer.NetConfig{})
+//	file := writer.MustNewFileWriter(writer.FileConfig{FilePath: "/var/log/app.log"})
+//	w, err := writer.NewFailoverWriter(remote, file, writer.NewStderrWriter())
+//	logger := tslog.NewLogger(tslog.WithWriter(w))
+func NewFailoverWriter(primary io.Writer, fallbacks ...io.Writer) (*FailoverWriter, error) {
+	return NewFailoverWriterWithConfig(FailoverConfig{}, primary, fallbacks...)
+}
+
+// NewFailoverWriterWithConfig is like NewFailoverWriter with the given
+// configuration.
+func NewFailoverWriterWithConfig(conf FailoverConfig, primary io.Writer, fallbacks ...io.Writer) (*FailoverWriter, error) {
+	outputs := append([]io.Writer{primary}, fallbacks...)
+	for i, output := range outputs {
+		if output == nil {
+			return nil, fmt.Errorf("invalid failover config: output %d cannot be nil", i)
+		}
+	}
+
+	// Validate configuration
+	if err := conf.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid failover config: %w", err)
+	}
+
+	// Apply defaults
+	conf.setDefaults()
+
+	return &FailoverWriter{
+		outputs: outputs,
+		conf:    conf,
+		now:     time.Now,
+		pending: make([]chan struct{}, len(outputs)),
+	}, nil
+}
+
+// MustNewFailoverWriter is like NewFailoverWriter but panics if an output
+// is nil.
+func MustNewFailoverWriter(primary io.Writer, fallbacks ...io.Writer) *FailoverWriter {
+	writer, err := NewFailoverWriter(primary, fallbacks...)
+	if err != nil {
+		panic(err)
+	}
+	return writer
+}
+
+// writesEntries marks the writer as treating each Write call as one entry.
+func (w *FailoverWriter) writesEntries() {}
+
+// Write writes p to the output in use, switching to the next outputs until
+// one succeeds. It returns the error of the last output if all of them fail.
+func (w *FailoverWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	// Retry the outputs from the primary once in a while
+	start := w.current
+	if start > 0 && w.now().Sub(w.lastProbe) >= w.conf.ProbeInterval {
+		w.lastProbe = w.now()
+		start = 0
+	}
+
+	var lastErr error
+	for i := start; i < len(w.outputs); i++ {
+		if err := w.write(i, p); err != nil {
+			lastErr = err
+			continue
+		}
+
+		if i != w.current {
+			// The entry is written, so a failing notice is only lost
+			_ = w.write(i, w.notice(p, i, lastErr))
+			w.switchLocked(i, lastErr)
+		}
+		return len(p), nil
+	}
+
+	return 0, fmt.Errorf("all %d log outputs failed, last with: %w", len(w.outputs), lastErr)
+}
+
+// Sync syncs every output that supports syncing, and returns the first
+// error.
+func (w *FailoverWriter) Sync() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	var firstErr error
+	for _, output := range w.outputs {
+		if s, ok := output.(interface{ Sync() error }); ok {
+			if err := s.Sync(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+// Stats returns a snapshot of the writer's counters.
+func (w *FailoverWriter) Stats() FailoverStats {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return FailoverStats{Current: w.current, Switches: w.switches}
+}
+
+// switchLocked makes output i the output in use.
+func (w *FailoverWriter) switchLocked(i int, err error) {
+	from := w.current
+	w.current = i
+	w.switches++
+	if from == 0 {
+		w.lastProbe = w.now()
+	}
+
+	if w.conf.OnSwitch != nil {
+		w.conf.OnSwitch(from, i, err)
+	}
+}
+
+// write writes p to output i within WriteTimeout. It fails right away if
+// an earlier write to the output timed out and hasn't returned yet.
+func (w *FailoverWriter) write(i int, p []byte) error {
+	output := w.outputs[i]
+	if w.conf.WriteTimeout < 0 {
+		return writeAll(output, p)
+	}
+
+	if w.pending[i] != nil {
+		select {
+		case <-w.pending[i]:
+			w.pending[i] = nil
+		default:
+			return ErrWriteTimeout
+		}
+	}
+
+	// The write may outlive the call, so it gets its own copy
+	entry := make([]byte, len(p))
+	copy(entry, p)
+	result := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		result <- writeAll(output, entry)
+	}()
+
+	timer := time.NewTimer(w.conf.WriteTimeout)
+	defer timer.Stop()
+	select {
+	case err := <-result:
+		return err
+	case <-timer.C:
+		w.pending[i] = done
+		return ErrWriteTimeout
+	}
+}
+
+// writeAll writes p to output, treating short writes as failures.
+func writeAll(output io.Writer, p []byte) error {
+	n, err := output.Write(p)
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	return err
+}
+
+// notice returns the entry announcing the switch to output i, in the format
+// of entry p.
+func (w *FailoverWriter) notice(p []byte, i int, err error) []byte {
+	msg := fmt.Sprintf("switched log output from %d to %d", w.current, i)
+	if i < w.current {
+		msg = fmt.Sprintf("switched log output back from %d to %d", w.current, i)
+	}
+	ts := w.now().Format(time.RFC3339)
+
+	if trimmed := bytes.TrimSpace(p); len(trimmed) > 0 && trimmed[0] == '{' {
+		fields := map[string]interface{}{
+			entryLevelKey:   "WARN",
+			entryTimeKey:    ts,
+			entryMessageKey: msg,
+		}
+		if err != nil {
+			fields["error"] = err.Error()
+		}
+		b, _ := json.Marshal(fields)
+		return append(b, '\n')
+	}
+
+	if err != nil {
+		msg += ": " + err.Error()
+	}
+	return []byte(ts + "\tWARN\t" + msg + "\n")
+}
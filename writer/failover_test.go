@@ -0,0 +1,318 @@
+package writer
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hangingWriter blocks writes until release is closed
+type hangingWriter struct {
+	release chan struct{}
+	writes  int32 // Number of writes started
+}
+
+// Write blocks until the writer is released
+func (h *hangingWriter) Write(p []byte) (int, error) {
+	atomic.AddInt32(&h.writes, 1)
+	<-h.release
+	return len(p), nil
+}
+
+// limitedWriter accepts a number of writes and fails the rest
+type limitedWriter struct {
+	flakyWriter
+	left int
+}
+
+// Write records p while writes are left
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.left == 0 {
+		l.setDown(true)
+	}
+	l.left--
+	return l.flakyWriter.Write(p)
+}
+
+// shortWriter writes all but the last byte
+type shortWriter struct{}
+
+// Write reports a short write
+func (shortWriter) Write(p []byte) (int, error) {
+	return len(p) - 1, nil
+}
+
+// switchEvent is a call of FailoverConfig.OnSwitch
+type switchEvent struct {
+	from, to int
+	err      error
+}
+
+// TestFailoverConfig tests failover writer configuration validation
+func TestFailoverConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config FailoverConfig
+		errMsg string
+	}{
+		{"Valid", FailoverConfig{WriteTimeout: -1, ProbeInterval: time.Minute}, ""},
+		{"WriteTimeout", FailoverConfig{WriteTimeout: -2}, "WriteTimeout must be -1 or greater"},
+		{"ProbeInterval", FailoverConfig{ProbeInterval: -1}, "ProbeInterval cannot be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.errMsg == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+
+	t.Run("Defaults", func(t *testing.T) {
+		config := FailoverConfig{}
+		config.setDefaults()
+		assert.Equal(t, 5*time.Second, config.WriteTimeout)
+		assert.Equal(t, 30*time.Second, config.ProbeInterval)
+	})
+
+	t.Run("Constructor", func(t *testing.T) {
+		_, err := NewFailoverWriter(nil)
+		assert.Contains(t, err.Error(), "invalid failover config: output 0 cannot be nil")
+		_, err = NewFailoverWriter(io.Discard, nil)
+		assert.Contains(t, err.Error(), "output 1 cannot be nil")
+		_, err = NewFailoverWriterWithConfig(FailoverConfig{ProbeInterval: -1}, io.Discard)
+		assert.Contains(t, err.Error(), "invalid failover config")
+		assert.Panics(t, func() { MustNewFailoverWriter(nil) })
+	})
+}
+
+// TestFailoverWriter tests switching between outputs
+func TestFailoverWriter(t *testing.T) {
+	t.Run("Failover", func(t *testing.T) {
+		primary, file, stderr := &flakyWriter{}, &flakyWriter{}, &flakyWriter{}
+		var events []switchEvent
+		w, err := NewFailoverWriterWithConfig(FailoverConfig{
+			OnSwitch: func(from, to int, err error) { events = append(events, switchEvent{from, to, err}) },
+		}, primary, file, stderr)
+		require.NoError(t, err)
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		w.now = func() time.Time { return now }
+
+		_, err = w.Write([]byte(`{"level":"INFO","msg":"a"}` + "\n"))
+		require.NoError(t, err)
+		assert.Equal(t, []string{`{"level":"INFO","msg":"a"}` + "\n"}, primary.received())
+
+		// The primary fails, the entry goes to the file followed by a notice
+		primary.setDown(true)
+		n, err := w.Write([]byte(`{"level":"INFO","msg":"b"}` + "\n"))
+		require.NoError(t, err)
+		assert.Equal(t, 27, n)
+		entries := file.received()
+		require.Len(t, entries, 2)
+		assert.Equal(t, `{"level":"INFO","msg":"b"}`+"\n", entries[0])
+		var notice map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(entries[1]), &notice))
+		assert.Equal(t, map[string]interface{}{
+			"level":     "WARN",
+			"timestamp": "2024-01-01T00:00:00Z",
+			"msg":       "switched log output from 0 to 1",
+			"error":     "endpoint unavailable",
+		}, notice)
+
+		// The file stays in use without notices
+		_, err = w.Write([]byte(`{"level":"INFO","msg":"c"}` + "\n"))
+		require.NoError(t, err)
+		assert.Len(t, file.received(), 3)
+
+		// Both fail, the entry goes to stderr
+		file.setDown(true)
+		_, err = w.Write([]byte("console entry\n"))
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"console entry\n",
+			"2024-01-01T00:00:00Z\tWARN\tswitched log output from 1 to 2: endpoint unavailable\n",
+		}, stderr.received())
+
+		// All fail
+		stderr.setDown(true)
+		_, err = w.Write([]byte("lost\n"))
+		assert.Contains(t, err.Error(), "all 3 log outputs failed, last with: endpoint unavailable")
+
+		require.Len(t, events, 2)
+		assert.Equal(t, 0, events[0].from)
+		assert.Equal(t, 1, events[0].to)
+		assert.EqualError(t, events[0].err, "endpoint unavailable")
+		assert.Equal(t, FailoverStats{Current: 2, Switches: 2}, w.Stats())
+	})
+
+	t.Run("SwitchBack", func(t *testing.T) {
+		primary, fallback := &flakyWriter{}, &flakyWriter{}
+		var events []switchEvent
+		w, err := NewFailoverWriterWithConfig(FailoverConfig{
+			ProbeInterval: time.Minute,
+			OnSwitch:      func(from, to int, err error) { events = append(events, switchEvent{from, to, err}) },
+		}, primary, fallback)
+		require.NoError(t, err)
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		w.now = func() time.Time { return now }
+
+		primary.setDown(true)
+		_, err = w.Write([]byte("a\n"))
+		require.NoError(t, err)
+
+		// The primary isn't probed before the interval has passed
+		primary.setDown(false)
+		now = now.Add(30 * time.Second)
+		_, err = w.Write([]byte("b\n"))
+		require.NoError(t, err)
+		assert.Empty(t, primary.received())
+
+		now = now.Add(30 * time.Second)
+		_, err = w.Write([]byte("c\n"))
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"c\n",
+			"2024-01-01T00:01:00Z\tWARN\tswitched log output back from 1 to 0\n",
+		}, primary.received())
+		assert.Len(t, fallback.received(), 3)
+
+		require.Len(t, events, 2)
+		assert.Equal(t, switchEvent{1, 0, nil}, events[1])
+		assert.Equal(t, FailoverStats{Current: 0, Switches: 2}, w.Stats())
+	})
+
+	t.Run("FailedProbe", func(t *testing.T) {
+		primary, fallback := &flakyWriter{}, &flakyWriter{}
+		w, err := NewFailoverWriterWithConfig(FailoverConfig{ProbeInterval: time.Minute}, primary, fallback)
+		require.NoError(t, err)
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		w.now = func() time.Time { return now }
+
+		primary.setDown(true)
+		_, err = w.Write([]byte("a\n"))
+		require.NoError(t, err)
+
+		// The probe fails and the entry goes to the fallback as usual
+		now = now.Add(time.Minute)
+		_, err = w.Write([]byte("b\n"))
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			"a\n",
+			"2024-01-01T00:00:00Z\tWARN\tswitched log output from 0 to 1: endpoint unavailable\n",
+			"b\n",
+		}, fallback.received())
+		assert.Equal(t, FailoverStats{Current: 1, Switches: 1}, w.Stats())
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		hanging := &hangingWriter{release: make(chan struct{})}
+		defer close(hanging.release)
+		fallback := &flakyWriter{}
+		w, err := NewFailoverWriterWithConfig(FailoverConfig{WriteTimeout: 20 * time.Millisecond}, hanging, fallback)
+		require.NoError(t, err)
+
+		entry := []byte("slow\n")
+		_, err = w.Write(entry)
+		require.NoError(t, err)
+		entries := fallback.received()
+		require.Len(t, entries, 2)
+		assert.Equal(t, "slow\n", entries[0])
+		assert.True(t, strings.HasSuffix(entries[1], "switched log output from 0 to 1: writer: write timed out\n"), entries[1])
+	})
+
+	t.Run("TimeoutInFlight", func(t *testing.T) {
+		hanging := &hangingWriter{release: make(chan struct{})}
+		fallback := &flakyWriter{}
+		w, err := NewFailoverWriterWithConfig(FailoverConfig{
+			WriteTimeout:  20 * time.Millisecond,
+			ProbeInterval: time.Minute,
+		}, hanging, fallback)
+		require.NoError(t, err)
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		w.now = func() time.Time { return now }
+
+		_, err = w.Write([]byte("a\n"))
+		require.NoError(t, err)
+
+		// The primary's first write is still hanging, so the probe fails
+		// without writing to it again
+		now = now.Add(time.Minute)
+		_, err = w.Write([]byte("b\n"))
+		require.NoError(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&hanging.writes))
+		assert.Len(t, fallback.received(), 3)
+
+		// Once the write returns, the next probe writes to the primary
+		close(hanging.release)
+		require.Eventually(t, func() bool {
+			w.mutex.Lock()
+			defer w.mutex.Unlock()
+			select {
+			case <-w.pending[0]:
+				return true
+			default:
+				return false
+			}
+		}, 5*time.Second, 5*time.Millisecond)
+		now = now.Add(time.Minute)
+		_, err = w.Write([]byte("c\n"))
+		require.NoError(t, err)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&hanging.writes))
+		assert.Equal(t, FailoverStats{Current: 0, Switches: 2}, w.Stats())
+	})
+
+	t.Run("FallbackFailsAfterOneWrite", func(t *testing.T) {
+		primary, secondary, tertiary := &flakyWriter{}, &limitedWriter{left: 1}, &flakyWriter{}
+		w, err := NewFailoverWriterWithConfig(FailoverConfig{}, primary, secondary, tertiary)
+		require.NoError(t, err)
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		w.now = func() time.Time { return now }
+
+		// The secondary takes the entry, and only the notice is lost
+		primary.setDown(true)
+		_, err = w.Write([]byte("a\n"))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a\n"}, secondary.received())
+
+		// The next entry fails over to the tertiary, again followed by a notice
+		_, err = w.Write([]byte("b\n"))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a\n"}, secondary.received())
+		assert.Equal(t, []string{
+			"b\n",
+			"2024-01-01T00:00:00Z\tWARN\tswitched log output from 1 to 2: endpoint unavailable\n",
+		}, tertiary.received())
+		assert.Equal(t, FailoverStats{Current: 2, Switches: 2}, w.Stats())
+	})
+
+	t.Run("ShortWrite", func(t *testing.T) {
+		fallback := &flakyWriter{}
+		w, err := NewFailoverWriterWithConfig(FailoverConfig{WriteTimeout: -1}, shortWriter{}, fallback)
+		require.NoError(t, err)
+
+		_, err = w.Write([]byte("entry\n"))
+		require.NoError(t, err)
+		entries := fallback.received()
+		require.Len(t, entries, 2)
+		assert.Equal(t, "entry\n", entries[0])
+		assert.Contains(t, entries[1], io.ErrShortWrite.Error())
+	})
+
+	t.Run("Sync", func(t *testing.T) {
+		primary, fallback := &syncBuffer{}, &syncBuffer{}
+		w := MustNewFailoverWriter(primary, io.Discard, fallback)
+		require.NoError(t, w.Sync())
+		assert.Equal(t, 1, primary.synced)
+		assert.Equal(t, 1, fallback.synced)
+	})
+}
@@ -0,0 +1,266 @@
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains a plain file writer that reopens its file when an
+// external tool such as logrotate moves, removes or truncates it.
+package writer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileConfig holds configuration for logging to a plain file that is rotated
+// by an external tool.
+type FileConfig struct {
+	// FilePath is the path to the log file. If the directory doesn't exist,
+	// it will be created automatically.
+	FilePath string
+
+	// CheckInterval is how often writes check whether the file at FilePath
+	// is still the one being written to. Defaults to 1 second if not
+	// specified.
+	CheckInterval time.Duration
+
+	// FileMode is the permission bits of newly created log files. Unlike
+	// the mode passed to os.OpenFile it isn't subject to the umask.
+	// Defaults to 0644 if not specified.
+	FileMode os.FileMode
+
+	// UID and GID are the owner and group of newly created log files.
+	// Ownership is left unchanged if nil. Changing the owner usually
+	// requires privileges.
+	UID *int
+	GID *int
+}
+
+// Validate checks if the configuration is valid and returns an error if not.
+func (c *FileConfig) Validate() error {
+	if c.FilePath == "" {
+		return fmt.Errorf("FilePath cannot be empty")
+	}
+
+	if c.CheckInterval < 0 {
+		return fmt.Errorf("CheckInterval cannot be negative")
+	}
+
+	if c.FileMode&^os.ModePerm != 0 {
+		return fmt.Errorf("FileMode can only contain permission bits")
+	}
+
+	if c.UID != nil && *c.UID < 0 || c.GID != nil && *c.GID < 0 {
+		return fmt.Errorf("UID and GID cannot be negative")
+	}
+
+	return nil
+}
+
+// setDefaults sets default values for unspecified configuration fields.
+func (c *FileConfig) setDefaults() {
+	if c.CheckInterval == 0 {
+		c.CheckInterval = time.Second // 1 second default
+	}
+
+	if c.FileMode == 0 {
+		c.FileMode = 0644
+	}
+}
+
+// FileWriter is an io.Writer that appends to a file and transparently
+// reopens it when the file at its path has been moved, removed, replaced or
+// truncated, so it keeps working when logrotate rotates the file with
+// either the create or the copytruncate method. It is safe for concurrent
+// use.
+type FileWriter struct {
+	conf FileConfig
+	now  func() time.Time
+
+	mutex     sync.Mutex
+	file      *os.File
+	info      os.FileInfo // Identity of the open file
+	size      int64       // Size of the file at the last check or write
+	lastCheck time.Time
+}
+
+// NewFileWriter creates a new file writer that reopens its file after
+// external rotation. The file is opened on the first write.
+//
+// Features:
+// - Detects moved, deleted, replaced and truncated files
+// - Configurable mode and ownership of recreated files
+// - Thread-safe operations
+//
+// Example:
+//
+//	w, err := writer.NewFileWriter(writer.FileConfig{
+//	    FilePath: "/var/log/app.log",
+//	    FileMode: 0640,
+//	})
+//	defer w.Close()
+//	logger := tslog.NewLogger(tslog.WithWriter(w))
+func NewFileWriter(conf FileConfig) (*FileWriter, error) {
+	// Validate configuration
+	if err := conf.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid file config: %w", err)
+	}
+
+	// Apply defaults
+	conf.setDefaults()
+
+	return &FileWriter{
+		conf: conf,
+		now:  time.Now,
+	}, nil
+}
+
+// MustNewFileWriter is like NewFileWriter but panics if the configuration
+// is invalid.
+func MustNewFileWriter(conf FileConfig) *FileWriter {
+	writer, err := NewFileWriter(conf)
+	if err != nil {
+		panic(err)
+	}
+	return writer
+}
+
+// Write appends p to the file, reopening it first if it has been rotated
+// since the last check.
+func (w *FileWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	now := w.now()
+	switch {
+	case w.file == nil:
+		if err := w.openLocked(now); err != nil {
+			return 0, err
+		}
+	case now.Sub(w.lastCheck) >= w.conf.CheckInterval:
+		if w.rotatedLocked(now) {
+			if err := w.reopenLocked(now); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Reopen closes the file and opens the file at the configured path,
+// without waiting for the next check.
+func (w *FileWriter) Reopen() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.reopenLocked(w.now())
+}
+
+// Sync commits the file to stable storage.
+func (w *FileWriter) Sync() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	return w.file.Sync()
+}
+
+// Close closes the file. Writing after Close reopens it.
+func (w *FileWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.closeLocked()
+}
+
+// rotatedLocked reports whether the file at the configured path is no
+// longer the open file, or has been truncated.
+func (w *FileWriter) rotatedLocked(now time.Time) bool {
+	w.lastCheck = now
+
+	fi, err := os.Stat(w.conf.FilePath)
+	if err != nil {
+		return true // Moved or removed
+	}
+	if !os.SameFile(fi, w.info) {
+		return true // Replaced
+	}
+	if fi.Size() < w.size {
+		return true // Truncated
+	}
+
+	// Other processes may append to the file too
+	w.size = fi.Size()
+	return false
+}
+
+// reopenLocked closes the file, if open, and opens the configured path.
+func (w *FileWriter) reopenLocked(now time.Time) error {
+	if err := w.closeLocked(); err != nil {
+		return err
+	}
+	return w.openLocked(now)
+}
+
+// openLocked opens the configured path, creating it if needed.
+func (w *FileWriter) openLocked(now time.Time) error {
+	name := w.conf.FilePath
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return fmt.Errorf("can't make directories for new logfile: %w", err)
+	}
+
+	created := !fileExists(name)
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, w.conf.FileMode)
+	if err != nil {
+		return fmt.Errorf("can't open logfile: %w", err)
+	}
+
+	if created {
+		if err := w.setOwnership(f); err != nil {
+			f.Close()
+			return err
+		}
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("can't stat logfile: %w", err)
+	}
+
+	w.file = f
+	w.info = fi
+	w.size = fi.Size()
+	w.lastCheck = now
+	return nil
+}
+
+// setOwnership applies the configured mode and ownership to a newly
+// created file.
+func (w *FileWriter) setOwnership(f *os.File) error {
+	if err := f.Chmod(w.conf.FileMode); err != nil {
+		return fmt.Errorf("can't set mode of new logfile: %w", err)
+	}
+
+	if w.conf.UID != nil || w.conf.GID != nil {
+		if err := f.Chown(ownerID(w.conf.UID), ownerID(w.conf.GID)); err != nil {
+			return fmt.Errorf("can't set owner of new logfile: %w", err)
+		}
+	}
+	return nil
+}
+
+// closeLocked closes the file, if open.
+func (w *FileWriter) closeLocked() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	w.info = nil
+	return err
+}
@@ -0,0 +1,191 @@
+package writer
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestFileConfig tests validation and defaults
+func TestFileConfig(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		config := FileConfig{FilePath: "/tmp/app.log"}
+		require.NoError(t, config.Validate())
+		config.setDefaults()
+
+		assert.Equal(t, time.Second, config.CheckInterval)
+		assert.Equal(t, os.FileMode(0644), config.FileMode)
+		assert.Nil(t, config.UID)
+		assert.Nil(t, config.GID)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		negative := -1
+		tests := []struct {
+			name   string
+			config FileConfig
+			errMsg string
+		}{
+			{"EmptyPath", FileConfig{}, "FilePath cannot be empty"},
+			{"NegativeInterval", FileConfig{FilePath: "a.log", CheckInterval: -1}, "CheckInterval cannot be negative"},
+			{"ModeType", FileConfig{FilePath: "a.log", FileMode: os.ModeDir | 0755}, "FileMode can only contain permission bits"},
+			{"UID", FileConfig{FilePath: "a.log", UID: &negative}, "UID and GID cannot be negative"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := tt.config.Validate()
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errMsg)
+
+				w, err := NewFileWriter(tt.config)
+				assert.Nil(t, w)
+				assert.Contains(t, err.Error(), "invalid file config")
+			})
+		}
+	})
+}
+
+// newTestFileWriter creates a file writer with a fake clock in a temp dir
+func newTestFileWriter(t *testing.T, conf FileConfig) (*FileWriter, *clock, string) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	conf.FilePath = path
+
+	w, err := NewFileWriter(conf)
+	require.NoError(t, err)
+
+	c := &clock{t: time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC)}
+	w.now = c.now
+	t.Cleanup(func() { w.Close() })
+	return w, c, path
+}
+
+// TestFileWriter tests reopening after external rotation
+func TestFileWriter(t *testing.T) {
+	t.Run("Moved", func(t *testing.T) {
+		w, c, path := newTestFileWriter(t, FileConfig{})
+
+		_, err := w.Write([]byte("a\n"))
+		require.NoError(t, err)
+		require.NoError(t, os.Rename(path, path+".1"))
+
+		// Writes keep following the moved file until the next check
+		_, _ = w.Write([]byte("b\n"))
+		c.t = c.t.Add(time.Second)
+		_, _ = w.Write([]byte("c\n"))
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, "a\nb\n", readFile(t, path+".1"))
+		assert.Equal(t, "c\n", readFile(t, path))
+	})
+
+	t.Run("Removed", func(t *testing.T) {
+		w, c, path := newTestFileWriter(t, FileConfig{})
+
+		_, _ = w.Write([]byte("a\n"))
+		require.NoError(t, os.Remove(path))
+		c.t = c.t.Add(time.Second)
+		_, err := w.Write([]byte("b\n"))
+		require.NoError(t, err)
+
+		assert.Equal(t, "b\n", readFile(t, path))
+	})
+
+	t.Run("Replaced", func(t *testing.T) {
+		w, c, path := newTestFileWriter(t, FileConfig{})
+
+		_, _ = w.Write([]byte("a\n"))
+		require.NoError(t, os.Rename(path, path+".1"))
+		require.NoError(t, os.WriteFile(path, []byte("new\n"), 0644))
+		c.t = c.t.Add(time.Second)
+		_, _ = w.Write([]byte("b\n"))
+
+		assert.Equal(t, "a\n", readFile(t, path+".1"))
+		assert.Equal(t, "new\nb\n", readFile(t, path))
+	})
+
+	t.Run("Truncated", func(t *testing.T) {
+		w, c, path := newTestFileWriter(t, FileConfig{})
+
+		_, _ = w.Write([]byte("before truncation\n"))
+		require.NoError(t, os.Truncate(path, 0))
+		c.t = c.t.Add(time.Second)
+		_, _ = w.Write([]byte("b\n"))
+
+		assert.Equal(t, "b\n", readFile(t, path))
+	})
+
+	t.Run("AppendedByOthers", func(t *testing.T) {
+		w, c, path := newTestFileWriter(t, FileConfig{})
+
+		_, _ = w.Write([]byte("a\n"))
+		other, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		require.NoError(t, err)
+		_, _ = other.Write([]byte("other\n"))
+		require.NoError(t, other.Close())
+
+		c.t = c.t.Add(time.Second)
+		_, _ = w.Write([]byte("b\n"))
+		info := w.info
+
+		c.t = c.t.Add(time.Second)
+		_, _ = w.Write([]byte("c\n"))
+		assert.Same(t, info, w.info, "file should not have been reopened")
+		assert.Equal(t, "a\nother\nb\nc\n", readFile(t, path))
+	})
+
+	t.Run("Reopen", func(t *testing.T) {
+		w, _, path := newTestFileWriter(t, FileConfig{CheckInterval: time.Hour})
+
+		_, _ = w.Write([]byte("a\n"))
+		require.NoError(t, os.Rename(path, path+".1"))
+		require.NoError(t, w.Reopen())
+		_, _ = w.Write([]byte("b\n"))
+		require.NoError(t, w.Sync())
+
+		assert.Equal(t, "b\n", readFile(t, path))
+	})
+
+	t.Run("ModeAndOwner", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("file modes and ownership are not supported on windows")
+		}
+
+		uid, gid := os.Getuid(), os.Getgid()
+		w, c, path := newTestFileWriter(t, FileConfig{
+			FileMode: 0600,
+			UID:      &uid,
+			GID:      &gid,
+		})
+
+		_, err := w.Write([]byte("a\n"))
+		require.NoError(t, err)
+		fi, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+		// The recreated file gets the same mode
+		require.NoError(t, os.Remove(path))
+		c.t = c.t.Add(time.Second)
+		_, err = w.Write([]byte("b\n"))
+		require.NoError(t, err)
+		fi, err = os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	})
+
+	t.Run("CreatesDirectory", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "nested", "app.log")
+		w := MustNewFileWriter(FileConfig{FilePath: path})
+		defer w.Close()
+
+		_, err := w.Write([]byte("a\n"))
+		require.NoError(t, err)
+		assert.FileExists(t, path)
+	})
+}
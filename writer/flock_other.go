@@ -0,0 +1,17 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains the fallback for systems without flock.
+package writer
+
+import "os"
+
+// lockFile always fails with errFlockUnsupported.
+func lockFile(f *os.File, exclusive bool) error {
+	return errFlockUnsupported
+}
+
+// unlockFile always fails with errFlockUnsupported.
+func unlockFile(f *os.File) error {
+	return errFlockUnsupported
+}
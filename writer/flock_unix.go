@@ -0,0 +1,32 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains advisory file locking for systems with flock.
+package writer
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile acquires a shared or exclusive flock on f, waiting until it is
+// available. Locks are held per open file, so goroutines of one process
+// must be serialized separately.
+func lockFile(f *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		if err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
+// unlockFile releases the flock on f.
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,422 @@
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains a writer sending entries to Fluentd or Fluent Bit with
+// the Forward protocol.
+package writer
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ForwardMode selects how entries are framed in Forward protocol messages.
+type ForwardMode int
+
+const (
+	// ForwardPackedForward sends each batch as one message holding the
+	// entries as a MessagePack stream
+	ForwardPackedForward ForwardMode = iota
+	// ForwardMessage sends each entry as a message of its own
+	ForwardMessage
+)
+
+// String returns the name of the mode.
+func (m ForwardMode) String() string {
+	switch m {
+	case ForwardPackedForward:
+		return "packed-forward"
+	case ForwardMessage:
+		return "message"
+	default:
+		return fmt.Sprintf("ForwardMode(%d)", int(m))
+	}
+}
+
+// ErrForwardWriterClosed is returned when a ForwardWriter is closed while
+// sending entries.
+var ErrForwardWriterClosed = errors.New("writer: forward writer is closed")
+
+// ForwardConfig holds configuration for sending entries with the Fluent
+// Forward protocol.
+type ForwardConfig struct {
+	// Tag is the tag of the entries, which Fluentd and Fluent Bit route on,
+	// such as "app.billing". Defaults to the name of the executable.
+	Tag string
+
+	// Mode frames the entries. Defaults to ForwardPackedForward.
+	Mode ForwardMode
+
+	// IntegerTime sends times as whole seconds, for Fluentd versions older
+	// than 0.14. Defaults to the EventTime extension, which keeps
+	// nanoseconds.
+	IntegerTime bool
+
+	// RequireAck asks the server to acknowledge each message with the
+	// "chunk" option, and resends the messages that aren't acknowledged
+	// within AckTimeout. Entries may then be received twice, but aren't
+	// lost when a connection breaks.
+	RequireAck bool
+
+	// TLSConfig is the TLS configuration of "tls" connections. Defaults to
+	// verifying the server against the system roots.
+	TLSConfig *tls.Config
+
+	// DialTimeout is the maximum time to establish a connection. Defaults
+	// to 5 seconds if not specified.
+	DialTimeout time.Duration
+
+	// WriteTimeout is the maximum time to write a message. Defaults to 5
+	// seconds if not specified.
+	WriteTimeout time.Duration
+
+	// AckTimeout is the maximum time to wait for an acknowledgment.
+	// Defaults to 30 seconds if not specified.
+	AckTimeout time.Duration
+
+	// Batch configures the batching of entries: QueueSize, BatchSize,
+	// FlushInterval, Overflow and OnError, which is called when a batch
+	// can't be sent.
+	Batch AsyncConfig
+
+	// Retry configures the retrying of messages that fail to be sent or
+	// acknowledged, each over a new connection.
+	Retry RetryConfig
+}
+
+// Validate checks if the configuration is valid and returns an error if not.
+func (c *ForwardConfig) Validate() error {
+	if c.Mode != ForwardPackedForward && c.Mode != ForwardMessage {
+		return fmt.Errorf("Mode must be either ForwardPackedForward or ForwardMessage")
+	}
+
+	if c.DialTimeout < 0 {
+		return fmt.Errorf("DialTimeout cannot be negative")
+	}
+
+	if c.WriteTimeout < 0 {
+		return fmt.Errorf("WriteTimeout cannot be negative")
+	}
+
+	if c.AckTimeout < 0 {
+		return fmt.Errorf("AckTimeout cannot be negative")
+	}
+
+	if err := c.Batch.Validate(); err != nil {
+		return fmt.Errorf("invalid Batch: %w", err)
+	}
+
+	if err := c.Retry.Validate(); err != nil {
+		return fmt.Errorf("invalid Retry: %w", err)
+	}
+
+	return nil
+}
+
+// setDefaults sets default values for unspecified configuration fields.
+func (c *ForwardConfig) setDefaults() {
+	if c.Tag == "" {
+		c.Tag = filepath.Base(os.Args[0])
+	}
+
+	if c.DialTimeout == 0 {
+		c.DialTimeout = 5 * time.Second
+	}
+
+	if c.WriteTimeout == 0 {
+		c.WriteTimeout = 5 * time.Second
+	}
+
+	if c.AckTimeout == 0 {
+		c.AckTimeout = 30 * time.Second
+	}
+
+	c.Retry.setDefaults()
+}
+
+// ForwardWriter is an io.Writer that sends entries to Fluentd or Fluent Bit
+// with the Forward protocol, in batches from a background goroutine. It is
+// safe for concurrent use.
+//
+// The fields of JSON entries become the fields of native records, and the
+// time of each entry is read from its "timestamp" field, falling back to the
+// time it is sent. Other entries become records with a "msg" field holding
+// the line.
+//
+// Without RequireAck, entries written just after the server goes away may be
+// lost, since the connection only reports the failure on the next write.
+type ForwardWriter struct {
+	*AsyncWriter
+	network string
+	addr    string
+	conf    ForwardConfig
+	now     func() time.Time
+
+	mutex  sync.Mutex
+	conn   net.Conn // nil while disconnected
+	reader *bufio.Reader
+	closed bool
+}
+
+// NewForwardWriter creates a new writer sending entries to a Fluentd or
+// Fluent Bit forward input at addr on network, which is "tcp", "tcp4",
+// "tcp6", "unix" or "tls". It connects when the first batch is sent.
+//
+// Close must be called to send the queued entries and stop the background
+// goroutine.
+//
+// Example:
+//
+//	w, err := writer.NewForwardWriter("tcp", "fluent-bit.logging:24224", writer.ForwardConfig{
+//	    Tag:        "app.billing",
+//	    RequireAck: true,
+//	})
+//	defer w.Close()
+//	logger := tslog.NewLogger(tslog.WithWriter(w), tslog.WithEncoder(tslog.EncoderJSON))
+func NewForwardWriter(network, addr string, conf ForwardConfig) (*ForwardWriter, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix", "tls":
+	default:
+		return nil, fmt.Errorf("invalid forward config: network must be one of tcp, tcp4, tcp6, unix or tls")
+	}
+
+	if addr == "" {
+		return nil, fmt.Errorf("invalid forward config: address cannot be empty")
+	}
+
+	// Validate configuration
+	if err := conf.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid forward config: %w", err)
+	}
+
+	// Apply defaults
+	conf.setDefaults()
+
+	w := &ForwardWriter{
+		network: network,
+		addr:    addr,
+		conf:    conf,
+		now:     time.Now,
+	}
+	w.AsyncWriter = newBatchWriter(conf.Batch, w.forward)
+	go w.run()
+
+	return w, nil
+}
+
+// MustNewForwardWriter is like NewForwardWriter but panics if the
+// configuration is invalid.
+func MustNewForwardWriter(network, addr string, conf ForwardConfig) *ForwardWriter {
+	writer, err := NewForwardWriter(network, addr, conf)
+	if err != nil {
+		panic(err)
+	}
+	return writer
+}
+
+// Close sends the queued entries as AsyncWriter.Close does, then closes the
+// connection.
+func (w *ForwardWriter) Close() error {
+	err := w.AsyncWriter.Close()
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.closed = true
+	if w.conn != nil {
+		w.conn.Close()
+		w.conn, w.reader = nil, nil
+	}
+	return err
+}
+
+// forward sends a batch of entries.
+func (w *ForwardWriter) forward(batch [][]byte) error {
+	now := w.now()
+
+	if w.conf.Mode == ForwardMessage {
+		for i, entry := range batch {
+			if err := w.send(w.message(entry, now)); err != nil {
+				return fmt.Errorf("failed to forward %d of %d entries: %w", len(batch)-i, len(batch), err)
+			}
+		}
+		return nil
+	}
+
+	if err := w.send(w.packedForward(batch, now)); err != nil {
+		return fmt.Errorf("failed to forward %d entries: %w", len(batch), err)
+	}
+	return nil
+}
+
+// send writes a message built by build, which is given the chunk ID to
+// request an acknowledgment with, and waits for the acknowledgment if
+// RequireAck is set. Failed attempts are retried over a new connection.
+func (w *ForwardWriter) send(build func(chunk string) []byte) error {
+	chunk := ""
+	if w.conf.RequireAck {
+		chunk = forwardChunkID()
+	}
+	msg := build(chunk)
+
+	return w.conf.Retry.do(w.expired, func() error {
+		conn, reader, err := w.connect()
+		if err != nil {
+			return err
+		}
+
+		if err := w.exchange(conn, reader, msg, chunk); err != nil {
+			w.disconnect(conn)
+			return err
+		}
+		return nil
+	})
+}
+
+// exchange writes msg to conn and reads the acknowledgment of chunk, if set.
+func (w *ForwardWriter) exchange(conn net.Conn, reader *bufio.Reader, msg []byte, chunk string) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(w.conf.WriteTimeout)); err != nil {
+		return err
+	}
+	if _, err := conn.Write(msg); err != nil {
+		return err
+	}
+	if chunk == "" {
+		return nil
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(w.conf.AckTimeout)); err != nil {
+		return err
+	}
+	resp, err := readMsgpackValue(reader)
+	if err != nil {
+		return fmt.Errorf("failed to read acknowledgment: %w", err)
+	}
+	if m, ok := resp.(map[string]interface{}); !ok || m["ack"] != chunk {
+		return fmt.Errorf("unexpected acknowledgment %v", resp)
+	}
+	return nil
+}
+
+// connect returns the current connection, establishing one if needed.
+func (w *ForwardWriter) connect() (net.Conn, *bufio.Reader, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return nil, nil, &permanentError{ErrForwardWriterClosed}
+	}
+	if w.conn != nil {
+		return w.conn, w.reader, nil
+	}
+
+	dialer := &net.Dialer{Timeout: w.conf.DialTimeout}
+	var conn net.Conn
+	var err error
+	if w.network == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", w.addr, w.conf.TLSConfig)
+	} else {
+		conn, err = dialer.Dial(w.network, w.addr)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	w.conn, w.reader = conn, bufio.NewReader(conn)
+	return w.conn, w.reader, nil
+}
+
+// disconnect closes conn if it is still the current connection.
+func (w *ForwardWriter) disconnect(conn net.Conn) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	conn.Close()
+	if w.conn == conn {
+		w.conn, w.reader = nil, nil
+	}
+}
+
+// message returns a builder of the Message mode message of entry:
+// [tag, time, record, option].
+func (w *ForwardWriter) message(entry []byte, now time.Time) func(chunk string) []byte {
+	return func(chunk string) []byte {
+		size := 3
+		if chunk != "" {
+			size = 4
+		}
+		b := appendMsgpackArrayHeader(nil, size)
+		b = appendMsgpackString(b, w.conf.Tag)
+		b = w.appendEntry(b, entry, now)
+		if chunk != "" {
+			b = appendMsgpackMapHeader(b, 1)
+			b = appendMsgpackString(b, "chunk")
+			b = appendMsgpackString(b, chunk)
+		}
+		return b
+	}
+}
+
+// packedForward returns a builder of the PackedForward mode message of
+// batch: [tag, entries, option], where entries is the stream of
+// [time, record] pairs.
+func (w *ForwardWriter) packedForward(batch [][]byte, now time.Time) func(chunk string) []byte {
+	return func(chunk string) []byte {
+		var entries []byte
+		for _, entry := range batch {
+			entries = appendMsgpackArrayHeader(entries, 2)
+			entries = w.appendEntry(entries, entry, now)
+		}
+
+		b := appendMsgpackArrayHeader(nil, 3)
+		b = appendMsgpackString(b, w.conf.Tag)
+		b = appendMsgpackBin(b, entries)
+		if chunk != "" {
+			b = appendMsgpackMapHeader(b, 2)
+			b = appendMsgpackString(b, "chunk")
+			b = appendMsgpackString(b, chunk)
+		} else {
+			b = appendMsgpackMapHeader(b, 1)
+		}
+		b = appendMsgpackString(b, "size")
+		return appendMsgpackInt(b, int64(len(batch)))
+	}
+}
+
+// appendEntry appends the time and the record of entry.
+func (w *ForwardWriter) appendEntry(b []byte, entry []byte, now time.Time) []byte {
+	t := now
+	fields, err := decodeEntry(entry)
+	if err == nil {
+		if s, ok := fields[entryTimeKey].(string); ok {
+			if parsed, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				t = parsed
+			}
+		}
+	} else {
+		fields = map[string]interface{}{
+			entryMessageKey: strings.TrimRight(string(entry), "\r\n"),
+		}
+	}
+
+	if w.conf.IntegerTime {
+		b = appendMsgpackInt(b, t.Unix())
+	} else {
+		b = appendMsgpackEventTime(b, t)
+	}
+	return appendMsgpackValue(b, fields)
+}
+
+// forwardChunkID returns a unique chunk ID: 128 random bits in base64.
+func forwardChunkID() string {
+	var id [16]byte
+	_, _ = rand.Read(id[:])
+	return base64.StdEncoding.EncodeToString(id[:])
+}
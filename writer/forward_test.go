@@ -0,0 +1,270 @@
+package writer
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// forwardServer is a stand-in for a forward input, recording the messages
+// it receives
+type forwardServer struct {
+	net.Listener
+	mutex    sync.Mutex
+	drop     int // Number of acknowledgments still to be withheld by closing the connection
+	messages [][]interface{}
+}
+
+// newForwardServer starts a forward stand-in listening on network
+func newForwardServer(t *testing.T, network string) *forwardServer {
+	addr := "127.0.0.1:0"
+	if network == "unix" {
+		addr = filepath.Join(t.TempDir(), "forward.sock")
+	}
+	l, err := net.Listen(network, addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	s := &forwardServer{Listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// serve reads messages from conn and acknowledges those requesting it
+func (s *forwardServer) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		v, err := readMsgpackValue(r)
+		if err != nil {
+			return
+		}
+		msg, _ := v.([]interface{})
+
+		s.mutex.Lock()
+		s.messages = append(s.messages, msg)
+		drop := s.drop > 0
+		if drop {
+			s.drop--
+		}
+		s.mutex.Unlock()
+
+		option, _ := msg[len(msg)-1].(map[string]interface{})
+		if chunk, ok := option["chunk"]; ok {
+			if drop {
+				return
+			}
+			if _, err := conn.Write(appendMsgpackValue(nil, map[string]interface{}{"ack": chunk})); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// received returns the messages received so far
+func (s *forwardServer) received() [][]interface{} {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.messages
+}
+
+// wait waits until n messages are received and returns them
+func (s *forwardServer) wait(t *testing.T, n int) [][]interface{} {
+	t.Helper()
+	require.Eventually(t, func() bool { return len(s.received()) >= n }, 5*time.Second, 10*time.Millisecond)
+	return s.received()
+}
+
+// TestForwardConfig tests forward writer configuration validation
+func TestForwardConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config ForwardConfig
+		errMsg string
+	}{
+		{"Valid", ForwardConfig{Tag: "app", Mode: ForwardMessage, RequireAck: true}, ""},
+		{"Mode", ForwardConfig{Mode: ForwardMode(5)}, "Mode must be either"},
+		{"DialTimeout", ForwardConfig{DialTimeout: -1}, "DialTimeout cannot be negative"},
+		{"WriteTimeout", ForwardConfig{WriteTimeout: -1}, "WriteTimeout cannot be negative"},
+		{"AckTimeout", ForwardConfig{AckTimeout: -1}, "AckTimeout cannot be negative"},
+		{"Batch", ForwardConfig{Batch: AsyncConfig{FlushInterval: -1}}, "invalid Batch"},
+		{"Retry", ForwardConfig{Retry: RetryConfig{MaxBackoff: -1}}, "invalid Retry"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.errMsg == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+
+	t.Run("Defaults", func(t *testing.T) {
+		config := ForwardConfig{}
+		config.setDefaults()
+		assert.NotEmpty(t, config.Tag)
+		assert.Equal(t, 5*time.Second, config.DialTimeout)
+		assert.Equal(t, 5*time.Second, config.WriteTimeout)
+		assert.Equal(t, 30*time.Second, config.AckTimeout)
+		assert.Equal(t, "packed-forward", config.Mode.String())
+	})
+
+	t.Run("Constructor", func(t *testing.T) {
+		_, err := NewForwardWriter("udp", "localhost:24224", ForwardConfig{})
+		assert.Contains(t, err.Error(), "network must be one of")
+		_, err = NewForwardWriter("tcp", "", ForwardConfig{})
+		assert.Contains(t, err.Error(), "address cannot be empty")
+		assert.Panics(t, func() { MustNewForwardWriter("tcp", "localhost:24224", ForwardConfig{Mode: -1}) })
+	})
+}
+
+// TestForwardWriter tests sending entries to a forward stand-in
+func TestForwardWriter(t *testing.T) {
+	fastRetry := RetryConfig{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+
+	t.Run("PackedForward", func(t *testing.T) {
+		server := newForwardServer(t, "tcp")
+		w, err := NewForwardWriter("tcp", server.Addr().String(), ForwardConfig{Tag: "app.billing"})
+		require.NoError(t, err)
+		now := time.Date(2024, 3, 4, 5, 6, 7, 0, time.UTC)
+		w.now = func() time.Time { return now }
+
+		entries := []string{
+			`{"level":"INFO","timestamp":"2024-01-01T00:00:00.5Z","msg":"a","user_id":42,"ctx":{"ok":true}}` + "\n",
+			"2024-01-01T00:00:01.000Z\tINFO\tconsole\n",
+		}
+		for _, entry := range entries {
+			_, err := w.Write([]byte(entry))
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.Close())
+
+		messages := server.wait(t, 1)
+		require.Len(t, messages, 1)
+		require.Len(t, messages[0], 3)
+		assert.Equal(t, "app.billing", messages[0][0])
+		assert.Equal(t, map[string]interface{}{"size": int64(2)}, messages[0][2])
+
+		// The entries are a stream of [time, record] pairs
+		require.IsType(t, []byte{}, messages[0][1])
+		r := bufio.NewReader(bytes.NewReader(messages[0][1].([]byte)))
+		var pairs []interface{}
+		for {
+			v, err := readMsgpackValue(r)
+			if err != nil {
+				break
+			}
+			pairs = append(pairs, v)
+		}
+		assert.Equal(t, []interface{}{
+			[]interface{}{
+				time.Date(2024, 1, 1, 0, 0, 0, 5e8, time.UTC).Local(),
+				map[string]interface{}{
+					"level":     "INFO",
+					"timestamp": "2024-01-01T00:00:00.5Z",
+					"msg":       "a",
+					"user_id":   int64(42),
+					"ctx":       map[string]interface{}{"ok": true},
+				},
+			},
+			[]interface{}{
+				now.Local(),
+				map[string]interface{}{"msg": "2024-01-01T00:00:01.000Z\tINFO\tconsole"},
+			},
+		}, pairs)
+	})
+
+	t.Run("Message", func(t *testing.T) {
+		server := newForwardServer(t, "unix")
+		w, err := NewForwardWriter("unix", server.Addr().String(), ForwardConfig{
+			Tag:         "app",
+			Mode:        ForwardMessage,
+			IntegerTime: true,
+		})
+		require.NoError(t, err)
+
+		for _, msg := range []string{"a", "b"} {
+			_, err := w.Write([]byte(`{"timestamp":"2024-01-01T00:00:00.5Z","msg":"` + msg + `"}` + "\n"))
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.Close())
+
+		messages := server.wait(t, 2)
+		require.Len(t, messages, 2)
+		for i, msg := range []string{"a", "b"} {
+			assert.Equal(t, []interface{}{
+				"app",
+				int64(1704067200),
+				map[string]interface{}{"timestamp": "2024-01-01T00:00:00.5Z", "msg": msg},
+			}, messages[i])
+		}
+	})
+
+	t.Run("RequireAck", func(t *testing.T) {
+		// The first message is received but not acknowledged
+		server := newForwardServer(t, "tcp")
+		server.drop = 1
+		var errs []error
+		w, err := NewForwardWriter("tcp", server.Addr().String(), ForwardConfig{
+			RequireAck: true,
+			Batch:      AsyncConfig{OnError: func(err error) { errs = append(errs, err) }},
+			Retry:      fastRetry,
+		})
+		require.NoError(t, err)
+
+		_, err = w.Write([]byte(`{"msg":"acked"}` + "\n"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		assert.Empty(t, errs)
+		assert.Equal(t, uint64(1), w.Stats().Written)
+
+		// The message is resent with the same chunk
+		messages := server.received()
+		require.Len(t, messages, 2)
+		chunk := messages[0][2].(map[string]interface{})["chunk"]
+		assert.NotEmpty(t, chunk)
+		assert.Equal(t, messages[0], messages[1])
+	})
+
+	t.Run("Unreachable", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := l.Addr().String()
+		l.Close()
+
+		var errs []error
+		w, err := NewForwardWriter("tcp", addr, ForwardConfig{
+			Batch: AsyncConfig{OnError: func(err error) { errs = append(errs, err) }},
+			Retry: RetryConfig{MaxRetries: 1, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		})
+		require.NoError(t, err)
+
+		_, err = w.Write([]byte(`{"msg":"lost"}` + "\n"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		require.Len(t, errs, 1)
+		assert.Contains(t, errs[0].Error(), "failed to forward 1 entries")
+		assert.Equal(t, uint64(1), w.Stats().Failed)
+	})
+}
@@ -0,0 +1,247 @@
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains the retry and request helpers shared by the writers
+// sending batches of entries over HTTP.
+package writer
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// maxErrorBody is the maximum number of bytes of a response body kept in
+// an error.
+const maxErrorBody = 1024
+
+// RetryConfig holds configuration for retrying failed requests.
+type RetryConfig struct {
+	// MaxRetries is the maximum number of times a request is retried after
+	// a network error, a 429 or a 5xx response. Set it to -1 to disable
+	// retries. Defaults to 5 if not specified. Writers give up retrying when
+	// Close stops waiting for them, after the batch CloseTimeout.
+	MaxRetries int
+
+	// MinBackoff is the delay before the first retry. It doubles with each
+	// retry, with random jitter, unless the server asks for a longer delay
+	// with Retry-After. Defaults to 500 milliseconds if not specified.
+	MinBackoff time.Duration
+
+	// MaxBackoff is the maximum delay between retries, including delays
+	// asked for with Retry-After. Defaults to 30 seconds if not specified.
+	MaxBackoff time.Duration
+}
+
+// Validate checks if the configuration is valid and returns an error if not.
+func (c *RetryConfig) Validate() error {
+	if c.MaxRetries < -1 {
+		return fmt.Errorf("MaxRetries must be -1 or greater")
+	}
+
+	if c.MinBackoff < 0 {
+		return fmt.Errorf("MinBackoff cannot be negative")
+	}
+
+	if c.MaxBackoff < 0 {
+		return fmt.Errorf("MaxBackoff cannot be negative")
+	}
+
+	if c.MaxBackoff > 0 && c.MinBackoff > c.MaxBackoff {
+		return fmt.Errorf("MinBackoff cannot be greater than MaxBackoff")
+	}
+
+	return nil
+}
+
+// setDefaults sets default values for unspecified configuration fields.
+func (c *RetryConfig) setDefaults() {
+	if c.MaxRetries == 0 {
+		c.MaxRetries = 5
+	}
+
+	if c.MinBackoff == 0 {
+		c.MinBackoff = 500 * time.Millisecond
+	}
+
+	if c.MaxBackoff == 0 {
+		c.MaxBackoff = 30 * time.Second
+	}
+
+	if c.MinBackoff > c.MaxBackoff {
+		c.MinBackoff = c.MaxBackoff
+	}
+}
+
+// do calls attempt until it succeeds, fails with an error that isn't worth
+// retrying, or MaxRetries is exhausted, and returns its last error. It stops
+// waiting for the next retry and returns the last error once stop is closed.
+func (c RetryConfig) do(stop <-chan struct{}, attempt func() error) error {
+	for retry := 0; ; retry++ {
+		err := attempt()
+		if err == nil || !isRetryable(err) || retry >= c.MaxRetries {
+			return err
+		}
+
+		delay := backoffDelay(c.MinBackoff, c.MaxBackoff, retry+1)
+		var statusErr *httpStatusError
+		if errors.As(err, &statusErr) && statusErr.retryAfter > delay {
+			delay = statusErr.retryAfter
+			if delay > c.MaxBackoff {
+				delay = c.MaxBackoff
+			}
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-stop:
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// backoffDelay returns the delay before the given attempt: min doubled for
+// each previous attempt, capped at max, of which a random half is
+// subtracted so that clients don't retry in lockstep.
+func backoffDelay(min, max time.Duration, attempt int) time.Duration {
+	d := min
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// httpStatusError is returned for responses with an unexpected status.
+type httpStatusError struct {
+	StatusCode int
+	Body       string
+	retryAfter time.Duration // Delay requested by the server
+}
+
+// Error returns the status and the start of the response body.
+func (e *httpStatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("unexpected response status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("unexpected response status %d: %s", e.StatusCode, e.Body)
+}
+
+// permanentError marks an error that retrying won't fix.
+type permanentError struct {
+	err error
+}
+
+// Error returns the wrapped error's message.
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// isRetryable reports whether a failed request is worth retrying: network
+// errors, 429 Too Many Requests and 5xx responses are.
+func isRetryable(err error) bool {
+	var permErr *permanentError
+	if errors.As(err, &permErr) {
+		return false
+	}
+
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode >= 500
+	}
+	return true
+}
+
+// httpRequest describes a request sending a batch of entries.
+type httpRequest struct {
+	client      *http.Client
+	method      string
+	url         string
+	contentType string
+	headers     map[string]string
+	gzip        bool // Compress the body
+}
+
+// send makes the request with body and returns the response body. Responses
+// with a status other than 2xx are returned as an *httpStatusError. The
+// request is aborted when ctx is done.
+func (r *httpRequest) send(ctx context.Context, body []byte) ([]byte, error) {
+	encoding := ""
+	if r.gzip {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(body); err != nil {
+			return nil, &permanentError{err}
+		}
+		if err := gz.Close(); err != nil {
+			return nil, &permanentError{err}
+		}
+		body, encoding = buf.Bytes(), "gzip"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, r.method, r.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, &permanentError{err}
+	}
+	req.Header.Set("Content-Type", r.contentType)
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	for name, value := range r.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if len(respBody) > maxErrorBody {
+			respBody = respBody[:maxErrorBody]
+		}
+		return nil, &httpStatusError{
+			StatusCode: resp.StatusCode,
+			Body:       string(bytes.TrimSpace(respBody)),
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+	return respBody, nil
+}
+
+// parseRetryAfter returns the delay of a Retry-After header, given in
+// seconds or as a date, or 0 if there is none.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
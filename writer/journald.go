@@ -0,0 +1,285 @@
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains a writer sending entries to systemd-journald.
+package writer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// errJournaldUnsupported is returned when passing large entries to journald
+// isn't supported on this system.
+var errJournaldUnsupported = errors.New("journald is not supported on this system")
+
+// JournaldConfig holds configuration for sending entries to systemd-journald.
+type JournaldConfig struct {
+	// SocketPath is the path of journald's native socket. Defaults to
+	// "/run/systemd/journal/socket".
+	SocketPath string
+
+	// Identifier is sent as SYSLOG_IDENTIFIER. Defaults to the name of the
+	// executable.
+	Identifier string
+
+	// Fields are added to every entry, such as {"SERVICE_VERSION": "1.2"}.
+	// Names are sanitized like the names of entry fields, including the
+	// prefix for names the writer sets itself.
+	Fields map[string]string
+}
+
+// Validate checks if the configuration is valid and returns an error if not.
+func (c *JournaldConfig) Validate() error {
+	for name := range c.Fields {
+		if journalFieldName(name) == "" {
+			return fmt.Errorf("field name %q has no valid characters", name)
+		}
+	}
+
+	return nil
+}
+
+// setDefaults sets default values for unspecified configuration fields.
+func (c *JournaldConfig) setDefaults() {
+	if c.SocketPath == "" {
+		c.SocketPath = "/run/systemd/journal/socket"
+	}
+
+	if c.Identifier == "" {
+		c.Identifier = filepath.Base(os.Args[0])
+	}
+}
+
+// JournaldWriter is an io.Writer that sends each written entry to
+// systemd-journald over its native protocol, so entries become structured
+// journal records rather than lines of captured output. It is safe for
+// concurrent use.
+//
+// JSON entries are split into journal fields: the message becomes MESSAGE,
+// the level PRIORITY, the caller CODE_FILE and CODE_LINE, the function
+// CODE_FUNC, and every other field, including those of tslog.T, a field of
+// its own with an upper-cased name. Fields whose names collide with those
+// the writer sets, such as "message" or "priority", get a USER_ prefix
+// instead of duplicating them. The timestamp is left out since journald
+// records its own. Other entries are sent whole as MESSAGE, with a PRIORITY
+// read from their level.
+type JournaldWriter struct {
+	conf   JournaldConfig
+	addr   *net.UnixAddr
+	fields []byte // Encoded Identifier and Fields
+
+	mutex sync.Mutex
+	conn  *net.UnixConn
+}
+
+// NewJournaldWriter creates a new writer sending entries to journald. It
+// doesn't check that journald is running, since the socket may appear later.
+//
+// Example:
+//
+//	w, err := writer.NewJournaldWriter(writer.JournaldConfig{Identifier: "billing"})
+//	defer w.Close()
+//	logger := tslog.NewLogger(tslog.WithWriter(w), tslog.WithEncoder(tslog.EncoderJSON))
+func NewJournaldWriter(conf JournaldConfig) (*JournaldWriter, error) {
+	// Validate configuration
+	if err := conf.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid journald config: %w", err)
+	}
+
+	// Apply defaults
+	conf.setDefaults()
+
+	var fields bytes.Buffer
+	appendJournalField(&fields, "SYSLOG_IDENTIFIER", conf.Identifier)
+	names := make([]string, 0, len(conf.Fields))
+	for name := range conf.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		appendJournalField(&fields, journalUserFieldName(name), conf.Fields[name])
+	}
+
+	return &JournaldWriter{
+		conf:   conf,
+		addr:   &net.UnixAddr{Name: conf.SocketPath, Net: "unixgram"},
+		fields: fields.Bytes(),
+	}, nil
+}
+
+// MustNewJournaldWriter is like NewJournaldWriter but panics if the
+// configuration is invalid.
+func MustNewJournaldWriter(conf JournaldConfig) *JournaldWriter {
+	writer, err := NewJournaldWriter(conf)
+	if err != nil {
+		panic(err)
+	}
+	return writer
+}
+
+// writesEntries marks the writer as treating each Write call as one entry.
+func (w *JournaldWriter) writesEntries() {}
+
+// Write sends each entry in p to journald as one journal entry. Entries
+// too large for a datagram are passed through a file descriptor instead.
+// When sending fails, the entries before the failed one have been sent and
+// are counted in the returned number of bytes.
+func (w *JournaldWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.conn == nil {
+		// Unbound and unconnected, so a restart of journald goes unnoticed
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+		if err != nil {
+			return 0, fmt.Errorf("failed to open journald socket: %w", err)
+		}
+		w.conn = conn
+	}
+
+	n := 0
+	for _, entry := range splitEntries(p) {
+		msg := w.encode(entry)
+		_, _, err := w.conn.WriteMsgUnix(msg, nil, w.addr)
+		if err != nil && isMessageTooLarge(err) {
+			err = sendJournalFile(w.conn, w.addr, msg)
+		}
+		if err != nil {
+			return n, fmt.Errorf("failed to send journal entry: %w", err)
+		}
+		n += len(entry)
+	}
+	return len(p), nil
+}
+
+// Close closes the socket. Writing after Close opens it again.
+func (w *JournaldWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// encode returns the journal entry for p in the native protocol.
+func (w *JournaldWriter) encode(p []byte) []byte {
+	var b bytes.Buffer
+	b.Grow(len(p) + len(w.fields) + 64)
+	b.Write(w.fields)
+
+	fields, err := decodeEntry(p)
+	if err != nil {
+		appendJournalField(&b, "MESSAGE", string(bytes.TrimRight(p, "\r\n")))
+		appendJournalField(&b, "PRIORITY", fmt.Sprint(syslogSeverity(parseLevel(p))))
+		return b.Bytes()
+	}
+
+	level, _ := fields[entryLevelKey].(string)
+	appendJournalField(&b, "PRIORITY", fmt.Sprint(syslogSeverity(normalizeLevel(level))))
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := fieldString(fields[name])
+		switch name {
+		case entryLevelKey, entryTimeKey:
+		case entryMessageKey:
+			appendJournalField(&b, "MESSAGE", value)
+		case entryCallerKey:
+			file, line := splitCaller(value)
+			appendJournalField(&b, "CODE_FILE", file)
+			if line != "" {
+				appendJournalField(&b, "CODE_LINE", line)
+			}
+		case entryFuncKey:
+			appendJournalField(&b, "CODE_FUNC", value)
+		default:
+			if key := journalUserFieldName(name); key != "" {
+				appendJournalField(&b, key, value)
+			}
+		}
+	}
+	return b.Bytes()
+}
+
+// appendJournalField appends a field in the native protocol. Values with
+// newlines are length-prefixed.
+func appendJournalField(b *bytes.Buffer, name, value string) {
+	b.WriteString(name)
+	if !strings.ContainsRune(value, '\n') {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+	b.WriteByte('\n')
+	b.Write(size[:])
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+// journalReservedFields are the journal fields set by the writer itself.
+var journalReservedFields = map[string]bool{
+	"MESSAGE":           true,
+	"PRIORITY":          true,
+	"CODE_FILE":         true,
+	"CODE_LINE":         true,
+	"CODE_FUNC":         true,
+	"SYSLOG_IDENTIFIER": true,
+}
+
+// journalUserFieldName returns the journal field name for a user field
+// name, prefixed with USER_ if it collides with a field the writer sets.
+func journalUserFieldName(name string) string {
+	key := journalFieldName(name)
+	if journalReservedFields[key] {
+		return "USER_" + key
+	}
+	return key
+}
+
+// journalFieldName turns name into a valid journal field name: upper-case
+// letters, digits and underscores, starting with a letter and at most 64
+// characters long. Other characters become underscores. It returns an empty
+// string if no valid name remains.
+func journalFieldName(name string) string {
+	b := make([]byte, 0, len(name))
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z':
+			b = append(b, c-'a'+'A')
+		case c >= 'A' && c <= 'Z':
+			b = append(b, c)
+		case len(b) == 0:
+			// Leading digits and underscores are dropped; fields starting
+			// with an underscore are reserved for journald
+		case c >= '0' && c <= '9':
+			b = append(b, c)
+		default:
+			b = append(b, '_')
+		}
+	}
+	if len(b) > 64 {
+		b = b[:64]
+	}
+	return string(b)
+}
@@ -0,0 +1,89 @@
+//go:build linux
+
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains the passing of large entries to journald.
+package writer
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// journalFileDir is where entries too large for a datagram are written when
+// a memfd can't be created. journald accepts sealed memfds from any client,
+// but unsealed files from unprivileged clients only if they are directly in
+// /dev/shm, /tmp or /var/tmp.
+var journalFileDir = "/dev/shm"
+
+// memfdCreate creates a memfd, replaced in tests to exercise the fallback.
+var memfdCreate = unix.MemfdCreate
+
+// journalSeals are the seals applied to a memfd before passing it to
+// journald, which then maps it instead of copying the entry.
+const journalSeals = unix.F_SEAL_SHRINK | unix.F_SEAL_GROW | unix.F_SEAL_WRITE | unix.F_SEAL_SEAL
+
+// isMessageTooLarge reports whether err means a datagram was too large to
+// send.
+func isMessageTooLarge(err error) bool {
+	return errors.Is(err, syscall.EMSGSIZE) || errors.Is(err, syscall.ENOBUFS)
+}
+
+// sendJournalFile writes msg to a sealed memfd, or to an unlinked temporary
+// file on kernels without memfds, and sends its descriptor to journald,
+// which reads the entry from it.
+func sendJournalFile(conn *net.UnixConn, addr *net.UnixAddr, msg []byte) error {
+	f, err := journalMemfd(msg)
+	if err != nil {
+		f, err = journalTempFile(msg)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, _, err = conn.WriteMsgUnix(nil, syscall.UnixRights(int(f.Fd())), addr)
+	return err
+}
+
+// journalMemfd returns a sealed memfd holding msg.
+func journalMemfd(msg []byte) (*os.File, error) {
+	fd, err := memfdCreate("tslog-journal", unix.MFD_CLOEXEC|unix.MFD_ALLOW_SEALING)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create journal memfd: %w", err)
+	}
+	f := os.NewFile(uintptr(fd), "tslog-journal")
+
+	if _, err := f.Write(msg); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write journal memfd: %w", err)
+	}
+	if _, err := unix.FcntlInt(f.Fd(), unix.F_ADD_SEALS, journalSeals); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to seal journal memfd: %w", err)
+	}
+	return f, nil
+}
+
+// journalTempFile returns an unlinked temporary file in journalFileDir
+// holding msg.
+func journalTempFile(msg []byte) (*os.File, error) {
+	f, err := os.CreateTemp(journalFileDir, "tslog-journal-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create journal file: %w", err)
+	}
+
+	if err := os.Remove(f.Name()); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to unlink journal file: %w", err)
+	}
+	if _, err := f.Write(msg); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write journal file: %w", err)
+	}
+	return f, nil
+}
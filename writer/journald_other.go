@@ -0,0 +1,17 @@
+//go:build !linux
+
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains the fallback for systems without journald.
+package writer
+
+import "net"
+
+// isMessageTooLarge always reports false, leaving errors as they are.
+func isMessageTooLarge(err error) bool {
+	return false
+}
+
+// sendJournalFile always fails with errJournaldUnsupported.
+func sendJournalFile(conn *net.UnixConn, addr *net.UnixAddr, msg []byte) error {
+	return errJournaldUnsupported
+}
@@ -0,0 +1,301 @@
+//go:build linux
+
+package writer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// fakeJournal is a stand-in for journald's native socket
+type fakeJournal struct {
+	t      *testing.T
+	conn   *net.UnixConn
+	path   string
+	sealed bool // Whether the last file received was a sealed memfd
+}
+
+// newFakeJournal listens on a unixgram socket in a temporary directory
+func newFakeJournal(t *testing.T) *fakeJournal {
+	path := filepath.Join(t.TempDir(), "socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return &fakeJournal{t: t, conn: conn, path: path}
+}
+
+// receive reads the next entry and decodes its fields, reading entries
+// passed through a file descriptor from the file
+func (j *fakeJournal) receive() map[string][]string {
+	j.t.Helper()
+	buf := make([]byte, 256*1024)
+	oob := make([]byte, 1024)
+	require.NoError(j.t, j.conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, oobn, _, _, err := j.conn.ReadMsgUnix(buf, oob)
+	require.NoError(j.t, err)
+	data := buf[:n]
+
+	if oobn > 0 {
+		msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		require.NoError(j.t, err)
+		require.Len(j.t, msgs, 1)
+		fds, err := syscall.ParseUnixRights(&msgs[0])
+		require.NoError(j.t, err)
+		require.Len(j.t, fds, 1)
+
+		f := os.NewFile(uintptr(fds[0]), "journal")
+		defer f.Close()
+		seals, err := unix.FcntlInt(f.Fd(), unix.F_GET_SEALS, 0)
+		j.sealed = err == nil && seals&journalSeals == journalSeals
+		fi, err := f.Stat()
+		require.NoError(j.t, err)
+		data = make([]byte, fi.Size())
+		_, err = f.ReadAt(data, 0)
+		require.NoError(j.t, err)
+	}
+
+	return decodeJournalEntry(j.t, data)
+}
+
+// decodeJournalEntry decodes an entry in journald's native protocol
+func decodeJournalEntry(t *testing.T, data []byte) map[string][]string {
+	fields := map[string][]string{}
+	for len(data) > 0 {
+		i := bytes.IndexAny(data, "=\n")
+		require.GreaterOrEqual(t, i, 0, "unterminated field")
+		name := string(data[:i])
+
+		var value []byte
+		if data[i] == '=' {
+			end := bytes.IndexByte(data[i:], '\n')
+			require.GreaterOrEqual(t, end, 0)
+			value, data = data[i+1:i+end], data[i+end+1:]
+		} else {
+			size := int(binary.LittleEndian.Uint64(data[i+1 : i+9]))
+			value = data[i+9 : i+9+size]
+			require.Equal(t, byte('\n'), data[i+9+size])
+			data = data[i+10+size:]
+		}
+		fields[name] = append(fields[name], string(value))
+	}
+	return fields
+}
+
+// TestJournaldWriter tests sending entries to a stand-in journald socket
+func TestJournaldWriter(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		config := JournaldConfig{}
+		config.setDefaults()
+		assert.Equal(t, "/run/systemd/journal/socket", config.SocketPath)
+		assert.Equal(t, filepath.Base(os.Args[0]), config.Identifier)
+
+		config = JournaldConfig{Fields: map[string]string{"__": "x"}}
+		err := config.Validate()
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "no valid characters")
+
+		_, err = NewJournaldWriter(config)
+		assert.Contains(t, err.Error(), "invalid journald config")
+		assert.Panics(t, func() { MustNewJournaldWriter(config) })
+	})
+
+	t.Run("JSONEntry", func(t *testing.T) {
+		j := newFakeJournal(t)
+		w, err := NewJournaldWriter(JournaldConfig{
+			SocketPath: j.path,
+			Identifier: "billing",
+			Fields:     map[string]string{"service.version": "1.2"},
+		})
+		require.NoError(t, err)
+		defer w.Close()
+
+		entry := `{"level":"WARN","timestamp":"2024-01-01T00:00:00Z","logger":"api","caller":"app/main.go:42",` +
+			`"func":"main.run","msg":"slow request","user_id":42,"request-path":"/pay","tags":["a","b"],` +
+			`"stacktrace":"main.run\n\tapp/main.go:42"}` + "\n"
+		n, err := w.Write([]byte(entry))
+		require.NoError(t, err)
+		assert.Equal(t, len(entry), n)
+
+		assert.Equal(t, map[string][]string{
+			"SYSLOG_IDENTIFIER": {"billing"},
+			"SERVICE_VERSION":   {"1.2"},
+			"PRIORITY":          {"4"},
+			"MESSAGE":           {"slow request"},
+			"CODE_FILE":         {"app/main.go"},
+			"CODE_LINE":         {"42"},
+			"CODE_FUNC":         {"main.run"},
+			"LOGGER":            {"api"},
+			"USER_ID":           {"42"},
+			"REQUEST_PATH":      {"/pay"},
+			"TAGS":              {`["a","b"]`},
+			"STACKTRACE":        {"main.run\n\tapp/main.go:42"},
+		}, j.receive())
+	})
+
+	t.Run("ConsoleEntry", func(t *testing.T) {
+		j := newFakeJournal(t)
+		w, err := NewJournaldWriter(JournaldConfig{SocketPath: j.path, Identifier: "billing"})
+		require.NoError(t, err)
+		defer w.Close()
+
+		_, err = w.Write([]byte("2024-01-01T00:00:00.000Z\t\x1b[31mERROR\x1b[0m\tfailed\n"))
+		require.NoError(t, err)
+
+		assert.Equal(t, map[string][]string{
+			"SYSLOG_IDENTIFIER": {"billing"},
+			"MESSAGE":           {"2024-01-01T00:00:00.000Z\t\x1b[31mERROR\x1b[0m\tfailed"},
+			"PRIORITY":          {"3"},
+		}, j.receive())
+	})
+
+	t.Run("MultipleEntries", func(t *testing.T) {
+		j := newFakeJournal(t)
+		w, err := NewJournaldWriter(JournaldConfig{SocketPath: j.path, Identifier: "billing"})
+		require.NoError(t, err)
+		defer w.Close()
+
+		// Each entry of a batched Write becomes its own journal entry
+		batch := `{"level":"INFO","msg":"first"}` + "\n" + `{"level":"ERROR","msg":"second","user_id":7}` + "\n"
+		n, err := w.Write([]byte(batch))
+		require.NoError(t, err)
+		assert.Equal(t, len(batch), n)
+
+		assert.Equal(t, map[string][]string{
+			"SYSLOG_IDENTIFIER": {"billing"},
+			"PRIORITY":          {"6"},
+			"MESSAGE":           {"first"},
+		}, j.receive())
+		assert.Equal(t, map[string][]string{
+			"SYSLOG_IDENTIFIER": {"billing"},
+			"PRIORITY":          {"3"},
+			"MESSAGE":           {"second"},
+			"USER_ID":           {"7"},
+		}, j.receive())
+	})
+
+	t.Run("LargeEntry", func(t *testing.T) {
+		if _, err := os.Stat(journalFileDir); err != nil {
+			t.Skip(err)
+		}
+
+		j := newFakeJournal(t)
+		w, err := NewJournaldWriter(JournaldConfig{SocketPath: j.path, Identifier: "billing"})
+		require.NoError(t, err)
+		defer w.Close()
+
+		msg := strings.Repeat("x", 4*1024*1024)
+		_, err = w.Write([]byte(`{"level":"INFO","msg":"` + msg + `"}`))
+		require.NoError(t, err)
+
+		fields := j.receive()
+		assert.Equal(t, []string{msg}, fields["MESSAGE"])
+		assert.Equal(t, []string{"6"}, fields["PRIORITY"])
+		assert.True(t, j.sealed, "the memfd should be sealed")
+	})
+
+	t.Run("LargeEntryWithoutMemfd", func(t *testing.T) {
+		if _, err := os.Stat(journalFileDir); err != nil {
+			t.Skip(err)
+		}
+		memfdCreate = func(string, int) (int, error) { return -1, syscall.ENOSYS }
+		defer func() { memfdCreate = unix.MemfdCreate }()
+
+		j := newFakeJournal(t)
+		w, err := NewJournaldWriter(JournaldConfig{SocketPath: j.path, Identifier: "billing"})
+		require.NoError(t, err)
+		defer w.Close()
+
+		msg := strings.Repeat("x", 4*1024*1024)
+		_, err = w.Write([]byte(`{"level":"INFO","msg":"` + msg + `"}`))
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{msg}, j.receive()["MESSAGE"])
+		assert.False(t, j.sealed, "a file on /dev/shm isn't sealed")
+	})
+
+	t.Run("ReservedFieldNames", func(t *testing.T) {
+		j := newFakeJournal(t)
+		w, err := NewJournaldWriter(JournaldConfig{
+			SocketPath: j.path,
+			Identifier: "billing",
+			Fields:     map[string]string{"syslog_identifier": "other"},
+		})
+		require.NoError(t, err)
+		defer w.Close()
+
+		entry := `{"level":"ERROR","msg":"failed","message":"user message","priority":"high","code_line":"7"}` + "\n"
+		_, err = w.Write([]byte(entry))
+		require.NoError(t, err)
+
+		// Fields named like the ones the writer sets don't duplicate them
+		assert.Equal(t, map[string][]string{
+			"SYSLOG_IDENTIFIER":      {"billing"},
+			"USER_SYSLOG_IDENTIFIER": {"other"},
+			"PRIORITY":               {"3"},
+			"MESSAGE":                {"failed"},
+			"USER_MESSAGE":           {"user message"},
+			"USER_PRIORITY":          {"high"},
+			"USER_CODE_LINE":         {"7"},
+		}, j.receive())
+	})
+
+	t.Run("NoJournal", func(t *testing.T) {
+		w, err := NewJournaldWriter(JournaldConfig{SocketPath: filepath.Join(t.TempDir(), "missing")})
+		require.NoError(t, err)
+		defer w.Close()
+
+		_, err = w.Write([]byte("lost\n"))
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to send journal entry")
+	})
+}
+
+// TestJournalFieldName tests the sanitization of journal field names
+func TestJournalFieldName(t *testing.T) {
+	tests := map[string]string{
+		"message":               "MESSAGE",
+		"user_id":               "USER_ID",
+		"http.status-code":      "HTTP_STATUS_CODE",
+		"_hidden":               "HIDDEN",
+		"2fa":                   "FA",
+		"ünicode":               "NICODE",
+		"café":                  "CAF__",
+		"__":                    "",
+		strings.Repeat("a", 70): strings.Repeat("A", 64),
+	}
+
+	for name, want := range tests {
+		assert.Equal(t, want, journalFieldName(name), name)
+	}
+}
+
+// TestEntryFields tests decoding the fields of encoded entries
+func TestEntryFields(t *testing.T) {
+	fields, err := decodeEntry([]byte(`{"msg":"hi","n":1.50,"ok":true,"obj":{"a":1}}` + "\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "hi", fieldString(fields["msg"]))
+	assert.Equal(t, "1.50", fieldString(fields["n"]))
+	assert.Equal(t, "true", fieldString(fields["ok"]))
+	assert.Equal(t, `{"a":1}`, fieldString(fields["obj"]))
+
+	_, err = decodeEntry([]byte("plain text\n"))
+	assert.Error(t, err)
+
+	file, line := splitCaller("app/main.go:42")
+	assert.Equal(t, "app/main.go", file)
+	assert.Equal(t, "42", line)
+	file, line = splitCaller("C:/app/main.go")
+	assert.Equal(t, "C:/app/main.go", file)
+	assert.Equal(t, "", line)
+}
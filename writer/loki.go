@@ -0,0 +1,294 @@
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains a writer pushing entries to Grafana Loki.
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lokiPushPath is the path of Loki's push endpoint.
+const lokiPushPath = "/loki/api/v1/push"
+
+// lokiLabelRegexp matches valid Loki label names.
+var lokiLabelRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// LokiConfig holds configuration for pushing entries to Grafana Loki.
+type LokiConfig struct {
+	// URL is the base URL of Loki, such as "http://loki:3100". Entries are
+	// pushed to its /loki/api/v1/push endpoint.
+	URL string
+
+	// Labels are the static labels of all streams, such as
+	// {"app": "billing", "env": "prod"}. Defaults to a "job" label with the
+	// name of the executable.
+	Labels map[string]string
+
+	// LabelKeys are the entry fields promoted to stream labels, such as
+	// "level" or a key of tslog.T. Keys missing from an entry are left out.
+	// Keep them to fields with few distinct values, since every combination
+	// of label values is a stream of its own.
+	LabelKeys []string
+
+	// TenantID is sent as the X-Scope-OrgID header in multi-tenant setups.
+	TenantID string
+
+	// Headers are added to every request, such as an Authorization header.
+	Headers map[string]string
+
+	// Gzip compresses request bodies. Defaults to false.
+	Gzip bool
+
+	// Timeout is the maximum time of a request. Defaults to 10 seconds if
+	// not specified. It is ignored if Client is set.
+	Timeout time.Duration
+
+	// Client makes the requests. Defaults to a client with Timeout.
+	Client *http.Client
+
+	// Batch configures the batching of entries: QueueSize, BatchSize,
+	// FlushInterval, Overflow and OnError, which is called when a batch
+	// can't be pushed.
+	Batch AsyncConfig
+
+	// Retry configures the retrying of pushes failing with a network error,
+	// a 429 or a 5xx response.
+	Retry RetryConfig
+}
+
+// Validate checks if the configuration is valid and returns an error if not.
+func (c *LokiConfig) Validate() error {
+	if c.URL == "" {
+		return fmt.Errorf("URL cannot be empty")
+	}
+
+	if u, err := url.Parse(c.URL); err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("URL must be an http or https URL")
+	}
+
+	for name := range c.Labels {
+		if !lokiLabelRegexp.MatchString(name) {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+	}
+
+	for _, key := range c.LabelKeys {
+		if key == "" {
+			return fmt.Errorf("LabelKeys cannot contain empty keys")
+		}
+	}
+
+	if c.Timeout < 0 {
+		return fmt.Errorf("Timeout cannot be negative")
+	}
+
+	if err := c.Batch.Validate(); err != nil {
+		return fmt.Errorf("invalid Batch: %w", err)
+	}
+
+	if err := c.Retry.Validate(); err != nil {
+		return fmt.Errorf("invalid Retry: %w", err)
+	}
+
+	return nil
+}
+
+// setDefaults sets default values for unspecified configuration fields.
+func (c *LokiConfig) setDefaults() {
+	if len(c.Labels) == 0 {
+		c.Labels = map[string]string{"job": filepath.Base(os.Args[0])}
+	}
+
+	if c.Timeout == 0 {
+		c.Timeout = 10 * time.Second
+	}
+
+	if c.Client == nil {
+		c.Client = &http.Client{Timeout: c.Timeout}
+	}
+
+	c.Retry.setDefaults()
+}
+
+// lokiStream is a stream of a push request.
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// LokiWriter is an io.Writer that pushes entries to Grafana Loki in batches
+// from a background goroutine, replacing an agent tailing the log files. It
+// is safe for concurrent use.
+//
+// The timestamp of each entry is read from its "timestamp" field, falling
+// back to the time it is pushed. Lines are sent as written.
+type LokiWriter struct {
+	*AsyncWriter
+	conf    LokiConfig
+	request httpRequest
+	now     func() time.Time
+}
+
+// NewLokiWriter creates a new writer pushing entries to Loki. JSON entries
+// are needed to promote fields to labels.
+//
+// Close must be called to push the queued entries and stop the background
+// goroutine.
+//
+// Example:
+//
+//	w, err := writer.NewLokiWriter(writer.LokiConfig{
+//	    URL:       "http://loki:3100",
+//	    Labels:    map[string]string{"app": "billing"},
+//	    LabelKeys: []string{"level"},
+//	})
+//	defer w.Close()
+//	logger := tslog.NewLogger(tslog.WithWriter(w), tslog.WithEncoder(tslog.EncoderJSON))
+func NewLokiWriter(conf LokiConfig) (*LokiWriter, error) {
+	// Validate configuration
+	if err := conf.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid loki config: %w", err)
+	}
+
+	// Apply defaults
+	conf.setDefaults()
+
+	headers := map[string]string{}
+	for name, value := range conf.Headers {
+		headers[name] = value
+	}
+	if conf.TenantID != "" {
+		headers["X-Scope-OrgID"] = conf.TenantID
+	}
+
+	w := &LokiWriter{
+		conf: conf,
+		request: httpRequest{
+			client:      conf.Client,
+			method:      http.MethodPost,
+			url:         strings.TrimSuffix(strings.TrimSuffix(conf.URL, "/"), lokiPushPath) + lokiPushPath,
+			contentType: "application/json",
+			headers:     headers,
+			gzip:        conf.Gzip,
+		},
+		now: time.Now,
+	}
+	w.AsyncWriter = newBatchWriter(conf.Batch, w.push)
+	go w.run()
+
+	return w, nil
+}
+
+// MustNewLokiWriter is like NewLokiWriter but panics if the configuration is
+// invalid.
+func MustNewLokiWriter(conf LokiConfig) *LokiWriter {
+	writer, err := NewLokiWriter(conf)
+	if err != nil {
+		panic(err)
+	}
+	return writer
+}
+
+// push sends a batch of entries to Loki, grouped into streams by label set.
+func (w *LokiWriter) push(batch [][]byte) error {
+	streams := map[string]*lokiStream{}
+	now := strconv.FormatInt(w.now().UnixNano(), 10)
+
+	for _, entry := range batch {
+		labels := make(map[string]string, len(w.conf.Labels)+len(w.conf.LabelKeys))
+		for name, value := range w.conf.Labels {
+			labels[name] = value
+		}
+
+		ts := now
+		if fields, err := decodeEntry(entry); err == nil {
+			if s, ok := fields[entryTimeKey].(string); ok {
+				if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+					ts = strconv.FormatInt(t.UnixNano(), 10)
+				}
+			}
+			for _, key := range w.conf.LabelKeys {
+				switch v := fields[key].(type) {
+				case nil, map[string]interface{}, []interface{}:
+				default:
+					labels[lokiLabelName(key)] = fieldString(v)
+				}
+			}
+		}
+
+		key := lokiStreamKey(labels)
+		stream, ok := streams[key]
+		if !ok {
+			stream = &lokiStream{Stream: labels}
+			streams[key] = stream
+		}
+		line := strings.TrimRight(string(entry), "\r\n")
+		stream.Values = append(stream.Values, [2]string{ts, line})
+	}
+
+	keys := make([]string, 0, len(streams))
+	for key := range streams {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	req := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{}
+	for _, key := range keys {
+		req.Streams = append(req.Streams, streams[key])
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to encode Loki push request: %w", err)
+	}
+
+	err = w.conf.Retry.do(w.expired, func() error {
+		_, err := w.request.send(context.Background(), body)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to push %d entries to Loki: %w", len(batch), err)
+	}
+	return nil
+}
+
+// lokiStreamKey returns a key identifying a label set.
+func lokiStreamKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte(0)
+		b.WriteString(labels[name])
+		b.WriteByte(0)
+	}
+	return b.String()
+}
+
+// lokiLabelName turns a field key into a valid label name. Characters other
+// than letters, digits and underscores become underscores.
+func lokiLabelName(key string) string {
+	b := []byte(key)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c >= '0' && c <= '9' && i > 0) {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
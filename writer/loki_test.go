@@ -0,0 +1,308 @@
+package writer
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lokiServer is a stand-in for Loki's push endpoint
+type lokiServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	statuses []int // Statuses of the next responses, 204 once used up
+	requests []*http.Request
+	pushes   [][]lokiStream
+}
+
+// newLokiServer starts a Loki stand-in responding with statuses first
+func newLokiServer(t *testing.T, statuses ...int) *lokiServer {
+	s := &lokiServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// handle records a push request
+func (s *lokiServer) handle(w http.ResponseWriter, r *http.Request) {
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body = gz
+	}
+	var req struct {
+		Streams []lokiStream `json:"streams"`
+	}
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests = append(s.requests, r)
+	status := http.StatusNoContent
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	if status == http.StatusNoContent {
+		s.pushes = append(s.pushes, req.Streams)
+	}
+	w.WriteHeader(status)
+}
+
+// received returns the requests and the successful pushes
+func (s *lokiServer) received() ([]*http.Request, [][]lokiStream) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests, s.pushes
+}
+
+// TestLokiConfig tests Loki configuration validation and defaults
+func TestLokiConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config LokiConfig
+		errMsg string
+	}{
+		{"Valid", LokiConfig{URL: "http://loki:3100"}, ""},
+		{"EmptyURL", LokiConfig{}, "URL cannot be empty"},
+		{"Scheme", LokiConfig{URL: "loki:3100"}, "must be an http or https URL"},
+		{"Label", LokiConfig{URL: "http://loki", Labels: map[string]string{"app-name": "x"}}, `invalid label name "app-name"`},
+		{"LabelKey", LokiConfig{URL: "http://loki", LabelKeys: []string{""}}, "empty keys"},
+		{"Timeout", LokiConfig{URL: "http://loki", Timeout: -1}, "Timeout cannot be negative"},
+		{"Batch", LokiConfig{URL: "http://loki", Batch: AsyncConfig{QueueSize: -1}}, "invalid Batch"},
+		{"Retry", LokiConfig{URL: "http://loki", Retry: RetryConfig{MaxRetries: -2}}, "invalid Retry"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.errMsg == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+
+	t.Run("Defaults", func(t *testing.T) {
+		config := LokiConfig{URL: "http://loki"}
+		config.setDefaults()
+		assert.Contains(t, config.Labels, "job")
+		assert.Equal(t, 10*time.Second, config.Timeout)
+		assert.Equal(t, 10*time.Second, config.Client.Timeout)
+		assert.Equal(t, RetryConfig{MaxRetries: 5, MinBackoff: 500 * time.Millisecond, MaxBackoff: 30 * time.Second}, config.Retry)
+	})
+
+	t.Run("Constructor", func(t *testing.T) {
+		_, err := NewLokiWriter(LokiConfig{})
+		assert.Contains(t, err.Error(), "invalid loki config")
+		assert.Panics(t, func() { MustNewLokiWriter(LokiConfig{}) })
+	})
+}
+
+// TestLokiWriter tests pushing entries to a Loki stand-in
+func TestLokiWriter(t *testing.T) {
+	t.Run("Streams", func(t *testing.T) {
+		server := newLokiServer(t)
+		w, err := NewLokiWriter(LokiConfig{
+			URL:       server.URL + "/",
+			Labels:    map[string]string{"app": "billing"},
+			LabelKeys: []string{"level", "http.method", "ctx"},
+			TenantID:  "team-a",
+			Headers:   map[string]string{"Authorization": "Bearer token"},
+			Gzip:      true,
+		})
+		require.NoError(t, err)
+		w.now = func() time.Time { return time.Unix(1700000000, 0) }
+
+		entries := []string{
+			`{"level":"INFO","timestamp":"2024-01-01T00:00:00Z","msg":"a","http.method":"GET","ctx":{"id":1}}` + "\n",
+			`{"level":"ERROR","timestamp":"2024-01-01T00:00:01.5Z","msg":"b"}` + "\n",
+			`{"level":"INFO","timestamp":"2024-01-01T00:00:02Z","msg":"c","http.method":"GET"}` + "\n",
+			"2024-01-01T00:00:03.000Z\tINFO\tconsole\n",
+		}
+		for _, entry := range entries {
+			_, err := w.Write([]byte(entry))
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.Close())
+
+		requests, pushes := server.received()
+		require.Len(t, requests, 1)
+		assert.Equal(t, lokiPushPath, requests[0].URL.Path)
+		assert.Equal(t, "team-a", requests[0].Header.Get("X-Scope-OrgID"))
+		assert.Equal(t, "Bearer token", requests[0].Header.Get("Authorization"))
+		assert.Equal(t, "application/json", requests[0].Header.Get("Content-Type"))
+
+		require.Len(t, pushes, 1)
+		assert.ElementsMatch(t, []lokiStream{
+			{
+				Stream: map[string]string{"app": "billing", "level": "INFO", "http_method": "GET"},
+				Values: [][2]string{
+					{"1704067200000000000", entries[0][:len(entries[0])-1]},
+					{"1704067202000000000", entries[2][:len(entries[2])-1]},
+				},
+			},
+			{
+				Stream: map[string]string{"app": "billing", "level": "ERROR"},
+				Values: [][2]string{{"1704067201500000000", entries[1][:len(entries[1])-1]}},
+			},
+			{
+				Stream: map[string]string{"app": "billing"},
+				Values: [][2]string{{"1700000000000000000", "2024-01-01T00:00:03.000Z\tINFO\tconsole"}},
+			},
+		}, pushes[0])
+	})
+
+	t.Run("RetryServerErrors", func(t *testing.T) {
+		server := newLokiServer(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+		w, err := NewLokiWriter(LokiConfig{
+			URL:   server.URL,
+			Retry: RetryConfig{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		})
+		require.NoError(t, err)
+
+		_, err = w.Write([]byte(`{"level":"INFO","msg":"retried"}` + "\n"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		requests, pushes := server.received()
+		assert.Len(t, requests, 3)
+		require.Len(t, pushes, 1)
+		assert.Equal(t, `{"level":"INFO","msg":"retried"}`, pushes[0][0].Values[0][1])
+		assert.Equal(t, uint64(1), w.Stats().Written)
+	})
+
+	t.Run("NoRetryClientErrors", func(t *testing.T) {
+		server := newLokiServer(t, http.StatusBadRequest)
+		var errs []error
+		w, err := NewLokiWriter(LokiConfig{
+			URL:   server.URL,
+			Batch: AsyncConfig{OnError: func(err error) { errs = append(errs, err) }},
+			Retry: RetryConfig{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		})
+		require.NoError(t, err)
+
+		_, err = w.Write([]byte(`{"level":"INFO","msg":"rejected"}` + "\n"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		requests, _ := server.received()
+		assert.Len(t, requests, 1)
+		require.Len(t, errs, 1)
+		assert.Contains(t, errs[0].Error(), "failed to push 1 entries to Loki")
+		assert.Contains(t, errs[0].Error(), "unexpected response status 400")
+		assert.Equal(t, uint64(1), w.Stats().Failed)
+	})
+
+	t.Run("CloseStopsRetrying", func(t *testing.T) {
+		server := newLokiServer(t, http.StatusServiceUnavailable)
+		errs := make(chan error, 1)
+		w, err := NewLokiWriter(LokiConfig{
+			URL: server.URL,
+			Batch: AsyncConfig{
+				CloseTimeout: 50 * time.Millisecond,
+				OnError:      func(err error) { errs <- err },
+			},
+			Retry: RetryConfig{MinBackoff: time.Hour, MaxBackoff: time.Hour},
+		})
+		require.NoError(t, err)
+
+		_, err = w.Write([]byte(`{"level":"INFO","msg":"abandoned"}` + "\n"))
+		require.NoError(t, err)
+		require.Eventually(t, func() bool {
+			requests, _ := server.received()
+			return len(requests) == 1
+		}, 5*time.Second, 5*time.Millisecond)
+
+		// The next attempt is given up instead of an hour away
+		assert.ErrorIs(t, w.Close(), ErrCloseTimeout)
+		select {
+		case err := <-errs:
+			assert.Contains(t, err.Error(), "unexpected response status 503")
+		case <-time.After(5 * time.Second):
+			t.Fatal("retry was not interrupted")
+		}
+		requests, _ := server.received()
+		assert.Len(t, requests, 1)
+	})
+}
+
+// TestRetryConfig tests retrying requests
+func TestRetryConfig(t *testing.T) {
+	retry := RetryConfig{MaxRetries: 2}
+	retry.setDefaults()
+	retry.MinBackoff, retry.MaxBackoff = time.Millisecond, time.Millisecond
+
+	attempts := 0
+	err := retry.do(nil, func() error {
+		attempts++
+		return &httpStatusError{StatusCode: http.StatusBadGateway}
+	})
+	assert.Equal(t, 3, attempts)
+	assert.Contains(t, err.Error(), "unexpected response status 502")
+
+	attempts = 0
+	err = retry.do(nil, func() error {
+		attempts++
+		return &permanentError{io.ErrUnexpectedEOF}
+	})
+	assert.Equal(t, 1, attempts)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	retry.MaxRetries = -1
+	attempts = 0
+	_ = retry.do(nil, func() error {
+		attempts++
+		return io.ErrUnexpectedEOF
+	})
+	assert.Equal(t, 1, attempts)
+
+	// Retry-After is capped at MaxBackoff
+	retry.MaxRetries = 1
+	attempts = 0
+	start := time.Now()
+	err = retry.do(nil, func() error {
+		attempts++
+		return &httpStatusError{StatusCode: http.StatusTooManyRequests, retryAfter: time.Hour}
+	})
+	assert.Equal(t, 2, attempts)
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second)
+
+	// Closing stop interrupts the wait for the next retry
+	retry.MaxBackoff = time.Hour
+	stop := make(chan struct{})
+	attempts = 0
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(stop)
+	}()
+	start = time.Now()
+	err = retry.do(stop, func() error {
+		attempts++
+		return &httpStatusError{StatusCode: http.StatusTooManyRequests, retryAfter: time.Hour}
+	})
+	assert.Equal(t, 1, attempts)
+	assert.Contains(t, err.Error(), "unexpected response status 429")
+	assert.Less(t, time.Since(start), time.Second)
+
+	assert.Equal(t, 3*time.Second, parseRetryAfter("3"))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon"))
+	assert.Greater(t, parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)), 50*time.Second)
+}
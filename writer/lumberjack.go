@@ -3,18 +3,75 @@
 package writer
 
 import (
+	"errors"
 	"fmt"
-	"io"
+	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/natefinch/lumberjack"
 )
 
+// ErrInsufficientDiskSpace is returned by RotatingFile.Write and
+// TimeRotatingWriter.Write instead of writing when the free disk space would
+// drop below MinFreeSpace.
+var ErrInsufficientDiskSpace = errors.New("writer: insufficient disk space")
+
+// errDiskFreeUnsupported is returned by diskFree on systems where the free
+// disk space can't be determined.
+var errDiskFreeUnsupported = errors.New("free disk space is not supported on this system")
+
+// diskCheckInterval is how often the free disk space is checked. In between,
+// written bytes are subtracted from the last result.
+const diskCheckInterval = time.Second
+
+// diskGuard keeps an estimate of the free disk space on the volume of a log
+// file, for refusing writes that would leave less than MinFreeSpace.
+type diskGuard struct {
+	free    int64     // Estimated free disk space, -1 if unknown
+	checked time.Time // When free was last measured
+}
+
+// check returns an error if writing n bytes would leave less than minFree
+// megabytes on the volume of dir. The free space is measured with freeSpace
+// at most once per diskCheckInterval.
+func (g *diskGuard) check(dir string, minFree, n int, now time.Time, freeSpace func(string) (uint64, error)) error {
+	if minFree == 0 {
+		return nil
+	}
+
+	if now.Sub(g.checked) >= diskCheckInterval {
+		g.checked = now
+		free, err := freeSpace(dir)
+		if err != nil {
+			g.free = -1 // Don't stop writing because the guard is unavailable
+		} else {
+			g.free = int64(free)
+		}
+	}
+
+	min := int64(minFree) * megabyte
+	if g.free >= 0 && g.free-int64(n) < min {
+		return fmt.Errorf("%w: %d MB free on %s, keeping at least %d MB",
+			ErrInsufficientDiskSpace, g.free/megabyte, dir, minFree)
+	}
+	return nil
+}
+
+// wrote subtracts n written bytes from the estimate.
+func (g *diskGuard) wrote(n int) {
+	g.free -= int64(n)
+}
+
 // LumberJackConfig holds configuration for file-based logging with rotation.
 // It provides options for controlling log file size, retention, and rotation behavior.
 type LumberJackConfig struct {
-	// FilePath is the path to the log file. If the directory doesn't exist,
-	// it will be created automatically.
+	// FilePath is the path to the log file. Its directory must exist and be
+	// writable unless CreateDirs is set.
 	FilePath string
 
 	// MaxRotatedSize is the maximum size in megabytes of the log file before
@@ -30,13 +87,69 @@ type LumberJackConfig struct {
 	// Defaults to 3 if not specified. Set to 0 to disable count-based retention.
 	MaxRetainFiles int
 
+	// MaxTotalSize is the maximum size in megabytes of the log file and all
+	// rotated files together, using the compressed size of compressed files.
+	// The oldest rotated files are removed first. It can't be less than
+	// MaxRotatedSize. Set to 0 to disable size-based retention.
+	MaxTotalSize int
+
+	// MinFreeSpace is the free disk space in megabytes to leave on the
+	// volume of the log file. Writes that would leave less fail with
+	// ErrInsufficientDiskSpace rather than fill the volume. The guard is
+	// only available on Linux, macOS and FreeBSD. Set to 0 to disable it.
+	MinFreeSpace int
+
 	// LocalTime determines if the time used for formatting the timestamps
 	// in backup files is the computer's local time. Defaults to UTC time.
 	LocalTime bool
 
-	// Compress determines if the rotated log files should be compressed
-	// using gzip. Defaults to false.
+	// Compress determines if the rotated log files should be compressed.
+	// Defaults to false.
 	Compress bool
+
+	// Compression is the format used when Compress is set, such as
+	// GzipCompressor(gzip.BestSpeed), ZstdCompressor(3) or XzCompressor().
+	// Its extension is appended to the names of compressed files. Defaults
+	// to gzip with the default compression level.
+	Compression Compressor
+
+	// CreateDirs determines if missing directories of FilePath are created
+	// with DirMode when the writer is created. Without it, a missing
+	// directory makes NewLumberJackWriter fail. Defaults to false.
+	CreateDirs bool
+
+	// DirMode is the permission bits of the directories created for
+	// FilePath and ArchiveDir. Defaults to 0755 if not specified.
+	DirMode os.FileMode
+
+	// FileMode is the permission bits of the log file, carried over to new
+	// files and rotated backups. If set, it is applied to an existing file
+	// too. If not specified, a new file is created with 0644 and an
+	// existing file keeps its mode.
+	FileMode os.FileMode
+
+	// UID and GID are the owner and group of the log file, carried over to
+	// new files and rotated backups like FileMode. Ownership is left
+	// unchanged if nil. Changing the owner usually requires privileges.
+	UID *int
+	GID *int
+
+	// ArchiveDir is the directory rotated files are moved to after they
+	// have been compressed. It is created with DirMode if needed, and files
+	// are copied when it is on another file system. Retention limits only
+	// cover the rotated files still next to the log file. Leave it empty
+	// to keep rotated files there.
+	ArchiveDir string
+
+	// OnRotate is called from a background goroutine with the final path
+	// of each file rotated by this writer, after compression and archiving,
+	// e.g. to notify an uploader.
+	OnRotate func(path string)
+
+	// OnError is called from a background goroutine when compressing,
+	// removing or archiving rotated files fails. Archiving is retried on
+	// the next rotation. Errors are discarded if it is nil.
+	OnError func(err error)
 }
 
 // Validate checks if the configuration is valid and returns an error if not.
@@ -57,6 +170,30 @@ func (c *LumberJackConfig) Validate() error {
 		return fmt.Errorf("MaxRetainFiles cannot be negative")
 	}
 
+	if c.MaxTotalSize < 0 {
+		return fmt.Errorf("MaxTotalSize cannot be negative")
+	}
+
+	if c.MaxTotalSize > 0 && (c.MaxTotalSize < c.MaxRotatedSize || c.MaxRotatedSize == 0 && c.MaxTotalSize < 100) {
+		return fmt.Errorf("MaxTotalSize cannot be less than MaxRotatedSize")
+	}
+
+	if c.MinFreeSpace < 0 {
+		return fmt.Errorf("MinFreeSpace cannot be negative")
+	}
+
+	if err := c.Compression.validate(); err != nil {
+		return fmt.Errorf("invalid Compression: %w", err)
+	}
+
+	if c.DirMode&^os.ModePerm != 0 || c.FileMode&^os.ModePerm != 0 {
+		return fmt.Errorf("DirMode and FileMode can only contain permission bits")
+	}
+
+	if c.UID != nil && *c.UID < 0 || c.GID != nil && *c.GID < 0 {
+		return fmt.Errorf("UID and GID cannot be negative")
+	}
+
 	return nil
 }
 
@@ -73,6 +210,10 @@ func (c *LumberJackConfig) setDefaults() {
 	if c.MaxRetainFiles == 0 {
 		c.MaxRetainFiles = 3 // 3 files default
 	}
+
+	if c.DirMode == 0 {
+		c.DirMode = 0755
+	}
 }
 
 // NewLumberJackWriter creates a new file writer with rotation capabilities
@@ -83,11 +224,14 @@ func (c *LumberJackConfig) setDefaults() {
 // - Automatic log rotation based on file size
 // - Age-based log file cleanup
 // - Count-based log file cleanup
+// - Total disk usage limit and free disk space guard
 // - Optional compression of rotated files
+// - Archive directory and rotation callback
+// - Configurable mode and ownership of log files
 // - Thread-safe operations
 //
-// The writer ensures that the directory containing the log file exists,
-// creating it if necessary.
+// The log file is created, or opened, right away so that a missing or
+// unwritable directory is reported here rather than on the first write.
 //
 // Example:
 //
@@ -100,8 +244,10 @@ func (c *LumberJackConfig) setDefaults() {
 //	    Compress: true,
 //	}
 //	writer := writer.NewLumberJackWriter(config)
+//	writer.HandleSignals() // Reopen on SIGHUP from logrotate's postrotate
+//	defer writer.Close()
 //	logger := tslog.NewLogger(tslog.WithWriter(writer))
-func NewLumberJackWriter(conf LumberJackConfig) (io.Writer, error) {
+func NewLumberJackWriter(conf LumberJackConfig) (*RotatingFile, error) {
 	// Validate configuration
 	if err := conf.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid lumberjack config: %w", err)
@@ -112,22 +258,50 @@ func NewLumberJackWriter(conf LumberJackConfig) (io.Writer, error) {
 
 	// Ensure the directory exists
 	dir := filepath.Dir(conf.FilePath)
-	if dir != "" && dir != "." {
-		// Note: We don't create the directory here as lumberjack will handle it,
-		// but we could add this functionality if needed
+	if conf.CreateDirs {
+		if err := os.MkdirAll(dir, conf.DirMode); err != nil {
+			return nil, fmt.Errorf("failed to create log directory: %w", err)
+		}
+	} else if fi, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("log directory is not accessible: %w", err)
+	} else if !fi.IsDir() {
+		return nil, fmt.Errorf("log directory %s is not a directory", dir)
 	}
 
-	// Create and configure the lumberjack logger
+	// Create and configure the lumberjack logger. It only opens and renames
+	// files; retention and compression are handled by the mill so they can
+	// account for the total size
 	logger := &lumberjack.Logger{
-		Filename:   conf.FilePath,
-		MaxSize:    conf.MaxRotatedSize,
-		MaxAge:     conf.MaxRetainDay,
-		MaxBackups: conf.MaxRetainFiles,
-		LocalTime:  conf.LocalTime,
-		Compress:   conf.Compress,
+		Filename:  conf.FilePath,
+		MaxSize:   conf.MaxRotatedSize,
+		LocalTime: conf.LocalTime,
 	}
 
-	return logger, nil
+	f := &RotatingFile{
+		conf:      conf,
+		logger:    logger,
+		now:       time.Now,
+		freeSpace: diskFree,
+	}
+	f.mill = &mill{
+		list:       f.listBackups,
+		compressor: compressorOf(conf.Compress, conf.Compression),
+		maxAge:     time.Duration(conf.MaxRetainDay) * 24 * time.Hour,
+		maxFiles:   conf.MaxRetainFiles,
+		maxTotal:   int64(conf.MaxTotalSize) * megabyte,
+		activeSize: f.activeSize,
+		archiveDir: conf.ArchiveDir,
+		dirMode:    conf.DirMode,
+		onRotate:   conf.OnRotate,
+		onError:    conf.OnError,
+	}
+
+	if err := f.prepareLocked(); err != nil {
+		return nil, err
+	}
+	// Apply the retention limits to existing backups
+	f.mill.trigger()
+	return f, nil
 }
 
 // MustNewLumberJackWriter is like NewLumberJackWriter but panics if the
@@ -141,10 +315,329 @@ func NewLumberJackWriter(conf LumberJackConfig) (io.Writer, error) {
 //	    MaxRotatedSize: 100,
 //	}
 //	writer := writer.MustNewLumberJackWriter(config)
-func MustNewLumberJackWriter(conf LumberJackConfig) io.Writer {
+func MustNewLumberJackWriter(conf LumberJackConfig) *RotatingFile {
 	writer, err := NewLumberJackWriter(conf)
 	if err != nil {
 		panic(err)
 	}
 	return writer
 }
+
+// RotatingFile is the file writer returned by NewLumberJackWriter. Besides
+// rotating automatically, it can be rotated on demand or told to reopen its
+// file after an external tool such as logrotate has moved it.
+//
+// Writes, rotations and reopens are serialized, so each entry is written
+// whole to either the old or the new file. It is safe for concurrent use.
+type RotatingFile struct {
+	conf      LumberJackConfig
+	logger    *lumberjack.Logger
+	mill      *mill
+	now       func() time.Time
+	freeSpace func(dir string) (uint64, error)
+
+	mutex sync.Mutex
+	file  *os.File // The log file lumberjack writes to, nil once closed
+	size  int64    // Size of the log file
+	guard diskGuard
+
+	sigMutex sync.Mutex
+	signals  chan os.Signal // Signals being handled, nil if none
+	done     chan struct{}  // Closed when the signal goroutine exits
+}
+
+// Write writes p to the log file, rotating it first if p doesn't fit
+// within MaxRotatedSize. It fails with ErrInsufficientDiskSpace if writing
+// p would leave less than MinFreeSpace on the volume.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	err := f.guard.check(filepath.Dir(f.conf.FilePath), f.conf.MinFreeSpace, len(p), f.now(), f.freeSpace)
+	if err != nil {
+		return 0, err
+	}
+
+	if f.file == nil {
+		if err := f.prepareLocked(); err != nil {
+			return 0, err
+		}
+	}
+
+	// Rotate before lumberjack would, so the new file gets the configured
+	// mode and ownership
+	if f.size > 0 && f.size+int64(len(p)) >= int64(f.conf.MaxRotatedSize)*megabyte {
+		if err := f.rotateLocked(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.logger.Write(p)
+	f.size += int64(n)
+	f.guard.wrote(n)
+	return n, err
+}
+
+// Rotate closes the log file, renames it to a backup name with the current
+// timestamp and opens a new file at the configured path. Retention and
+// compression are applied to the backups in the background.
+func (f *RotatingFile) Rotate() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.rotateLocked()
+}
+
+// Reopen closes the log file so that the next write opens the file at the
+// configured path again, creating it if it no longer exists. Call it after
+// an external tool has renamed or removed the file.
+func (f *RotatingFile) Reopen() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if err := f.closeLocked(); err != nil {
+		return err
+	}
+	return f.prepareLocked()
+}
+
+// Sync commits the log file's contents to stable storage.
+func (f *RotatingFile) Sync() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return nil // Closed, so nothing was written since
+	}
+	// lumberjack doesn't expose its file, but the writer keeps its own
+	// descriptor of the same file, which flushes the same data
+	return f.file.Sync()
+}
+
+// Close stops signal handling, closes the log file and waits for background
+// processing of rotated files to finish. Writing after Close reopens the
+// file.
+func (f *RotatingFile) Close() error {
+	f.stopSignals()
+
+	f.mutex.Lock()
+	err := f.closeLocked()
+	f.mutex.Unlock()
+
+	f.mill.close()
+	return err
+}
+
+// HandleSignals reopens the log file whenever one of sigs is received,
+// defaulting to SIGHUP. This matches the postrotate convention of
+// logrotate, which moves the file and then signals the process:
+//
+//	/var/log/app.log {
+//	    daily
+//	    postrotate
+//	        kill -HUP $(cat /var/run/app.pid)
+//	    endscript
+//	}
+//
+// Calling HandleSignals again replaces the handled signals. Handling stops
+// when the file is closed.
+func (f *RotatingFile) HandleSignals(sigs ...os.Signal) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGHUP}
+	}
+
+	f.stopSignals()
+
+	f.sigMutex.Lock()
+	defer f.sigMutex.Unlock()
+
+	f.signals = make(chan os.Signal, 1)
+	f.done = make(chan struct{})
+	signal.Notify(f.signals, sigs...)
+
+	go func(signals <-chan os.Signal, done chan<- struct{}) {
+		defer close(done)
+		for range signals {
+			_ = f.Reopen()
+		}
+	}(f.signals, f.done)
+}
+
+// stopSignals stops handling signals and waits for the handler to exit.
+func (f *RotatingFile) stopSignals() {
+	f.sigMutex.Lock()
+	signals, done := f.signals, f.done
+	f.signals, f.done = nil, nil
+	f.sigMutex.Unlock()
+
+	if signals != nil {
+		signal.Stop(signals)
+		close(signals)
+		<-done
+	}
+}
+
+// rotateLocked rotates the log file and applies the configured mode and
+// ownership to the new one.
+func (f *RotatingFile) rotateLocked() error {
+	// lumberjack picks the backup name, so find it by comparing the backups
+	// before and after rotating
+	before, err := f.listBackups()
+	if err != nil {
+		return err
+	}
+	if err := f.logger.Rotate(); err != nil {
+		return err
+	}
+	if f.file != nil {
+		f.file.Close()
+		f.file = nil
+	}
+	after, err := f.listBackups()
+	if err != nil {
+		return err
+	}
+	defer f.mill.trigger(newBackups(before, after)...)
+
+	return f.prepareLocked()
+}
+
+// prepareLocked creates the log file if needed, applies the configured
+// mode and ownership, and keeps the file open for syncing. lumberjack
+// copies the mode and ownership to the files it creates.
+func (f *RotatingFile) prepareLocked() error {
+	mode := f.conf.FileMode
+	if mode == 0 {
+		mode = 0644
+	}
+	name := f.conf.FilePath
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, mode)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	if f.conf.FileMode != 0 {
+		if err := file.Chmod(f.conf.FileMode); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to set mode of log file: %w", err)
+		}
+	}
+
+	if f.conf.UID != nil || f.conf.GID != nil {
+		if err := file.Chown(ownerID(f.conf.UID), ownerID(f.conf.GID)); err != nil {
+			file.Close()
+			return fmt.Errorf("failed to set owner of log file: %w", err)
+		}
+	}
+
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+	f.file = file
+	f.size = fi.Size()
+	return nil
+}
+
+// closeLocked closes the log file and the writer's own descriptor of it.
+func (f *RotatingFile) closeLocked() error {
+	err := f.logger.Close()
+	if f.file != nil {
+		if cerr := f.file.Close(); err == nil {
+			err = cerr
+		}
+		f.file = nil
+	}
+	return err
+}
+
+// ownerID returns the user or group ID to pass to os.Chown for id, -1 to
+// leave it unchanged if nil.
+func ownerID(id *int) int {
+	if id == nil {
+		return -1
+	}
+	return *id
+}
+
+// activeSize returns the size of the log file.
+func (f *RotatingFile) activeSize() int64 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.size
+}
+
+// listBackups returns the rotated files, with the time encoded in their
+// name as their modification time.
+func (f *RotatingFile) listBackups() ([]backupFile, error) {
+	return listLumberjackBackups(f.conf.FilePath, f.conf.LocalTime, f.conf.Compression.orDefault().extensions())
+}
+
+// newBackups returns the paths of the files in after that aren't in before.
+func newBackups(before, after []backupFile) []string {
+	seen := make(map[string]bool, len(before))
+	for _, f := range before {
+		seen[f.path] = true
+	}
+
+	var paths []string
+	for _, f := range after {
+		if !seen[f.path] {
+			paths = append(paths, f.path)
+		}
+	}
+	return paths
+}
+
+// lumberjackBackupTimeFormat is the format of the time lumberjack inserts
+// into the names of rotated files.
+const lumberjackBackupTimeFormat = "2006-01-02T15-04-05.000"
+
+// lumberjackBackupName returns the name lumberjack gives the file at path
+// when rotating it at t, such as "app-2024-01-01T10-30-00.000.log".
+func lumberjackBackupName(path string, t time.Time) string {
+	dir, name := filepath.Split(path)
+	ext := filepath.Ext(name)
+	return filepath.Join(dir, name[:len(name)-len(ext)]+"-"+t.Format(lumberjackBackupTimeFormat)+ext)
+}
+
+// listLumberjackBackups returns the files rotated from the log file at
+// path using lumberjack's naming, with the time encoded in their name as
+// their modification time.
+func listLumberjackBackups(path string, localTime bool, exts []string) ([]backupFile, error) {
+	dir := filepath.Dir(path)
+	name := filepath.Base(path)
+	ext := filepath.Ext(name)
+	prefix := name[:len(name)-len(ext)] + "-"
+
+	loc := time.UTC
+	if localTime {
+		loc = time.Local
+	}
+
+	files, err := listBackupFiles(dir, func(name string) bool {
+		_, ok := lumberjackBackupTime(name, prefix, ext, exts, loc)
+		return ok
+	}, path)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range files {
+		files[i].modTime, _ = lumberjackBackupTime(filepath.Base(files[i].path), prefix, ext, exts, loc)
+	}
+	return files, nil
+}
+
+// lumberjackBackupTime parses the time from the name of a file rotated by
+// lumberjack, such as "app-2024-01-01T10-30-00.000.log.gz", that may end
+// with one of the compression extensions exts.
+func lumberjackBackupTime(name, prefix, ext string, exts []string, loc *time.Location) (time.Time, bool) {
+	name = strings.TrimSuffix(name, compressionExt(name, exts))
+	if len(name) < len(prefix)+len(ext) || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(lumberjackBackupTimeFormat, name[len(prefix):len(name)-len(ext)], loc)
+	return t, err == nil
+}
@@ -0,0 +1,355 @@
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains the background processing of rotated log files shared by
+// the rotating file writers: compression, retention and archiving.
+package writer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupFile describes a rotated log file.
+type backupFile struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// mill compresses rotated log files, removes them once they fall outside
+// the retention limits, and hands newly rotated files over to the archive
+// directory and rotation callback. It runs in a background goroutine started
+// on the first rotation, so rotation never waits for it.
+type mill struct {
+	// list returns the rotated files, not including the active file
+	list func() ([]backupFile, error)
+	// compressor compresses rotated files, nil to leave them uncompressed
+	compressor *Compressor
+	// maxAge is the age after which rotated files are removed, 0 to keep them
+	maxAge time.Duration
+	// maxFiles is the number of rotated files to keep, 0 to keep them all
+	maxFiles int
+	// maxTotal is the total size in bytes of the rotated files and the
+	// active file to keep, 0 for no limit
+	maxTotal int64
+	// activeSize returns the size of the active file, counted in maxTotal
+	activeSize func() int64
+	// archiveDir is the directory rotated files are moved to, if not empty
+	archiveDir string
+	// dirMode is the permission bits of a created archive directory
+	dirMode os.FileMode
+	// onRotate is called with the final path of each rotated file
+	onRotate func(path string)
+	// onError is called with the errors of a run
+	onError func(err error)
+	// lock, if set, is held during each run to serialize runs with other
+	// processes sharing the files
+	lock func() (unlock func(), err error)
+
+	mutex   sync.Mutex
+	ch      chan struct{} // Triggers a run, nil while the goroutine isn't running
+	done    chan struct{} // Closed when the goroutine exits
+	rotated []string      // Rotated files not yet archived and reported
+}
+
+// trigger schedules a run of the mill, starting its goroutine if needed.
+// Runs requested while one is already pending are merged. The paths of
+// files that have just been rotated are archived and reported by the run.
+func (m *mill) trigger(rotated ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.rotated = append(m.rotated, rotated...)
+
+	if m.ch == nil {
+		m.ch = make(chan struct{}, 1)
+		m.done = make(chan struct{})
+		go m.loop(m.ch, m.done)
+	}
+
+	select {
+	case m.ch <- struct{}{}:
+	default:
+	}
+}
+
+// close waits for a pending run to complete and stops the goroutine.
+// A later trigger starts it again.
+func (m *mill) close() {
+	m.mutex.Lock()
+	ch, done := m.ch, m.done
+	m.ch, m.done = nil, nil
+	m.mutex.Unlock()
+
+	if ch != nil {
+		close(ch)
+		<-done
+	}
+}
+
+// loop runs the mill each time it is triggered until ch is closed.
+func (m *mill) loop(ch <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	for range ch {
+		if err := m.run(); err != nil && m.onError != nil {
+			m.onError(err)
+		}
+	}
+}
+
+// run applies the retention limits, compresses the remaining files, and
+// archives and reports the rotated files.
+func (m *mill) run() error {
+	if m.lock != nil {
+		unlock, err := m.lock()
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+
+	files, err := m.list()
+	if err != nil {
+		return err
+	}
+
+	// Newest first
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.After(files[j].modTime)
+	})
+
+	var remove, keep []backupFile
+	cutoff := time.Now().Add(-m.maxAge)
+	for i, f := range files {
+		switch {
+		case m.maxFiles > 0 && i >= m.maxFiles:
+			remove = append(remove, f)
+		case m.maxAge > 0 && f.modTime.Before(cutoff):
+			remove = append(remove, f)
+		default:
+			keep = append(keep, f)
+		}
+	}
+
+	var errs []string
+	for _, f := range remove {
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if m.compressor != nil {
+		exts := m.compressor.extensions()
+		for i, f := range keep {
+			if compressionExt(f.path, exts) != "" {
+				continue
+			}
+			dst := f.path + m.compressor.Extension
+			if err := compressFile(f.path, dst, m.compressor.NewWriter); err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			// Count the compressed size towards maxTotal
+			if fi, err := os.Stat(dst); err == nil {
+				keep[i] = backupFile{path: dst, modTime: f.modTime, size: fi.Size()}
+			}
+		}
+	}
+
+	if m.maxTotal > 0 {
+		total := int64(0)
+		if m.activeSize != nil {
+			total = m.activeSize()
+		}
+		for _, f := range keep {
+			total += f.size
+			if total <= m.maxTotal {
+				continue
+			}
+			// Oldest files are the last to be counted
+			if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+
+	errs = append(errs, m.handOver()...)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("processing rotated files: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// handOver moves the rotated files to the archive directory and reports
+// them. Files that can't be archived, or haven't been compressed yet, are
+// retried on the next run.
+func (m *mill) handOver() []string {
+	m.mutex.Lock()
+	rotated := m.rotated
+	m.mutex.Unlock()
+
+	if len(rotated) == 0 || m.archiveDir == "" && m.onRotate == nil {
+		m.mutex.Lock()
+		m.rotated = m.rotated[len(rotated):]
+		m.mutex.Unlock()
+		return nil
+	}
+
+	ext := ""
+	if m.compressor != nil {
+		ext = m.compressor.Extension
+	}
+
+	var errs []string
+	var retry []string
+	for _, path := range rotated {
+		switch {
+		case ext != "" && fileExists(path+ext):
+			path += ext
+		case ext != "" && fileExists(path):
+			// Compression failed and is retried first
+			retry = append(retry, path)
+			continue
+		case !fileExists(path):
+			continue // Removed by retention
+		}
+
+		if m.archiveDir != "" {
+			dst, err := m.archive(path)
+			if err != nil {
+				errs = append(errs, err.Error())
+				retry = append(retry, strings.TrimSuffix(path, ext))
+				continue
+			}
+			path = dst
+		}
+
+		if m.onRotate != nil {
+			m.onRotate(path)
+		}
+	}
+
+	// Keep the files rotated during this run
+	m.mutex.Lock()
+	m.rotated = append(retry, m.rotated[len(rotated):]...)
+	m.mutex.Unlock()
+	return errs
+}
+
+// archive moves the file at path to the archive directory and returns its
+// new path. Moves across file systems fall back to copying.
+func (m *mill) archive(path string) (string, error) {
+	if err := os.MkdirAll(m.archiveDir, m.dirMode); err != nil {
+		return "", fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	dst := filepath.Join(m.archiveDir, filepath.Base(path))
+	if err := os.Rename(path, dst); err == nil {
+		return dst, nil
+	}
+
+	if err := copyFile(path, dst); err != nil {
+		return "", fmt.Errorf("failed to archive log file: %w", err)
+	}
+	if err := os.Remove(path); err != nil {
+		return "", fmt.Errorf("failed to remove archived log file: %w", err)
+	}
+	return dst, nil
+}
+
+// copyFile copies src to dst, preserving its mode and modification time.
+func copyFile(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a partial copy never looks like
+	// a complete file
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fi.Mode())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	if _, err = io.Copy(out, in); err != nil {
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	if err = os.Chtimes(tmp, fi.ModTime(), fi.ModTime()); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// compressFile compresses src into dst with the writer returned by
+// newWriter, preserving its mode and modification time, and removes src.
+func compressFile(src, dst string, newWriter func(io.Writer) io.WriteCloser) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer in.Close()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+
+	// Write to a temporary file first so a partial result never looks
+	// like a complete backup
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fi.Mode())
+	if err != nil {
+		return fmt.Errorf("failed to open compressed log file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(tmp)
+		}
+	}()
+
+	cw := newWriter(out)
+	if _, err = io.Copy(cw, in); err != nil {
+		return fmt.Errorf("failed to compress log file: %w", err)
+	}
+	if err = cw.Close(); err != nil {
+		return fmt.Errorf("failed to compress log file: %w", err)
+	}
+	if err = out.Close(); err != nil {
+		return fmt.Errorf("failed to close compressed log file: %w", err)
+	}
+	if err = os.Chtimes(tmp, fi.ModTime(), fi.ModTime()); err != nil {
+		return fmt.Errorf("failed to set time of compressed log file: %w", err)
+	}
+	if err = os.Rename(tmp, dst); err != nil {
+		return fmt.Errorf("failed to rename compressed log file: %w", err)
+	}
+
+	in.Close()
+	if err = os.Remove(src); err != nil {
+		return fmt.Errorf("failed to remove log file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,371 @@
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains a minimal MessagePack codec, covering the types needed
+// to speak the Fluent Forward protocol.
+package writer
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// msgpackEventTimeType is the extension type of Fluent's EventTime.
+const msgpackEventTimeType = 0
+
+// msgpackMaxLen is the maximum length of a decoded string, binary, array or
+// map, which guards against allocating for a corrupt length.
+const msgpackMaxLen = 64 << 20
+
+// appendMsgpackNil appends a nil.
+func appendMsgpackNil(b []byte) []byte {
+	return append(b, 0xc0)
+}
+
+// appendMsgpackBool appends a boolean.
+func appendMsgpackBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 0xc3)
+	}
+	return append(b, 0xc2)
+}
+
+// appendMsgpackInt appends a signed integer in its smallest encoding.
+func appendMsgpackInt(b []byte, v int64) []byte {
+	switch {
+	case v >= 0:
+		return appendMsgpackUint(b, uint64(v))
+	case v >= -32:
+		return append(b, byte(v))
+	case v >= math.MinInt8:
+		return append(b, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		return appendUint16(append(b, 0xd1), uint16(v))
+	case v >= math.MinInt32:
+		return appendUint32(append(b, 0xd2), uint32(v))
+	default:
+		return appendUint64(append(b, 0xd3), uint64(v))
+	}
+}
+
+// appendMsgpackUint appends an unsigned integer in its smallest encoding.
+func appendMsgpackUint(b []byte, v uint64) []byte {
+	switch {
+	case v <= math.MaxInt8:
+		return append(b, byte(v))
+	case v <= math.MaxUint8:
+		return append(b, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		return appendUint16(append(b, 0xcd), uint16(v))
+	case v <= math.MaxUint32:
+		return appendUint32(append(b, 0xce), uint32(v))
+	default:
+		return appendUint64(append(b, 0xcf), v)
+	}
+}
+
+// appendMsgpackFloat appends a 64-bit float.
+func appendMsgpackFloat(b []byte, v float64) []byte {
+	return appendUint64(append(b, 0xcb), math.Float64bits(v))
+}
+
+// appendMsgpackString appends a string.
+func appendMsgpackString(b []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n <= 31:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = appendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = appendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+// appendMsgpackBin appends a byte array.
+func appendMsgpackBin(b []byte, p []byte) []byte {
+	n := len(p)
+	switch {
+	case n <= math.MaxUint8:
+		b = append(b, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		b = appendUint16(append(b, 0xc5), uint16(n))
+	default:
+		b = appendUint32(append(b, 0xc6), uint32(n))
+	}
+	return append(b, p...)
+}
+
+// appendMsgpackArrayHeader appends the header of an array of n elements,
+// which must follow it.
+func appendMsgpackArrayHeader(b []byte, n int) []byte {
+	switch {
+	case n <= 15:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return appendUint16(append(b, 0xdc), uint16(n))
+	default:
+		return appendUint32(append(b, 0xdd), uint32(n))
+	}
+}
+
+// appendMsgpackMapHeader appends the header of a map of n pairs, which must
+// follow it as alternating keys and values.
+func appendMsgpackMapHeader(b []byte, n int) []byte {
+	switch {
+	case n <= 15:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return appendUint16(append(b, 0xde), uint16(n))
+	default:
+		return appendUint32(append(b, 0xdf), uint32(n))
+	}
+}
+
+// appendMsgpackEventTime appends t as Fluent's EventTime extension: seconds
+// and nanoseconds as 32-bit big-endian integers.
+func appendMsgpackEventTime(b []byte, t time.Time) []byte {
+	b = append(b, 0xd7, msgpackEventTimeType)
+	b = appendUint32(b, uint32(t.Unix()))
+	return appendUint32(b, uint32(t.Nanosecond()))
+}
+
+// appendMsgpackValue appends a value decoded from JSON: nil, a boolean, a
+// json.Number, a float64, a string, a slice or a map, whose keys are sorted.
+// Numbers are encoded as integers when they have no fraction or exponent.
+// Other types are appended as their JSON encoding in a string.
+func appendMsgpackValue(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return appendMsgpackNil(b)
+	case bool:
+		return appendMsgpackBool(b, v)
+	case string:
+		return appendMsgpackString(b, v)
+	case float64:
+		return appendMsgpackFloat(b, v)
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return appendMsgpackInt(b, i)
+		}
+		if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return appendMsgpackUint(b, u)
+		}
+		if f, err := v.Float64(); err == nil {
+			return appendMsgpackFloat(b, f)
+		}
+		return appendMsgpackString(b, string(v))
+	case []interface{}:
+		b = appendMsgpackArrayHeader(b, len(v))
+		for _, elem := range v {
+			b = appendMsgpackValue(b, elem)
+		}
+		return b
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		b = appendMsgpackMapHeader(b, len(v))
+		for _, key := range keys {
+			b = appendMsgpackString(b, key)
+			b = appendMsgpackValue(b, v[key])
+		}
+		return b
+	default:
+		return appendMsgpackString(b, fieldString(v))
+	}
+}
+
+// readMsgpackValue reads a value from r. Integers are returned as int64, or
+// uint64 beyond its range, floats as float64, strings as string, byte arrays as []byte,
+// arrays as []interface{}, maps as map[string]interface{} with keys of any
+// type converted to strings, EventTime as time.Time and other extensions as
+// []byte.
+func readMsgpackValue(r *bufio.Reader) (interface{}, error) {
+	c, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xe0 == 0xa0:
+		return readMsgpackString(r, int(c&0x1f))
+	case c&0xf0 == 0x90:
+		return readMsgpackArray(r, int(c&0x0f))
+	case c&0xf0 == 0x80:
+		return readMsgpackMap(r, int(c&0x0f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n, err := readMsgpackUint(r, 1<<(c-0xcc))
+		if n > math.MaxInt64 {
+			return n, err
+		}
+		return int64(n), err
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		n, err := readMsgpackUint(r, size)
+		shift := 64 - 8*size
+		return int64(n<<shift) >> shift, err
+	case 0xca:
+		n, err := readMsgpackUint(r, 4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := readMsgpackUint(r, 8)
+		return math.Float64frombits(n), err
+	case 0xd9, 0xda, 0xdb:
+		n, err := readMsgpackUint(r, 1<<(c-0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackString(r, int(n))
+	case 0xc4, 0xc5, 0xc6:
+		n, err := readMsgpackUint(r, 1<<(c-0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackBytes(r, int(n))
+	case 0xdc, 0xdd:
+		n, err := readMsgpackUint(r, 2<<(c-0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackArray(r, int(n))
+	case 0xde, 0xdf:
+		n, err := readMsgpackUint(r, 2<<(c-0xde))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackMap(r, int(n))
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return readMsgpackExt(r, 1<<(c-0xd4))
+	case 0xc7, 0xc8, 0xc9:
+		n, err := readMsgpackUint(r, 1<<(c-0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackExt(r, int(n))
+	}
+
+	return nil, fmt.Errorf("unsupported msgpack type 0x%02x", c)
+}
+
+// readMsgpackUint reads a big-endian unsigned integer of size bytes.
+func readMsgpackUint(r *bufio.Reader, size int) (uint64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[8-size:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+// readMsgpackBytes reads n bytes.
+func readMsgpackBytes(r *bufio.Reader, n int) ([]byte, error) {
+	if n > msgpackMaxLen {
+		return nil, fmt.Errorf("msgpack length %d exceeds limit", n)
+	}
+	p := make([]byte, n)
+	_, err := io.ReadFull(r, p)
+	return p, err
+}
+
+// readMsgpackString reads a string of n bytes.
+func readMsgpackString(r *bufio.Reader, n int) (string, error) {
+	p, err := readMsgpackBytes(r, n)
+	return string(p), err
+}
+
+// readMsgpackArray reads n array elements.
+func readMsgpackArray(r *bufio.Reader, n int) ([]interface{}, error) {
+	if n > msgpackMaxLen {
+		return nil, fmt.Errorf("msgpack length %d exceeds limit", n)
+	}
+	a := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		v, err := readMsgpackValue(r)
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, v)
+	}
+	return a, nil
+}
+
+// readMsgpackMap reads n map pairs.
+func readMsgpackMap(r *bufio.Reader, n int) (map[string]interface{}, error) {
+	if n > msgpackMaxLen {
+		return nil, fmt.Errorf("msgpack length %d exceeds limit", n)
+	}
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := readMsgpackValue(r)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readMsgpackValue(r)
+		if err != nil {
+			return nil, err
+		}
+		if s, ok := key.(string); ok {
+			m[s] = value
+		} else {
+			m[fmt.Sprint(key)] = value
+		}
+	}
+	return m, nil
+}
+
+// readMsgpackExt reads the type and n data bytes of an extension.
+func readMsgpackExt(r *bufio.Reader, n int) (interface{}, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	data, err := readMsgpackBytes(r, n)
+	if err != nil {
+		return nil, err
+	}
+	if typ == msgpackEventTimeType && n == 8 {
+		sec := binary.BigEndian.Uint32(data)
+		nsec := binary.BigEndian.Uint32(data[4:])
+		return time.Unix(int64(sec), int64(nsec)), nil
+	}
+	return data, nil
+}
+
+// appendUint16 appends v in big-endian order.
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+// appendUint32 appends v in big-endian order.
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// appendUint64 appends v in big-endian order.
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
@@ -0,0 +1,110 @@
+package writer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// decodeMsgpack reads a single value from b
+func decodeMsgpack(t *testing.T, b []byte) interface{} {
+	r := bufio.NewReader(bytes.NewReader(b))
+	v, err := readMsgpackValue(r)
+	require.NoError(t, err)
+	_, err = r.ReadByte()
+	assert.Error(t, err, "trailing bytes")
+	return v
+}
+
+// TestMsgpack tests encoding and decoding MessagePack values
+func TestMsgpack(t *testing.T) {
+	t.Run("Integers", func(t *testing.T) {
+		tests := []struct {
+			value int64
+			size  int
+		}{
+			{0, 1}, {127, 1}, {128, 2}, {255, 2}, {256, 3}, {65536, 5}, {math.MaxInt64, 9},
+			{-1, 1}, {-32, 1}, {-33, 2}, {-129, 3}, {-32769, 5}, {math.MinInt64, 9},
+		}
+		for _, tt := range tests {
+			b := appendMsgpackInt(nil, tt.value)
+			assert.Len(t, b, tt.size, tt.value)
+			switch v := decodeMsgpack(t, b).(type) {
+			case int64:
+				assert.Equal(t, tt.value, v)
+			case uint64:
+				assert.Equal(t, uint64(tt.value), v)
+			default:
+				t.Errorf("unexpected type %T", v)
+			}
+		}
+	})
+
+	t.Run("Strings", func(t *testing.T) {
+		for _, n := range []int{0, 31, 32, 255, 256, 65536} {
+			s := strings.Repeat("x", n)
+			assert.Equal(t, s, decodeMsgpack(t, appendMsgpackString(nil, s)))
+		}
+		bin := bytes.Repeat([]byte{0xff}, 300)
+		assert.Equal(t, bin, decodeMsgpack(t, appendMsgpackBin(nil, bin)))
+	})
+
+	t.Run("JSONValues", func(t *testing.T) {
+		var fields map[string]interface{}
+		decoder := json.NewDecoder(strings.NewReader(`{
+			"s": "text", "i": 42, "n": -7, "big": 18446744073709551615, "f": 1.5,
+			"b": true, "null": null, "list": [1, "two"], "obj": {"k": "v"}
+		}`))
+		decoder.UseNumber()
+		require.NoError(t, decoder.Decode(&fields))
+
+		assert.Equal(t, map[string]interface{}{
+			"s":    "text",
+			"i":    int64(42),
+			"n":    int64(-7),
+			"big":  uint64(math.MaxUint64),
+			"f":    1.5,
+			"b":    true,
+			"null": nil,
+			"list": []interface{}{int64(1), "two"},
+			"obj":  map[string]interface{}{"k": "v"},
+		}, decodeMsgpack(t, appendMsgpackValue(nil, fields)))
+
+		// Keys are sorted so that records encode the same every time
+		assert.Equal(t, appendMsgpackValue(nil, fields), appendMsgpackValue(nil, fields))
+	})
+
+	t.Run("Containers", func(t *testing.T) {
+		list := make([]interface{}, 20)
+		obj := map[string]interface{}{}
+		for i := range list {
+			list[i] = "v"
+			obj[strings.Repeat("k", i+1)] = "v"
+		}
+		assert.Equal(t, list, decodeMsgpack(t, appendMsgpackValue(nil, list)))
+		assert.Equal(t, obj, decodeMsgpack(t, appendMsgpackValue(nil, obj)))
+	})
+
+	t.Run("EventTime", func(t *testing.T) {
+		ts := time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.UTC)
+		b := appendMsgpackEventTime(nil, ts)
+		assert.Equal(t, []byte{0xd7, 0x00}, b[:2])
+		v := decodeMsgpack(t, b)
+		require.IsType(t, time.Time{}, v)
+		assert.True(t, ts.Equal(v.(time.Time)))
+	})
+
+	t.Run("Corrupt", func(t *testing.T) {
+		_, err := readMsgpackValue(bufio.NewReader(bytes.NewReader([]byte{0xc1})))
+		assert.Contains(t, err.Error(), "unsupported msgpack type 0xc1")
+		_, err = readMsgpackValue(bufio.NewReader(bytes.NewReader([]byte{0xdb, 0xff, 0xff, 0xff, 0xff})))
+		assert.Contains(t, err.Error(), "exceeds limit")
+	})
+}
@@ -0,0 +1,446 @@
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains a writer streaming entries over a network connection
+// that is re-established when the peer goes away.
+package writer
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// NetFraming selects how entries are delimited on the connection.
+type NetFraming int
+
+const (
+	// NetFramingNewline terminates each entry with a newline, unless it
+	// already ends with one
+	NetFramingNewline NetFraming = iota
+	// NetFramingLengthPrefix prefixes each entry with its length as a
+	// 4-byte big-endian integer
+	NetFramingLengthPrefix
+)
+
+// NetState is the state of a NetWriter's connection.
+type NetState int
+
+const (
+	// NetDisconnected means entries are buffered until the connection is
+	// re-established
+	NetDisconnected NetState = iota
+	// NetConnected means entries are written to the peer
+	NetConnected
+)
+
+// String returns the name of the connection state.
+func (s NetState) String() string {
+	switch s {
+	case NetDisconnected:
+		return "disconnected"
+	case NetConnected:
+		return "connected"
+	default:
+		return fmt.Sprintf("NetState(%d)", int(s))
+	}
+}
+
+// ErrNetWriterClosed is returned when writing to a closed NetWriter.
+var ErrNetWriterClosed = errors.New("writer: net writer is closed")
+
+// NetConfig holds configuration for a network stream writer.
+type NetConfig struct {
+	// TLSConfig is the TLS configuration of "tls" connections. Defaults to
+	// verifying the server against the system roots.
+	TLSConfig *tls.Config
+
+	// Framing delimits entries on the connection.
+	// Defaults to NetFramingNewline.
+	Framing NetFraming
+
+	// DialTimeout is the maximum time to establish a connection. Defaults
+	// to 5 seconds if not specified.
+	DialTimeout time.Duration
+
+	// WriteTimeout is the maximum time to write an entry before the
+	// connection is considered lost. Defaults to 5 seconds if not specified.
+	WriteTimeout time.Duration
+
+	// MinBackoff is the delay before the first reconnection attempt. It
+	// doubles with each failed attempt, with random jitter. Defaults to 100
+	// milliseconds if not specified.
+	MinBackoff time.Duration
+
+	// MaxBackoff is the maximum delay between reconnection attempts.
+	// Defaults to 30 seconds if not specified.
+	MaxBackoff time.Duration
+
+	// BufferSize is the maximum number of entries buffered while
+	// disconnected. The oldest entries are dropped when it is exceeded.
+	// Defaults to 1024 if not specified.
+	BufferSize int
+
+	// OnStateChange is called from a background goroutine when the
+	// connection is established or lost, with the error that ended it.
+	OnStateChange func(state NetState, err error)
+}
+
+// Validate checks if the configuration is valid and returns an error if not.
+func (c *NetConfig) Validate() error {
+	if c.Framing != NetFramingNewline && c.Framing != NetFramingLengthPrefix {
+		return fmt.Errorf("Framing must be either NetFramingNewline or NetFramingLengthPrefix")
+	}
+
+	if c.DialTimeout < 0 {
+		return fmt.Errorf("DialTimeout cannot be negative")
+	}
+
+	if c.WriteTimeout < 0 {
+		return fmt.Errorf("WriteTimeout cannot be negative")
+	}
+
+	if c.MinBackoff < 0 {
+		return fmt.Errorf("MinBackoff cannot be negative")
+	}
+
+	if c.MaxBackoff < 0 {
+		return fmt.Errorf("MaxBackoff cannot be negative")
+	}
+
+	if c.MaxBackoff > 0 && c.MinBackoff > c.MaxBackoff {
+		return fmt.Errorf("MinBackoff cannot be greater than MaxBackoff")
+	}
+
+	if c.BufferSize < 0 {
+		return fmt.Errorf("BufferSize cannot be negative")
+	}
+
+	return nil
+}
+
+// setDefaults sets default values for unspecified configuration fields.
+func (c *NetConfig) setDefaults() {
+	if c.DialTimeout == 0 {
+		c.DialTimeout = 5 * time.Second
+	}
+
+	if c.WriteTimeout == 0 {
+		c.WriteTimeout = 5 * time.Second
+	}
+
+	if c.MinBackoff == 0 {
+		c.MinBackoff = 100 * time.Millisecond
+	}
+
+	if c.MaxBackoff == 0 {
+		c.MaxBackoff = 30 * time.Second
+	}
+
+	if c.MinBackoff > c.MaxBackoff {
+		c.MinBackoff = c.MaxBackoff
+	}
+
+	if c.BufferSize == 0 {
+		c.BufferSize = 1024
+	}
+}
+
+// NetStats holds counters of a NetWriter.
+type NetStats struct {
+	// Connected reports whether entries are currently written to the peer
+	Connected bool
+	// Buffered is the number of entries waiting for the connection
+	Buffered int
+	// Dropped is the number of entries discarded because the buffer was full
+	Dropped uint64
+}
+
+// NetWriter is an io.Writer that streams entries to a peer over TCP, TLS or
+// a unix socket. When the peer goes away, entries are buffered while a
+// background goroutine reconnects with exponential backoff, and are sent in
+// order once the connection is re-established. It is safe for concurrent use.
+type NetWriter struct {
+	network string
+	addr    string
+	conf    NetConfig
+
+	mutex   sync.Mutex
+	conn    net.Conn // nil while disconnected
+	pending [][]byte // Framed entries buffered while disconnected
+	dropped uint64
+	closed  bool
+
+	lost chan error    // Receives the error that ended the connection
+	quit chan struct{} // Closed by Close
+	done chan struct{} // Closed when the background goroutine exits
+}
+
+// NewNetWriter creates a new writer streaming entries to addr on network,
+// which is "tcp", "tcp4", "tcp6", "unix" or "tls". It connects in the
+// background, so entries written before the peer is reachable are buffered.
+//
+// Each Write call is treated as one entry, which is how the tslog drivers
+// write. Close must be called to stop the background goroutine.
+//
+// Example:
+//
+//	w, err := writer.NewNetWriter("tcp", "logs.internal:5170", writer.NetConfig{
+//	    OnStateChange: func(state writer.NetState, err error) {
+//	        fmt.Fprintln(os.Stderr, "log aggregator", state, err)
+//	    },
+//	})
+//	defer w.Close()
+//	logger := tslog.NewLogger(tslog.WithWriter(w))
+func NewNetWriter(network, addr string, conf NetConfig) (*NetWriter, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix", "tls":
+	default:
+		return nil, fmt.Errorf("invalid net config: network must be one of tcp, tcp4, tcp6, unix or tls")
+	}
+
+	if addr == "" {
+		return nil, fmt.Errorf("invalid net config: address cannot be empty")
+	}
+
+	// Validate configuration
+	if err := conf.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid net config: %w", err)
+	}
+
+	// Apply defaults
+	conf.setDefaults()
+
+	w := &NetWriter{
+		network: network,
+		addr:    addr,
+		conf:    conf,
+		lost:    make(chan error, 1),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go w.run()
+
+	return w, nil
+}
+
+// MustNewNetWriter is like NewNetWriter but panics if the configuration is
+// invalid.
+func MustNewNetWriter(network, addr string, conf NetConfig) *NetWriter {
+	writer, err := NewNetWriter(network, addr, conf)
+	if err != nil {
+		panic(err)
+	}
+	return writer
+}
+
+// writesEntries marks the writer as treating each Write call as one entry.
+func (w *NetWriter) writesEntries() {}
+
+// Write sends p to the peer, or buffers it while disconnected. It doesn't
+// return errors of the connection; use NetConfig.OnStateChange to observe
+// those.
+func (w *NetWriter) Write(p []byte) (int, error) {
+	frame := w.frame(p)
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return 0, ErrNetWriterClosed
+	}
+
+	if w.conn != nil {
+		err := w.sendLocked(w.conn, frame)
+		if err == nil {
+			return len(p), nil
+		}
+		w.lostLocked(w.conn, err)
+	}
+
+	if len(w.pending) >= w.conf.BufferSize {
+		w.pending[0] = nil
+		w.pending = w.pending[1:]
+		w.dropped++
+	}
+	w.pending = append(w.pending, frame)
+	return len(p), nil
+}
+
+// Close stops reconnecting and closes the connection. Entries still
+// buffered are discarded.
+func (w *NetWriter) Close() error {
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		return nil
+	}
+	w.closed = true
+	var err error
+	if w.conn != nil {
+		err = w.conn.Close()
+		w.conn = nil
+	}
+	w.mutex.Unlock()
+
+	close(w.quit)
+	<-w.done
+	return err
+}
+
+// Stats returns a snapshot of the writer's counters.
+func (w *NetWriter) Stats() NetStats {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return NetStats{
+		Connected: w.conn != nil,
+		Buffered:  len(w.pending),
+		Dropped:   w.dropped,
+	}
+}
+
+// frame returns a copy of p delimited as configured.
+func (w *NetWriter) frame(p []byte) []byte {
+	if w.conf.Framing == NetFramingLengthPrefix {
+		frame := make([]byte, 4+len(p))
+		binary.BigEndian.PutUint32(frame, uint32(len(p)))
+		copy(frame[4:], p)
+		return frame
+	}
+
+	frame := make([]byte, len(p), len(p)+1)
+	copy(frame, p)
+	if len(p) == 0 || p[len(p)-1] != '\n' {
+		frame = append(frame, '\n')
+	}
+	return frame
+}
+
+// sendLocked writes a frame to conn within the write timeout.
+func (w *NetWriter) sendLocked(conn net.Conn, frame []byte) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(w.conf.WriteTimeout)); err != nil {
+		return err
+	}
+	_, err := conn.Write(frame)
+	return err
+}
+
+// lostLocked closes conn if it is still the current connection, and tells
+// the background goroutine to reconnect.
+func (w *NetWriter) lostLocked(conn net.Conn, err error) {
+	if w.conn != conn {
+		return
+	}
+	w.conn.Close()
+	w.conn = nil
+	w.lost <- err
+}
+
+// run is the background goroutine keeping the writer connected.
+func (w *NetWriter) run() {
+	defer close(w.done)
+
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && !w.sleep(w.backoff(attempt)) {
+			return
+		}
+
+		conn, err := w.dial()
+		if err != nil {
+			continue
+		}
+		if err := w.attach(conn); err != nil {
+			if err == ErrNetWriterClosed {
+				return
+			}
+			continue
+		}
+		attempt = 0
+
+		w.notify(NetConnected, nil)
+		go w.watch(conn)
+
+		select {
+		case err := <-w.lost:
+			w.notify(NetDisconnected, err)
+		case <-w.quit:
+			return
+		}
+	}
+}
+
+// dial connects to the peer.
+func (w *NetWriter) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: w.conf.DialTimeout}
+	if w.network == "tls" {
+		return tls.DialWithDialer(dialer, "tcp", w.addr, w.conf.TLSConfig)
+	}
+	return dialer.Dial(w.network, w.addr)
+}
+
+// attach sends the buffered entries over conn and makes it the current
+// connection. Entries that couldn't be sent stay buffered.
+func (w *NetWriter) attach(conn net.Conn) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		conn.Close()
+		return ErrNetWriterClosed
+	}
+
+	for len(w.pending) > 0 {
+		if err := w.sendLocked(conn, w.pending[0]); err != nil {
+			conn.Close()
+			return err
+		}
+		w.pending[0] = nil
+		w.pending = w.pending[1:]
+	}
+
+	w.pending = nil
+	w.conn = conn
+	return nil
+}
+
+// watch reads from conn until the peer closes it, so that a peer going
+// away is noticed before entries are written into the void.
+func (w *NetWriter) watch(conn net.Conn) {
+	_, err := io.Copy(io.Discard, conn)
+	if err == nil {
+		err = io.EOF
+	}
+
+	w.mutex.Lock()
+	w.lostLocked(conn, err)
+	w.mutex.Unlock()
+}
+
+// backoff returns the delay before the given reconnection attempt.
+func (w *NetWriter) backoff(attempt int) time.Duration {
+	return backoffDelay(w.conf.MinBackoff, w.conf.MaxBackoff, attempt)
+}
+
+// sleep waits for d, and returns false if the writer is closed meanwhile.
+func (w *NetWriter) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-w.quit:
+		return false
+	}
+}
+
+// notify reports a state change.
+func (w *NetWriter) notify(state NetState, err error) {
+	if w.conf.OnStateChange != nil {
+		w.conf.OnStateChange(state, err)
+	}
+}
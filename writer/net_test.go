@@ -0,0 +1,313 @@
+package writer
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stateRecorder records the state changes of a NetWriter
+type stateRecorder struct {
+	ch chan NetState
+}
+
+// newStateRecorder creates a state recorder
+func newStateRecorder() *stateRecorder {
+	return &stateRecorder{ch: make(chan NetState, 16)}
+}
+
+// onStateChange records a state change
+func (r *stateRecorder) onStateChange(state NetState, err error) {
+	r.ch <- state
+}
+
+// wait waits for the next state change and checks it
+func (r *stateRecorder) wait(t *testing.T, want NetState) {
+	t.Helper()
+	select {
+	case state := <-r.ch:
+		require.Equal(t, want, state)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for %v", want)
+	}
+}
+
+// acceptLines serves one connection, sending the lines it receives to the
+// returned channel
+func acceptLines(t *testing.T, l net.Listener) (<-chan string, <-chan net.Conn) {
+	t.Helper()
+	lines := make(chan string, 64)
+	conns := make(chan net.Conn, 1)
+	go func() {
+		defer close(lines)
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conns <- conn
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines <- line
+		}
+	}()
+	return lines, conns
+}
+
+// TestNetConfigValidate tests net writer configuration validation
+func TestNetConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config NetConfig
+		errMsg string
+	}{
+		{"Empty", NetConfig{}, ""},
+		{"Framing", NetConfig{Framing: 2}, "Framing must be"},
+		{"DialTimeout", NetConfig{DialTimeout: -1}, "DialTimeout cannot be negative"},
+		{"WriteTimeout", NetConfig{WriteTimeout: -1}, "WriteTimeout cannot be negative"},
+		{"MinBackoff", NetConfig{MinBackoff: -1}, "MinBackoff cannot be negative"},
+		{"MaxBackoff", NetConfig{MaxBackoff: -1}, "MaxBackoff cannot be negative"},
+		{"BackoffOrder", NetConfig{MinBackoff: time.Second, MaxBackoff: time.Millisecond}, "cannot be greater"},
+		{"BufferSize", NetConfig{BufferSize: -1}, "BufferSize cannot be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.errMsg == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+
+	t.Run("Defaults", func(t *testing.T) {
+		config := NetConfig{}
+		config.setDefaults()
+		assert.Equal(t, NetConfig{
+			DialTimeout:  5 * time.Second,
+			WriteTimeout: 5 * time.Second,
+			MinBackoff:   100 * time.Millisecond,
+			MaxBackoff:   30 * time.Second,
+			BufferSize:   1024,
+		}, config)
+
+		config = NetConfig{MinBackoff: time.Minute}
+		config.setDefaults()
+		assert.Equal(t, 30*time.Second, config.MinBackoff)
+	})
+
+	t.Run("Constructor", func(t *testing.T) {
+		_, err := NewNetWriter("udp", "localhost:1", NetConfig{})
+		assert.Contains(t, err.Error(), "network must be one of")
+		_, err = NewNetWriter("tcp", "", NetConfig{})
+		assert.Contains(t, err.Error(), "address cannot be empty")
+		_, err = NewNetWriter("tcp", "localhost:1", NetConfig{BufferSize: -1})
+		assert.Contains(t, err.Error(), "invalid net config")
+		assert.Panics(t, func() { MustNewNetWriter("udp", "localhost:1", NetConfig{}) })
+	})
+}
+
+// TestNetWriter tests streaming entries to local listeners
+func TestNetWriter(t *testing.T) {
+	t.Run("Newline", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer l.Close()
+		lines, _ := acceptLines(t, l)
+
+		states := newStateRecorder()
+		w, err := NewNetWriter("tcp", l.Addr().String(), NetConfig{OnStateChange: states.onStateChange})
+		require.NoError(t, err)
+		defer w.Close()
+		states.wait(t, NetConnected)
+
+		n, err := w.Write([]byte("first\n"))
+		require.NoError(t, err)
+		assert.Equal(t, 6, n)
+		_, err = w.Write([]byte("second"))
+		require.NoError(t, err)
+
+		assert.Equal(t, "first\n", receive(t, lines))
+		assert.Equal(t, "second\n", receive(t, lines))
+		assert.True(t, w.Stats().Connected)
+	})
+
+	t.Run("LengthPrefix", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("unix sockets are not supported on Windows")
+		}
+
+		path := filepath.Join(t.TempDir(), "net.sock")
+		l, err := net.Listen("unix", path)
+		require.NoError(t, err)
+		defer l.Close()
+
+		w, err := NewNetWriter("unix", path, NetConfig{Framing: NetFramingLengthPrefix})
+		require.NoError(t, err)
+		defer w.Close()
+
+		conn, err := l.Accept()
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = w.Write([]byte("multi\nline\n"))
+		require.NoError(t, err)
+
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		var size [4]byte
+		_, err = io.ReadFull(conn, size[:])
+		require.NoError(t, err)
+		require.Equal(t, uint32(11), binary.BigEndian.Uint32(size[:]))
+		data := make([]byte, 11)
+		_, err = io.ReadFull(conn, data)
+		require.NoError(t, err)
+		assert.Equal(t, "multi\nline\n", string(data))
+	})
+
+	t.Run("TLS", func(t *testing.T) {
+		// Borrow the certificate of a TLS test server
+		ts := httptest.NewTLSServer(http.NotFoundHandler())
+		defer ts.Close()
+		clientConfig := ts.Client().Transport.(*http.Transport).TLSClientConfig
+
+		l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: ts.TLS.Certificates})
+		require.NoError(t, err)
+		defer l.Close()
+		lines, _ := acceptLines(t, l)
+
+		w, err := NewNetWriter("tls", l.Addr().String(), NetConfig{
+			TLSConfig: &tls.Config{RootCAs: clientConfig.RootCAs, ServerName: "example.com"},
+		})
+		require.NoError(t, err)
+		defer w.Close()
+
+		_, err = w.Write([]byte("secure\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "secure\n", receive(t, lines))
+	})
+
+	t.Run("BufferUntilConnected", func(t *testing.T) {
+		// Reserve an address nobody listens on yet
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := l.Addr().String()
+		require.NoError(t, l.Close())
+
+		states := newStateRecorder()
+		w, err := NewNetWriter("tcp", addr, NetConfig{
+			MinBackoff:    10 * time.Millisecond,
+			MaxBackoff:    50 * time.Millisecond,
+			BufferSize:    3,
+			OnStateChange: states.onStateChange,
+		})
+		require.NoError(t, err)
+		defer w.Close()
+
+		for i := 1; i <= 5; i++ {
+			_, err := w.Write([]byte(fmt.Sprintf("entry %d\n", i)))
+			require.NoError(t, err)
+		}
+		assert.Equal(t, NetStats{Buffered: 3, Dropped: 2}, w.Stats())
+
+		l, err = net.Listen("tcp", addr)
+		require.NoError(t, err)
+		defer l.Close()
+		lines, _ := acceptLines(t, l)
+		states.wait(t, NetConnected)
+
+		// The oldest entries were dropped, the rest arrive in order
+		assert.Equal(t, "entry 3\n", receive(t, lines))
+		assert.Equal(t, "entry 4\n", receive(t, lines))
+		assert.Equal(t, "entry 5\n", receive(t, lines))
+		assert.Equal(t, NetStats{Connected: true, Dropped: 2}, w.Stats())
+	})
+
+	t.Run("Reconnect", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer l.Close()
+		lines, conns := acceptLines(t, l)
+
+		states := newStateRecorder()
+		w, err := NewNetWriter("tcp", l.Addr().String(), NetConfig{
+			MinBackoff:    10 * time.Millisecond,
+			MaxBackoff:    50 * time.Millisecond,
+			OnStateChange: states.onStateChange,
+		})
+		require.NoError(t, err)
+		defer w.Close()
+		states.wait(t, NetConnected)
+
+		_, err = w.Write([]byte("before\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "before\n", receive(t, lines))
+
+		// The aggregator restarts
+		(<-conns).Close()
+		states.wait(t, NetDisconnected)
+
+		_, err = w.Write([]byte("during\n"))
+		require.NoError(t, err)
+
+		lines, _ = acceptLines(t, l)
+		states.wait(t, NetConnected)
+		_, err = w.Write([]byte("after\n"))
+		require.NoError(t, err)
+
+		assert.Equal(t, "during\n", receive(t, lines))
+		assert.Equal(t, "after\n", receive(t, lines))
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		w, err := NewNetWriter("tcp", "127.0.0.1:1", NetConfig{MinBackoff: time.Millisecond})
+		require.NoError(t, err)
+
+		require.NoError(t, w.Close())
+		require.NoError(t, w.Close())
+		_, err = w.Write([]byte("late\n"))
+		assert.ErrorIs(t, err, ErrNetWriterClosed)
+	})
+}
+
+// TestNetWriterBackoff tests the growth and jitter of reconnection delays
+func TestNetWriterBackoff(t *testing.T) {
+	w := &NetWriter{conf: NetConfig{MinBackoff: 100 * time.Millisecond, MaxBackoff: time.Second}}
+
+	bounds := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 200 * time.Millisecond, 400 * time.Millisecond},
+		{5, 500 * time.Millisecond, time.Second},
+		{50, 500 * time.Millisecond, time.Second},
+	}
+
+	for _, b := range bounds {
+		for i := 0; i < 20; i++ {
+			d := w.backoff(b.attempt)
+			assert.GreaterOrEqual(t, d, b.min, "attempt %d", b.attempt)
+			assert.LessOrEqual(t, d, b.max, "attempt %d", b.attempt)
+		}
+	}
+}
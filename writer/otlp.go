@@ -0,0 +1,485 @@
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains a writer exporting entries as OpenTelemetry log records
+// over OTLP/HTTP with JSON encoding.
+package writer
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// otlpLogsPath is the path of the OTLP/HTTP logs endpoint.
+const otlpLogsPath = "/v1/logs"
+
+// OTLPConfig holds configuration for exporting entries to an OpenTelemetry
+// collector or backend over OTLP/HTTP.
+type OTLPConfig struct {
+	// URL is the base URL of the OTLP/HTTP endpoint, such as
+	// "http://otel-collector:4318". Entries are sent to its /v1/logs path.
+	URL string
+
+	// ServiceName is the service.name resource attribute. Defaults to the
+	// name of the executable.
+	ServiceName string
+
+	// ResourceAttributes are other attributes of the resource, such as
+	// {"service.version": "1.4.2", "deployment.environment": "prod"}.
+	ResourceAttributes map[string]string
+
+	// TraceIDKey is the entry field holding the trace ID, as 32 hex digits.
+	// Defaults to "trace_id" if not specified.
+	TraceIDKey string
+
+	// SpanIDKey is the entry field holding the span ID, as 16 hex digits.
+	// Defaults to "span_id" if not specified.
+	SpanIDKey string
+
+	// Headers are added to every request, such as an authentication header.
+	Headers map[string]string
+
+	// Gzip compresses request bodies. Defaults to false.
+	Gzip bool
+
+	// Timeout is the maximum time of a request. Defaults to 10 seconds if
+	// not specified. It is ignored if Client is set.
+	Timeout time.Duration
+
+	// Client makes the requests. Defaults to a client with Timeout.
+	Client *http.Client
+
+	// Batch configures the batching of entries: QueueSize, BatchSize,
+	// FlushInterval, Overflow and OnError, which is called when entries
+	// can't be exported.
+	Batch AsyncConfig
+
+	// Retry configures the retrying of exports failing with a network
+	// error, a 429 or a 5xx response.
+	Retry RetryConfig
+}
+
+// Validate checks if the configuration is valid and returns an error if not.
+func (c *OTLPConfig) Validate() error {
+	if c.URL == "" {
+		return fmt.Errorf("URL cannot be empty")
+	}
+
+	if u, err := url.Parse(c.URL); err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("URL must be an http or https URL")
+	}
+
+	for name := range c.ResourceAttributes {
+		if name == "" {
+			return fmt.Errorf("ResourceAttributes cannot contain empty names")
+		}
+	}
+
+	if c.Timeout < 0 {
+		return fmt.Errorf("Timeout cannot be negative")
+	}
+
+	if err := c.Batch.Validate(); err != nil {
+		return fmt.Errorf("invalid Batch: %w", err)
+	}
+
+	if err := c.Retry.Validate(); err != nil {
+		return fmt.Errorf("invalid Retry: %w", err)
+	}
+
+	return nil
+}
+
+// setDefaults sets default values for unspecified configuration fields.
+func (c *OTLPConfig) setDefaults() {
+	if c.ServiceName == "" {
+		c.ServiceName = filepath.Base(os.Args[0])
+	}
+
+	if c.TraceIDKey == "" {
+		c.TraceIDKey = "trace_id"
+	}
+
+	if c.SpanIDKey == "" {
+		c.SpanIDKey = "span_id"
+	}
+
+	if c.Timeout == 0 {
+		c.Timeout = 10 * time.Second
+	}
+
+	if c.Client == nil {
+		c.Client = &http.Client{Timeout: c.Timeout}
+	}
+
+	c.Retry.setDefaults()
+}
+
+// The types below follow the JSON mapping of the OTLP logs protobuf
+// messages: field names in lowerCamelCase, 64-bit integers as strings, and
+// trace and span IDs as hex strings.
+
+// otlpExportRequest is an ExportLogsServiceRequest.
+type otlpExportRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+// otlpResourceLogs is a ResourceLogs.
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+// otlpResource is a Resource.
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+// otlpScopeLogs is a ScopeLogs.
+type otlpScopeLogs struct {
+	Scope      otlpScope       `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+// otlpScope is an InstrumentationScope.
+type otlpScope struct {
+	Name string `json:"name,omitempty"`
+}
+
+// otlpLogRecord is a LogRecord.
+type otlpLogRecord struct {
+	TimeUnixNano         string         `json:"timeUnixNano,omitempty"`
+	ObservedTimeUnixNano string         `json:"observedTimeUnixNano"`
+	SeverityNumber       int            `json:"severityNumber,omitempty"`
+	SeverityText         string         `json:"severityText,omitempty"`
+	Body                 otlpAnyValue   `json:"body"`
+	Attributes           []otlpKeyValue `json:"attributes,omitempty"`
+	TraceID              string         `json:"traceId,omitempty"`
+	SpanID               string         `json:"spanId,omitempty"`
+}
+
+// otlpKeyValue is a KeyValue.
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+// otlpAnyValue is an AnyValue, of which one field is set.
+type otlpAnyValue struct {
+	StringValue *string         `json:"stringValue,omitempty"`
+	BoolValue   *bool           `json:"boolValue,omitempty"`
+	IntValue    string          `json:"intValue,omitempty"`
+	DoubleValue *float64        `json:"doubleValue,omitempty"`
+	ArrayValue  *otlpArrayValue `json:"arrayValue,omitempty"`
+	KvlistValue *otlpKvlist     `json:"kvlistValue,omitempty"`
+}
+
+// otlpArrayValue is an ArrayValue.
+type otlpArrayValue struct {
+	Values []otlpAnyValue `json:"values"`
+}
+
+// otlpKvlist is a KeyValueList.
+type otlpKvlist struct {
+	Values []otlpKeyValue `json:"values"`
+}
+
+// otlpExportResponse is the part of an ExportLogsServiceResponse reporting
+// rejected records.
+type otlpExportResponse struct {
+	PartialSuccess struct {
+		RejectedLogRecords json.Number `json:"rejectedLogRecords"`
+		ErrorMessage       string      `json:"errorMessage"`
+	} `json:"partialSuccess"`
+}
+
+// OTLPWriter is an io.Writer that exports entries as OpenTelemetry log
+// records over OTLP/HTTP with JSON encoding, in batches from a background
+// goroutine. It is safe for concurrent use.
+//
+// The fields of JSON entries map to the record as follows:
+//   - "timestamp" is the time of the record, and "level" its severity
+//   - "msg" is the body
+//   - "logger" is the name of the instrumentation scope
+//   - "caller", "func" and "stacktrace" are the code.filepath, code.lineno,
+//     code.function and exception.stacktrace attributes
+//   - the trace and span ID fields set the record's trace context when they
+//     hold valid IDs
+//   - any other field, such as those of tslog.T, is an attribute
+//
+// Other entries are exported with the line as the body and the severity of
+// their level column.
+type OTLPWriter struct {
+	*AsyncWriter
+	conf     OTLPConfig
+	request  httpRequest
+	resource otlpResource
+	now      func() time.Time
+}
+
+// NewOTLPWriter creates a new writer exporting entries over OTLP/HTTP. JSON
+// entries are needed to export fields as attributes.
+//
+// Close must be called to export the queued entries and stop the background
+// goroutine.
+//
+// Example:
+//
+//	w, err := writer.NewOTLPWriter(writer.OTLPConfig{
+//	    URL:                "http://otel-collector:4318",
+//	    ServiceName:        "billing",
+//	    ResourceAttributes: map[string]string{"deployment.environment": "prod"},
+//	})
+//	defer w.Close()
+//	logger := tslog.NewLogger(tslog.WithWriter(w), tslog.WithEncoder(tslog.EncoderJSON))
+func NewOTLPWriter(conf OTLPConfig) (*OTLPWriter, error) {
+	// Validate configuration
+	if err := conf.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid otlp config: %w", err)
+	}
+
+	// Apply defaults
+	conf.setDefaults()
+
+	attrs := map[string]interface{}{"service.name": conf.ServiceName}
+	for name, value := range conf.ResourceAttributes {
+		attrs[name] = value
+	}
+
+	w := &OTLPWriter{
+		conf: conf,
+		request: httpRequest{
+			client:      conf.Client,
+			method:      http.MethodPost,
+			url:         strings.TrimSuffix(strings.TrimSuffix(conf.URL, "/"), otlpLogsPath) + otlpLogsPath,
+			contentType: "application/json",
+			headers:     conf.Headers,
+			gzip:        conf.Gzip,
+		},
+		resource: otlpResource{Attributes: otlpAttributes(attrs)},
+		now:      time.Now,
+	}
+	w.AsyncWriter = newBatchWriter(conf.Batch, w.export)
+	go w.run()
+
+	return w, nil
+}
+
+// MustNewOTLPWriter is like NewOTLPWriter but panics if the configuration is
+// invalid.
+func MustNewOTLPWriter(conf OTLPConfig) *OTLPWriter {
+	writer, err := NewOTLPWriter(conf)
+	if err != nil {
+		panic(err)
+	}
+	return writer
+}
+
+// export sends a batch of entries, grouped by scope.
+func (w *OTLPWriter) export(batch [][]byte) error {
+	now := strconv.FormatInt(w.now().UnixNano(), 10)
+
+	var scopes []otlpScopeLogs
+	index := map[string]int{}
+	for _, entry := range batch {
+		scope, record := w.record(entry)
+		record.ObservedTimeUnixNano = now
+
+		i, ok := index[scope]
+		if !ok {
+			i = len(scopes)
+			index[scope] = i
+			scopes = append(scopes, otlpScopeLogs{Scope: otlpScope{Name: scope}})
+		}
+		scopes[i].LogRecords = append(scopes[i].LogRecords, record)
+	}
+
+	body, err := json.Marshal(otlpExportRequest{
+		ResourceLogs: []otlpResourceLogs{{Resource: w.resource, ScopeLogs: scopes}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode OTLP export request: %w", err)
+	}
+
+	var respBody []byte
+	err = w.conf.Retry.do(w.expired, func() error {
+		respBody, err = w.request.send(context.Background(), body)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export %d entries over OTLP: %w", len(batch), err)
+	}
+
+	// A collector may accept part of the request
+	var resp otlpExportResponse
+	if json.Unmarshal(respBody, &resp) == nil {
+		if rejected, _ := resp.PartialSuccess.RejectedLogRecords.Int64(); rejected > 0 {
+			return fmt.Errorf("failed to export %d of %d entries over OTLP: %s",
+				rejected, len(batch), resp.PartialSuccess.ErrorMessage)
+		}
+	}
+	return nil
+}
+
+// record returns the scope name and the log record of entry.
+func (w *OTLPWriter) record(entry []byte) (string, otlpLogRecord) {
+	fields, err := decodeEntry(entry)
+	if err != nil {
+		// Console entries carry the level in their second column
+		line := strings.TrimRight(string(entry), "\r\n")
+		level := parseLevel(entry)
+		return "", otlpLogRecord{
+			SeverityText:   strings.ToUpper(level),
+			SeverityNumber: otlpSeverity(level),
+			Body:           otlpValue(line),
+		}
+	}
+
+	var record otlpLogRecord
+	if s, ok := fields[entryTimeKey].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			record.TimeUnixNano = strconv.FormatInt(t.UnixNano(), 10)
+			delete(fields, entryTimeKey)
+		}
+	}
+	if s, ok := fields[entryLevelKey].(string); ok {
+		record.SeverityText = s
+		record.SeverityNumber = otlpSeverity(s)
+		delete(fields, entryLevelKey)
+	}
+	if v, ok := fields[entryMessageKey]; ok {
+		record.Body = otlpValue(v)
+		delete(fields, entryMessageKey)
+	}
+	if s, ok := fields[w.conf.TraceIDKey].(string); ok && isOTLPID(s, 16) {
+		record.TraceID = strings.ToLower(s)
+		delete(fields, w.conf.TraceIDKey)
+	}
+	if s, ok := fields[w.conf.SpanIDKey].(string); ok && isOTLPID(s, 8) {
+		record.SpanID = strings.ToLower(s)
+		delete(fields, w.conf.SpanIDKey)
+	}
+
+	scope, _ := fields[entryLoggerKey].(string)
+	delete(fields, entryLoggerKey)
+
+	// Fields with a semantic convention are renamed
+	if s, ok := fields[entryCallerKey].(string); ok {
+		file, line := splitCaller(s)
+		fields["code.filepath"] = file
+		if line != "" {
+			fields["code.lineno"] = json.Number(line)
+		}
+		delete(fields, entryCallerKey)
+	}
+	if v, ok := fields[entryFuncKey]; ok {
+		fields["code.function"] = v
+		delete(fields, entryFuncKey)
+	}
+	if v, ok := fields[entryStacktraceKey]; ok {
+		fields["exception.stacktrace"] = v
+		delete(fields, entryStacktraceKey)
+	}
+
+	record.Attributes = otlpAttributes(fields)
+	return scope, record
+}
+
+// otlpSeverity returns the OpenTelemetry severity number of a level name,
+// or 0 if it is unknown.
+func otlpSeverity(level string) int {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return 5
+	case "info":
+		return 9
+	case "warn", "warning":
+		return 13
+	case "error":
+		return 17
+	case "dpanic":
+		return 18
+	case "panic":
+		return 19
+	case "fatal":
+		return 21
+	default:
+		return 0
+	}
+}
+
+// isOTLPID reports whether s is the hex encoding of a valid ID of size
+// bytes, which isn't all zeros.
+func isOTLPID(s string, size int) bool {
+	if len(s) != 2*size {
+		return false
+	}
+	id, err := hex.DecodeString(s)
+	if err != nil {
+		return false
+	}
+	for _, b := range id {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// otlpAttributes returns the attributes of fields, sorted by key.
+func otlpAttributes(fields map[string]interface{}) []otlpKeyValue {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]otlpKeyValue, 0, len(keys))
+	for _, key := range keys {
+		attrs = append(attrs, otlpKeyValue{Key: key, Value: otlpValue(fields[key])})
+	}
+	return attrs
+}
+
+// otlpValue returns the AnyValue of a value decoded from JSON. Numbers are
+// integers when they fit in an int64, and doubles otherwise.
+func otlpValue(v interface{}) otlpAnyValue {
+	switch v := v.(type) {
+	case nil:
+		return otlpAnyValue{}
+	case string:
+		return otlpAnyValue{StringValue: &v}
+	case bool:
+		return otlpAnyValue{BoolValue: &v}
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return otlpAnyValue{IntValue: strconv.FormatInt(i, 10)}
+		}
+		if f, err := v.Float64(); err == nil {
+			return otlpAnyValue{DoubleValue: &f}
+		}
+		s := v.String()
+		return otlpAnyValue{StringValue: &s}
+	case float64:
+		return otlpAnyValue{DoubleValue: &v}
+	case []interface{}:
+		values := make([]otlpAnyValue, 0, len(v))
+		for _, elem := range v {
+			values = append(values, otlpValue(elem))
+		}
+		return otlpAnyValue{ArrayValue: &otlpArrayValue{Values: values}}
+	case map[string]interface{}:
+		return otlpAnyValue{KvlistValue: &otlpKvlist{Values: otlpAttributes(v)}}
+	default:
+		s := fieldString(v)
+		return otlpAnyValue{StringValue: &s}
+	}
+}
@@ -0,0 +1,237 @@
+package writer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// otlpServer is a stand-in for an OTLP/HTTP logs endpoint
+type otlpServer struct {
+	*httptest.Server
+	mutex     sync.Mutex
+	responses []func(w http.ResponseWriter) // Next responses, 200 once used up
+	requests  []*http.Request
+	exports   []otlpExportRequest
+}
+
+// newOTLPServer starts an OTLP stand-in answering with responses first
+func newOTLPServer(t *testing.T, responses ...func(w http.ResponseWriter)) *otlpServer {
+	s := &otlpServer{responses: responses}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// handle records an export request
+func (s *otlpServer) handle(w http.ResponseWriter, r *http.Request) {
+	var req otlpExportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.requests = append(s.requests, r)
+	s.exports = append(s.exports, req)
+	if len(s.responses) > 0 {
+		respond := s.responses[0]
+		s.responses = s.responses[1:]
+		respond(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte("{}"))
+}
+
+// received returns the requests and their bodies
+func (s *otlpServer) received() ([]*http.Request, []otlpExportRequest) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.requests, s.exports
+}
+
+// otlpString returns a string AnyValue
+func otlpString(s string) otlpAnyValue {
+	return otlpAnyValue{StringValue: &s}
+}
+
+// TestOTLPConfig tests OTLP writer configuration validation and defaults
+func TestOTLPConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config OTLPConfig
+		errMsg string
+	}{
+		{"Valid", OTLPConfig{URL: "http://collector:4318", ResourceAttributes: map[string]string{"env": "prod"}}, ""},
+		{"EmptyURL", OTLPConfig{}, "URL cannot be empty"},
+		{"Scheme", OTLPConfig{URL: "collector:4318"}, "must be an http or https URL"},
+		{"Attribute", OTLPConfig{URL: "http://collector", ResourceAttributes: map[string]string{"": "x"}}, "empty names"},
+		{"Timeout", OTLPConfig{URL: "http://collector", Timeout: -1}, "Timeout cannot be negative"},
+		{"Batch", OTLPConfig{URL: "http://collector", Batch: AsyncConfig{CloseTimeout: -1}}, "invalid Batch"},
+		{"Retry", OTLPConfig{URL: "http://collector", Retry: RetryConfig{MinBackoff: -1}}, "invalid Retry"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.errMsg == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+
+	t.Run("Defaults", func(t *testing.T) {
+		config := OTLPConfig{URL: "http://collector"}
+		config.setDefaults()
+		assert.NotEmpty(t, config.ServiceName)
+		assert.Equal(t, "trace_id", config.TraceIDKey)
+		assert.Equal(t, "span_id", config.SpanIDKey)
+		assert.Equal(t, 10*time.Second, config.Client.Timeout)
+	})
+
+	t.Run("Constructor", func(t *testing.T) {
+		_, err := NewOTLPWriter(OTLPConfig{})
+		assert.Contains(t, err.Error(), "invalid otlp config")
+		assert.Panics(t, func() { MustNewOTLPWriter(OTLPConfig{}) })
+	})
+}
+
+// TestOTLPWriter tests exporting entries to an OTLP stand-in
+func TestOTLPWriter(t *testing.T) {
+	t.Run("Export", func(t *testing.T) {
+		server := newOTLPServer(t)
+		w, err := NewOTLPWriter(OTLPConfig{
+			URL:                server.URL,
+			ServiceName:        "billing",
+			ResourceAttributes: map[string]string{"deployment.environment": "prod"},
+			Headers:            map[string]string{"Authorization": "Bearer token"},
+		})
+		require.NoError(t, err)
+		w.now = func() time.Time { return time.Unix(1700000000, 0) }
+
+		entries := []string{
+			`{"level":"INFO","timestamp":"2024-01-01T00:00:00.5Z","logger":"http","caller":"api/handler.go:42",` +
+				`"func":"api.Serve","msg":"served","status":200,"ratio":0.5,"ok":true,"tags":["a"],"ctx":{"id":"x"},` +
+				`"trace_id":"4BF92F3577B34DA6A3CE929D0E0E4736","span_id":"00f067aa0ba902b7"}` + "\n",
+			`{"level":"ERROR","msg":"failed","stacktrace":"main.main()","trace_id":"not-an-id"}` + "\n",
+			"2024-01-01T00:00:01.000Z\tWARN\tconsole\n",
+		}
+		for _, entry := range entries {
+			_, err := w.Write([]byte(entry))
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.Close())
+
+		requests, exports := server.received()
+		require.Len(t, requests, 1)
+		assert.Equal(t, otlpLogsPath, requests[0].URL.Path)
+		assert.Equal(t, "application/json", requests[0].Header.Get("Content-Type"))
+		assert.Equal(t, "Bearer token", requests[0].Header.Get("Authorization"))
+
+		require.Len(t, exports[0].ResourceLogs, 1)
+		resource := exports[0].ResourceLogs[0]
+		assert.Equal(t, []otlpKeyValue{
+			{Key: "deployment.environment", Value: otlpString("prod")},
+			{Key: "service.name", Value: otlpString("billing")},
+		}, resource.Resource.Attributes)
+
+		// Records are grouped by logger
+		require.Len(t, resource.ScopeLogs, 2)
+		assert.Equal(t, "http", resource.ScopeLogs[0].Scope.Name)
+		assert.Equal(t, "", resource.ScopeLogs[1].Scope.Name)
+
+		ratio, ok := 0.5, true
+		assert.Equal(t, []otlpLogRecord{{
+			TimeUnixNano:         "1704067200500000000",
+			ObservedTimeUnixNano: "1700000000000000000",
+			SeverityNumber:       9,
+			SeverityText:         "INFO",
+			Body:                 otlpString("served"),
+			Attributes: []otlpKeyValue{
+				{Key: "code.filepath", Value: otlpString("api/handler.go")},
+				{Key: "code.function", Value: otlpString("api.Serve")},
+				{Key: "code.lineno", Value: otlpAnyValue{IntValue: "42"}},
+				{Key: "ctx", Value: otlpAnyValue{KvlistValue: &otlpKvlist{Values: []otlpKeyValue{{Key: "id", Value: otlpString("x")}}}}},
+				{Key: "ok", Value: otlpAnyValue{BoolValue: &ok}},
+				{Key: "ratio", Value: otlpAnyValue{DoubleValue: &ratio}},
+				{Key: "status", Value: otlpAnyValue{IntValue: "200"}},
+				{Key: "tags", Value: otlpAnyValue{ArrayValue: &otlpArrayValue{Values: []otlpAnyValue{otlpString("a")}}}},
+			},
+			TraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+			SpanID:  "00f067aa0ba902b7",
+		}}, resource.ScopeLogs[0].LogRecords)
+
+		assert.Equal(t, []otlpLogRecord{
+			{
+				ObservedTimeUnixNano: "1700000000000000000",
+				SeverityNumber:       17,
+				SeverityText:         "ERROR",
+				Body:                 otlpString("failed"),
+				Attributes: []otlpKeyValue{
+					{Key: "exception.stacktrace", Value: otlpString("main.main()")},
+					{Key: "trace_id", Value: otlpString("not-an-id")},
+				},
+			},
+			{
+				ObservedTimeUnixNano: "1700000000000000000",
+				SeverityText:         "WARN",
+				SeverityNumber:       13,
+				Body:                 otlpString("2024-01-01T00:00:01.000Z\tWARN\tconsole"),
+			},
+		}, resource.ScopeLogs[1].LogRecords)
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		server := newOTLPServer(t, func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+		w, err := NewOTLPWriter(OTLPConfig{
+			URL:   server.URL,
+			Retry: RetryConfig{MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		})
+		require.NoError(t, err)
+
+		_, err = w.Write([]byte(`{"level":"INFO","msg":"retried"}` + "\n"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		requests, exports := server.received()
+		assert.Len(t, requests, 2)
+		assert.Equal(t, exports[0], exports[1])
+		assert.Equal(t, uint64(1), w.Stats().Written)
+	})
+
+	t.Run("PartialSuccess", func(t *testing.T) {
+		server := newOTLPServer(t, func(w http.ResponseWriter) {
+			_, _ = w.Write([]byte(`{"partialSuccess":{"rejectedLogRecords":"1","errorMessage":"record too large"}}`))
+		})
+		var errs []error
+		w, err := NewOTLPWriter(OTLPConfig{
+			URL:   server.URL,
+			Batch: AsyncConfig{OnError: func(err error) { errs = append(errs, err) }},
+		})
+		require.NoError(t, err)
+
+		for _, msg := range []string{"a", "b"} {
+			_, err := w.Write([]byte(`{"msg":"` + msg + `"}` + "\n"))
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.Close())
+
+		requests, _ := server.received()
+		assert.Len(t, requests, 1)
+		require.Len(t, errs, 1)
+		assert.Contains(t, errs[0].Error(), "failed to export 1 of 2 entries over OTLP: record too large")
+	})
+}
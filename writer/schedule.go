@@ -0,0 +1,214 @@
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains the cron-like schedules used for the period boundaries
+// of TimeRotatingWriter.
+package writer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scheduleDescriptors are the shorthands accepted in place of a cron
+// expression.
+var scheduleDescriptors = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+// scheduleSearchYears is how far a schedule is searched for a matching
+// time. It covers schedules that only match on February 29.
+const scheduleSearchYears = 8
+
+// schedule is a parsed cron expression. Each field is a set of bits, bit n
+// being set if value n matches.
+type schedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool // The day fields are "*"
+}
+
+// scheduleField describes a field of a cron expression.
+type scheduleField struct {
+	name     string
+	min, max int
+}
+
+// scheduleFields are the fields of a cron expression, in order.
+var scheduleFields = []scheduleField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// parseSchedule parses a cron expression with five fields, or one of the
+// scheduleDescriptors.
+func parseSchedule(spec string) (*schedule, error) {
+	if expr, ok := scheduleDescriptors[strings.ToLower(strings.TrimSpace(spec))]; ok {
+		spec = expr
+	}
+
+	parts := strings.Fields(spec)
+	if len(parts) != len(scheduleFields) {
+		return nil, fmt.Errorf("expected %d fields, got %d", len(scheduleFields), len(parts))
+	}
+
+	var bits [5]uint64
+	for i, part := range parts {
+		b, err := parseScheduleField(part, scheduleFields[i])
+		if err != nil {
+			return nil, err
+		}
+		bits[i] = b
+	}
+
+	s := &schedule{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		domAny: parts[2] == "*",
+		dowAny: parts[4] == "*",
+	}
+	// Sunday is both 0 and 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+// parseScheduleField parses a comma-separated list of values, ranges and
+// steps, such as "*/15" or "1-5,10", into a set of bits.
+func parseScheduleField(text string, f scheduleField) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(text, ",") {
+		rng, stepText, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepText)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepText, f.name)
+			}
+			step = n
+		}
+
+		lo, hi := f.min, f.max
+		switch {
+		case rng == "*":
+		case strings.Contains(rng, "-"):
+			loText, hiText, _ := strings.Cut(rng, "-")
+			var err1, err2 error
+			lo, err1 = strconv.Atoi(loText)
+			hi, err2 = strconv.Atoi(hiText)
+			if err1 != nil || err2 != nil || lo > hi {
+				return 0, fmt.Errorf("invalid range %q in %s field", rng, f.name)
+			}
+		default:
+			n, err := strconv.Atoi(rng)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %q in %s field", rng, f.name)
+			}
+			lo = n
+			if !hasStep {
+				hi = n
+			}
+		}
+		if lo < f.min || hi > f.max {
+			return 0, fmt.Errorf("%s field %q is out of range %d-%d", f.name, item, f.min, f.max)
+		}
+
+		for n := lo; n <= hi; n += step {
+			bits |= 1 << uint(n)
+		}
+	}
+	return bits, nil
+}
+
+// matchDay reports whether the day of t matches. As with cron, a time
+// matches if either day field matches when both are restricted.
+func (s *schedule) matchDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the first time after t that matches the schedule, or the
+// zero time if there is none within scheduleSearchYears. A wall clock time
+// repeated when daylight saving time ends only matches once.
+func (s *schedule) next(t time.Time) time.Time {
+	loc := t.Location()
+	limit := t.AddDate(scheduleSearchYears, 0, 0)
+	after := wallClock(t)
+
+	c := t.Truncate(time.Minute).Add(time.Minute)
+	for c.Before(limit) {
+		prev := c
+		y, m, d := c.Date()
+		switch {
+		case s.month&(1<<uint(m)) == 0:
+			c = time.Date(y, m+1, 1, 0, 0, 0, 0, loc)
+		case !s.matchDay(c):
+			c = time.Date(y, m, d+1, 0, 0, 0, 0, loc)
+		case s.hour&(1<<uint(c.Hour())) == 0:
+			c = time.Date(y, m, d, c.Hour()+1, 0, 0, 0, loc)
+		case s.minute&(1<<uint(c.Minute())) == 0 || !wallClock(c).After(after):
+			c = c.Add(time.Minute)
+		default:
+			return c
+		}
+		if !c.After(prev) {
+			// Skip over an ambiguous wall clock time
+			c = prev.Add(time.Minute)
+		}
+	}
+	return time.Time{}
+}
+
+// prev returns the last time at or before t that matches the schedule, or
+// the zero time if there is none within scheduleSearchYears.
+func (s *schedule) prev(t time.Time) time.Time {
+	loc := t.Location()
+	limit := t.AddDate(-scheduleSearchYears, 0, 0)
+
+	c := t.Truncate(time.Minute)
+	for c.After(limit) {
+		next := c
+		y, m, d := c.Date()
+		switch {
+		case s.month&(1<<uint(m)) == 0:
+			c = time.Date(y, m, 1, 0, 0, 0, 0, loc).Add(-time.Minute)
+		case !s.matchDay(c):
+			c = time.Date(y, m, d, 0, 0, 0, 0, loc).Add(-time.Minute)
+		case s.hour&(1<<uint(c.Hour())) == 0:
+			c = time.Date(y, m, d, c.Hour(), 0, 0, 0, loc).Add(-time.Minute)
+		case s.minute&(1<<uint(c.Minute())) == 0:
+			c = c.Add(-time.Minute)
+		default:
+			return c
+		}
+		if !c.Before(next) {
+			// Skip over an ambiguous wall clock time
+			c = next.Add(-time.Minute)
+		}
+	}
+	return time.Time{}
+}
+
+// wallClock returns the wall clock time of t, as a UTC time with the same
+// date and time of day, for comparing times across a daylight saving time
+// change.
+func wallClock(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+}
@@ -0,0 +1,399 @@
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains a rotating file writer that several processes can
+// share.
+package writer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// errFlockUnsupported is returned by lockFile on systems without flock.
+var errFlockUnsupported = errors.New("file locking is not supported on this system")
+
+// atomicWriteSize is the largest entry written under the shared lock.
+// Appends up to PIPE_BUF, 4096 bytes on Linux, are written in one piece;
+// larger entries take the exclusive lock so other processes can't
+// interleave with them.
+const atomicWriteSize = 4096
+
+// SharedFileConfig holds configuration for a rotating log file shared by
+// several processes.
+type SharedFileConfig struct {
+	// FilePath is the path to the log file. If the directory doesn't exist,
+	// it will be created automatically. The processes coordinate through
+	// the lock files FilePath+".lock" and FilePath+".mill.lock".
+	FilePath string
+
+	// MaxRotatedSize is the maximum size in megabytes of the log file before
+	// it gets rotated. Defaults to 100 MB if not specified.
+	MaxRotatedSize int
+
+	// MaxRetainDay is the maximum number of days to retain old log files
+	// based on the timestamp encoded in their filename. Defaults to 7 days
+	// if not specified.
+	MaxRetainDay int
+
+	// MaxRetainFiles is the maximum number of old log files to retain.
+	// Defaults to 3 if not specified.
+	MaxRetainFiles int
+
+	// LocalTime determines if the time used for formatting the timestamps
+	// in backup files is the computer's local time. Defaults to UTC time.
+	LocalTime bool
+
+	// Compress determines if the rotated log files should be compressed.
+	// Defaults to false.
+	Compress bool
+
+	// Compression is the format used when Compress is set. Defaults to gzip
+	// with the default compression level.
+	Compression Compressor
+
+	// FileMode is the permission bits of created log files. Defaults to
+	// 0644 if not specified.
+	FileMode os.FileMode
+
+	// OnError is called from a background goroutine when compressing or
+	// removing rotated files fails. Errors are discarded if it is nil.
+	OnError func(err error)
+}
+
+// Validate checks if the configuration is valid and returns an error if not.
+func (c *SharedFileConfig) Validate() error {
+	if c.FilePath == "" {
+		return fmt.Errorf("FilePath cannot be empty")
+	}
+
+	if c.MaxRotatedSize < 0 {
+		return fmt.Errorf("MaxRotatedSize cannot be negative")
+	}
+
+	if c.MaxRetainDay < 0 {
+		return fmt.Errorf("MaxRetainDay cannot be negative")
+	}
+
+	if c.MaxRetainFiles < 0 {
+		return fmt.Errorf("MaxRetainFiles cannot be negative")
+	}
+
+	if c.FileMode&^os.ModePerm != 0 {
+		return fmt.Errorf("FileMode can only contain permission bits")
+	}
+
+	if err := c.Compression.validate(); err != nil {
+		return fmt.Errorf("invalid Compression: %w", err)
+	}
+
+	return nil
+}
+
+// setDefaults sets default values for unspecified configuration fields.
+func (c *SharedFileConfig) setDefaults() {
+	if c.MaxRotatedSize == 0 {
+		c.MaxRotatedSize = 100 // 100 MB default
+	}
+
+	if c.MaxRetainDay == 0 {
+		c.MaxRetainDay = 7 // 7 days default
+	}
+
+	if c.MaxRetainFiles == 0 {
+		c.MaxRetainFiles = 3 // 3 files default
+	}
+
+	if c.FileMode == 0 {
+		c.FileMode = 0644
+	}
+}
+
+// SharedFileWriter is an io.Writer for a log file that several processes
+// write to and rotate. Entries are appended with O_APPEND under a shared
+// flock, and rotation takes the lock exclusively, so entries are neither
+// lost nor torn when another process rotates the file. Rotated files use
+// lumberjack's naming. It is safe for concurrent use.
+type SharedFileWriter struct {
+	conf    SharedFileConfig
+	maxSize int64
+	mill    *mill
+	now     func() time.Time
+
+	mutex sync.Mutex // Serializes the goroutines of this process
+	lock  *os.File   // Lock file shared by the processes
+	file  *os.File
+	info  os.FileInfo // Identity of file
+}
+
+// NewSharedFileWriter creates a new rotating file writer that is safe to
+// use from several processes writing to the same file. It requires flock,
+// which is available on Linux, macOS and the BSDs.
+//
+// Example:
+//
+//	w, err := writer.NewSharedFileWriter(writer.SharedFileConfig{
+//	    FilePath:       "/var/log/workers.log",
+//	    MaxRotatedSize: 100,
+//	    Compress:       true,
+//	})
+//	defer w.Close()
+//	logger := tslog.NewLogger(tslog.WithWriter(w))
+func NewSharedFileWriter(conf SharedFileConfig) (*SharedFileWriter, error) {
+	// Validate configuration
+	if err := conf.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid shared file config: %w", err)
+	}
+
+	// Apply defaults
+	conf.setDefaults()
+
+	w := &SharedFileWriter{
+		conf:    conf,
+		maxSize: int64(conf.MaxRotatedSize) * megabyte,
+		now:     time.Now,
+	}
+	w.mill = &mill{
+		list: func() ([]backupFile, error) {
+			return listLumberjackBackups(conf.FilePath, conf.LocalTime, conf.Compression.orDefault().extensions())
+		},
+		compressor: compressorOf(conf.Compress, conf.Compression),
+		maxAge:     time.Duration(conf.MaxRetainDay) * 24 * time.Hour,
+		maxFiles:   conf.MaxRetainFiles,
+		onError:    conf.OnError,
+		lock:       w.lockMill,
+	}
+
+	// Fail fast if the directory isn't usable or flock isn't supported
+	if err := w.lockLocked(false); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := unlockFile(w.lock); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("failed to unlock log file: %w", err)
+	}
+
+	return w, nil
+}
+
+// MustNewSharedFileWriter is like NewSharedFileWriter but panics if the
+// configuration is invalid or the file can't be locked.
+func MustNewSharedFileWriter(conf SharedFileConfig) *SharedFileWriter {
+	writer, err := NewSharedFileWriter(conf)
+	if err != nil {
+		panic(err)
+	}
+	return writer
+}
+
+// Write appends p to the log file as a single write, and rotates the file
+// afterwards if it has reached MaxRotatedSize.
+func (w *SharedFileWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	size, n, err := w.writeLocked(p)
+	if err != nil {
+		return n, err
+	}
+
+	if size >= w.maxSize {
+		if err := w.rotateLocked(false); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Rotate renames the log file to a backup name with the current timestamp
+// and opens a new file, unless it is empty.
+func (w *SharedFileWriter) Rotate() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.rotateLocked(true)
+}
+
+// Sync commits the log file to stable storage.
+func (w *SharedFileWriter) Sync() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	return w.file.Sync()
+}
+
+// Close closes the log file and waits for background processing of rotated
+// files to finish. Writing after Close reopens the file.
+func (w *SharedFileWriter) Close() error {
+	w.mutex.Lock()
+	var err error
+	if w.file != nil {
+		err = w.file.Close()
+		w.file, w.info = nil, nil
+	}
+	if w.lock != nil {
+		if cerr := w.lock.Close(); err == nil {
+			err = cerr
+		}
+		w.lock = nil
+	}
+	w.mutex.Unlock()
+
+	w.mill.close()
+	return err
+}
+
+// writeLocked appends p under the lock and returns the size of the file
+// after writing.
+func (w *SharedFileWriter) writeLocked(p []byte) (int64, int, error) {
+	if err := w.lockLocked(len(p) > atomicWriteSize); err != nil {
+		return 0, 0, err
+	}
+	defer unlockFile(w.lock)
+
+	fi, err := w.currentLocked()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	n, err := w.file.Write(p)
+	return fi.Size() + int64(n), n, err
+}
+
+// rotateLocked rotates the log file under the exclusive lock. Unless force
+// is set, it does nothing if another process has rotated the file already.
+func (w *SharedFileWriter) rotateLocked(force bool) error {
+	if err := w.lockLocked(true); err != nil {
+		return err
+	}
+	defer unlockFile(w.lock)
+
+	fi, err := w.currentLocked()
+	if err != nil {
+		return err
+	}
+	if fi.Size() == 0 || !force && fi.Size() < w.maxSize {
+		return nil
+	}
+
+	t := w.now()
+	if !w.conf.LocalTime {
+		t = t.UTC()
+	}
+	// Names have millisecond resolution, so make sure not to replace a
+	// backup, compressed or not, rotated within the same millisecond
+	backup := lumberjackBackupName(w.conf.FilePath, t)
+	for w.backupExists(backup) {
+		t = t.Add(time.Millisecond)
+		backup = lumberjackBackupName(w.conf.FilePath, t)
+	}
+
+	if err := os.Rename(w.conf.FilePath, backup); err != nil {
+		return fmt.Errorf("can't rename log file: %w", err)
+	}
+	defer w.mill.trigger(backup)
+
+	_, err = w.currentLocked()
+	return err
+}
+
+// backupExists reports whether a backup exists at path, with or without a
+// compression extension.
+func (w *SharedFileWriter) backupExists(path string) bool {
+	if fileExists(path) {
+		return true
+	}
+	for _, ext := range w.conf.Compression.orDefault().extensions() {
+		if fileExists(path + ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// currentLocked makes sure the open file is the one at the configured path,
+// reopening it if another process has rotated it, and returns its info.
+func (w *SharedFileWriter) currentLocked() (os.FileInfo, error) {
+	name := w.conf.FilePath
+	fi, err := os.Stat(name)
+	if err == nil && w.file != nil && os.SameFile(fi, w.info) {
+		return fi, nil
+	}
+
+	if w.file != nil {
+		w.file.Close()
+		w.file, w.info = nil, nil
+	}
+
+	created := os.IsNotExist(err)
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, w.conf.FileMode)
+	if err != nil {
+		return nil, fmt.Errorf("can't open logfile: %w", err)
+	}
+	if created {
+		// Not subject to the umask
+		if err := f.Chmod(w.conf.FileMode); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("can't set mode of new logfile: %w", err)
+		}
+	}
+
+	fi, err = f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("can't stat logfile: %w", err)
+	}
+
+	w.file, w.info = f, fi
+	return fi, nil
+}
+
+// lockLocked opens the lock file if needed and locks it.
+func (w *SharedFileWriter) lockLocked(exclusive bool) error {
+	if w.lock == nil {
+		f, err := openLockFile(w.conf.FilePath+".lock", w.conf.FileMode)
+		if err != nil {
+			return err
+		}
+		w.lock = f
+	}
+
+	if err := lockFile(w.lock, exclusive); err != nil {
+		return fmt.Errorf("failed to lock log file: %w", err)
+	}
+	return nil
+}
+
+// lockMill serializes the processing of rotated files with other processes.
+func (w *SharedFileWriter) lockMill() (func(), error) {
+	f, err := openLockFile(w.conf.FilePath+".mill.lock", w.conf.FileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(f, true); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock rotated files: %w", err)
+	}
+	return func() { f.Close() }, nil
+}
+
+// openLockFile opens the lock file at path, creating it and its directory
+// if needed.
+func openLockFile(path string, mode os.FileMode) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("can't make directories for new logfile: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, mode)
+	if err != nil {
+		return nil, fmt.Errorf("can't open lock file: %w", err)
+	}
+	return f, nil
+}
@@ -0,0 +1,180 @@
+package writer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Settings of the child processes writing to a shared file
+const (
+	sharedChildEnv   = "TSLOG_SHARED_WRITER_CHILD"
+	sharedChildLines = 400
+	sharedMaxSize    = 256 * 1024
+)
+
+// newTestSharedWriter creates a shared file writer, skipping the test if
+// flock isn't supported
+func newTestSharedWriter(t *testing.T, conf SharedFileConfig) *SharedFileWriter {
+	w, err := NewSharedFileWriter(conf)
+	if errors.Is(err, errFlockUnsupported) {
+		t.Skip(err)
+	}
+	require.NoError(t, err)
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+// sharedLine returns the i-th line written by child, alternating between
+// entries that fit within atomicWriteSize and entries that don't
+func sharedLine(child, i int) string {
+	size := 100
+	if i%5 == 0 {
+		size = 3 * atomicWriteSize
+	}
+	prefix := fmt.Sprintf("child=%d line=%d len=%d ", child, i, size)
+	return prefix + strings.Repeat(string(rune('a'+child)), size-len(prefix)) + "\n"
+}
+
+// TestSharedFileWriterHelperProcess writes lines to a shared file when run
+// as a child process by TestSharedFileWriterProcesses
+func TestSharedFileWriterHelperProcess(t *testing.T) {
+	env := os.Getenv(sharedChildEnv)
+	if env == "" {
+		return // Not a child process
+	}
+
+	parts := strings.SplitN(env, ":", 2)
+	child, err := strconv.Atoi(parts[0])
+	require.NoError(t, err)
+
+	w, err := NewSharedFileWriter(SharedFileConfig{
+		FilePath:       parts[1],
+		MaxRetainFiles: 1000,
+	})
+	require.NoError(t, err)
+	w.maxSize = sharedMaxSize
+
+	for i := 0; i < sharedChildLines; i++ {
+		_, err := w.Write([]byte(sharedLine(child, i)))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+}
+
+// TestSharedFileWriterProcesses tests that processes writing to and
+// rotating the same file neither lose nor tear lines
+func TestSharedFileWriterProcesses(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts child processes")
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	newTestSharedWriter(t, SharedFileConfig{FilePath: path}).Close()
+
+	const children = 4
+	var wg sync.WaitGroup
+	for i := 0; i < children; i++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestSharedFileWriterHelperProcess$", "-test.count=1")
+		cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%d:%s", sharedChildEnv, i, path))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, err := cmd.CombinedOutput()
+			assert.NoError(t, err, string(out))
+		}()
+	}
+	wg.Wait()
+
+	files, err := filepath.Glob(filepath.Join(dir, "app*.log"))
+	require.NoError(t, err)
+	assert.Greater(t, len(files), 2, "the file should have been rotated")
+
+	seen := make(map[string]bool)
+	for _, file := range files {
+		f, err := os.Open(file)
+		require.NoError(t, err)
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 4*atomicWriteSize)
+		for scanner.Scan() {
+			line := scanner.Text()
+			var child, i, size int
+			_, err := fmt.Sscanf(line, "child=%d line=%d len=%d ", &child, &i, &size)
+			require.NoError(t, err, "torn line %.80q", line)
+			require.Equal(t, sharedLine(child, i), line+"\n", "torn line")
+			assert.False(t, seen[line], "duplicate line")
+			seen[line] = true
+		}
+		require.NoError(t, scanner.Err())
+		f.Close()
+	}
+	assert.Len(t, seen, children*sharedChildLines)
+}
+
+// TestSharedFileWriter tests the writer within a single process
+func TestSharedFileWriter(t *testing.T) {
+	t.Run("Config", func(t *testing.T) {
+		config := SharedFileConfig{FilePath: "/tmp/app.log"}
+		require.NoError(t, config.Validate())
+		config.setDefaults()
+		assert.Equal(t, 100, config.MaxRotatedSize)
+		assert.Equal(t, 7, config.MaxRetainDay)
+		assert.Equal(t, 3, config.MaxRetainFiles)
+		assert.Equal(t, os.FileMode(0644), config.FileMode)
+
+		w, err := NewSharedFileWriter(SharedFileConfig{MaxRotatedSize: -1})
+		assert.Nil(t, w)
+		assert.Contains(t, err.Error(), "invalid shared file config")
+	})
+
+	t.Run("RotateAndRetain", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "app.log")
+		w := newTestSharedWriter(t, SharedFileConfig{
+			FilePath:       path,
+			MaxRetainFiles: 2,
+			Compress:       true,
+		})
+
+		for i := 0; i < 4; i++ {
+			_, err := w.Write([]byte(fmt.Sprintf("line %d\n", i)))
+			require.NoError(t, err)
+			require.NoError(t, w.Rotate())
+		}
+		require.NoError(t, w.Rotate(), "rotating an empty file is a no-op")
+		require.NoError(t, w.Close())
+
+		backups, err := filepath.Glob(filepath.Join(dir, "app-*.log.gz"))
+		require.NoError(t, err)
+		require.Len(t, backups, 2)
+		assert.Equal(t, "line 3\n", readFile(t, backups[1]))
+		assert.Equal(t, "", readFile(t, path))
+	})
+
+	t.Run("FollowsOtherWriter", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "app.log")
+		conf := SharedFileConfig{FilePath: path, MaxRetainFiles: 10}
+		a := newTestSharedWriter(t, conf)
+		b := newTestSharedWriter(t, conf)
+
+		_, _ = a.Write([]byte("a1\n"))
+		_, _ = b.Write([]byte("b1\n"))
+		require.NoError(t, a.Rotate())
+		_, _ = b.Write([]byte("b2\n"))
+		require.NoError(t, b.Sync())
+
+		assert.Equal(t, "b2\n", readFile(t, path))
+	})
+}
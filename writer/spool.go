@@ -0,0 +1,755 @@
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains a writer that spools entries to disk while its
+// destination is unavailable.
+package writer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSpoolWriterClosed is returned when writing to a closed SpoolWriter.
+var ErrSpoolWriterClosed = errors.New("writer: spool writer is closed")
+
+// Names of the files in a spool directory.
+const (
+	spoolSegmentPrefix = "spool-"
+	spoolSegmentExt    = ".seg"
+	spoolCursorName    = "cursor"
+)
+
+// spoolRecordHeader is the size of the length prefix of each spooled entry.
+const spoolRecordHeader = 4
+
+// spoolCursorBatch is the number of replayed entries after which the cursor
+// is saved. It is also saved whenever replaying stops.
+const spoolCursorBatch = 100
+
+// SpoolConfig holds configuration for a disk-backed spool.
+type SpoolConfig struct {
+	// MaxSize is the maximum size in megabytes of the spool. When it is
+	// exceeded, the oldest segments are dropped. Defaults to 256 MB if not
+	// specified.
+	MaxSize int
+
+	// SegmentSize is the size in megabytes at which a new segment file is
+	// started. Defaults to 8 MB if not specified.
+	SegmentSize int
+
+	// RetryInterval is the time to wait before replaying the spool again
+	// after the inner writer failed. Defaults to 1 second if not specified.
+	RetryInterval time.Duration
+
+	// DirMode is the permission bits of a created spool directory. Defaults
+	// to 0755 if not specified.
+	DirMode os.FileMode
+
+	// FileMode is the permission bits of segment files. Defaults to 0644 if
+	// not specified.
+	FileMode os.FileMode
+
+	// OnError is called when the inner writer or the spool fails. Errors are
+	// discarded if it is nil.
+	OnError func(err error)
+}
+
+// Validate checks if the configuration is valid and returns an error if not.
+func (c *SpoolConfig) Validate() error {
+	if c.MaxSize < 0 {
+		return fmt.Errorf("MaxSize cannot be negative")
+	}
+
+	if c.SegmentSize < 0 {
+		return fmt.Errorf("SegmentSize cannot be negative")
+	}
+
+	if c.MaxSize > 0 && c.SegmentSize > c.MaxSize {
+		return fmt.Errorf("SegmentSize cannot be greater than MaxSize")
+	}
+
+	if c.RetryInterval < 0 {
+		return fmt.Errorf("RetryInterval cannot be negative")
+	}
+
+	if c.DirMode&^os.ModePerm != 0 {
+		return fmt.Errorf("DirMode can only contain permission bits")
+	}
+
+	if c.FileMode&^os.ModePerm != 0 {
+		return fmt.Errorf("FileMode can only contain permission bits")
+	}
+
+	return nil
+}
+
+// setDefaults sets default values for unspecified configuration fields.
+func (c *SpoolConfig) setDefaults() {
+	if c.MaxSize == 0 {
+		c.MaxSize = 256 // 256 MB default
+	}
+
+	if c.SegmentSize == 0 {
+		c.SegmentSize = 8 // 8 MB default
+	}
+
+	if c.SegmentSize > c.MaxSize {
+		c.SegmentSize = c.MaxSize
+	}
+
+	if c.RetryInterval == 0 {
+		c.RetryInterval = time.Second
+	}
+
+	if c.DirMode == 0 {
+		c.DirMode = 0755
+	}
+
+	if c.FileMode == 0 {
+		c.FileMode = 0644
+	}
+}
+
+// SpoolStats holds counters of a SpoolWriter.
+type SpoolStats struct {
+	// Spooled is the number of entries waiting on disk
+	Spooled int
+	// Size is the size in bytes of the spool
+	Size int64
+	// Replayed is the number of spooled entries written to the inner writer
+	Replayed uint64
+	// Dropped is the number of entries discarded because the spool was full
+	Dropped uint64
+}
+
+// spoolSegment is a file of spooled entries. Each entry is prefixed with
+// its length as a 4-byte big-endian integer.
+type spoolSegment struct {
+	seq     uint64
+	size    int64 // Size of the complete entries
+	records int   // Number of entries not yet replayed
+}
+
+// SpoolWriter is an io.Writer that writes entries to an inner writer, and
+// spools them to segment files on disk while the inner writer fails. A
+// background goroutine replays spooled entries in order once the inner
+// writer recovers; new entries are spooled behind them meanwhile, so order
+// is preserved. The spool survives restarts: entries left by a previous
+// process are replayed first. It is safe for concurrent use.
+//
+// Delivery is at least once: the replay position is saved every 100 entries
+// and whenever replaying stops, so after a crash up to that many entries
+// are replayed again on the next start.
+type SpoolWriter struct {
+	w           io.Writer
+	dir         string
+	conf        SpoolConfig
+	maxSize     int64
+	segmentSize int64
+
+	mutex    sync.Mutex
+	segments []*spoolSegment // Oldest first, the last one is appended to
+	size     int64           // Total size of segments
+	out      *os.File        // Last segment, opened for appending
+	in       *os.File        // First segment, opened for replaying
+	offset   int64           // Offset of the next entry to replay in the first segment
+	unsaved  int             // Entries replayed since the cursor was saved
+	replayed uint64
+	dropped  uint64
+	closed   bool
+
+	wake chan struct{} // Triggers a replay
+	quit chan struct{} // Closed by Close
+	done chan struct{} // Closed when the background goroutine exits
+}
+
+// NewSpoolWriter creates a writer that writes entries to w, spooling them
+// in dir while w fails. Entries spooled by a previous process in dir are
+// replayed first. Only one writer may use dir at a time.
+//
+// Each Write call is treated as one entry, which is how the tslog drivers
+// write. Close must be called to stop the background goroutine. It doesn't
+// close w.
+//
+// Example:
+//
+//	remote := writer.MustNewNetWriter("tcp", "logs.internal:5170", writer.NetConfig{})
+//	spool, err := writer.NewSpoolWriter(remote, "/var/spool/app", writer.SpoolConfig{MaxSize: 1024})
+//	defer spool.Close()
+//	logger := tslog.NewLogger(tslog.WithWriter(spool))
+func NewSpoolWriter(w io.Writer, dir string, conf SpoolConfig) (*SpoolWriter, error) {
+	if w == nil {
+		return nil, fmt.Errorf("invalid spool config: writer cannot be nil")
+	}
+
+	if dir == "" {
+		return nil, fmt.Errorf("invalid spool config: directory cannot be empty")
+	}
+
+	// Validate configuration
+	if err := conf.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid spool config: %w", err)
+	}
+
+	// Apply defaults
+	conf.setDefaults()
+
+	s := &SpoolWriter{
+		w:           w,
+		dir:         dir,
+		conf:        conf,
+		maxSize:     int64(conf.MaxSize) * megabyte,
+		segmentSize: int64(conf.SegmentSize) * megabyte,
+		wake:        make(chan struct{}, 1),
+		quit:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+
+	if err := os.MkdirAll(dir, conf.DirMode); err != nil {
+		return nil, fmt.Errorf("can't make spool directory: %w", err)
+	}
+	if err := s.recover(); err != nil {
+		s.closeFiles()
+		return nil, err
+	}
+
+	go s.run()
+	if len(s.segments) > 0 {
+		s.trigger()
+	}
+
+	return s, nil
+}
+
+// MustNewSpoolWriter is like NewSpoolWriter but panics if the configuration
+// is invalid or the spool can't be opened.
+func MustNewSpoolWriter(w io.Writer, dir string, conf SpoolConfig) *SpoolWriter {
+	writer, err := NewSpoolWriter(w, dir, conf)
+	if err != nil {
+		panic(err)
+	}
+	return writer
+}
+
+// writesEntries marks the writer as treating each Write call as one entry.
+func (s *SpoolWriter) writesEntries() {}
+
+// Write writes p to the inner writer, or spools it if the inner writer
+// fails or spooled entries are waiting to be replayed. It only returns an
+// error if the entry can't be spooled either.
+func (s *SpoolWriter) Write(p []byte) (int, error) {
+	werr, err := s.write(p)
+	// Report outside the lock, in case OnError logs
+	if werr != nil {
+		s.report(werr)
+	}
+	if err != nil {
+		if err != ErrSpoolWriterClosed {
+			s.report(err)
+		}
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// write writes p to the inner writer or the spool, and returns the error of
+// the inner writer and the error of the spool.
+func (s *SpoolWriter) write(p []byte) (error, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return nil, ErrSpoolWriterClosed
+	}
+
+	var werr error
+	if len(s.segments) == 0 {
+		if _, werr = s.w.Write(p); werr == nil {
+			return nil, nil
+		}
+	}
+
+	if err := s.appendLocked(p); err != nil {
+		return werr, err
+	}
+	s.trigger()
+	return werr, nil
+}
+
+// Sync commits the spool to stable storage, and syncs the inner writer if
+// it supports syncing.
+func (s *SpoolWriter) Sync() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.out != nil {
+		if err := s.out.Sync(); err != nil {
+			return err
+		}
+	}
+	if syncer, ok := s.w.(interface{ Sync() error }); ok {
+		return syncer.Sync()
+	}
+	return nil
+}
+
+// Close stops replaying and closes the spool. Entries still spooled are
+// replayed by the next writer using the directory.
+func (s *SpoolWriter) Close() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mutex.Unlock()
+
+	close(s.quit)
+	<-s.done
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.closeFiles()
+}
+
+// Stats returns a snapshot of the writer's counters.
+func (s *SpoolWriter) Stats() SpoolStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	stats := SpoolStats{
+		Size:     s.size,
+		Replayed: s.replayed,
+		Dropped:  s.dropped,
+	}
+	for _, seg := range s.segments {
+		stats.Spooled += seg.records
+	}
+	return stats
+}
+
+// appendLocked appends an entry to the last segment, starting a new one if
+// it is full and dropping the oldest ones to stay within MaxSize.
+func (s *SpoolWriter) appendLocked(p []byte) error {
+	n := int64(spoolRecordHeader + len(p))
+
+	if s.out == nil || s.last().size+n > s.segmentSize && s.last().size > 0 {
+		if err := s.newSegmentLocked(); err != nil {
+			return err
+		}
+	}
+
+	for s.size+n > s.maxSize && len(s.segments) > 1 {
+		if err := s.dropOldestLocked(); err != nil {
+			return err
+		}
+	}
+	if s.size+n > s.maxSize {
+		s.dropped++
+		return nil
+	}
+
+	record := make([]byte, n)
+	binary.BigEndian.PutUint32(record, uint32(len(p)))
+	copy(record[spoolRecordHeader:], p)
+	if _, err := s.out.Write(record); err != nil {
+		// Cut off a partial entry so that later ones stay readable
+		s.out.Truncate(s.last().size)
+		return fmt.Errorf("can't write to spool: %w", err)
+	}
+
+	s.last().size += n
+	s.last().records++
+	s.size += n
+	return nil
+}
+
+// newSegmentLocked starts a new segment.
+func (s *SpoolWriter) newSegmentLocked() error {
+	seq := uint64(1)
+	if len(s.segments) > 0 {
+		seq = s.last().seq + 1
+	}
+
+	f, err := os.OpenFile(s.segmentPath(seq), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, s.conf.FileMode)
+	if err != nil {
+		return fmt.Errorf("can't create spool segment: %w", err)
+	}
+
+	if s.out != nil {
+		s.out.Close()
+	}
+	s.out = f
+	s.segments = append(s.segments, &spoolSegment{seq: seq})
+	return nil
+}
+
+// dropOldestLocked removes the oldest segment with the entries it holds.
+func (s *SpoolWriter) dropOldestLocked() error {
+	seg := s.segments[0]
+	s.dropped += uint64(seg.records)
+	return s.removeFirstLocked()
+}
+
+// removeFirstLocked removes the first segment and resets replaying to the
+// start of the next one.
+func (s *SpoolWriter) removeFirstLocked() error {
+	seg := s.segments[0]
+	if s.in != nil {
+		s.in.Close()
+		s.in = nil
+	}
+	if len(s.segments) == 1 {
+		s.out.Close()
+		s.out = nil
+	}
+
+	s.segments[0] = nil
+	s.segments = s.segments[1:]
+	s.size -= seg.size
+	s.offset = 0
+
+	if err := os.Remove(s.segmentPath(seg.seq)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("can't remove spool segment: %w", err)
+	}
+	return s.saveCursorLocked()
+}
+
+// last returns the segment being appended to.
+func (s *SpoolWriter) last() *spoolSegment {
+	return s.segments[len(s.segments)-1]
+}
+
+// segmentPath returns the path of the segment with sequence number seq.
+func (s *SpoolWriter) segmentPath(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%s%020d%s", spoolSegmentPrefix, seq, spoolSegmentExt))
+}
+
+// run is the background goroutine replaying spooled entries.
+func (s *SpoolWriter) run() {
+	defer close(s.done)
+
+	for {
+		select {
+		case <-s.wake:
+		case <-s.quit:
+			return
+		}
+
+		if s.replay() {
+			continue
+		}
+
+		// The inner writer failed, try again later
+		timer := time.NewTimer(s.conf.RetryInterval)
+		select {
+		case <-timer.C:
+			s.trigger()
+		case <-s.quit:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// replay writes spooled entries to the inner writer until the spool is
+// empty, and reports whether it got there.
+func (s *SpoolWriter) replay() bool {
+	defer func() {
+		var err error
+		s.mutex.Lock()
+		if s.unsaved > 0 {
+			err = s.saveCursorLocked()
+		}
+		s.mutex.Unlock()
+		if err != nil {
+			s.report(err)
+		}
+	}()
+
+	for {
+		select {
+		case <-s.quit:
+			return true
+		default:
+		}
+
+		s.mutex.Lock()
+		entry, seq, err := s.nextLocked()
+		s.mutex.Unlock()
+		if err != nil {
+			s.report(err)
+			return false
+		}
+		if entry == nil {
+			return true
+		}
+
+		if _, err := s.w.Write(entry); err != nil {
+			s.report(err)
+			return false
+		}
+
+		s.mutex.Lock()
+		err = s.advanceLocked(seq, int64(spoolRecordHeader+len(entry)))
+		s.mutex.Unlock()
+		if err != nil {
+			s.report(err)
+			return false
+		}
+	}
+}
+
+// nextLocked returns the next entry to replay and the sequence number of
+// its segment, or nil if the spool is empty. Fully replayed segments are
+// removed; once none are left, entries go straight to the inner writer again.
+func (s *SpoolWriter) nextLocked() ([]byte, uint64, error) {
+	for len(s.segments) > 0 && s.offset >= s.segments[0].size {
+		if err := s.removeFirstLocked(); err != nil {
+			return nil, 0, err
+		}
+	}
+	if len(s.segments) == 0 {
+		return nil, 0, nil
+	}
+
+	if s.in == nil {
+		f, err := os.Open(s.segmentPath(s.segments[0].seq))
+		if err != nil {
+			return nil, 0, fmt.Errorf("can't open spool segment: %w", err)
+		}
+		s.in = f
+	}
+
+	var header [spoolRecordHeader]byte
+	if _, err := s.in.ReadAt(header[:], s.offset); err != nil {
+		return nil, 0, fmt.Errorf("can't read spool segment: %w", err)
+	}
+	entry := make([]byte, binary.BigEndian.Uint32(header[:]))
+	if _, err := s.in.ReadAt(entry, s.offset+spoolRecordHeader); err != nil {
+		return nil, 0, fmt.Errorf("can't read spool segment: %w", err)
+	}
+	return entry, s.segments[0].seq, nil
+}
+
+// advanceLocked moves past a replayed entry of n bytes of segment seq.
+func (s *SpoolWriter) advanceLocked(seq uint64, n int64) error {
+	if len(s.segments) == 0 || s.segments[0].seq != seq {
+		// The segment was dropped while the entry was being replayed
+		return nil
+	}
+
+	s.offset += n
+	s.segments[0].records--
+	s.replayed++
+	s.unsaved++
+	if s.unsaved < spoolCursorBatch {
+		return nil
+	}
+	return s.saveCursorLocked()
+}
+
+// saveCursorLocked records the position of the next entry to replay, so a
+// restart doesn't replay entries twice. The cursor is written to a temporary
+// file first and renamed into place, so a crash never leaves it truncated.
+func (s *SpoolWriter) saveCursorLocked() error {
+	s.unsaved = 0
+	path := filepath.Join(s.dir, spoolCursorName)
+	if len(s.segments) == 0 || s.offset == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("can't remove spool cursor: %w", err)
+		}
+		return nil
+	}
+
+	cursor := fmt.Sprintf("%d %d\n", s.segments[0].seq, s.offset)
+	tmp := path + ".tmp"
+	if err := writeFileSync(tmp, []byte(cursor), s.conf.FileMode); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("can't save spool cursor: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("can't save spool cursor: %w", err)
+	}
+	return nil
+}
+
+// writeFileSync writes data to the named file like os.WriteFile, and syncs
+// it before closing.
+func writeFileSync(name string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// recover loads the segments left in the directory by a previous writer.
+func (s *SpoolWriter) recover() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("can't read spool directory: %w", err)
+	}
+
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, spoolSegmentPrefix) || !strings.HasSuffix(name, spoolSegmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, spoolSegmentPrefix), spoolSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		seg, err := s.scanSegment(seq)
+		if err != nil {
+			return err
+		}
+		s.segments = append(s.segments, seg)
+		s.size += seg.size
+	}
+	sort.Slice(s.segments, func(i, j int) bool {
+		return s.segments[i].seq < s.segments[j].seq
+	})
+
+	if len(s.segments) > 0 {
+		s.offset = s.loadCursor()
+		if err := s.skipLocked(s.offset); err != nil {
+			return err
+		}
+
+		f, err := os.OpenFile(s.segmentPath(s.last().seq), os.O_WRONLY|os.O_APPEND, s.conf.FileMode)
+		if err != nil {
+			return fmt.Errorf("can't open spool segment: %w", err)
+		}
+		s.out = f
+	}
+	return nil
+}
+
+// scanSegment counts the complete entries of a segment, and truncates a
+// partial entry left by a crash.
+func (s *SpoolWriter) scanSegment(seq uint64) (*spoolSegment, error) {
+	path := s.segmentPath(seq)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open spool segment: %w", err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("can't stat spool segment: %w", err)
+	}
+
+	seg := &spoolSegment{seq: seq}
+	var header [spoolRecordHeader]byte
+	for seg.size+spoolRecordHeader <= fi.Size() {
+		if _, err := f.ReadAt(header[:], seg.size); err != nil {
+			return nil, fmt.Errorf("can't read spool segment: %w", err)
+		}
+		n := spoolRecordHeader + int64(binary.BigEndian.Uint32(header[:]))
+		if seg.size+n > fi.Size() {
+			break
+		}
+		seg.size += n
+		seg.records++
+	}
+
+	if seg.size < fi.Size() {
+		if err := os.Truncate(path, seg.size); err != nil {
+			return nil, fmt.Errorf("can't truncate spool segment: %w", err)
+		}
+	}
+	return seg, nil
+}
+
+// loadCursor returns the offset of the next entry to replay in the first
+// segment, or 0 if it wasn't saved.
+func (s *SpoolWriter) loadCursor() int64 {
+	data, err := os.ReadFile(filepath.Join(s.dir, spoolCursorName))
+	if err != nil {
+		return 0
+	}
+
+	var seq uint64
+	var offset int64
+	if _, err := fmt.Sscan(string(data), &seq, &offset); err != nil {
+		return 0
+	}
+	if seq != s.segments[0].seq || offset < 0 || offset > s.segments[0].size {
+		return 0
+	}
+	return offset
+}
+
+// skipLocked discounts the entries of the first segment before offset,
+// which were replayed by a previous writer.
+func (s *SpoolWriter) skipLocked(offset int64) error {
+	if offset == 0 {
+		return nil
+	}
+
+	f, err := os.Open(s.segmentPath(s.segments[0].seq))
+	if err != nil {
+		return fmt.Errorf("can't open spool segment: %w", err)
+	}
+	defer f.Close()
+
+	var header [spoolRecordHeader]byte
+	for pos := int64(0); pos < offset; {
+		if _, err := f.ReadAt(header[:], pos); err != nil {
+			return fmt.Errorf("can't read spool segment: %w", err)
+		}
+		pos += spoolRecordHeader + int64(binary.BigEndian.Uint32(header[:]))
+		s.segments[0].records--
+	}
+	return nil
+}
+
+// closeFiles closes the open segments.
+func (s *SpoolWriter) closeFiles() error {
+	var err error
+	if s.out != nil {
+		err = s.out.Close()
+		s.out = nil
+	}
+	if s.in != nil {
+		if cerr := s.in.Close(); err == nil {
+			err = cerr
+		}
+		s.in = nil
+	}
+	return err
+}
+
+// trigger schedules a replay.
+func (s *SpoolWriter) trigger() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// report passes err to OnError.
+func (s *SpoolWriter) report(err error) {
+	if s.conf.OnError != nil {
+		s.conf.OnError(err)
+	}
+}
@@ -0,0 +1,330 @@
+package writer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyWriter records entries, and fails while it is down
+type flakyWriter struct {
+	mutex   sync.Mutex
+	down    bool
+	entries []string
+}
+
+// Write records p unless the writer is down
+func (f *flakyWriter) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.down {
+		return 0, errors.New("endpoint unavailable")
+	}
+	f.entries = append(f.entries, string(p))
+	return len(p), nil
+}
+
+// setDown switches the writer between failing and recording
+func (f *flakyWriter) setDown(down bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.down = down
+}
+
+// received returns the recorded entries
+func (f *flakyWriter) received() []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return append([]string(nil), f.entries...)
+}
+
+// countingWriter accepts the first n entries and fails afterwards
+type countingWriter struct {
+	flakyWriter
+	n int
+}
+
+// Write records p while fewer than n entries were recorded
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if len(c.received()) >= c.n {
+		return 0, errors.New("endpoint unavailable")
+	}
+	return c.flakyWriter.Write(p)
+}
+
+// entryLines returns n entries numbered from first
+func entryLines(first, n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("entry %d\n", first+i)
+	}
+	return lines
+}
+
+// newTestSpoolWriter creates a spool writer that retries quickly
+func newTestSpoolWriter(t *testing.T, w *flakyWriter, dir string) *SpoolWriter {
+	s, err := NewSpoolWriter(w, dir, SpoolConfig{RetryInterval: 10 * time.Millisecond})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// TestSpoolConfig tests spool configuration validation and defaults
+func TestSpoolConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config SpoolConfig
+		errMsg string
+	}{
+		{"Empty", SpoolConfig{}, ""},
+		{"MaxSize", SpoolConfig{MaxSize: -1}, "MaxSize cannot be negative"},
+		{"SegmentSize", SpoolConfig{SegmentSize: -1}, "SegmentSize cannot be negative"},
+		{"SegmentLarger", SpoolConfig{MaxSize: 1, SegmentSize: 2}, "cannot be greater than MaxSize"},
+		{"RetryInterval", SpoolConfig{RetryInterval: -1}, "RetryInterval cannot be negative"},
+		{"DirMode", SpoolConfig{DirMode: os.ModeDir | 0755}, "DirMode can only contain"},
+		{"FileMode", SpoolConfig{FileMode: os.ModeSetuid | 0644}, "FileMode can only contain"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.errMsg == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+
+	t.Run("Defaults", func(t *testing.T) {
+		config := SpoolConfig{}
+		config.setDefaults()
+		assert.Equal(t, SpoolConfig{
+			MaxSize:       256,
+			SegmentSize:   8,
+			RetryInterval: time.Second,
+			DirMode:       0755,
+			FileMode:      0644,
+		}, config)
+
+		config = SpoolConfig{MaxSize: 4}
+		config.setDefaults()
+		assert.Equal(t, 4, config.SegmentSize)
+	})
+
+	t.Run("Constructor", func(t *testing.T) {
+		_, err := NewSpoolWriter(nil, t.TempDir(), SpoolConfig{})
+		assert.Contains(t, err.Error(), "writer cannot be nil")
+		_, err = NewSpoolWriter(&flakyWriter{}, "", SpoolConfig{})
+		assert.Contains(t, err.Error(), "directory cannot be empty")
+		_, err = NewSpoolWriter(&flakyWriter{}, t.TempDir(), SpoolConfig{MaxSize: -1})
+		assert.Contains(t, err.Error(), "invalid spool config")
+		assert.Panics(t, func() { MustNewSpoolWriter(nil, "", SpoolConfig{}) })
+	})
+}
+
+// TestSpoolWriter tests spooling entries while the inner writer is down
+func TestSpoolWriter(t *testing.T) {
+	t.Run("PassThrough", func(t *testing.T) {
+		inner := &flakyWriter{}
+		dir := t.TempDir()
+		s := newTestSpoolWriter(t, inner, dir)
+
+		for _, line := range entryLines(1, 3) {
+			n, err := s.Write([]byte(line))
+			require.NoError(t, err)
+			assert.Equal(t, len(line), n)
+		}
+
+		assert.Equal(t, entryLines(1, 3), inner.received())
+		assert.Equal(t, SpoolStats{}, s.Stats())
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("ReplayInOrder", func(t *testing.T) {
+		inner := &flakyWriter{down: true}
+		var errs []error
+		var mutex sync.Mutex
+		s, err := NewSpoolWriter(inner, t.TempDir(), SpoolConfig{
+			RetryInterval: 10 * time.Millisecond,
+			OnError: func(err error) {
+				mutex.Lock()
+				errs = append(errs, err)
+				mutex.Unlock()
+			},
+		})
+		require.NoError(t, err)
+		defer s.Close()
+
+		for _, line := range entryLines(1, 50) {
+			_, err := s.Write([]byte(line))
+			require.NoError(t, err)
+		}
+		stats := s.Stats()
+		assert.Equal(t, 50, stats.Spooled)
+		assert.Equal(t, int64(50*spoolRecordHeader+9*len("entry 1\n")+41*len("entry 10\n")), stats.Size)
+
+		inner.setDown(false)
+		// Entries written during the replay queue up behind the spool
+		for _, line := range entryLines(51, 50) {
+			_, err := s.Write([]byte(line))
+			require.NoError(t, err)
+		}
+
+		require.Eventually(t, func() bool { return len(inner.received()) == 100 }, 5*time.Second, 5*time.Millisecond)
+		assert.Equal(t, entryLines(1, 100), inner.received())
+		assert.GreaterOrEqual(t, s.Stats().Replayed, uint64(50))
+
+		mutex.Lock()
+		require.NotEmpty(t, errs)
+		assert.Contains(t, errs[0].Error(), "endpoint unavailable")
+		mutex.Unlock()
+
+		// Once drained, entries go straight through again
+		require.Eventually(t, func() bool { return s.Stats().Size == 0 }, 5*time.Second, 5*time.Millisecond)
+		_, err = s.Write([]byte("direct\n"))
+		require.NoError(t, err)
+		assert.Equal(t, "direct\n", inner.received()[100])
+	})
+
+	t.Run("DropOldest", func(t *testing.T) {
+		inner := &flakyWriter{down: true}
+		s := newTestSpoolWriter(t, inner, t.TempDir())
+
+		// Segments of 4 entries, room for 3 segments
+		line := strings.Repeat("x", 96) + "\n"
+		record := int64(spoolRecordHeader + len(line))
+		s.mutex.Lock()
+		s.segmentSize = 4 * record
+		s.maxSize = 12 * record
+		s.mutex.Unlock()
+
+		for i := 0; i < 14; i++ {
+			_, err := s.Write([]byte(fmt.Sprintf("%02d", i) + line[2:]))
+			require.NoError(t, err)
+		}
+
+		// The first segment was dropped to make room for the 13th entry
+		stats := s.Stats()
+		assert.Equal(t, uint64(4), stats.Dropped)
+		assert.Equal(t, 10, stats.Spooled)
+		assert.Equal(t, 10*record, stats.Size)
+
+		inner.setDown(false)
+		require.Eventually(t, func() bool { return len(inner.received()) == 10 }, 5*time.Second, 5*time.Millisecond)
+		received := inner.received()
+		assert.True(t, strings.HasPrefix(received[0], "04"))
+		assert.True(t, strings.HasPrefix(received[9], "13"))
+	})
+
+	t.Run("SurvivesRestart", func(t *testing.T) {
+		inner := &flakyWriter{down: true}
+		dir := t.TempDir()
+		s, err := NewSpoolWriter(inner, dir, SpoolConfig{RetryInterval: time.Hour})
+		require.NoError(t, err)
+
+		for _, line := range entryLines(1, 5) {
+			_, err := s.Write([]byte(line))
+			require.NoError(t, err)
+		}
+		require.NoError(t, s.Close())
+		_, err = s.Write([]byte("late\n"))
+		assert.ErrorIs(t, err, ErrSpoolWriterClosed)
+
+		// A crash left half an entry behind
+		segments, err := filepath.Glob(filepath.Join(dir, "spool-*.seg"))
+		require.NoError(t, err)
+		require.Len(t, segments, 1)
+		f, err := os.OpenFile(segments[0], os.O_WRONLY|os.O_APPEND, 0)
+		require.NoError(t, err)
+		_, err = f.Write([]byte{0, 0, 0, 20, 'p', 'a'})
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		inner = &flakyWriter{}
+		s = newTestSpoolWriter(t, inner, dir)
+		_, err = s.Write([]byte("entry 6\n"))
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool { return len(inner.received()) == 6 }, 5*time.Second, 5*time.Millisecond)
+		assert.Equal(t, entryLines(1, 6), inner.received())
+	})
+
+	t.Run("ResumeFromCursor", func(t *testing.T) {
+		dir := t.TempDir()
+		inner := &flakyWriter{down: true}
+		s := newTestSpoolWriter(t, inner, dir)
+		for _, line := range entryLines(1, 4) {
+			_, err := s.Write([]byte(line))
+			require.NoError(t, err)
+		}
+		require.NoError(t, s.Close())
+
+		// A previous process replayed the first two entries
+		offset := 2 * (spoolRecordHeader + len("entry 1\n"))
+		segments, err := filepath.Glob(filepath.Join(dir, "spool-*.seg"))
+		require.NoError(t, err)
+		require.Len(t, segments, 1)
+		seq := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(segments[0]), "spool-"), ".seg")
+		cursor := fmt.Sprintf("%s %d\n", strings.TrimLeft(seq, "0"), offset)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "cursor"), []byte(cursor), 0644))
+
+		inner = &flakyWriter{down: true}
+		s = newTestSpoolWriter(t, inner, dir)
+		assert.Equal(t, 2, s.Stats().Spooled)
+
+		inner.setDown(false)
+		require.Eventually(t, func() bool { return len(inner.received()) == 2 }, 5*time.Second, 5*time.Millisecond)
+		assert.Equal(t, entryLines(3, 2), inner.received())
+
+		require.Eventually(t, func() bool { return s.Stats().Size == 0 }, 5*time.Second, 5*time.Millisecond)
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+	t.Run("CursorBatch", func(t *testing.T) {
+		dir := t.TempDir()
+		inner := &flakyWriter{down: true}
+		s := newTestSpoolWriter(t, inner, dir)
+		for _, line := range entryLines(1, 250) {
+			_, err := s.Write([]byte(line))
+			require.NoError(t, err)
+		}
+		require.NoError(t, s.Close())
+
+		// The replay stops in the middle of the second batch
+		counting := &countingWriter{n: 150}
+		s, err := NewSpoolWriter(counting, dir, SpoolConfig{RetryInterval: time.Hour})
+		require.NoError(t, err)
+		_, err = s.Write([]byte("entry 251\n"))
+		require.NoError(t, err)
+		require.Eventually(t, func() bool { return s.Stats().Replayed == 150 }, 5*time.Second, 5*time.Millisecond)
+		require.NoError(t, s.Close())
+		assert.Equal(t, entryLines(1, 150), counting.received())
+
+		cursor, err := os.ReadFile(filepath.Join(dir, "cursor"))
+		require.NoError(t, err)
+		offset := 9*(spoolRecordHeader+len("entry 1\n")) + 90*(spoolRecordHeader+len("entry 10\n")) +
+			51*(spoolRecordHeader+len("entry 100\n"))
+		assert.True(t, strings.HasSuffix(string(cursor), fmt.Sprintf(" %d\n", offset)), string(cursor))
+		_, err = os.Stat(filepath.Join(dir, "cursor.tmp"))
+		assert.True(t, os.IsNotExist(err))
+
+		inner = &flakyWriter{}
+		s = newTestSpoolWriter(t, inner, dir)
+		require.Eventually(t, func() bool { return len(inner.received()) == 101 }, 5*time.Second, 5*time.Millisecond)
+		assert.Equal(t, entryLines(151, 101), inner.received())
+	})
+}
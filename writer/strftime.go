@@ -0,0 +1,96 @@
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains strftime-style formatting used for time-based file and
+// index names.
+package writer
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// strftimeSpecs maps supported conversion specifications to their
+// formatter and a regular expression matching their output.
+var strftimeSpecs = map[byte]struct {
+	format func(t time.Time) string
+	re     string
+}{
+	'Y': {func(t time.Time) string { return fmt.Sprintf("%04d", t.Year()) }, `\d{4}`},
+	'y': {func(t time.Time) string { return fmt.Sprintf("%02d", t.Year()%100) }, `\d{2}`},
+	'm': {func(t time.Time) string { return fmt.Sprintf("%02d", int(t.Month())) }, `\d{2}`},
+	'd': {func(t time.Time) string { return fmt.Sprintf("%02d", t.Day()) }, `\d{2}`},
+	'H': {func(t time.Time) string { return fmt.Sprintf("%02d", t.Hour()) }, `\d{2}`},
+	'M': {func(t time.Time) string { return fmt.Sprintf("%02d", t.Minute()) }, `\d{2}`},
+	'S': {func(t time.Time) string { return fmt.Sprintf("%02d", t.Second()) }, `\d{2}`},
+	'j': {func(t time.Time) string { return fmt.Sprintf("%03d", t.YearDay()) }, `\d{3}`},
+	's': {func(t time.Time) string { return strconv.FormatInt(t.Unix(), 10) }, `\d+`},
+	'%': {func(time.Time) string { return "%" }, `%`},
+}
+
+// validateStrftime checks that pattern only uses supported conversion
+// specifications.
+func validateStrftime(pattern string) error {
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' {
+			continue
+		}
+		if i+1 == len(pattern) {
+			return fmt.Errorf("pattern %q ends with a lone %%", pattern)
+		}
+		i++
+		if _, ok := strftimeSpecs[pattern[i]]; !ok {
+			return fmt.Errorf("pattern %q uses unsupported conversion %%%c", pattern, pattern[i])
+		}
+	}
+	return nil
+}
+
+// strftime formats t according to pattern. Supported conversions are
+// %Y, %y, %m, %d, %H, %M, %S, %j, %s and %%. Unsupported conversions are
+// copied unchanged.
+func strftime(pattern string, t time.Time) string {
+	if strings.IndexByte(pattern, '%') < 0 {
+		return pattern
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		if c != '%' || i+1 == len(pattern) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		if spec, ok := strftimeSpecs[pattern[i]]; ok {
+			b.WriteString(spec.format(t))
+		} else {
+			b.WriteByte('%')
+			b.WriteByte(pattern[i])
+		}
+	}
+	return b.String()
+}
+
+// strftimeRegexp returns a regular expression, without anchors, matching the
+// strings strftime produces for pattern.
+func strftimeRegexp(pattern string) string {
+	var b strings.Builder
+	literal := 0
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' || i+1 == len(pattern) {
+			continue
+		}
+		b.WriteString(regexp.QuoteMeta(pattern[literal:i]))
+		i++
+		if spec, ok := strftimeSpecs[pattern[i]]; ok {
+			b.WriteString(spec.re)
+		} else {
+			b.WriteString(regexp.QuoteMeta(pattern[i-1 : i+1]))
+		}
+		literal = i + 1
+	}
+	b.WriteString(regexp.QuoteMeta(pattern[literal:]))
+	return b.String()
+}
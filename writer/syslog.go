@@ -0,0 +1,455 @@
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains a writer sending entries to a syslog daemon.
+package writer
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SyslogFacility is the facility part of a syslog priority.
+type SyslogFacility int
+
+// Syslog facilities as defined by RFC 5424.
+const (
+	FacilityKern SyslogFacility = iota
+	FacilityUser
+	FacilityMail
+	FacilityDaemon
+	FacilityAuth
+	FacilitySyslog
+	FacilityLpr
+	FacilityNews
+	FacilityUucp
+	FacilityCron
+	FacilityAuthpriv
+	FacilityFtp
+)
+
+// Local syslog facilities, reserved for site-specific use.
+const (
+	FacilityLocal0 SyslogFacility = iota + 16
+	FacilityLocal1
+	FacilityLocal2
+	FacilityLocal3
+	FacilityLocal4
+	FacilityLocal5
+	FacilityLocal6
+	FacilityLocal7
+)
+
+// SyslogFormat selects the syslog message format.
+type SyslogFormat int
+
+const (
+	// SyslogRFC5424 formats messages as defined by RFC 5424
+	SyslogRFC5424 SyslogFormat = iota
+	// SyslogRFC3164 formats messages in the older BSD format of RFC 3164
+	SyslogRFC3164
+)
+
+// SyslogFraming selects how messages are delimited on stream connections.
+type SyslogFraming int
+
+const (
+	// SyslogOctetCounting prefixes each message with its length, as
+	// defined by RFC 6587. It supports messages spanning several lines
+	SyslogOctetCounting SyslogFraming = iota
+	// SyslogNewlineFraming terminates each message with a newline, which
+	// some older daemons require. Newlines within messages are replaced
+	// with spaces
+	SyslogNewlineFraming
+)
+
+// Syslog severities as defined by RFC 5424.
+const (
+	severityError   = 3
+	severityWarning = 4
+	severityInfo    = 6
+	severityDebug   = 7
+)
+
+// syslogSeverity maps a level name to a syslog severity. Entries of an
+// unknown level are sent as informational.
+func syslogSeverity(level string) int {
+	switch level {
+	case LevelDebug:
+		return severityDebug
+	case LevelWarn:
+		return severityWarning
+	case LevelError:
+		return severityError
+	default:
+		return severityInfo
+	}
+}
+
+// localSyslogPaths are the sockets tried when no address is configured.
+var localSyslogPaths = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// SyslogConfig holds configuration for sending entries to a syslog daemon.
+type SyslogConfig struct {
+	// Network is the network of the syslog daemon: "unixgram", "unix",
+	// "udp", "tcp" or "tls". Leave both Network and Address empty to use
+	// the local daemon through /dev/log.
+	Network string
+
+	// Address is the address of the syslog daemon, such as
+	// "logs.example.com:514", or the path of its unix socket.
+	Address string
+
+	// TLSConfig is the TLS configuration of "tls" connections. Defaults to
+	// verifying the server against the system roots.
+	TLSConfig *tls.Config
+
+	// Format is the message format. Defaults to SyslogRFC5424.
+	Format SyslogFormat
+
+	// Framing delimits messages on stream connections ("unix", "tcp" and
+	// "tls"). Defaults to SyslogOctetCounting.
+	Framing SyslogFraming
+
+	// Facility is the facility of all messages. Since FacilityKern is
+	// reserved for the kernel, its zero value defaults to FacilityUser.
+	Facility SyslogFacility
+
+	// AppName identifies the application. Defaults to the name of the
+	// executable.
+	AppName string
+
+	// Hostname identifies the machine. Defaults to os.Hostname.
+	Hostname string
+
+	// MsgID identifies the type of messages in RFC 5424 messages. Defaults
+	// to none.
+	MsgID string
+
+	// Timeout is the maximum time to connect or send a message. Defaults
+	// to 5 seconds if not specified.
+	Timeout time.Duration
+}
+
+// Validate checks if the configuration is valid and returns an error if not.
+func (c *SyslogConfig) Validate() error {
+	switch c.Network {
+	case "":
+		if c.Address != "" {
+			return fmt.Errorf("Network cannot be empty when Address is set")
+		}
+	case "unixgram", "unix", "udp", "tcp", "tls":
+		if c.Address == "" {
+			return fmt.Errorf("Address cannot be empty")
+		}
+	default:
+		return fmt.Errorf("Network must be one of unixgram, unix, udp, tcp or tls")
+	}
+
+	if c.Format != SyslogRFC5424 && c.Format != SyslogRFC3164 {
+		return fmt.Errorf("Format must be either SyslogRFC5424 or SyslogRFC3164")
+	}
+
+	if c.Framing != SyslogOctetCounting && c.Framing != SyslogNewlineFraming {
+		return fmt.Errorf("Framing must be either SyslogOctetCounting or SyslogNewlineFraming")
+	}
+
+	if c.Facility < FacilityKern || c.Facility > FacilityLocal7 {
+		return fmt.Errorf("Facility must be between 0 and 23")
+	}
+
+	if err := validateSyslogName("AppName", c.AppName, 48); err != nil {
+		return err
+	}
+
+	if err := validateSyslogName("Hostname", c.Hostname, 255); err != nil {
+		return err
+	}
+
+	if err := validateSyslogName("MsgID", c.MsgID, 32); err != nil {
+		return err
+	}
+
+	if c.Timeout < 0 {
+		return fmt.Errorf("Timeout cannot be negative")
+	}
+
+	return nil
+}
+
+// validateSyslogName checks that a header field consists of at most max
+// printable ASCII characters, as RFC 5424 requires.
+func validateSyslogName(field, value string, max int) error {
+	if len(value) > max {
+		return fmt.Errorf("%s cannot be longer than %d characters", field, max)
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < 33 || value[i] > 126 {
+			return fmt.Errorf("%s can only contain printable ASCII characters", field)
+		}
+	}
+	return nil
+}
+
+// setDefaults sets default values for unspecified configuration fields.
+func (c *SyslogConfig) setDefaults() {
+	if c.Facility == FacilityKern {
+		c.Facility = FacilityUser
+	}
+
+	if c.AppName == "" {
+		c.AppName = syslogName(filepath.Base(os.Args[0]), 48)
+	}
+
+	if c.Hostname == "" {
+		hostname, _ := os.Hostname()
+		c.Hostname = syslogName(hostname, 255)
+	}
+
+	if c.Timeout == 0 {
+		c.Timeout = 5 * time.Second // 5 seconds default
+	}
+}
+
+// syslogName makes s usable as a header field, or returns "-" if it is
+// empty.
+func syslogName(s string, max int) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c < 33 || c > 126 {
+			b[i] = '_'
+		}
+	}
+	if len(b) > max {
+		b = b[:max]
+	}
+	if len(b) == 0 {
+		return "-"
+	}
+	return string(b)
+}
+
+// SyslogWriter is an io.Writer that sends each written entry to a syslog
+// daemon as a single message, with a severity matching the entry's level.
+// It reconnects when sending fails. It is safe for concurrent use.
+type SyslogWriter struct {
+	conf SyslogConfig
+	pid  int
+	now  func() time.Time
+
+	mutex   sync.Mutex
+	conn    net.Conn
+	network string // Network of conn, resolved for the local daemon
+}
+
+// NewSyslogWriter creates a new writer sending entries to a syslog daemon.
+// It connects right away so that an unreachable daemon is reported here.
+//
+// The level of each entry is read from the encoded entry, so the logger's
+// encoder must write it: JSON entries need a "level" key and console
+// entries the level in their second column, as the tslog encoders do.
+//
+// Example:
+//
+//	w, err := writer.NewSyslogWriter(writer.SyslogConfig{
+//	    Facility: writer.FacilityLocal0,
+//	    AppName:  "billing",
+//	})
+//	defer w.Close()
+//	logger := tslog.NewLogger(tslog.WithWriter(w), tslog.WithEncoder(tslog.EncoderJSON))
+func NewSyslogWriter(conf SyslogConfig) (*SyslogWriter, error) {
+	// Validate configuration
+	if err := conf.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid syslog config: %w", err)
+	}
+
+	// Apply defaults
+	conf.setDefaults()
+
+	w := &SyslogWriter{
+		conf: conf,
+		pid:  os.Getpid(),
+		now:  time.Now,
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if err := w.connectLocked(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// MustNewSyslogWriter is like NewSyslogWriter but panics if the
+// configuration is invalid or the daemon can't be reached.
+func MustNewSyslogWriter(conf SyslogConfig) *SyslogWriter {
+	writer, err := NewSyslogWriter(conf)
+	if err != nil {
+		panic(err)
+	}
+	return writer
+}
+
+// writesEntries marks the writer as treating each Write call as one entry.
+func (w *SyslogWriter) writesEntries() {}
+
+// Write sends each entry in p to the syslog daemon as one message, with the
+// severity of that entry. Trailing newlines are removed. If sending fails,
+// Write reconnects and tries once more. When it still fails, the entries
+// before the failed one have been sent and are counted in the returned
+// number of bytes.
+func (w *SyslogWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	n := 0
+	for _, entry := range splitEntries(p) {
+		if err := w.sendEntryLocked(w.format(entry)); err != nil {
+			return n, err
+		}
+		n += len(entry)
+	}
+	return len(p), nil
+}
+
+// sendEntryLocked sends msg, reconnecting and trying once more if sending
+// fails.
+func (w *SyslogWriter) sendEntryLocked(msg []byte) error {
+	if w.conn != nil {
+		if err := w.sendLocked(msg); err == nil {
+			return nil
+		}
+		w.conn.Close()
+		w.conn = nil
+	}
+
+	if err := w.connectLocked(); err != nil {
+		return err
+	}
+	if err := w.sendLocked(msg); err != nil {
+		w.conn.Close()
+		w.conn = nil
+		return fmt.Errorf("failed to send syslog message: %w", err)
+	}
+	return nil
+}
+
+// Close closes the connection to the syslog daemon. Writing after Close
+// reconnects.
+func (w *SyslogWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// format returns the syslog message for entry p, without framing.
+func (w *SyslogWriter) format(p []byte) []byte {
+	body := bytes.TrimRight(p, "\r\n")
+	pri := int(w.conf.Facility)*8 + syslogSeverity(parseLevel(body))
+	now := w.now()
+
+	var b bytes.Buffer
+	b.Grow(len(body) + 128)
+	b.WriteByte('<')
+	b.WriteString(strconv.Itoa(pri))
+	b.WriteByte('>')
+
+	if w.conf.Format == SyslogRFC3164 {
+		// <PRI>TIMESTAMP HOSTNAME TAG[PID]: MSG
+		b.WriteString(now.Format(time.Stamp))
+		b.WriteByte(' ')
+		b.WriteString(w.conf.Hostname)
+		b.WriteByte(' ')
+		b.WriteString(w.conf.AppName)
+		b.WriteByte('[')
+		b.WriteString(strconv.Itoa(w.pid))
+		b.WriteString("]: ")
+	} else {
+		// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+		msgID := w.conf.MsgID
+		if msgID == "" {
+			msgID = "-"
+		}
+		b.WriteString("1 ")
+		b.WriteString(now.Format("2006-01-02T15:04:05.000000Z07:00"))
+		b.WriteByte(' ')
+		b.WriteString(w.conf.Hostname)
+		b.WriteByte(' ')
+		b.WriteString(w.conf.AppName)
+		b.WriteByte(' ')
+		b.WriteString(strconv.Itoa(w.pid))
+		b.WriteByte(' ')
+		b.WriteString(msgID)
+		b.WriteString(" - ")
+	}
+
+	b.Write(body)
+	return b.Bytes()
+}
+
+// sendLocked writes msg to the connection, framed for stream connections.
+func (w *SyslogWriter) sendLocked(msg []byte) error {
+	if w.network != "udp" && w.network != "unixgram" {
+		if w.conf.Framing == SyslogNewlineFraming {
+			msg = append(bytes.ReplaceAll(msg, []byte{'\n'}, []byte{' '}), '\n')
+		} else {
+			msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+		}
+	}
+
+	if err := w.conn.SetWriteDeadline(time.Now().Add(w.conf.Timeout)); err != nil {
+		return err
+	}
+	_, err := w.conn.Write(msg)
+	return err
+}
+
+// connectLocked connects to the syslog daemon.
+func (w *SyslogWriter) connectLocked() error {
+	if w.conf.Network == "" {
+		return w.connectLocalLocked()
+	}
+
+	dialer := &net.Dialer{Timeout: w.conf.Timeout}
+	var conn net.Conn
+	var err error
+	if w.conf.Network == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", w.conf.Address, w.conf.TLSConfig)
+	} else {
+		conn, err = dialer.Dial(w.conf.Network, w.conf.Address)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to syslog daemon: %w", err)
+	}
+
+	w.conn = conn
+	w.network = w.conf.Network
+	return nil
+}
+
+// connectLocalLocked connects to the local syslog daemon's unix socket.
+func (w *SyslogWriter) connectLocalLocked() error {
+	var err error
+	for _, network := range []string{"unixgram", "unix"} {
+		for _, path := range localSyslogPaths {
+			var conn net.Conn
+			if conn, err = net.DialTimeout(network, path, w.conf.Timeout); err == nil {
+				w.conn = conn
+				w.network = network
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("failed to connect to local syslog daemon: %w", err)
+}
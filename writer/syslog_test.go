@@ -0,0 +1,383 @@
+package writer
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfc5424Regexp matches the header of an RFC 5424 message
+var rfc5424Regexp = regexp.MustCompile(`^<(\d+)>1 (\S+) (\S+) (\S+) (\d+) (\S+) - `)
+
+// rfc3164Regexp matches the header of an RFC 3164 message
+var rfc3164Regexp = regexp.MustCompile(`^<(\d+)>(\w{3} [ \d]\d \d\d:\d\d:\d\d) (\S+) (\S+)\[(\d+)\]: `)
+
+// readPacket reads a datagram from a fake syslog daemon
+func readPacket(t *testing.T, conn net.PacketConn) string {
+	t.Helper()
+	buf := make([]byte, 64*1024)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
+
+// acceptFrames serves one connection of a fake syslog daemon, sending the
+// octet-counted messages it receives to the returned channel
+func acceptFrames(t *testing.T, l net.Listener) <-chan string {
+	t.Helper()
+	ch := make(chan string, 16)
+	go func() {
+		defer close(ch)
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			length, err := r.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+			if err != nil {
+				return
+			}
+			buf := make([]byte, n)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return
+			}
+			ch <- string(buf)
+		}
+	}()
+	return ch
+}
+
+// receive returns the next message from ch
+func receive(t *testing.T, ch <-chan string) string {
+	t.Helper()
+	select {
+	case msg, ok := <-ch:
+		require.True(t, ok, "connection closed")
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for syslog message")
+		return ""
+	}
+}
+
+// TestSyslogConfigValidate tests syslog configuration validation
+func TestSyslogConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		config SyslogConfig
+		errMsg string
+	}{
+		{"Local", SyslogConfig{}, ""},
+		{"UDP", SyslogConfig{Network: "udp", Address: "localhost:514"}, ""},
+		{"AddressWithoutNetwork", SyslogConfig{Address: "localhost:514"}, "Network cannot be empty"},
+		{"UnknownNetwork", SyslogConfig{Network: "http", Address: "localhost:514"}, "Network must be one of"},
+		{"EmptyAddress", SyslogConfig{Network: "tcp"}, "Address cannot be empty"},
+		{"Format", SyslogConfig{Format: 2}, "Format must be"},
+		{"Framing", SyslogConfig{Framing: 2}, "Framing must be"},
+		{"Facility", SyslogConfig{Facility: 24}, "Facility must be"},
+		{"AppNameSpace", SyslogConfig{AppName: "my app"}, "AppName can only contain"},
+		{"AppNameLength", SyslogConfig{AppName: strings.Repeat("a", 49)}, "AppName cannot be longer"},
+		{"MsgIDLength", SyslogConfig{MsgID: strings.Repeat("a", 33)}, "MsgID cannot be longer"},
+		{"Timeout", SyslogConfig{Timeout: -time.Second}, "Timeout cannot be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.errMsg == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+
+	t.Run("Defaults", func(t *testing.T) {
+		config := SyslogConfig{}
+		config.setDefaults()
+		assert.Equal(t, FacilityUser, config.Facility)
+		assert.Equal(t, syslogName(filepath.Base(os.Args[0]), 48), config.AppName)
+		assert.NotEmpty(t, config.Hostname)
+		assert.Equal(t, 5*time.Second, config.Timeout)
+	})
+
+	t.Run("Constructor", func(t *testing.T) {
+		_, err := NewSyslogWriter(SyslogConfig{Network: "tcp"})
+		assert.Contains(t, err.Error(), "invalid syslog config")
+		assert.Panics(t, func() { MustNewSyslogWriter(SyslogConfig{Network: "tcp"}) })
+	})
+}
+
+// TestSyslogWriter tests message formatting and transports against fake
+// syslog daemons
+func TestSyslogWriter(t *testing.T) {
+	t.Run("UDPRFC5424", func(t *testing.T) {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer conn.Close()
+
+		w, err := NewSyslogWriter(SyslogConfig{
+			Network:  "udp",
+			Address:  conn.LocalAddr().String(),
+			Facility: FacilityLocal0,
+			AppName:  "billing",
+			Hostname: "web-1",
+			MsgID:    "audit",
+		})
+		require.NoError(t, err)
+		defer w.Close()
+
+		tests := []struct {
+			entry    string
+			severity int
+		}{
+			{`{"level":"DEBUG","msg":"debug"}` + "\n", 7},
+			{`{"level":"INFO","msg":"info"}` + "\n", 6},
+			{`{"level":"WARN","msg":"warn"}` + "\n", 4},
+			{`{"level":"ERROR","msg":"error"}` + "\n", 3},
+			{`{"level":"FATAL","msg":"fatal"}` + "\n", 3},
+			{"2024-01-01T00:00:00.000Z\tWARN\tconsole\n", 4},
+			{"no level\n", 6},
+		}
+
+		for _, tt := range tests {
+			n, err := w.Write([]byte(tt.entry))
+			require.NoError(t, err)
+			assert.Equal(t, len(tt.entry), n)
+
+			msg := readPacket(t, conn)
+			m := rfc5424Regexp.FindStringSubmatch(msg)
+			require.NotNil(t, m, msg)
+			assert.Equal(t, strconv.Itoa(16*8+tt.severity), m[1])
+			_, err = time.Parse(time.RFC3339Nano, m[2])
+			assert.NoError(t, err)
+			assert.Equal(t, "web-1", m[3])
+			assert.Equal(t, "billing", m[4])
+			assert.Equal(t, strconv.Itoa(os.Getpid()), m[5])
+			assert.Equal(t, "audit", m[6])
+			assert.Equal(t, strings.TrimSuffix(tt.entry, "\n"), msg[len(m[0]):])
+		}
+	})
+
+	t.Run("UDPRFC3164", func(t *testing.T) {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer conn.Close()
+
+		w, err := NewSyslogWriter(SyslogConfig{
+			Network:  "udp",
+			Address:  conn.LocalAddr().String(),
+			Format:   SyslogRFC3164,
+			Facility: FacilityDaemon,
+			AppName:  "worker",
+			Hostname: "web-1",
+		})
+		require.NoError(t, err)
+		defer w.Close()
+		w.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local) }
+
+		_, err = w.Write([]byte(`{"level":"ERROR","msg":"failed"}` + "\n"))
+		require.NoError(t, err)
+
+		msg := readPacket(t, conn)
+		m := rfc3164Regexp.FindStringSubmatch(msg)
+		require.NotNil(t, m, msg)
+		assert.Equal(t, strconv.Itoa(3*8+3), m[1])
+		assert.Equal(t, "Jan  2 03:04:05", m[2])
+		assert.Equal(t, "web-1", m[3])
+		assert.Equal(t, "worker", m[4])
+		assert.Equal(t, `{"level":"ERROR","msg":"failed"}`, msg[len(m[0]):])
+	})
+
+	t.Run("TCPOctetCounting", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer l.Close()
+		ch := acceptFrames(t, l)
+
+		w, err := NewSyslogWriter(SyslogConfig{Network: "tcp", Address: l.Addr().String()})
+		require.NoError(t, err)
+		defer w.Close()
+
+		// Multi-line entries, such as stack traces, stay in one message
+		entry := "2024-01-01T00:00:00.000Z\tERROR\tfailed\nmain.main()\n\tmain.go:10\n"
+		_, err = w.Write([]byte(entry))
+		require.NoError(t, err)
+		_, err = w.Write([]byte("2024-01-01T00:00:00.000Z\tINFO\tdone\n"))
+		require.NoError(t, err)
+
+		msg := receive(t, ch)
+		assert.True(t, strings.HasPrefix(msg, "<11>1 "), msg)
+		assert.True(t, strings.HasSuffix(msg, "failed\nmain.main()\n\tmain.go:10"), msg)
+
+		msg = receive(t, ch)
+		assert.True(t, strings.HasPrefix(msg, "<14>1 "), msg)
+		assert.True(t, strings.HasSuffix(msg, "\tdone"), msg)
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer conn.Close()
+
+		w, err := NewSyslogWriter(SyslogConfig{Network: "udp", Address: conn.LocalAddr().String()})
+		require.NoError(t, err)
+		defer w.Close()
+
+		// Several entries in one Write are sent separately, each with its own severity
+		batch := `{"level":"INFO","msg":"first"}` + "\n" +
+			`{"level":"ERROR","msg":"second"}` + "\n" +
+			"2024-01-01T00:00:00.000Z\tWARN\tthird\nmain.main()\n\tmain.go:10\n"
+		n, err := w.Write([]byte(batch))
+		require.NoError(t, err)
+		assert.Equal(t, len(batch), n)
+
+		for _, want := range []struct {
+			severity int
+			body     string
+		}{
+			{6, `{"level":"INFO","msg":"first"}`},
+			{3, `{"level":"ERROR","msg":"second"}`},
+			{4, "2024-01-01T00:00:00.000Z\tWARN\tthird\nmain.main()\n\tmain.go:10"},
+		} {
+			msg := readPacket(t, conn)
+			m := rfc5424Regexp.FindStringSubmatch(msg)
+			require.NotNil(t, m, msg)
+			assert.Equal(t, strconv.Itoa(int(FacilityUser)*8+want.severity), m[1])
+			assert.Equal(t, want.body, msg[len(m[0]):])
+		}
+	})
+
+	t.Run("TCPNewlineFraming", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer l.Close()
+
+		w, err := NewSyslogWriter(SyslogConfig{
+			Network: "tcp",
+			Address: l.Addr().String(),
+			Framing: SyslogNewlineFraming,
+		})
+		require.NoError(t, err)
+		defer w.Close()
+
+		conn, err := l.Accept()
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = w.Write([]byte("first\nsecond\n"))
+		require.NoError(t, err)
+
+		require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		require.NoError(t, err)
+		assert.True(t, strings.HasSuffix(line, " - first second\n"), line)
+	})
+
+	t.Run("TLS", func(t *testing.T) {
+		// Borrow the certificate of a TLS test server
+		ts := httptest.NewTLSServer(http.NotFoundHandler())
+		defer ts.Close()
+		clientConfig := ts.Client().Transport.(*http.Transport).TLSClientConfig
+
+		l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: ts.TLS.Certificates})
+		require.NoError(t, err)
+		defer l.Close()
+		ch := acceptFrames(t, l)
+
+		w, err := NewSyslogWriter(SyslogConfig{
+			Network:   "tls",
+			Address:   l.Addr().String(),
+			TLSConfig: &tls.Config{RootCAs: clientConfig.RootCAs, ServerName: "example.com"},
+		})
+		require.NoError(t, err)
+		defer w.Close()
+
+		_, err = w.Write([]byte(`{"level":"WARN","msg":"secure"}` + "\n"))
+		require.NoError(t, err)
+
+		msg := receive(t, ch)
+		assert.True(t, strings.HasPrefix(msg, "<12>1 "), msg)
+		assert.True(t, strings.HasSuffix(msg, `{"level":"WARN","msg":"secure"}`), msg)
+	})
+
+	t.Run("UnixDatagram", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("unixgram sockets are not supported on Windows")
+		}
+
+		path := filepath.Join(t.TempDir(), "log.sock")
+		conn, err := net.ListenPacket("unixgram", path)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		w, err := NewSyslogWriter(SyslogConfig{Network: "unixgram", Address: path})
+		require.NoError(t, err)
+		defer w.Close()
+
+		_, err = w.Write([]byte(`{"level":"DEBUG","msg":"local"}` + "\n"))
+		require.NoError(t, err)
+
+		msg := readPacket(t, conn)
+		assert.True(t, strings.HasPrefix(msg, "<15>1 "), msg)
+		assert.True(t, strings.HasSuffix(msg, `{"level":"DEBUG","msg":"local"}`), msg)
+	})
+
+	t.Run("Reconnect", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer l.Close()
+		first := acceptFrames(t, l)
+
+		w, err := NewSyslogWriter(SyslogConfig{Network: "tcp", Address: l.Addr().String()})
+		require.NoError(t, err)
+		defer w.Close()
+
+		_, err = w.Write([]byte("before\n"))
+		require.NoError(t, err)
+		assert.True(t, strings.HasSuffix(receive(t, first), " before"))
+
+		// Drop the connection as a restarting daemon would
+		w.mutex.Lock()
+		w.conn.Close()
+		w.mutex.Unlock()
+		second := acceptFrames(t, l)
+
+		_, err = w.Write([]byte("after\n"))
+		require.NoError(t, err)
+		assert.True(t, strings.HasSuffix(receive(t, second), " after"))
+	})
+
+	t.Run("Unreachable", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := l.Addr().String()
+		l.Close()
+
+		_, err = NewSyslogWriter(SyslogConfig{Network: "tcp", Address: addr, Timeout: time.Second})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to connect to syslog daemon")
+	})
+}
@@ -0,0 +1,624 @@
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains a file writer that rotates on a time schedule and,
+// optionally, on size.
+package writer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Common rotation intervals for TimeRotatingConfig.RotationInterval.
+const (
+	// RotateHourly starts a new file at the top of every hour
+	RotateHourly = time.Hour
+	// RotateDaily starts a new file at midnight
+	RotateDaily = 24 * time.Hour
+)
+
+// TimeRotatingConfig holds configuration for file-based logging with
+// time-based rotation.
+type TimeRotatingConfig struct {
+	// FilePattern is the path of the log file, with strftime-style
+	// conversions that are replaced with the start of the current rotation
+	// period, such as "/var/log/app-%Y%m%d-%H.log". Supported conversions
+	// are %Y, %y, %m, %d, %H, %M, %S, %j, %s and %%. The directory part of
+	// the path can't contain conversions. If the directory doesn't exist,
+	// it will be created automatically with DirMode.
+	FilePattern string
+
+	// RotationInterval is the length of a rotation period. Periods are
+	// aligned to midnight, so the interval must divide 24 hours evenly,
+	// e.g. 15*time.Minute, RotateHourly or RotateDaily. Defaults to
+	// RotateDaily if neither it nor RotationSchedule is specified.
+	RotationInterval time.Duration
+
+	// RotationSchedule is a cron expression of the times rotation periods
+	// start, for boundaries that a fixed interval can't express, such as
+	// "0 0 * * 1" for weekly files starting on Monday or "0 6,18 * * *"
+	// for two files a day. Its five fields are the minute, hour, day of
+	// month, month and day of week (0 or 7 being Sunday); each is "*", a
+	// number, a range such as "1-5", or a list of those, optionally with a
+	// step such as "*/15". @hourly, @daily, @weekly, @monthly and @yearly
+	// are accepted too. It can't be combined with RotationInterval.
+	RotationSchedule string
+
+	// MaxRotatedSize is the maximum size in megabytes of a log file before
+	// it gets rotated within the current period. Files rotated because of
+	// their size get a sequence number before their extension, such as
+	// "app-20240101.1.log". Set to 0 to rotate on time only.
+	MaxRotatedSize int
+
+	// MaxRetainDay is the maximum number of days to retain old log files
+	// based on their modification time. Defaults to 7 days if not specified.
+	MaxRetainDay int
+
+	// MaxRetainFiles is the maximum number of old log files to retain.
+	// Set to 0 to disable count-based retention.
+	MaxRetainFiles int
+
+	// MaxTotalSize is the maximum size in megabytes of the active file and
+	// all rotated files together, using the compressed size of compressed
+	// files. The oldest rotated files are removed first. It can't be less
+	// than MaxRotatedSize. Set to 0 to disable size-based retention.
+	MaxTotalSize int
+
+	// MinFreeSpace is the free disk space in megabytes to leave on the
+	// volume of the log files. Writes that would leave less fail with
+	// ErrInsufficientDiskSpace rather than fill the volume. The guard is
+	// only available on Linux, macOS and FreeBSD. Set to 0 to disable it.
+	MinFreeSpace int
+
+	// DirMode is the permission bits of the directories created for
+	// FilePattern and ArchiveDir. Defaults to 0755 if not specified.
+	DirMode os.FileMode
+
+	// FileMode is the permission bits of the log files. If not specified,
+	// new files are created with 0644 and existing files keep their mode.
+	FileMode os.FileMode
+
+	// LocalTime determines if period boundaries and the times in file names
+	// use the computer's local time. Defaults to UTC time.
+	LocalTime bool
+
+	// Compress determines if the rotated log files should be compressed.
+	// Defaults to false.
+	Compress bool
+
+	// Compression is the format used when Compress is set, such as
+	// GzipCompressor(gzip.BestSpeed), ZstdCompressor(3) or XzCompressor().
+	// Its extension is appended to the names of compressed files. Defaults
+	// to gzip with the default compression level.
+	Compression Compressor
+
+	// ArchiveDir is the directory rotated files are moved to once they have
+	// been compressed, created if needed. Files are copied when it is on a
+	// different file system. Retention only applies to files that haven't
+	// been archived. Rotated files stay next to the log file if empty.
+	ArchiveDir string
+
+	// OnRotate is called from a background goroutine with the final path
+	// of each rotated file, after compression and archiving.
+	OnRotate func(path string)
+
+	// OnError is called from a background goroutine when processing rotated
+	// files fails. Failed archiving is retried on the next rotation.
+	// Errors are discarded if it is nil.
+	OnError func(err error)
+}
+
+// Validate checks if the configuration is valid and returns an error if not.
+func (c *TimeRotatingConfig) Validate() error {
+	if c.FilePattern == "" {
+		return fmt.Errorf("FilePattern cannot be empty")
+	}
+
+	if err := validateStrftime(c.FilePattern); err != nil {
+		return fmt.Errorf("invalid FilePattern: %w", err)
+	}
+
+	if strings.Contains(filepath.Dir(c.FilePattern), "%") {
+		return fmt.Errorf("FilePattern directory cannot contain conversions")
+	}
+
+	if c.RotationInterval < 0 {
+		return fmt.Errorf("RotationInterval cannot be negative")
+	}
+
+	if c.RotationInterval > 0 && (c.RotationInterval < time.Second || RotateDaily%c.RotationInterval != 0) {
+		return fmt.Errorf("RotationInterval must divide 24 hours evenly")
+	}
+
+	if c.RotationSchedule != "" {
+		if c.RotationInterval != 0 {
+			return fmt.Errorf("RotationInterval and RotationSchedule cannot both be set")
+		}
+		sched, err := parseSchedule(c.RotationSchedule)
+		if err != nil {
+			return fmt.Errorf("invalid RotationSchedule: %w", err)
+		}
+		if sched.next(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+			return fmt.Errorf("RotationSchedule never matches")
+		}
+	}
+
+	if c.MaxRotatedSize < 0 {
+		return fmt.Errorf("MaxRotatedSize cannot be negative")
+	}
+
+	if c.MaxRetainDay < 0 {
+		return fmt.Errorf("MaxRetainDay cannot be negative")
+	}
+
+	if c.MaxRetainFiles < 0 {
+		return fmt.Errorf("MaxRetainFiles cannot be negative")
+	}
+
+	if c.MaxTotalSize < 0 {
+		return fmt.Errorf("MaxTotalSize cannot be negative")
+	}
+
+	if c.MaxTotalSize > 0 && c.MaxTotalSize < c.MaxRotatedSize {
+		return fmt.Errorf("MaxTotalSize cannot be less than MaxRotatedSize")
+	}
+
+	if c.MinFreeSpace < 0 {
+		return fmt.Errorf("MinFreeSpace cannot be negative")
+	}
+
+	if err := c.Compression.validate(); err != nil {
+		return fmt.Errorf("invalid Compression: %w", err)
+	}
+
+	if c.DirMode&^os.ModePerm != 0 || c.FileMode&^os.ModePerm != 0 {
+		return fmt.Errorf("DirMode and FileMode can only contain permission bits")
+	}
+
+	return nil
+}
+
+// setDefaults sets default values for unspecified configuration fields.
+func (c *TimeRotatingConfig) setDefaults() {
+	if c.RotationInterval == 0 && c.RotationSchedule == "" {
+		c.RotationInterval = RotateDaily
+	}
+
+	if c.MaxRetainDay == 0 {
+		c.MaxRetainDay = 7 // 7 days default
+	}
+
+	if c.DirMode == 0 {
+		c.DirMode = 0755
+	}
+}
+
+// TimeRotatingWriter is an io.Writer that writes to a file named after the
+// current rotation period and switches to a new file when the period ends
+// or the file grows too large. It is safe for concurrent use.
+type TimeRotatingWriter struct {
+	conf    TimeRotatingConfig
+	backups *regexp.Regexp // Matches the base names of all files of the pattern
+	exts    []string       // Compression extensions of rotated files
+	mill    *mill
+	now     func() time.Time
+	loc     *time.Location // Location of period boundaries and file names
+	sched   *schedule      // Parsed RotationSchedule, nil if not set
+
+	freeSpace func(dir string) (uint64, error)
+
+	mutex      sync.Mutex
+	file       *os.File
+	name       string    // Path of the active file
+	size       int64     // Size of the active file
+	periodEnd  time.Time // When the active period ends
+	seq        int       // Sequence number of the active file within its period
+	periodName string    // Path of the active period's file without a sequence number
+	guard      diskGuard
+}
+
+// NewTimeRotatingWriter creates a new file writer that rotates on a time
+// schedule and, optionally, on size. Rotated files are compressed and
+// removed according to the retention settings in the background.
+//
+// Features:
+// - Fixed rotation intervals or cron-like schedules in local or UTC time
+// - Optional size-based rotation within a period
+// - strftime-style file name patterns
+// - Age-based, count-based and total size log file cleanup
+// - Free disk space guard
+// - Optional compression of rotated files
+// - Archive directory and rotation callback
+// - Configurable mode of log files and directories
+// - Thread-safe operations
+//
+// Example:
+//
+//	w, err := writer.NewTimeRotatingWriter(writer.TimeRotatingConfig{
+//	    FilePattern:      "/var/log/app-%Y%m%d-%H.log",
+//	    RotationInterval: writer.RotateHourly,
+//	    MaxRetainDay:     30,
+//	    Compress:         true,
+//	})
+//	defer w.Close()
+//	logger := tslog.NewLogger(tslog.WithWriter(w))
+func NewTimeRotatingWriter(conf TimeRotatingConfig) (*TimeRotatingWriter, error) {
+	// Validate configuration
+	if err := conf.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid time rotating config: %w", err)
+	}
+
+	// Apply defaults
+	conf.setDefaults()
+
+	exts := conf.Compression.orDefault().extensions()
+	w := &TimeRotatingWriter{
+		conf:      conf,
+		backups:   backupPattern(filepath.Base(conf.FilePattern), exts),
+		exts:      exts,
+		now:       time.Now,
+		loc:       time.UTC,
+		freeSpace: diskFree,
+	}
+	if conf.LocalTime {
+		w.loc = time.Local
+	}
+	if conf.RotationSchedule != "" {
+		w.sched, _ = parseSchedule(conf.RotationSchedule)
+	}
+	w.mill = &mill{
+		list:       w.listBackups,
+		compressor: compressorOf(conf.Compress, conf.Compression),
+		maxAge:     time.Duration(conf.MaxRetainDay) * RotateDaily,
+		maxFiles:   conf.MaxRetainFiles,
+		maxTotal:   int64(conf.MaxTotalSize) * megabyte,
+		activeSize: w.activeSize,
+		archiveDir: conf.ArchiveDir,
+		dirMode:    conf.DirMode,
+		onRotate:   conf.OnRotate,
+		onError:    conf.OnError,
+	}
+
+	return w, nil
+}
+
+// MustNewTimeRotatingWriter is like NewTimeRotatingWriter but panics if the
+// configuration is invalid.
+func MustNewTimeRotatingWriter(conf TimeRotatingConfig) *TimeRotatingWriter {
+	writer, err := NewTimeRotatingWriter(conf)
+	if err != nil {
+		panic(err)
+	}
+	return writer
+}
+
+// Write writes p to the active file, rotating first if the period has ended
+// or p doesn't fit within MaxRotatedSize. It fails with
+// ErrInsufficientDiskSpace if writing p would leave less than MinFreeSpace
+// on the volume.
+func (w *TimeRotatingWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	now := w.currentTime()
+	dir := filepath.Dir(w.conf.FilePattern)
+	if err := w.guard.check(dir, w.conf.MinFreeSpace, len(p), w.now(), w.freeSpace); err != nil {
+		return 0, err
+	}
+	switch {
+	case w.file == nil:
+		if err := w.openLocked(now); err != nil {
+			return 0, err
+		}
+	case !now.Before(w.periodEnd):
+		if err := w.rotateLocked(now, false); err != nil {
+			return 0, err
+		}
+	}
+
+	if max := w.maxSize(); max > 0 && w.size > 0 && w.size+int64(len(p)) > max {
+		if err := w.rotateLocked(now, true); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	w.guard.wrote(n)
+	return n, err
+}
+
+// Rotate closes the active file and starts a new one, even if the current
+// period hasn't ended.
+func (w *TimeRotatingWriter) Rotate() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	now := w.currentTime()
+	if w.file == nil {
+		return w.openLocked(now)
+	}
+	return w.rotateLocked(now, now.Before(w.periodEnd))
+}
+
+// Sync commits the active file to stable storage.
+func (w *TimeRotatingWriter) Sync() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	return w.file.Sync()
+}
+
+// Close closes the active file and waits for background processing of
+// rotated files to finish. Writing after Close reopens the file.
+func (w *TimeRotatingWriter) Close() error {
+	w.mutex.Lock()
+	err := w.closeLocked()
+	w.mutex.Unlock()
+
+	w.mill.close()
+	return err
+}
+
+// Filename returns the path of the file currently written to, or an empty
+// string if nothing has been written yet.
+func (w *TimeRotatingWriter) Filename() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.name
+}
+
+// currentTime returns the current time in the configured time zone.
+func (w *TimeRotatingWriter) currentTime() time.Time {
+	return w.now().In(w.loc)
+}
+
+// activeSize returns the size of the active file.
+func (w *TimeRotatingWriter) activeSize() int64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.size
+}
+
+// maxSize returns the maximum file size in bytes, or 0 if unlimited.
+func (w *TimeRotatingWriter) maxSize() int64 {
+	return int64(w.conf.MaxRotatedSize) * megabyte
+}
+
+// period returns the start and the end of the rotation period containing t.
+// Periods are counted in wall clock time from midnight, so that they stay
+// aligned with the clock on days with a daylight saving time change: such a
+// day's period spans 23 or 25 hours with daily rotation, and with hourly
+// rotation the repeated hour of a day goes to a single file. With a
+// RotationSchedule, periods run from one matching time to the next.
+func (w *TimeRotatingWriter) period(t time.Time) (start, end time.Time) {
+	if w.sched != nil {
+		start, end = w.sched.prev(t), w.sched.next(t)
+		if start.IsZero() {
+			start = t // No match within the search range
+		}
+		if end.IsZero() {
+			end = t.AddDate(scheduleSearchYears, 0, 0)
+		}
+		return start, end
+	}
+
+	y, m, d := t.Date()
+	wall := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+	wall -= wall % w.conf.RotationInterval
+
+	start = time.Date(y, m, d, 0, 0, int(wall/time.Second), 0, t.Location())
+	if next := wall + w.conf.RotationInterval; next < RotateDaily {
+		end = time.Date(y, m, d, 0, 0, int(next/time.Second), 0, t.Location())
+	} else {
+		end = time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+	}
+	if !end.After(t) {
+		// The next boundary falls into a skipped hour before t
+		end = t.Add(w.conf.RotationInterval)
+	}
+	return start, end
+}
+
+// openLocked opens the file of the period containing now, appending to the
+// file with the highest sequence number if the period already has files.
+// If that file has been compressed or archived, the next sequence number
+// is used instead.
+func (w *TimeRotatingWriter) openLocked(now time.Time) error {
+	start, end := w.period(now)
+	base := strftime(w.conf.FilePattern, start)
+
+	seq := 0
+	for w.seqTaken(base, seq+1) {
+		seq++
+	}
+	if !fileExists(seqName(base, seq)) && w.seqTaken(base, seq) {
+		seq++
+	}
+
+	return w.openFileLocked(end, base, seq)
+}
+
+// rotateLocked closes the active file and opens the next one. If samePeriod
+// is true the next file belongs to the active period.
+func (w *TimeRotatingWriter) rotateLocked(now time.Time, samePeriod bool) error {
+	rotated := w.name
+	if err := w.closeLocked(); err != nil {
+		return err
+	}
+	defer w.mill.trigger(rotated)
+
+	if samePeriod {
+		seq := w.seq + 1
+		for w.seqTaken(w.periodName, seq) {
+			seq++
+		}
+		return w.openFileLocked(w.periodEnd, w.periodName, seq)
+	}
+
+	start, end := w.period(now)
+	base := strftime(w.conf.FilePattern, start)
+	seq := 0
+	for w.seqTaken(base, seq) {
+		seq++
+	}
+	return w.openFileLocked(end, base, seq)
+}
+
+// seqTaken reports whether sequence number seq of the period whose
+// unnumbered path is base is in use, by a log file or by its compressed or
+// archived form. Reusing it would overwrite the older file once the new one
+// is compressed or archived.
+func (w *TimeRotatingWriter) seqTaken(base string, seq int) bool {
+	name := seqName(base, seq)
+	var archived string
+	if w.conf.ArchiveDir != "" {
+		archived = filepath.Join(w.conf.ArchiveDir, filepath.Base(name))
+	}
+
+	for _, ext := range append([]string{""}, w.exts...) {
+		if fileExists(name + ext) {
+			return true
+		}
+		if archived != "" && fileExists(archived+ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// openFileLocked opens the file with sequence number seq of the period
+// ending at end, whose unnumbered path is base.
+func (w *TimeRotatingWriter) openFileLocked(end time.Time, base string, seq int) error {
+	name := seqName(base, seq)
+	if err := os.MkdirAll(filepath.Dir(name), w.conf.DirMode); err != nil {
+		return fmt.Errorf("can't make directories for new logfile: %w", err)
+	}
+
+	mode := w.conf.FileMode
+	if mode == 0 {
+		mode = 0644
+	}
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, mode)
+	if err != nil {
+		return fmt.Errorf("can't open new logfile: %w", err)
+	}
+	if w.conf.FileMode != 0 {
+		if err := f.Chmod(w.conf.FileMode); err != nil {
+			f.Close()
+			return fmt.Errorf("can't set mode of new logfile: %w", err)
+		}
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("can't stat new logfile: %w", err)
+	}
+
+	w.file = f
+	w.name = name
+	w.size = fi.Size()
+	w.seq = seq
+	w.periodName = base
+	w.periodEnd = end
+	return nil
+}
+
+// closeLocked closes the active file, if any.
+func (w *TimeRotatingWriter) closeLocked() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// listBackups returns the files of the pattern other than the active one.
+func (w *TimeRotatingWriter) listBackups() ([]backupFile, error) {
+	w.mutex.Lock()
+	active := w.name
+	w.mutex.Unlock()
+
+	return listBackupFiles(filepath.Dir(w.conf.FilePattern), func(name string) bool {
+		return w.backups.MatchString(name)
+	}, active)
+}
+
+// megabyte is the number of bytes in a megabyte.
+const megabyte = 1024 * 1024
+
+// backupPattern returns a regular expression matching the base names of all
+// files created for the base name pattern, including sequence numbers and
+// the compression extensions exts.
+func backupPattern(pattern string, exts []string) *regexp.Regexp {
+	stem, ext := splitExt(pattern)
+	quoted := make([]string, len(exts))
+	for i, e := range exts {
+		quoted[i] = regexp.QuoteMeta(e)
+	}
+	return regexp.MustCompile("^" + strftimeRegexp(stem) + `(?:\.\d+)?` +
+		regexp.QuoteMeta(ext) + "(?:" + strings.Join(quoted, "|") + ")?$")
+}
+
+// seqName inserts sequence number seq before the extension of name.
+// Sequence number 0 leaves name unchanged.
+func seqName(name string, seq int) string {
+	if seq == 0 {
+		return name
+	}
+	stem, ext := splitExt(name)
+	return stem + "." + strconv.Itoa(seq) + ext
+}
+
+// splitExt splits name into its stem and extension. The extension can't
+// contain strftime conversions.
+func splitExt(name string) (stem, ext string) {
+	ext = filepath.Ext(name)
+	if strings.Contains(ext, "%") {
+		return name, ""
+	}
+	return name[:len(name)-len(ext)], ext
+}
+
+// fileExists reports whether a file exists at path.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// listBackupFiles returns the regular files in dir whose base name matches,
+// excluding the file at path active.
+func listBackupFiles(dir string, match func(name string) bool, active string) ([]backupFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("can't read log file directory: %w", err)
+	}
+
+	active = filepath.Clean(active)
+	var files []backupFile
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !match(e.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if path == active {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, backupFile{path: path, modTime: fi.ModTime(), size: fi.Size()})
+	}
+	return files, nil
+}
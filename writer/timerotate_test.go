@@ -0,0 +1,772 @@
+package writer
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	_ "time/tzdata" // America/New_York for the daylight saving time tests
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
+)
+
+// TestStrftime tests strftime-style formatting
+func TestStrftime(t *testing.T) {
+	ts := time.Date(2024, 3, 7, 9, 5, 2, 0, time.UTC)
+
+	tests := []struct {
+		pattern  string
+		expected string
+	}{
+		{"app.log", "app.log"},
+		{"app-%Y%m%d.log", "app-20240307.log"},
+		{"app-%Y%m%d-%H.log", "app-20240307-09.log"},
+		{"%y/%j %H:%M:%S", "24/067 09:05:02"},
+		{"%s", "1709802302"},
+		{"100%%", "100%"},
+		{"%Q", "%Q"},
+		{"trailing%", "trailing%"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			assert.Equal(t, tt.expected, strftime(tt.pattern, ts))
+		})
+	}
+
+	assert.NoError(t, validateStrftime("app-%Y%m%d-%H%M%S.log"))
+	assert.Error(t, validateStrftime("app-%Q.log"))
+	assert.Error(t, validateStrftime("app-%"))
+}
+
+// TestSchedule tests parsing and matching of cron-like rotation schedules
+func TestSchedule(t *testing.T) {
+	t.Run("Parse", func(t *testing.T) {
+		s, err := parseSchedule("*/15 9-17 * * 1-5")
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1|1<<15|1<<30|1<<45), s.minute)
+		assert.Equal(t, uint64(0x3fe00), s.hour)
+		assert.Equal(t, uint64(0x3e), s.dow)
+
+		s, err = parseSchedule("@weekly")
+		require.NoError(t, err)
+		assert.Equal(t, uint64(1), s.dow)
+
+		s, err = parseSchedule("0 0 * * 7")
+		require.NoError(t, err)
+		assert.NotZero(t, s.dow&1, "7 is Sunday")
+
+		for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *",
+			"* * * 13 *", "* * * * 8", "5-1 * * * *", "*/0 * * * *", "a * * * *", "1-x * * * *"} {
+			_, err := parseSchedule(spec)
+			assert.Error(t, err, spec)
+		}
+	})
+
+	t.Run("NextPrev", func(t *testing.T) {
+		ny, err := time.LoadLocation("America/New_York")
+		require.NoError(t, err)
+
+		tests := []struct {
+			name       string
+			spec       string
+			t          time.Time
+			prev, next time.Time
+		}{
+			{
+				"Weekly", "0 0 * * 1", time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC),
+				time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC),
+			},
+			{
+				"OnBoundary", "0 6,18 * * *", time.Date(2024, 1, 1, 18, 0, 0, 0, time.UTC),
+				time.Date(2024, 1, 1, 18, 0, 0, 0, time.UTC), time.Date(2024, 1, 2, 6, 0, 0, 0, time.UTC),
+			},
+			{
+				"Monthly", "@monthly", time.Date(2024, 2, 29, 23, 59, 0, 0, time.UTC),
+				time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+			},
+			{
+				"LeapDay", "0 0 29 2 *", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+			},
+			{
+				// Either day field matches when both are restricted
+				"DayOr", "0 0 15 * 1", time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC),
+				time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+			},
+			{
+				// 02:30 doesn't exist on Mar 10, 2024
+				"SkippedTime", "30 2 * * *", time.Date(2024, 3, 10, 12, 0, 0, 0, ny),
+				time.Date(2024, 3, 9, 2, 30, 0, 0, ny), time.Date(2024, 3, 11, 2, 30, 0, 0, ny),
+			},
+			{
+				// 01:30 happens twice on Nov 3, 2024 and only the first matches
+				"RepeatedTime", "30 1 * * *", time.Date(2024, 11, 3, 1, 30, 0, 0, ny),
+				time.Date(2024, 11, 3, 1, 30, 0, 0, ny), time.Date(2024, 11, 4, 1, 30, 0, 0, ny),
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				s, err := parseSchedule(tt.spec)
+				require.NoError(t, err)
+				assert.True(t, tt.prev.Equal(s.prev(tt.t)), "prev: %v", s.prev(tt.t))
+				assert.True(t, tt.next.Equal(s.next(tt.t)), "next: %v", s.next(tt.t))
+			})
+		}
+
+		s, err := parseSchedule("0 0 31 2 *")
+		require.NoError(t, err)
+		assert.True(t, s.next(time.Now()).IsZero())
+		assert.True(t, s.prev(time.Now()).IsZero())
+	})
+}
+
+// TestTimeRotatingConfig tests validation and defaults
+func TestTimeRotatingConfig(t *testing.T) {
+	t.Run("Defaults", func(t *testing.T) {
+		config := TimeRotatingConfig{FilePattern: "/tmp/app-%Y%m%d.log"}
+		require.NoError(t, config.Validate())
+		config.setDefaults()
+
+		assert.Equal(t, RotateDaily, config.RotationInterval)
+		assert.Equal(t, 7, config.MaxRetainDay)
+		assert.Equal(t, 0, config.MaxRetainFiles)
+		assert.Equal(t, os.FileMode(0755), config.DirMode)
+		assert.Equal(t, os.FileMode(0), config.FileMode)
+
+		config = TimeRotatingConfig{FilePattern: "/tmp/app-%Y%m%d.log", RotationSchedule: "@weekly"}
+		require.NoError(t, config.Validate())
+		config.setDefaults()
+		assert.Equal(t, time.Duration(0), config.RotationInterval)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			config TimeRotatingConfig
+			errMsg string
+		}{
+			{"EmptyPattern", TimeRotatingConfig{}, "FilePattern cannot be empty"},
+			{"BadConversion", TimeRotatingConfig{FilePattern: "app-%Q.log"}, "unsupported conversion"},
+			{"DirConversion", TimeRotatingConfig{FilePattern: "/var/log/%Y/app.log"}, "directory cannot contain conversions"},
+			{"NegativeInterval", TimeRotatingConfig{FilePattern: "a.log", RotationInterval: -1}, "RotationInterval cannot be negative"},
+			{"UnevenInterval", TimeRotatingConfig{FilePattern: "a.log", RotationInterval: 7 * time.Hour}, "must divide 24 hours"},
+			{"NegativeSize", TimeRotatingConfig{FilePattern: "a.log", MaxRotatedSize: -1}, "MaxRotatedSize cannot be negative"},
+			{"NegativeDays", TimeRotatingConfig{FilePattern: "a.log", MaxRetainDay: -1}, "MaxRetainDay cannot be negative"},
+			{"NegativeFiles", TimeRotatingConfig{FilePattern: "a.log", MaxRetainFiles: -1}, "MaxRetainFiles cannot be negative"},
+			{"NegativeTotal", TimeRotatingConfig{FilePattern: "a.log", MaxTotalSize: -1}, "MaxTotalSize cannot be negative"},
+			{"TotalBelowSize", TimeRotatingConfig{FilePattern: "a.log", MaxRotatedSize: 10, MaxTotalSize: 5}, "MaxTotalSize cannot be less than MaxRotatedSize"},
+			{"NegativeFreeSpace", TimeRotatingConfig{FilePattern: "a.log", MinFreeSpace: -1}, "MinFreeSpace cannot be negative"},
+			{"BadFileMode", TimeRotatingConfig{FilePattern: "a.log", FileMode: os.ModeDir | 0644}, "only contain permission bits"},
+			{"BadSchedule", TimeRotatingConfig{FilePattern: "a.log", RotationSchedule: "0 0 * *"}, "invalid RotationSchedule"},
+			{"ScheduleNeverMatches", TimeRotatingConfig{FilePattern: "a.log", RotationSchedule: "0 0 30 2 *"}, "RotationSchedule never matches"},
+			{"ScheduleAndInterval", TimeRotatingConfig{FilePattern: "a.log", RotationSchedule: "@daily", RotationInterval: RotateHourly}, "cannot both be set"},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := tt.config.Validate()
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.errMsg)
+
+				w, err := NewTimeRotatingWriter(tt.config)
+				assert.Nil(t, w)
+				assert.Contains(t, err.Error(), "invalid time rotating config")
+			})
+		}
+	})
+}
+
+// clock is a settable time source for tests
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time { return c.t }
+
+// newTestTimeWriter creates a writer with a fake clock in a temp dir
+func newTestTimeWriter(t *testing.T, conf TimeRotatingConfig) (*TimeRotatingWriter, *clock, string) {
+	dir := t.TempDir()
+	conf.FilePattern = filepath.Join(dir, conf.FilePattern)
+
+	w, err := NewTimeRotatingWriter(conf)
+	require.NoError(t, err)
+
+	c := &clock{t: time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC)}
+	w.now = c.now
+	t.Cleanup(func() { w.Close() })
+	return w, c, dir
+}
+
+// logFiles returns the sorted base names of the files in dir
+func logFiles(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readFile returns the content of a possibly gzip, zstd or xz compressed
+// file
+func readFile(t *testing.T, path string) string {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var r io.Reader = f
+	switch filepath.Ext(path) {
+	case ".gz":
+		gz, err := gzip.NewReader(f)
+		require.NoError(t, err)
+		r = gz
+	case ".zst":
+		dec, err := zstd.NewReader(f)
+		require.NoError(t, err)
+		defer dec.Close()
+		r = dec
+	case ".xz":
+		xr, err := xz.NewReader(f)
+		require.NoError(t, err)
+		r = xr
+	}
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(data)
+}
+
+// TestTimeRotatingWriter tests time and size based rotation
+func TestTimeRotatingWriter(t *testing.T) {
+	t.Run("Hourly", func(t *testing.T) {
+		w, c, dir := newTestTimeWriter(t, TimeRotatingConfig{
+			FilePattern:      "app-%Y%m%d-%H.log",
+			RotationInterval: RotateHourly,
+		})
+
+		_, err := w.Write([]byte("first\n"))
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(dir, "app-20240101-10.log"), w.Filename())
+
+		c.t = c.t.Add(20 * time.Minute)
+		_, err = w.Write([]byte("second\n"))
+		require.NoError(t, err)
+
+		c.t = c.t.Add(time.Hour)
+		_, err = w.Write([]byte("third\n"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, []string{"app-20240101-10.log", "app-20240101-11.log"}, logFiles(t, dir))
+		assert.Equal(t, "first\nsecond\n", readFile(t, filepath.Join(dir, "app-20240101-10.log")))
+		assert.Equal(t, "third\n", readFile(t, filepath.Join(dir, "app-20240101-11.log")))
+	})
+
+	t.Run("CustomBoundary", func(t *testing.T) {
+		w, c, dir := newTestTimeWriter(t, TimeRotatingConfig{
+			FilePattern:      "app-%H%M.log",
+			RotationInterval: 15 * time.Minute,
+		})
+
+		c.t = time.Date(2024, 1, 1, 10, 44, 59, 0, time.UTC)
+		_, _ = w.Write([]byte("a\n"))
+		c.t = time.Date(2024, 1, 1, 10, 45, 0, 0, time.UTC)
+		_, _ = w.Write([]byte("b\n"))
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, []string{"app-1030.log", "app-1045.log"}, logFiles(t, dir))
+	})
+
+	t.Run("LocalTime", func(t *testing.T) {
+		w, c, _ := newTestTimeWriter(t, TimeRotatingConfig{
+			FilePattern: "app-%Y%m%d-%H.log",
+			LocalTime:   true,
+		})
+
+		_, _ = w.Write([]byte("a\n"))
+		local := c.t.Local()
+		expected := "app-" + local.Format("20060102") + "-00.log"
+		assert.Equal(t, expected, filepath.Base(w.Filename()))
+	})
+
+	t.Run("DaylightSaving", func(t *testing.T) {
+		ny, err := time.LoadLocation("America/New_York")
+		require.NoError(t, err)
+
+		tests := []struct {
+			name     string
+			pattern  string
+			interval time.Duration
+			writes   []time.Time
+			files    []string
+		}{
+			{
+				// The day after the clocks moved forward starts at midnight
+				name:     "SpringDaily",
+				pattern:  "app-%Y%m%d.log",
+				interval: RotateDaily,
+				writes: []time.Time{
+					time.Date(2024, 3, 10, 0, 0, 0, 0, ny),
+					time.Date(2024, 3, 10, 23, 59, 0, 0, ny),
+					time.Date(2024, 3, 11, 0, 30, 0, 0, ny),
+				},
+				files: []string{"app-20240310.log", "app-20240311.log"},
+			},
+			{
+				// The 25 hour day doesn't spill into the next one
+				name:     "AutumnDaily",
+				pattern:  "app-%Y%m%d.log",
+				interval: RotateDaily,
+				writes: []time.Time{
+					time.Date(2024, 11, 3, 0, 0, 0, 0, ny),
+					time.Date(2024, 11, 3, 23, 30, 0, 0, ny),
+					time.Date(2024, 11, 4, 0, 30, 0, 0, ny),
+					time.Date(2024, 11, 4, 23, 30, 0, 0, ny),
+				},
+				files: []string{"app-20241103.log", "app-20241104.log"},
+			},
+			{
+				// The hour skipped in spring has no file
+				name:     "SpringHourly",
+				pattern:  "app-%H.log",
+				interval: RotateHourly,
+				writes: []time.Time{
+					time.Date(2024, 3, 10, 1, 30, 0, 0, ny),
+					time.Date(2024, 3, 10, 1, 30, 0, 0, ny).Add(time.Hour),
+					time.Date(2024, 3, 10, 4, 0, 0, 0, ny),
+				},
+				files: []string{"app-01.log", "app-03.log", "app-04.log"},
+			},
+			{
+				// The hour repeated in autumn goes to a single file
+				name:     "AutumnHourly",
+				pattern:  "app-%H.log",
+				interval: RotateHourly,
+				writes: []time.Time{
+					time.Date(2024, 11, 3, 1, 30, 0, 0, ny),
+					time.Date(2024, 11, 3, 1, 30, 0, 0, ny).Add(time.Hour),
+					time.Date(2024, 11, 3, 2, 30, 0, 0, ny),
+				},
+				files: []string{"app-01.log", "app-02.log"},
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				w, c, dir := newTestTimeWriter(t, TimeRotatingConfig{
+					FilePattern:      tt.pattern,
+					RotationInterval: tt.interval,
+					LocalTime:        true,
+				})
+				w.loc = ny
+
+				for _, ts := range tt.writes {
+					c.t = ts
+					_, err := w.Write([]byte(ts.Format(time.RFC3339) + "\n"))
+					require.NoError(t, err)
+				}
+				require.NoError(t, w.Close())
+				assert.Equal(t, tt.files, logFiles(t, dir))
+			})
+		}
+
+		// Entries land in the file of their wall clock day
+		w, c, dir := newTestTimeWriter(t, TimeRotatingConfig{FilePattern: "app-%Y%m%d.log", LocalTime: true})
+		w.loc = ny
+		for _, ts := range []time.Time{
+			time.Date(2024, 3, 10, 12, 0, 0, 0, ny),
+			time.Date(2024, 3, 11, 0, 30, 0, 0, ny),
+		} {
+			c.t = ts
+			_, err := w.Write([]byte(ts.Format(time.RFC3339) + "\n"))
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.Close())
+		assert.Equal(t, "2024-03-11T00:30:00-04:00\n", readFile(t, filepath.Join(dir, "app-20240311.log")))
+	})
+
+	t.Run("Schedule", func(t *testing.T) {
+		w, c, dir := newTestTimeWriter(t, TimeRotatingConfig{
+			FilePattern:      "app-%Y%m%d.log",
+			RotationSchedule: "0 0 * * 1", // Weekly from Monday
+		})
+
+		// Jan 1, 2024 is a Monday
+		_, _ = w.Write([]byte("monday\n"))
+		c.t = time.Date(2024, 1, 7, 23, 59, 0, 0, time.UTC)
+		_, _ = w.Write([]byte("sunday\n"))
+		c.t = time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)
+		_, _ = w.Write([]byte("next monday\n"))
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, []string{"app-20240101.log", "app-20240108.log"}, logFiles(t, dir))
+		assert.Equal(t, "monday\nsunday\n", readFile(t, filepath.Join(dir, "app-20240101.log")))
+		assert.Equal(t, "next monday\n", readFile(t, filepath.Join(dir, "app-20240108.log")))
+	})
+
+	t.Run("ScheduleMidPeriodStart", func(t *testing.T) {
+		// A writer started mid-week names the file after the week's start
+		w, c, dir := newTestTimeWriter(t, TimeRotatingConfig{
+			FilePattern:      "app-%Y%m%d.log",
+			RotationSchedule: "@weekly",
+		})
+		c.t = time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC)
+		_, _ = w.Write([]byte("a\n"))
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, []string{"app-20231231.log"}, logFiles(t, dir))
+	})
+
+	t.Run("Size", func(t *testing.T) {
+		w, _, dir := newTestTimeWriter(t, TimeRotatingConfig{
+			FilePattern:    "app-%Y%m%d.log",
+			MaxRotatedSize: 1,
+		})
+
+		line := []byte(strings.Repeat("x", 600*1024) + "\n")
+		for i := 0; i < 3; i++ {
+			_, err := w.Write(line)
+			require.NoError(t, err)
+		}
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, []string{"app-20240101.1.log", "app-20240101.2.log", "app-20240101.log"}, logFiles(t, dir))
+	})
+
+	t.Run("ResumeExistingPeriod", func(t *testing.T) {
+		w, _, dir := newTestTimeWriter(t, TimeRotatingConfig{FilePattern: "app-%Y%m%d.log"})
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "app-20240101.log"), []byte("old\n"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "app-20240101.1.log"), []byte("older\n"), 0644))
+
+		_, _ = w.Write([]byte("new\n"))
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, "older\nnew\n", readFile(t, filepath.Join(dir, "app-20240101.1.log")))
+	})
+
+	t.Run("RestartWithCompression", func(t *testing.T) {
+		w, _, dir := newTestTimeWriter(t, TimeRotatingConfig{FilePattern: "app-%Y%m%d.log", Compress: true})
+		for _, name := range []string{"app-20240101.log.gz", "app-20240101.1.log.gz"} {
+			require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("archive"), 0644))
+		}
+
+		// The compressed files keep their sequence numbers
+		_, _ = w.Write([]byte("new\n"))
+		assert.Equal(t, filepath.Join(dir, "app-20240101.2.log"), w.Filename())
+		require.NoError(t, w.Rotate())
+		assert.Equal(t, filepath.Join(dir, "app-20240101.3.log"), w.Filename())
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, []string{
+			"app-20240101.1.log.gz",
+			"app-20240101.2.log.gz",
+			"app-20240101.3.log",
+			"app-20240101.log.gz",
+		}, logFiles(t, dir))
+		archive, err := os.ReadFile(filepath.Join(dir, "app-20240101.1.log.gz"))
+		require.NoError(t, err)
+		assert.Equal(t, "archive", string(archive))
+		assert.Equal(t, "new\n", readFile(t, filepath.Join(dir, "app-20240101.2.log.gz")))
+	})
+
+	t.Run("RotateAfterCompression", func(t *testing.T) {
+		w, c, dir := newTestTimeWriter(t, TimeRotatingConfig{
+			FilePattern:      "app.log",
+			RotationInterval: RotateHourly,
+			Compress:         true,
+		})
+
+		// The pattern names every period's file the same, so the third
+		// period must not reuse the compressed name of the first
+		for _, line := range []string{"a\n", "b\n", "c\n"} {
+			_, err := w.Write([]byte(line))
+			require.NoError(t, err)
+			c.t = c.t.Add(time.Hour)
+			w.mill.close()
+		}
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, []string{"app.1.log.gz", "app.2.log", "app.log.gz"}, logFiles(t, dir))
+		assert.Equal(t, "a\n", readFile(t, filepath.Join(dir, "app.log.gz")))
+		assert.Equal(t, "b\n", readFile(t, filepath.Join(dir, "app.1.log.gz")))
+	})
+
+	t.Run("ManualRotate", func(t *testing.T) {
+		w, _, dir := newTestTimeWriter(t, TimeRotatingConfig{FilePattern: "app-%Y%m%d.log"})
+
+		_, _ = w.Write([]byte("a\n"))
+		require.NoError(t, w.Rotate())
+		_, _ = w.Write([]byte("b\n"))
+		require.NoError(t, w.Sync())
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, []string{"app-20240101.1.log", "app-20240101.log"}, logFiles(t, dir))
+		assert.Equal(t, "b\n", readFile(t, filepath.Join(dir, "app-20240101.1.log")))
+	})
+
+	t.Run("Modes", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "logs")
+		w, err := NewTimeRotatingWriter(TimeRotatingConfig{
+			FilePattern: filepath.Join(dir, "app-%Y%m%d.log"),
+			FileMode:    0600,
+			DirMode:     0700,
+		})
+		require.NoError(t, err)
+		defer w.Close()
+
+		_, err = w.Write([]byte("a\n"))
+		require.NoError(t, err)
+
+		fi, err := os.Stat(w.Filename())
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+		di, err := os.Stat(dir)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0700), di.Mode().Perm())
+	})
+
+	t.Run("CreatesDirectory", func(t *testing.T) {
+		w, _, dir := newTestTimeWriter(t, TimeRotatingConfig{FilePattern: "nested/logs/app-%Y%m%d.log"})
+		_, err := w.Write([]byte("a\n"))
+		require.NoError(t, err)
+		assert.FileExists(t, filepath.Join(dir, "nested", "logs", "app-20240101.log"))
+	})
+}
+
+// TestTimeRotatingWriterRetention tests compression and cleanup of rotated files
+func TestTimeRotatingWriterRetention(t *testing.T) {
+	t.Run("CompressAndCount", func(t *testing.T) {
+		w, c, dir := newTestTimeWriter(t, TimeRotatingConfig{
+			FilePattern:    "app-%Y%m%d.log",
+			MaxRetainFiles: 2,
+			Compress:       true,
+		})
+
+		for day := 0; day < 4; day++ {
+			_, err := w.Write([]byte("day " + string(rune('0'+day)) + "\n"))
+			require.NoError(t, err)
+			// Order modification times like the fake clock, within the retention age
+			mtime := time.Now().Add(time.Duration(day-4) * time.Hour)
+			require.NoError(t, os.Chtimes(w.Filename(), mtime, mtime))
+			c.t = c.t.Add(RotateDaily)
+			// Let each run of the mill complete before the next rotation
+			w.mill.close()
+		}
+		_, _ = w.Write([]byte("today\n"))
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, []string{
+			"app-20240103.log.gz",
+			"app-20240104.log.gz",
+			"app-20240105.log",
+		}, logFiles(t, dir))
+		assert.Equal(t, "day 3\n", readFile(t, filepath.Join(dir, "app-20240104.log.gz")))
+	})
+
+	t.Run("Age", func(t *testing.T) {
+		w, _, dir := newTestTimeWriter(t, TimeRotatingConfig{
+			FilePattern:  "app-%Y%m%d.log",
+			MaxRetainDay: 1,
+		})
+
+		old := filepath.Join(dir, "app-20231201.log")
+		recent := filepath.Join(dir, "app-20231231.log")
+		unrelated := filepath.Join(dir, "other.log")
+		for _, p := range []string{old, recent, unrelated} {
+			require.NoError(t, os.WriteFile(p, []byte("x\n"), 0644))
+		}
+		require.NoError(t, os.Chtimes(old, time.Now().Add(-48*time.Hour), time.Now().Add(-48*time.Hour)))
+
+		_, _ = w.Write([]byte("a\n"))
+		require.NoError(t, w.Rotate())
+		require.NoError(t, w.Close())
+
+		assert.NoFileExists(t, old)
+		assert.FileExists(t, recent)
+		assert.FileExists(t, unrelated)
+	})
+}
+
+// TestTimeRotatingWriterDiskUsage tests retention by total size and the free
+// disk space guard
+func TestTimeRotatingWriterDiskUsage(t *testing.T) {
+	t.Run("MaxTotalSize", func(t *testing.T) {
+		w, c, dir := newTestTimeWriter(t, TimeRotatingConfig{
+			FilePattern:  "app-%Y%m%d.log",
+			MaxTotalSize: 1,
+		})
+
+		chunk := []byte(strings.Repeat("x", 300*1024) + "\n")
+		for day := 0; day < 4; day++ {
+			_, err := w.Write(chunk)
+			require.NoError(t, err)
+			mtime := time.Now().Add(time.Duration(day-4) * time.Hour)
+			require.NoError(t, os.Chtimes(w.Filename(), mtime, mtime))
+			c.t = c.t.Add(RotateDaily)
+			w.mill.close()
+		}
+		_, _ = w.Write(chunk)
+		require.NoError(t, w.Close())
+
+		// The active file and the two newest backups fit within 1 MB
+		assert.Equal(t, []string{
+			"app-20240103.log",
+			"app-20240104.log",
+			"app-20240105.log",
+		}, logFiles(t, dir))
+	})
+
+	t.Run("MinFreeSpace", func(t *testing.T) {
+		w, c, _ := newTestTimeWriter(t, TimeRotatingConfig{
+			FilePattern:  "app-%Y%m%d.log",
+			MinFreeSpace: 1,
+		})
+
+		free, checks := uint64(2*megabyte), 0
+		w.freeSpace = func(string) (uint64, error) {
+			checks++
+			return free, nil
+		}
+
+		line := []byte(strings.Repeat("x", 600*1024))
+		_, err := w.Write(line)
+		require.NoError(t, err)
+
+		// The estimate accounts for the previous write until the next check
+		_, err = w.Write(line)
+		assert.ErrorIs(t, err, ErrInsufficientDiskSpace)
+		assert.Contains(t, err.Error(), "keeping at least 1 MB")
+		assert.Equal(t, 1, checks)
+
+		free = 10 * megabyte
+		c.t = c.t.Add(diskCheckInterval)
+		_, err = w.Write(line)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, checks)
+	})
+}
+
+// rotationRecorder collects the paths and errors reported by the mill
+type rotationRecorder struct {
+	mutex  sync.Mutex
+	paths  []string
+	errors []error
+}
+
+func (r *rotationRecorder) onRotate(path string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.paths = append(r.paths, path)
+}
+
+func (r *rotationRecorder) onError(err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.errors = append(r.errors, err)
+}
+
+// TestTimeRotatingWriterArchive tests archiving and reporting rotated files
+func TestTimeRotatingWriterArchive(t *testing.T) {
+	t.Run("ArchiveAndNotify", func(t *testing.T) {
+		archive := filepath.Join(t.TempDir(), "archive")
+		rec := &rotationRecorder{}
+		w, c, dir := newTestTimeWriter(t, TimeRotatingConfig{
+			FilePattern: "app-%Y%m%d.log",
+			Compress:    true,
+			ArchiveDir:  archive,
+			OnRotate:    rec.onRotate,
+			OnError:     rec.onError,
+		})
+
+		_, _ = w.Write([]byte("day 1\n"))
+		c.t = c.t.Add(RotateDaily)
+		_, _ = w.Write([]byte("day 2\n"))
+		require.NoError(t, w.Close())
+
+		archived := filepath.Join(archive, "app-20240101.log.gz")
+		assert.Equal(t, []string{archived}, rec.paths)
+		assert.Empty(t, rec.errors)
+		assert.Equal(t, "day 1\n", readFile(t, archived))
+		assert.Equal(t, []string{"app-20240102.log"}, logFiles(t, dir))
+	})
+
+	t.Run("NotifyWithoutArchive", func(t *testing.T) {
+		rec := &rotationRecorder{}
+		w, _, dir := newTestTimeWriter(t, TimeRotatingConfig{
+			FilePattern: "app-%Y%m%d.log",
+			OnRotate:    rec.onRotate,
+		})
+
+		_, _ = w.Write([]byte("a\n"))
+		require.NoError(t, w.Rotate())
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, []string{filepath.Join(dir, "app-20240101.log")}, rec.paths)
+	})
+
+	t.Run("RetryOnNextRotation", func(t *testing.T) {
+		// A file where the archive directory should be makes archiving fail
+		archive := filepath.Join(t.TempDir(), "archive")
+		require.NoError(t, os.WriteFile(archive, nil, 0644))
+
+		rec := &rotationRecorder{}
+		w, c, dir := newTestTimeWriter(t, TimeRotatingConfig{
+			FilePattern: "app-%Y%m%d.log",
+			ArchiveDir:  archive,
+			OnRotate:    rec.onRotate,
+			OnError:     rec.onError,
+		})
+
+		_, _ = w.Write([]byte("day 1\n"))
+		c.t = c.t.Add(RotateDaily)
+		_, _ = w.Write([]byte("day 2\n"))
+		w.mill.close()
+
+		require.Len(t, rec.errors, 1)
+		assert.Contains(t, rec.errors[0].Error(), "failed to create archive directory")
+		assert.Empty(t, rec.paths)
+		assert.Equal(t, []string{"app-20240101.log", "app-20240102.log"}, logFiles(t, dir))
+
+		require.NoError(t, os.Remove(archive))
+		c.t = c.t.Add(RotateDaily)
+		_, _ = w.Write([]byte("day 3\n"))
+		require.NoError(t, w.Close())
+
+		assert.Equal(t, []string{
+			filepath.Join(archive, "app-20240101.log"),
+			filepath.Join(archive, "app-20240102.log"),
+		}, rec.paths)
+		assert.Len(t, rec.errors, 1)
+		assert.Equal(t, []string{"app-20240103.log"}, logFiles(t, dir))
+	})
+}
+
+// TestCopyFile tests the fallback used to archive across file systems
+func TestCopyFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.log")
+	dst := filepath.Join(dir, "dst.log")
+	require.NoError(t, os.WriteFile(src, []byte("content\n"), 0640))
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	require.NoError(t, os.Chtimes(src, mtime, mtime))
+
+	require.NoError(t, copyFile(src, dst))
+
+	assert.Equal(t, "content\n", readFile(t, dst))
+	fi, err := os.Stat(dst)
+	require.NoError(t, err)
+	assert.True(t, fi.ModTime().Equal(mtime))
+	assert.NoFileExists(t, dst+".tmp")
+}
@@ -0,0 +1,577 @@
+// Package writer provides various io.Writer implementations for logging output.
+// This file contains a writer posting alerts for severe entries to a webhook,
+// with grouping and throttling.
+package writer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// DefaultWebhookTemplate is the default payload of webhook alerts, a JSON
+// object with a "text" field as understood by Slack and Mattermost
+// incoming webhooks.
+const DefaultWebhookTemplate = `{"text":{{if eq .Count 0}}{{.Message | json}}` +
+	`{{else if gt .Count 1}}{{printf "[%s] %s (%d times)" .Level .Message .Count | json}}` +
+	`{{else}}{{printf "[%s] %s" .Level .Message | json}}{{end}}}`
+
+// ErrWebhookWriterClosed is returned when writing to a closed WebhookWriter.
+var ErrWebhookWriterClosed = errors.New("writer: webhook writer is closed")
+
+// WebhookConfig holds configuration for posting alerts to a webhook.
+type WebhookConfig struct {
+	// URL is the URL alerts are posted to.
+	URL string
+
+	// MinLevel is the lowest level of the entries that raise alerts.
+	// Defaults to LevelError if not specified.
+	MinLevel string
+
+	// Template renders the payload of an alert from a WebhookAlert. It is a
+	// text/template with a "json" function encoding a value as JSON.
+	// Defaults to DefaultWebhookTemplate.
+	Template string
+
+	// ContentType is the content type of the payload. Defaults to
+	// "application/json" if not specified.
+	ContentType string
+
+	// Headers are added to every request.
+	Headers map[string]string
+
+	// GroupWindow is the time entries with the same level, logger and
+	// message are grouped for, from the first of them. They raise a single
+	// alert with their count once it has passed. Defaults to 10 seconds if
+	// not specified.
+	GroupWindow time.Duration
+
+	// MaxAlerts is the maximum number of alerts posted per AlertInterval.
+	// Further alerts are discarded, and counted in the next alert posted.
+	// Defaults to 10 if not specified.
+	MaxAlerts int
+
+	// AlertInterval is the interval MaxAlerts applies to. Defaults to 1
+	// minute if not specified.
+	AlertInterval time.Duration
+
+	// QueueSize is the maximum number of entries waiting to be grouped.
+	// Entries written while the queue is full are discarded, so that
+	// logging never blocks. Defaults to 256 if not specified.
+	QueueSize int
+
+	// Timeout is the maximum time of a request. Defaults to 10 seconds if
+	// not specified. It is ignored if Client is set.
+	Timeout time.Duration
+
+	// Client makes the requests. Defaults to a client with Timeout.
+	Client *http.Client
+
+	// Retry configures the retrying of alerts failing with a network error,
+	// a 429 or a 5xx response.
+	Retry RetryConfig
+
+	// CloseTimeout is the maximum time Close waits for the pending alerts
+	// to be posted. Once it has passed, the request in flight is aborted and
+	// the alerts not posted yet are counted as failed. Defaults to 5
+	// seconds if not specified.
+	CloseTimeout time.Duration
+
+	// OnError is called from the background goroutine when an alert can't
+	// be rendered or posted. Errors are discarded if it is nil.
+	OnError func(err error)
+}
+
+// Validate checks if the configuration is valid and returns an error if not.
+func (c *WebhookConfig) Validate() error {
+	if c.URL == "" {
+		return fmt.Errorf("URL cannot be empty")
+	}
+
+	if u, err := url.Parse(c.URL); err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("URL must be an http or https URL")
+	}
+
+	if c.MinLevel != "" && rankOf(c.MinLevel) == 0 {
+		return fmt.Errorf("unknown MinLevel %q", c.MinLevel)
+	}
+
+	if c.Template != "" {
+		if _, err := parseWebhookTemplate(c.Template); err != nil {
+			return fmt.Errorf("invalid Template: %w", err)
+		}
+	}
+
+	if c.GroupWindow < 0 {
+		return fmt.Errorf("GroupWindow cannot be negative")
+	}
+
+	if c.MaxAlerts < 0 {
+		return fmt.Errorf("MaxAlerts cannot be negative")
+	}
+
+	if c.AlertInterval < 0 {
+		return fmt.Errorf("AlertInterval cannot be negative")
+	}
+
+	if c.QueueSize < 0 {
+		return fmt.Errorf("QueueSize cannot be negative")
+	}
+
+	if c.Timeout < 0 {
+		return fmt.Errorf("Timeout cannot be negative")
+	}
+
+	if c.CloseTimeout < 0 {
+		return fmt.Errorf("CloseTimeout cannot be negative")
+	}
+
+	if err := c.Retry.Validate(); err != nil {
+		return fmt.Errorf("invalid Retry: %w", err)
+	}
+
+	return nil
+}
+
+// setDefaults sets default values for unspecified configuration fields.
+func (c *WebhookConfig) setDefaults() {
+	if c.MinLevel == "" {
+		c.MinLevel = LevelError
+	}
+
+	if c.Template == "" {
+		c.Template = DefaultWebhookTemplate
+	}
+
+	if c.ContentType == "" {
+		c.ContentType = "application/json"
+	}
+
+	if c.GroupWindow == 0 {
+		c.GroupWindow = 10 * time.Second
+	}
+
+	if c.MaxAlerts == 0 {
+		c.MaxAlerts = 10
+	}
+
+	if c.AlertInterval == 0 {
+		c.AlertInterval = time.Minute
+	}
+
+	if c.QueueSize == 0 {
+		c.QueueSize = 256
+	}
+
+	if c.Timeout == 0 {
+		c.Timeout = 10 * time.Second
+	}
+
+	if c.Client == nil {
+		c.Client = &http.Client{Timeout: c.Timeout}
+	}
+
+	if c.CloseTimeout == 0 {
+		c.CloseTimeout = 5 * time.Second
+	}
+
+	c.Retry.setDefaults()
+}
+
+// parseWebhookTemplate parses an alert template.
+func parseWebhookTemplate(text string) (*template.Template, error) {
+	return template.New("webhook").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+}
+
+// WebhookAlert is the data an alert payload is rendered from. It describes
+// the first of the entries grouped into the alert.
+type WebhookAlert struct {
+	// Level is the level of the entries, as written
+	Level string
+	// Message is the message of the entries. For entries other than JSON,
+	// it is the first line without its time column.
+	Message string
+	// Logger is the name of the logger, if any
+	Logger string
+	// Caller is the location of the logging call, if any
+	Caller string
+	// Time is the time of the first entry
+	Time time.Time
+	// Fields are the fields of the first entry, empty for entries other
+	// than JSON
+	Fields map[string]interface{}
+	// Entry is the first entry, without its trailing newline
+	Entry string
+	// Count is the number of entries grouped into the alert. It is 0 for
+	// the summary posted by Close when alerts were discarded since the last
+	// one posted, which only sets Message, Time and Suppressed.
+	Count int
+	// Suppressed is the number of alerts discarded by MaxAlerts since the
+	// previous alert was posted
+	Suppressed int
+}
+
+// WebhookStats holds counters of a WebhookWriter.
+type WebhookStats struct {
+	// Sent is the number of alerts posted
+	Sent uint64
+	// Grouped is the number of entries grouped into an earlier alert
+	Grouped uint64
+	// Throttled is the number of alerts discarded by MaxAlerts
+	Throttled uint64
+	// Dropped is the number of entries discarded because the queue was full
+	Dropped uint64
+	// Failed is the number of alerts that couldn't be rendered or posted
+	Failed uint64
+}
+
+// webhookGroup is an alert waiting for its group window to pass.
+type webhookGroup struct {
+	key      string
+	alert    WebhookAlert
+	deadline time.Time
+}
+
+// WebhookWriter is an io.Writer that posts alerts for severe entries to a
+// webhook, such as a chat or incident management service. It is safe for
+// concurrent use.
+//
+// Entries below MinLevel are ignored. The others are grouped and posted from
+// a background goroutine, so Write never blocks on the webhook: entries are
+// discarded when the queue is full.
+type WebhookWriter struct {
+	conf     WebhookConfig
+	request  httpRequest
+	template *template.Template
+	now      func() time.Time
+
+	queue  chan []byte
+	quit   chan struct{}   // Closed by Close
+	done   chan struct{}   // Closed when the background goroutine exits
+	ctx    context.Context // Done once CloseTimeout has passed
+	cancel context.CancelFunc
+
+	mutex  sync.RWMutex // Held for reading while queuing, for writing while closing
+	closed bool
+
+	sent      uint64
+	grouped   uint64
+	throttled uint64
+	dropped   uint64
+	failed    uint64
+}
+
+// NewWebhookWriter creates a new writer posting alerts to a webhook. It is
+// meant to be combined with the main output of a logger, such as with
+// io.MultiWriter.
+//
+// Close must be called to post the pending alerts and stop the background
+// goroutine.
+//
+// Example:
+//
+//	alerts, err := writer.NewWebhookWriter(writer.WebhookConfig{
+//	    URL:       "https://hooks.slack.com/services/T000/B000/XXXX",
+//	    MaxAlerts: 5,
+//	})
+//	defer alerts.Close()
+//	logger := tslog.NewLogger(tslog.WithWriter(io.MultiWriter(os.Stdout, alerts)))
+func NewWebhookWriter(conf WebhookConfig) (*WebhookWriter, error) {
+	// Validate configuration
+	if err := conf.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid webhook config: %w", err)
+	}
+
+	// Apply defaults
+	conf.setDefaults()
+
+	tmpl, err := parseWebhookTemplate(conf.Template)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook config: invalid Template: %w", err)
+	}
+
+	w := &WebhookWriter{
+		conf: conf,
+		request: httpRequest{
+			client:      conf.Client,
+			method:      http.MethodPost,
+			url:         conf.URL,
+			contentType: conf.ContentType,
+			headers:     conf.Headers,
+		},
+		template: tmpl,
+		now:      time.Now,
+		queue:    make(chan []byte, conf.QueueSize),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	go w.run()
+
+	return w, nil
+}
+
+// MustNewWebhookWriter is like NewWebhookWriter but panics if the
+// configuration is invalid.
+func MustNewWebhookWriter(conf WebhookConfig) *WebhookWriter {
+	writer, err := NewWebhookWriter(conf)
+	if err != nil {
+		panic(err)
+	}
+	return writer
+}
+
+// writesEntries marks the writer as treating each Write call as one entry.
+func (w *WebhookWriter) writesEntries() {}
+
+// Write queues a copy of p to raise an alert if its level is at least
+// MinLevel. It never blocks and never returns an error of the webhook; use
+// WebhookConfig.OnError to observe those.
+func (w *WebhookWriter) Write(p []byte) (int, error) {
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+
+	if w.closed {
+		return 0, ErrWebhookWriterClosed
+	}
+
+	if rankOf(parseLevel(p)) < rankOf(w.conf.MinLevel) {
+		return len(p), nil
+	}
+
+	entry := make([]byte, len(p))
+	copy(entry, p)
+	select {
+	case w.queue <- entry:
+	default:
+		atomic.AddUint64(&w.dropped, 1)
+	}
+	return len(p), nil
+}
+
+// Close stops accepting entries, posts the pending alerts without waiting
+// for their group windows to pass, and stops the background goroutine. If
+// alerts were discarded by MaxAlerts since the last one posted, a summary
+// with their number is posted last, regardless of MaxAlerts.
+// Failed posts are not retried once Close is called.
+//
+// Close waits up to WebhookConfig.CloseTimeout. It returns ErrCloseTimeout
+// if the alerts could not be posted in time; the request in flight is then
+// aborted, and the alerts left are not posted but counted as failed.
+func (w *WebhookWriter) Close() error {
+	w.mutex.Lock()
+	if w.closed {
+		w.mutex.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.quit)
+	w.mutex.Unlock()
+
+	timer := time.NewTimer(w.conf.CloseTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-w.done:
+		w.cancel()
+		return nil
+	case <-timer.C:
+		w.cancel()
+		return ErrCloseTimeout
+	}
+}
+
+// Stats returns a snapshot of the writer's counters.
+func (w *WebhookWriter) Stats() WebhookStats {
+	return WebhookStats{
+		Sent:      atomic.LoadUint64(&w.sent),
+		Grouped:   atomic.LoadUint64(&w.grouped),
+		Throttled: atomic.LoadUint64(&w.throttled),
+		Dropped:   atomic.LoadUint64(&w.dropped),
+		Failed:    atomic.LoadUint64(&w.failed),
+	}
+}
+
+// run is the background goroutine grouping entries and posting alerts.
+func (w *WebhookWriter) run() {
+	defer close(w.done)
+
+	var (
+		groups      []*webhookGroup // In order of deadline
+		byKey       = map[string]*webhookGroup{}
+		timer       *time.Timer
+		timerC      <-chan time.Time
+		windowStart time.Time // Start of the current AlertInterval
+		posted      int       // Alerts posted in the current AlertInterval
+		suppressed  int       // Alerts discarded since the last one posted
+	)
+
+	// send posts an alert, counting the alerts discarded before it
+	send := func(alert *WebhookAlert) {
+		alert.Suppressed, suppressed = suppressed, 0
+		if err := w.post(alert); err != nil {
+			atomic.AddUint64(&w.failed, 1)
+			if w.conf.OnError != nil {
+				w.conf.OnError(err)
+			}
+			return
+		}
+		atomic.AddUint64(&w.sent, 1)
+	}
+
+	// post posts the alert of a group unless MaxAlerts is reached
+	post := func(g *webhookGroup) {
+		delete(byKey, g.key)
+
+		now := w.now()
+		if now.Sub(windowStart) >= w.conf.AlertInterval {
+			windowStart, posted = now, 0
+		}
+		if posted >= w.conf.MaxAlerts {
+			suppressed++
+			atomic.AddUint64(&w.throttled, 1)
+			return
+		}
+		posted++
+		send(&g.alert)
+	}
+
+	// schedule sets the timer to the earliest deadline
+	schedule := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, timerC = nil, nil
+		}
+		if len(groups) > 0 {
+			timer = time.NewTimer(groups[0].deadline.Sub(w.now()))
+			timerC = timer.C
+		}
+	}
+
+	add := func(entry []byte) {
+		alert := w.alert(entry)
+		key := alert.Level + "\x00" + alert.Logger + "\x00" + alert.Message
+		if g, ok := byKey[key]; ok {
+			g.alert.Count++
+			atomic.AddUint64(&w.grouped, 1)
+			return
+		}
+
+		g := &webhookGroup{key: key, alert: alert, deadline: w.now().Add(w.conf.GroupWindow)}
+		byKey[key] = g
+		groups = append(groups, g)
+		if len(groups) == 1 {
+			schedule()
+		}
+	}
+
+	for {
+		select {
+		case entry := <-w.queue:
+			add(entry)
+		case <-timerC:
+			timer, timerC = nil, nil
+			now := w.now()
+			for len(groups) > 0 && !groups[0].deadline.After(now) {
+				g := groups[0]
+				groups[0] = nil
+				groups = groups[1:]
+				post(g)
+			}
+			schedule()
+		case <-w.quit:
+			if timer != nil {
+				timer.Stop()
+			}
+		drain:
+			for {
+				select {
+				case entry := <-w.queue:
+					add(entry)
+				default:
+					break drain
+				}
+			}
+			for i, g := range groups {
+				if w.ctx.Err() != nil {
+					// CloseTimeout has passed
+					atomic.AddUint64(&w.failed, uint64(len(groups)-i))
+					return
+				}
+				post(g)
+			}
+
+			// Don't leave the last discarded alerts unreported
+			if suppressed > 0 && w.ctx.Err() == nil {
+				send(&WebhookAlert{
+					Message: fmt.Sprintf("%d alerts suppressed", suppressed),
+					Time:    w.now(),
+					Fields:  map[string]interface{}{},
+				})
+			}
+			return
+		}
+	}
+}
+
+// alert returns the alert of a single entry.
+func (w *WebhookWriter) alert(entry []byte) WebhookAlert {
+	line := strings.TrimRight(string(entry), "\r\n")
+	alert := WebhookAlert{Time: w.now(), Entry: line, Count: 1, Fields: map[string]interface{}{}}
+
+	fields, err := decodeEntry(entry)
+	if err != nil {
+		// Console entries start with the time and the level
+		cols := strings.SplitN(string(stripANSI(firstLine([]byte(line)))), "\t", 3)
+		alert.Message = cols[len(cols)-1]
+		if len(cols) == 3 {
+			alert.Level = strings.TrimSpace(cols[1])
+		}
+		return alert
+	}
+
+	alert.Fields = fields
+	if s, ok := fields[entryTimeKey].(string); ok {
+		if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+			alert.Time = t
+		}
+	}
+	alert.Level, _ = fields[entryLevelKey].(string)
+	alert.Logger, _ = fields[entryLoggerKey].(string)
+	alert.Caller, _ = fields[entryCallerKey].(string)
+	if v, ok := fields[entryMessageKey]; ok {
+		alert.Message = fieldString(v)
+	}
+	return alert
+}
+
+// post renders and posts an alert.
+func (w *WebhookWriter) post(alert *WebhookAlert) error {
+	var body bytes.Buffer
+	if err := w.template.Execute(&body, alert); err != nil {
+		return fmt.Errorf("failed to render webhook alert: %w", err)
+	}
+
+	err := w.conf.Retry.do(w.quit, func() error {
+		_, err := w.request.send(w.ctx, body.Bytes())
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to post webhook alert: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+package writer
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// webhookServer is a stand-in for a webhook, recording the payloads it
+// receives
+type webhookServer struct {
+	*httptest.Server
+	mutex    sync.Mutex
+	payloads []string
+	arrived  chan struct{} // Receives a value for each request, if set
+	release  chan struct{} // Blocks the responses until closed, if set
+	status   int           // Status of the responses, 200 if not set
+}
+
+// newWebhookServer starts a webhook stand-in
+func newWebhookServer(t *testing.T) *webhookServer {
+	s := &webhookServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// handle records a payload
+func (s *webhookServer) handle(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	s.mutex.Lock()
+	s.payloads = append(s.payloads, string(body))
+	s.mutex.Unlock()
+
+	if s.arrived != nil {
+		s.arrived <- struct{}{}
+	}
+	if s.release != nil {
+		<-s.release
+	}
+	if s.status != 0 {
+		w.WriteHeader(s.status)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// received returns the payloads received so far
+func (s *webhookServer) received() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string(nil), s.payloads...)
+}
+
+// wait waits until n payloads are received and returns them
+func (s *webhookServer) wait(t *testing.T, n int) []string {
+	t.Helper()
+	require.Eventually(t, func() bool { return len(s.received()) >= n }, 5*time.Second, 5*time.Millisecond)
+	return s.received()
+}
+
+// errorEntry returns a JSON entry at error level
+func errorEntry(msg string) []byte {
+	return []byte(`{"level":"ERROR","timestamp":"2024-01-01T00:00:00Z","logger":"db","msg":"` + msg + `"}` + "\n")
+}
+
+// TestWebhookConfig tests webhook writer configuration validation and defaults
+func TestWebhookConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config WebhookConfig
+		errMsg string
+	}{
+		{"Valid", WebhookConfig{URL: "https://hooks.example.com/x", MinLevel: LevelWarn, Template: "{{.Message}}"}, ""},
+		{"EmptyURL", WebhookConfig{}, "URL cannot be empty"},
+		{"Scheme", WebhookConfig{URL: "hooks.example.com"}, "must be an http or https URL"},
+		{"MinLevel", WebhookConfig{URL: "http://hook", MinLevel: "critical"}, `unknown MinLevel "critical"`},
+		{"Template", WebhookConfig{URL: "http://hook", Template: "{{.Message"}, "invalid Template"},
+		{"GroupWindow", WebhookConfig{URL: "http://hook", GroupWindow: -1}, "GroupWindow cannot be negative"},
+		{"MaxAlerts", WebhookConfig{URL: "http://hook", MaxAlerts: -1}, "MaxAlerts cannot be negative"},
+		{"AlertInterval", WebhookConfig{URL: "http://hook", AlertInterval: -1}, "AlertInterval cannot be negative"},
+		{"QueueSize", WebhookConfig{URL: "http://hook", QueueSize: -1}, "QueueSize cannot be negative"},
+		{"Timeout", WebhookConfig{URL: "http://hook", Timeout: -1}, "Timeout cannot be negative"},
+		{"CloseTimeout", WebhookConfig{URL: "http://hook", CloseTimeout: -1}, "CloseTimeout cannot be negative"},
+		{"Retry", WebhookConfig{URL: "http://hook", Retry: RetryConfig{MaxRetries: -2}}, "invalid Retry"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if tt.errMsg == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errMsg)
+		})
+	}
+
+	t.Run("Defaults", func(t *testing.T) {
+		config := WebhookConfig{URL: "http://hook"}
+		config.setDefaults()
+		assert.Equal(t, LevelError, config.MinLevel)
+		assert.Equal(t, DefaultWebhookTemplate, config.Template)
+		assert.Equal(t, "application/json", config.ContentType)
+		assert.Equal(t, 10*time.Second, config.GroupWindow)
+		assert.Equal(t, 10, config.MaxAlerts)
+		assert.Equal(t, time.Minute, config.AlertInterval)
+		assert.Equal(t, 256, config.QueueSize)
+		assert.Equal(t, 5*time.Second, config.CloseTimeout)
+	})
+
+	t.Run("Constructor", func(t *testing.T) {
+		_, err := NewWebhookWriter(WebhookConfig{})
+		assert.Contains(t, err.Error(), "invalid webhook config")
+		assert.Panics(t, func() { MustNewWebhookWriter(WebhookConfig{}) })
+	})
+}
+
+// TestWebhookWriter tests posting alerts to a webhook stand-in
+func TestWebhookWriter(t *testing.T) {
+	t.Run("Group", func(t *testing.T) {
+		server := newWebhookServer(t)
+		w, err := NewWebhookWriter(WebhookConfig{URL: server.URL, GroupWindow: 50 * time.Millisecond})
+		require.NoError(t, err)
+
+		for _, entry := range [][]byte{
+			errorEntry("db down"),
+			[]byte(`{"level":"INFO","msg":"db down"}` + "\n"),
+			errorEntry("db down"),
+			errorEntry("disk full"),
+			errorEntry("db down"),
+		} {
+			n, err := w.Write(entry)
+			require.NoError(t, err)
+			assert.Equal(t, len(entry), n)
+		}
+
+		// The alerts are posted once the window has passed
+		payloads := server.wait(t, 2)
+		require.NoError(t, w.Close())
+
+		var texts []string
+		for _, payload := range payloads {
+			var body struct {
+				Text string `json:"text"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(payload), &body))
+			texts = append(texts, body.Text)
+		}
+		assert.Equal(t, []string{"[ERROR] db down (3 times)", "[ERROR] disk full"}, texts)
+		assert.Equal(t, WebhookStats{Sent: 2, Grouped: 2}, w.Stats())
+	})
+
+	t.Run("Template", func(t *testing.T) {
+		server := newWebhookServer(t)
+		var errs []error
+		w, err := NewWebhookWriter(WebhookConfig{
+			URL:      server.URL,
+			MinLevel: LevelWarn,
+			Template: `{{.Level}}|{{.Message}}|{{.Logger}}|{{.Time.Year}}|{{index .Fields "user"}}|{{.Missing}}`,
+			OnError:  func(err error) { errs = append(errs, err) },
+		})
+		require.NoError(t, err)
+
+		_, err = w.Write([]byte(`{"level":"WARN","timestamp":"2024-01-01T00:00:00Z","msg":"slow","user":"ann"}` + "\n"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		// Rendering fails on the missing field, so nothing is posted
+		assert.Empty(t, server.received())
+		require.Len(t, errs, 1)
+		assert.Contains(t, errs[0].Error(), "failed to render webhook alert")
+		assert.Equal(t, uint64(1), w.Stats().Failed)
+
+		w, err = NewWebhookWriter(WebhookConfig{
+			URL:         server.URL,
+			Template:    `{{.Level}}|{{.Message}}|{{.Count}}`,
+			ContentType: "text/plain",
+		})
+		require.NoError(t, err)
+		_, err = w.Write([]byte("2024-01-01T00:00:00.000Z\tERROR\tapp/main.go:1\tboom\n"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		assert.Equal(t, []string{"ERROR|app/main.go:1\tboom|1"}, server.received())
+	})
+
+	t.Run("Throttle", func(t *testing.T) {
+		server := newWebhookServer(t)
+		w, err := NewWebhookWriter(WebhookConfig{
+			URL:           server.URL,
+			Template:      `{{.Message}} {{.Suppressed}}`,
+			GroupWindow:   time.Millisecond,
+			MaxAlerts:     1,
+			AlertInterval: 200 * time.Millisecond,
+		})
+		require.NoError(t, err)
+
+		_, err = w.Write(errorEntry("a"))
+		require.NoError(t, err)
+		server.wait(t, 1)
+		_, err = w.Write(errorEntry("b"))
+		require.NoError(t, err)
+		require.Eventually(t, func() bool { return w.Stats().Throttled == 1 }, 5*time.Second, 5*time.Millisecond)
+
+		// The next alert after the interval counts the discarded one
+		time.Sleep(250 * time.Millisecond)
+		_, err = w.Write(errorEntry("c"))
+		require.NoError(t, err)
+		assert.Equal(t, []string{"a 0", "c 1"}, server.wait(t, 2))
+		require.NoError(t, w.Close())
+	})
+
+	t.Run("ThrottleSummary", func(t *testing.T) {
+		server := newWebhookServer(t)
+		w, err := NewWebhookWriter(WebhookConfig{
+			URL:           server.URL,
+			GroupWindow:   time.Millisecond,
+			MaxAlerts:     1,
+			AlertInterval: time.Hour,
+		})
+		require.NoError(t, err)
+
+		_, err = w.Write(errorEntry("a"))
+		require.NoError(t, err)
+		server.wait(t, 1)
+		for _, msg := range []string{"b", "c"} {
+			_, err = w.Write(errorEntry(msg))
+			require.NoError(t, err)
+		}
+		require.Eventually(t, func() bool { return w.Stats().Throttled == 2 }, 5*time.Second, 5*time.Millisecond)
+
+		// Close reports the discarded alerts instead of losing them
+		require.NoError(t, w.Close())
+		assert.Equal(t, []string{`{"text":"[ERROR] a"}`, `{"text":"2 alerts suppressed"}`}, server.received())
+		assert.Equal(t, WebhookStats{Sent: 2, Throttled: 2}, w.Stats())
+	})
+
+	t.Run("NeverBlocks", func(t *testing.T) {
+		server := newWebhookServer(t)
+		server.arrived = make(chan struct{}, 2)
+		server.release = make(chan struct{})
+		w, err := NewWebhookWriter(WebhookConfig{
+			URL:         server.URL,
+			GroupWindow: time.Millisecond,
+			QueueSize:   1,
+		})
+		require.NoError(t, err)
+
+		_, err = w.Write(errorEntry("first"))
+		require.NoError(t, err)
+		<-server.arrived
+
+		// The webhook hangs, so one entry is queued and the rest dropped
+		for i := 0; i < 10; i++ {
+			_, err := w.Write(errorEntry("next"))
+			require.NoError(t, err)
+		}
+		assert.Equal(t, uint64(9), w.Stats().Dropped)
+
+		close(server.release)
+		require.NoError(t, w.Close())
+		assert.Len(t, server.received(), 2)
+
+		_, err = w.Write(errorEntry("closed"))
+		assert.ErrorIs(t, err, ErrWebhookWriterClosed)
+	})
+	t.Run("CloseStopsRetrying", func(t *testing.T) {
+		server := newWebhookServer(t)
+		server.status = http.StatusServiceUnavailable
+		w, err := NewWebhookWriter(WebhookConfig{
+			URL:         server.URL,
+			GroupWindow: time.Millisecond,
+			Retry:       RetryConfig{MinBackoff: time.Hour, MaxBackoff: time.Hour},
+		})
+		require.NoError(t, err)
+
+		_, err = w.Write(errorEntry("boom"))
+		require.NoError(t, err)
+		server.wait(t, 1)
+
+		// Close doesn't wait an hour for the next attempt
+		require.NoError(t, w.Close())
+		assert.Len(t, server.received(), 1)
+		assert.Equal(t, uint64(1), w.Stats().Failed)
+	})
+
+	t.Run("CloseTimeout", func(t *testing.T) {
+		server := newWebhookServer(t)
+		server.arrived = make(chan struct{}, 3)
+		server.release = make(chan struct{})
+		defer close(server.release)
+		w, err := NewWebhookWriter(WebhookConfig{
+			URL:          server.URL,
+			GroupWindow:  time.Hour,
+			CloseTimeout: 50 * time.Millisecond,
+		})
+		require.NoError(t, err)
+
+		for _, msg := range []string{"first", "second", "third"} {
+			_, err := w.Write(errorEntry(msg))
+			require.NoError(t, err)
+		}
+
+		// The webhook hangs on the first alert, so Close gives up on all three
+		start := time.Now()
+		assert.ErrorIs(t, w.Close(), ErrCloseTimeout)
+		assert.Less(t, time.Since(start), 5*time.Second)
+		<-server.arrived
+
+		require.Eventually(t, func() bool { return w.Stats().Failed == 3 }, 5*time.Second, 5*time.Millisecond)
+		assert.Equal(t, uint64(0), w.Stats().Sent)
+		assert.Len(t, server.received(), 1)
+	})
+}
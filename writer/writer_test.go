@@ -1,15 +1,18 @@
 package writer
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
-	"github.com/natefinch/lumberjack"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -109,6 +112,8 @@ func TestLumberJackConfigDefaults(t *testing.T) {
 		assert.Equal(t, 100, config.MaxRotatedSize)
 		assert.Equal(t, 7, config.MaxRetainDay)
 		assert.Equal(t, 3, config.MaxRetainFiles)
+		assert.Equal(t, os.FileMode(0755), config.DirMode)
+		assert.Equal(t, os.FileMode(0), config.FileMode, "existing files keep their mode")
 	})
 
 	t.Run("PartialDefaults", func(t *testing.T) {
@@ -132,6 +137,8 @@ func TestLumberJackConfigDefaults(t *testing.T) {
 			MaxRotatedSize: 200,
 			MaxRetainDay:   14,
 			MaxRetainFiles: 10,
+			DirMode:        0750,
+			FileMode:       0640,
 		}
 
 		originalConfig := config
@@ -160,7 +167,7 @@ func TestNewLumberJackWriter(t *testing.T) {
 		writer, err := NewLumberJackWriter(config)
 		assert.NoError(t, err)
 		assert.NotNil(t, writer)
-		assert.IsType(t, &lumberjack.Logger{}, writer)
+		assert.IsType(t, &RotatingFile{}, writer)
 
 		// Test writing to the logger
 		n, err := writer.Write([]byte("test message\n"))
@@ -190,13 +197,17 @@ func TestNewLumberJackWriter(t *testing.T) {
 		assert.NotNil(t, writer)
 
 		// Verify defaults were applied by checking the underlying lumberjack.Logger
-		ljLogger := writer.(*lumberjack.Logger)
+		// and the mill that handles retention
+		ljLogger := writer.logger
 		assert.Equal(t, config.FilePath, ljLogger.Filename)
-		assert.Equal(t, 100, ljLogger.MaxSize)     // Default
-		assert.Equal(t, 7, ljLogger.MaxAge)        // Default
-		assert.Equal(t, 3, ljLogger.MaxBackups)    // Default
-		assert.Equal(t, false, ljLogger.LocalTime) // Default
-		assert.Equal(t, false, ljLogger.Compress)  // Default
+		assert.Equal(t, 100, ljLogger.MaxSize)                  // Default
+		assert.Equal(t, 7*24*time.Hour, writer.mill.maxAge)     // Default
+		assert.Equal(t, 3, writer.mill.maxFiles)                // Default
+		assert.Equal(t, int64(0), writer.mill.maxTotal)         // Default
+		assert.Equal(t, false, ljLogger.LocalTime)              // Default
+		assert.Nil(t, writer.mill.compressor)                   // Default
+		assert.Equal(t, 0, ljLogger.MaxAge+ljLogger.MaxBackups) // Handled by the mill
+		assert.Equal(t, false, ljLogger.Compress)               // Handled by the mill
 	})
 
 	t.Run("FullConfig", func(t *testing.T) {
@@ -214,13 +225,13 @@ func TestNewLumberJackWriter(t *testing.T) {
 		assert.NotNil(t, writer)
 
 		// Verify all settings were applied
-		ljLogger := writer.(*lumberjack.Logger)
+		ljLogger := writer.logger
 		assert.Equal(t, config.FilePath, ljLogger.Filename)
 		assert.Equal(t, config.MaxRotatedSize, ljLogger.MaxSize)
-		assert.Equal(t, config.MaxRetainDay, ljLogger.MaxAge)
-		assert.Equal(t, config.MaxRetainFiles, ljLogger.MaxBackups)
+		assert.Equal(t, time.Duration(config.MaxRetainDay)*24*time.Hour, writer.mill.maxAge)
+		assert.Equal(t, config.MaxRetainFiles, writer.mill.maxFiles)
 		assert.Equal(t, config.LocalTime, ljLogger.LocalTime)
-		assert.Equal(t, config.Compress, ljLogger.Compress)
+		assert.Equal(t, config.Compress, writer.mill.compressor != nil)
 	})
 }
 
@@ -239,7 +250,7 @@ func TestMustNewLumberJackWriter(t *testing.T) {
 		// Should not panic
 		writer := MustNewLumberJackWriter(config)
 		assert.NotNil(t, writer)
-		assert.IsType(t, &lumberjack.Logger{}, writer)
+		assert.IsType(t, &RotatingFile{}, writer)
 	})
 
 	t.Run("InvalidConfig", func(t *testing.T) {
@@ -279,10 +290,8 @@ func TestLumberJackWriterActualWriting(t *testing.T) {
 	assert.Equal(t, len(testMessage), n)
 
 	// Force sync to ensure file is written
-	if syncer, ok := writer.(interface{ Sync() error }); ok {
-		err = syncer.Sync()
-		assert.NoError(t, err)
-	}
+	err = writer.Sync()
+	assert.NoError(t, err)
 
 	// Verify file was created and contains the message
 	content, err := os.ReadFile(logFile)
@@ -291,6 +300,498 @@ func TestLumberJackWriterActualWriting(t *testing.T) {
 	}
 }
 
+// TestRotatingFile tests manual rotation, reopening and signal handling
+func TestRotatingFile(t *testing.T) {
+	newFile := func(t *testing.T) (*RotatingFile, string) {
+		dir := t.TempDir()
+		writer, err := NewLumberJackWriter(LumberJackConfig{
+			FilePath:       filepath.Join(dir, "app.log"),
+			MaxRetainFiles: 10,
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { writer.Close() })
+		return writer, dir
+	}
+
+	t.Run("Rotate", func(t *testing.T) {
+		writer, dir := newFile(t)
+
+		_, err := writer.Write([]byte("before\n"))
+		require.NoError(t, err)
+		require.NoError(t, writer.Rotate())
+		_, err = writer.Write([]byte("after\n"))
+		require.NoError(t, err)
+		require.NoError(t, writer.Sync())
+
+		content, err := os.ReadFile(filepath.Join(dir, "app.log"))
+		require.NoError(t, err)
+		assert.Equal(t, "after\n", string(content))
+
+		backups, err := filepath.Glob(filepath.Join(dir, "app-*.log"))
+		require.NoError(t, err)
+		require.Len(t, backups, 1)
+		content, err = os.ReadFile(backups[0])
+		require.NoError(t, err)
+		assert.Equal(t, "before\n", string(content))
+	})
+
+	t.Run("Reopen", func(t *testing.T) {
+		writer, dir := newFile(t)
+		path := filepath.Join(dir, "app.log")
+		moved := filepath.Join(dir, "app.log.1")
+
+		_, err := writer.Write([]byte("before\n"))
+		require.NoError(t, err)
+
+		// Without reopening, writes follow the moved file
+		require.NoError(t, os.Rename(path, moved))
+		_, err = writer.Write([]byte("moved\n"))
+		require.NoError(t, err)
+
+		require.NoError(t, writer.Reopen())
+		_, err = writer.Write([]byte("after\n"))
+		require.NoError(t, err)
+
+		content, err := os.ReadFile(moved)
+		require.NoError(t, err)
+		assert.Equal(t, "before\nmoved\n", string(content))
+		content, err = os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "after\n", string(content))
+	})
+
+	t.Run("SyncBeforeWrite", func(t *testing.T) {
+		writer, _ := newFile(t)
+		assert.NoError(t, writer.Sync())
+	})
+
+	t.Run("SyncMovedFile", func(t *testing.T) {
+		writer, dir := newFile(t)
+		path := filepath.Join(dir, "app.log")
+		moved := filepath.Join(dir, "app.log.1")
+
+		_, err := writer.Write([]byte("before\n"))
+		require.NoError(t, err)
+		require.NoError(t, os.Rename(path, moved))
+
+		// Sync flushes the file being written to, not the path
+		require.NoError(t, writer.Sync())
+		synced, err := writer.file.Stat()
+		require.NoError(t, err)
+		fi, err := os.Stat(moved)
+		require.NoError(t, err)
+		assert.True(t, os.SameFile(fi, synced))
+	})
+
+	t.Run("SyncAfterClose", func(t *testing.T) {
+		writer, dir := newFile(t)
+		require.NoError(t, writer.Close())
+		assert.NoError(t, writer.Sync())
+
+		// Writing reopens the file, and syncing follows
+		_, err := writer.Write([]byte("reopened\n"))
+		require.NoError(t, err)
+		require.NoError(t, writer.Sync())
+		assert.Equal(t, filepath.Join(dir, "app.log"), writer.file.Name())
+	})
+
+	t.Run("HandleSignals", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("signals are not supported on windows")
+		}
+
+		writer, dir := newFile(t)
+		path := filepath.Join(dir, "app.log")
+		writer.HandleSignals(syscall.SIGHUP)
+
+		_, err := writer.Write([]byte("before\n"))
+		require.NoError(t, err)
+		require.NoError(t, os.Rename(path, filepath.Join(dir, "app.log.1")))
+
+		process, err := os.FindProcess(os.Getpid())
+		require.NoError(t, err)
+		require.NoError(t, process.Signal(syscall.SIGHUP))
+
+		// Write until the signal has been handled and a new file is opened
+		require.Eventually(t, func() bool {
+			_, err := writer.Write([]byte("after\n"))
+			require.NoError(t, err)
+			_, err = os.Stat(path)
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+
+		// Handling stops on Close and resumes when requested again
+		require.NoError(t, writer.Close())
+		writer.HandleSignals()
+		require.NoError(t, writer.Close())
+	})
+
+	t.Run("ConcurrentRotate", func(t *testing.T) {
+		writer, dir := newFile(t)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(id int) {
+				defer wg.Done()
+				for j := 0; j < 100; j++ {
+					_, err := writer.Write([]byte(fmt.Sprintf("writer %d message %d\n", id, j)))
+					assert.NoError(t, err)
+				}
+			}(i)
+		}
+		for i := 0; i < 5; i++ {
+			assert.NoError(t, writer.Rotate())
+			// Backup names have millisecond resolution
+			time.Sleep(2 * time.Millisecond)
+		}
+		wg.Wait()
+		require.NoError(t, writer.Close())
+
+		// Every entry ends up whole in exactly one file
+		files, err := filepath.Glob(filepath.Join(dir, "app*.log"))
+		require.NoError(t, err)
+		lines := 0
+		for _, file := range files {
+			content, err := os.ReadFile(file)
+			require.NoError(t, err)
+			for _, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+				if line == "" {
+					continue
+				}
+				assert.Regexp(t, `^writer \d message \d+$`, line)
+				lines++
+			}
+		}
+		assert.Equal(t, 400, lines)
+	})
+}
+
+// TestLumberJackWriterPermissions tests directory creation, file modes and
+// ownership
+func TestLumberJackWriterPermissions(t *testing.T) {
+	t.Run("MissingDirectory", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "missing", "app.log")
+
+		writer, err := NewLumberJackWriter(LumberJackConfig{FilePath: path})
+		assert.Nil(t, writer)
+		assert.Contains(t, err.Error(), "log directory is not accessible")
+	})
+
+	t.Run("DirectoryIsFile", func(t *testing.T) {
+		parent := filepath.Join(t.TempDir(), "file")
+		require.NoError(t, os.WriteFile(parent, nil, 0644))
+
+		writer, err := NewLumberJackWriter(LumberJackConfig{
+			FilePath: filepath.Join(parent, "app.log"),
+		})
+		assert.Nil(t, writer)
+		assert.Contains(t, err.Error(), "is not a directory")
+
+		writer, err = NewLumberJackWriter(LumberJackConfig{
+			FilePath:   filepath.Join(parent, "app.log"),
+			CreateDirs: true,
+		})
+		assert.Nil(t, writer)
+		assert.Contains(t, err.Error(), "failed to create log directory")
+	})
+
+	t.Run("UnwritableDirectory", func(t *testing.T) {
+		if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+			t.Skip("directory permissions are not enforced")
+		}
+
+		dir := filepath.Join(t.TempDir(), "readonly")
+		require.NoError(t, os.Mkdir(dir, 0555))
+
+		writer, err := NewLumberJackWriter(LumberJackConfig{FilePath: filepath.Join(dir, "app.log")})
+		assert.Nil(t, writer)
+		assert.Contains(t, err.Error(), "failed to open log file")
+	})
+
+	t.Run("CreateDirs", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("file modes are not supported on windows")
+		}
+
+		dir := filepath.Join(t.TempDir(), "nested", "logs")
+		writer, err := NewLumberJackWriter(LumberJackConfig{
+			FilePath:   filepath.Join(dir, "app.log"),
+			CreateDirs: true,
+			DirMode:    0700,
+		})
+		require.NoError(t, err)
+		defer writer.Close()
+
+		fi, err := os.Stat(dir)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0700), fi.Mode().Perm())
+		assert.FileExists(t, filepath.Join(dir, "app.log"), "file should be created right away")
+	})
+
+	t.Run("FileModeAndOwner", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("file modes and ownership are not supported on windows")
+		}
+
+		dir := t.TempDir()
+		path := filepath.Join(dir, "app.log")
+		require.NoError(t, os.WriteFile(path, []byte("existing\n"), 0600))
+
+		gid := os.Getgid()
+		writer, err := NewLumberJackWriter(LumberJackConfig{
+			FilePath:       path,
+			MaxRotatedSize: 1,
+			MaxRetainFiles: 10,
+			FileMode:       0664,
+			GID:            &gid,
+		})
+		require.NoError(t, err)
+		defer writer.Close()
+
+		assertMode := func(path string) {
+			t.Helper()
+			fi, err := os.Stat(path)
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0664), fi.Mode().Perm(), path)
+		}
+
+		// Applied to an existing file, the files created by manual and
+		// size-based rotation, and the backups
+		assertMode(path)
+		require.NoError(t, writer.Rotate())
+		assertMode(path)
+		time.Sleep(2 * time.Millisecond) // Backup names have millisecond resolution
+
+		line := []byte(strings.Repeat("x", 600*1024) + "\n")
+		for i := 0; i < 2; i++ {
+			_, err := writer.Write(line)
+			require.NoError(t, err)
+		}
+		assertMode(path)
+
+		backups, err := filepath.Glob(filepath.Join(dir, "app-*.log"))
+		require.NoError(t, err)
+		assert.Len(t, backups, 2)
+		for _, backup := range backups {
+			assertMode(backup)
+		}
+	})
+
+	t.Run("InvalidModes", func(t *testing.T) {
+		config := LumberJackConfig{FilePath: "/tmp/test.log", FileMode: os.ModeDir | 0755}
+		assert.Contains(t, config.Validate().Error(), "DirMode and FileMode can only contain permission bits")
+
+		gid := -1
+		config = LumberJackConfig{FilePath: "/tmp/test.log", GID: &gid}
+		assert.Contains(t, config.Validate().Error(), "UID and GID cannot be negative")
+	})
+
+	t.Run("ExistingFileMode", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("file modes are not supported on windows")
+		}
+
+		path := filepath.Join(t.TempDir(), "app.log")
+		require.NoError(t, os.WriteFile(path, []byte("existing\n"), 0600))
+		require.NoError(t, os.Chmod(path, 0600))
+
+		// Without FileMode the existing file keeps its mode
+		writer, err := NewLumberJackWriter(LumberJackConfig{FilePath: path})
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		fi, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	})
+}
+
+// TestLumberJackWriterRetention tests retention by total size and the free
+// disk space guard
+func TestLumberJackWriterRetention(t *testing.T) {
+	// writeBackup creates a rotated file with the given age and size
+	writeBackup := func(t *testing.T, dir string, age time.Duration, size int) string {
+		name := "app-" + time.Now().UTC().Add(-age).Format(lumberjackBackupTimeFormat) + ".log"
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(strings.Repeat("x", size)), 0644))
+		return path
+	}
+
+	t.Run("TotalSize", func(t *testing.T) {
+		dir := t.TempDir()
+		var backups []string
+		for i := 1; i <= 4; i++ {
+			backups = append(backups, writeBackup(t, dir, time.Duration(i)*time.Hour, 600*1024))
+		}
+
+		writer, err := NewLumberJackWriter(LumberJackConfig{
+			FilePath:       filepath.Join(dir, "app.log"),
+			MaxRotatedSize: 1,
+			MaxRetainFiles: 10,
+			MaxTotalSize:   2,
+		})
+		require.NoError(t, err)
+		_, err = writer.Write([]byte(strings.Repeat("y", 300*1024) + "\n"))
+		require.NoError(t, err)
+		require.NoError(t, writer.Rotate())
+		require.NoError(t, writer.Close())
+
+		// The new backup and the two newest old ones fit within 2 MB
+		assert.FileExists(t, backups[0])
+		assert.FileExists(t, backups[1])
+		assert.NoFileExists(t, backups[2])
+		assert.NoFileExists(t, backups[3])
+		files, err := filepath.Glob(filepath.Join(dir, "app-*.log"))
+		require.NoError(t, err)
+		assert.Len(t, files, 3)
+	})
+
+	t.Run("CompressedSize", func(t *testing.T) {
+		dir := t.TempDir()
+		for i := 1; i <= 4; i++ {
+			writeBackup(t, dir, time.Duration(i)*time.Hour, 600*1024)
+		}
+
+		writer, err := NewLumberJackWriter(LumberJackConfig{
+			FilePath:       filepath.Join(dir, "app.log"),
+			MaxRotatedSize: 1,
+			MaxRetainFiles: 10,
+			MaxTotalSize:   1,
+			Compress:       true,
+		})
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		// Compressed, all of them fit
+		files, err := filepath.Glob(filepath.Join(dir, "app-*.log.gz"))
+		require.NoError(t, err)
+		assert.Len(t, files, 4)
+		assert.Equal(t, strings.Repeat("x", 600*1024), readFile(t, files[0]))
+	})
+
+	t.Run("AgeFromName", func(t *testing.T) {
+		dir := t.TempDir()
+		old := writeBackup(t, dir, 3*24*time.Hour, 10)
+		recent := writeBackup(t, dir, time.Hour, 10)
+		unrelated := filepath.Join(dir, "app-other.log")
+		require.NoError(t, os.WriteFile(unrelated, nil, 0644))
+
+		writer, err := NewLumberJackWriter(LumberJackConfig{
+			FilePath:     filepath.Join(dir, "app.log"),
+			MaxRetainDay: 2,
+		})
+		require.NoError(t, err)
+		require.NoError(t, writer.Close())
+
+		assert.NoFileExists(t, old)
+		assert.FileExists(t, recent)
+		assert.FileExists(t, unrelated)
+	})
+
+	t.Run("MinFreeSpace", func(t *testing.T) {
+		writer, err := NewLumberJackWriter(LumberJackConfig{
+			FilePath:     filepath.Join(t.TempDir(), "app.log"),
+			MinFreeSpace: 1,
+		})
+		require.NoError(t, err)
+		defer writer.Close()
+
+		c := &clock{t: time.Now()}
+		free, checks := uint64(2*megabyte), 0
+		writer.now = c.now
+		writer.freeSpace = func(string) (uint64, error) {
+			checks++
+			return free, nil
+		}
+
+		line := []byte(strings.Repeat("x", 600*1024))
+		_, err = writer.Write(line)
+		require.NoError(t, err)
+
+		// The estimate accounts for the previous write until the next check
+		_, err = writer.Write(line)
+		assert.ErrorIs(t, err, ErrInsufficientDiskSpace)
+		assert.Contains(t, err.Error(), "keeping at least 1 MB")
+		assert.Equal(t, 1, checks)
+
+		free = 10 * megabyte
+		c.t = c.t.Add(diskCheckInterval)
+		_, err = writer.Write(line)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, checks)
+	})
+
+	t.Run("MinFreeSpaceUnsupported", func(t *testing.T) {
+		writer, err := NewLumberJackWriter(LumberJackConfig{
+			FilePath:     filepath.Join(t.TempDir(), "app.log"),
+			MinFreeSpace: 1,
+		})
+		require.NoError(t, err)
+		defer writer.Close()
+
+		writer.freeSpace = func(string) (uint64, error) { return 0, errDiskFreeUnsupported }
+		_, err = writer.Write([]byte("a\n"))
+		assert.NoError(t, err)
+	})
+
+	t.Run("DiskFree", func(t *testing.T) {
+		free, err := diskFree(t.TempDir())
+		if errors.Is(err, errDiskFreeUnsupported) {
+			t.Skip(err)
+		}
+		require.NoError(t, err)
+		assert.Greater(t, free, uint64(0))
+	})
+
+	t.Run("InvalidConfig", func(t *testing.T) {
+		config := LumberJackConfig{FilePath: "/tmp/test.log", MaxTotalSize: 50}
+		assert.Contains(t, config.Validate().Error(), "MaxTotalSize cannot be less than MaxRotatedSize")
+
+		config = LumberJackConfig{FilePath: "/tmp/test.log", MaxRotatedSize: 10, MaxTotalSize: 5}
+		assert.Contains(t, config.Validate().Error(), "MaxTotalSize cannot be less than MaxRotatedSize")
+
+		config = LumberJackConfig{FilePath: "/tmp/test.log", MaxTotalSize: -1}
+		assert.Contains(t, config.Validate().Error(), "MaxTotalSize cannot be negative")
+
+		config = LumberJackConfig{FilePath: "/tmp/test.log", MinFreeSpace: -1}
+		assert.Contains(t, config.Validate().Error(), "MinFreeSpace cannot be negative")
+	})
+}
+
+// TestLumberJackWriterArchive tests archiving and reporting rotated files
+func TestLumberJackWriterArchive(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(t.TempDir(), "archive")
+	rec := &rotationRecorder{}
+
+	writer, err := NewLumberJackWriter(LumberJackConfig{
+		FilePath:   filepath.Join(dir, "app.log"),
+		Compress:   true,
+		ArchiveDir: archive,
+		OnRotate:   rec.onRotate,
+		OnError:    rec.onError,
+	})
+	require.NoError(t, err)
+
+	// Existing backups are left alone
+	existing := filepath.Join(dir, "app-"+time.Now().UTC().Format(lumberjackBackupTimeFormat)+".log.gz")
+	require.NoError(t, os.WriteFile(existing, nil, 0644))
+	time.Sleep(2 * time.Millisecond) // Backup names have millisecond resolution
+
+	_, err = writer.Write([]byte("before\n"))
+	require.NoError(t, err)
+	require.NoError(t, writer.Rotate())
+	require.NoError(t, writer.Close())
+
+	require.Len(t, rec.paths, 1)
+	assert.Empty(t, rec.errors)
+	assert.Equal(t, archive, filepath.Dir(rec.paths[0]))
+	assert.True(t, strings.HasSuffix(rec.paths[0], ".log.gz"))
+	assert.Equal(t, "before\n", readFile(t, rec.paths[0]))
+	assert.FileExists(t, existing)
+}
+
 // TestWriterInterfaces tests that writers implement io.Writer interface
 func TestWriterInterfaces(t *testing.T) {
 	t.Run("StdoutWriter", func(t *testing.T) {
@@ -19,6 +19,7 @@ type zapLogger struct {
 	zap    *zap.SugaredLogger
 	mutex  sync.RWMutex // Protects the zap field for safe concurrent access
 	closed bool         // Indicates if the logger has been closed
+	caller bool         // Whether entries include caller information
 }
 
 // zapLevel maps tslog.Level to zapcore.Level for compatibility.
@@ -40,6 +41,7 @@ var zapLevel = map[Level]zapcore.Level{
 // - Multiple log levels with efficient level checking
 // - JSON and Console output encoders
 // - Multiple output writers
+// - Per-output level thresholds and encoders (see WithOutput)
 // - Optional caller information
 // - High-performance structured logging
 //
@@ -74,53 +76,50 @@ func NewZapDriver(opts *Options) Logger {
 	atomicLevel := zap.NewAtomicLevel()
 	atomicLevel.SetLevel(lvl)
 
-	// Configure encoder with production-ready settings
-	encoderConfig := zap.NewProductionEncoderConfig()
-	encoderConfig.TimeKey = "timestamp"
-	encoderConfig.EncodeTime = zapcore.RFC3339TimeEncoder
-	encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
-	encoderConfig.EncodeDuration = zapcore.StringDurationEncoder
-	encoderConfig.FunctionKey = "func"
-	encoderConfig.MessageKey = "msg"
-	encoderConfig.StacktraceKey = "stacktrace"
-
-	// Choose encoder based on configuration
-	var encoder zapcore.Encoder
-	switch opts.encoder {
-	case EncoderConsole:
-		// Console encoder for human-readable output
-		encoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
-		encoder = zapcore.NewConsoleEncoder(encoderConfig)
-	case EncoderJSON:
-		fallthrough
-	default:
-		// JSON encoder for structured logging (default)
-		encoder = zapcore.NewJSONEncoder(encoderConfig)
-	}
+	var cores []zapcore.Core
 
 	// Create write syncers from provided writers
-	var syncers []zapcore.WriteSyncer
-	if len(opts.w) == 0 {
-		// Fallback to stdout if no writers provided
-		syncers = append(syncers, zapcore.AddSync(os.Stdout))
-	} else {
-		for _, w := range opts.w {
-			if w != nil {
-				syncers = append(syncers, zapcore.AddSync(w))
+	if len(opts.w) > 0 || len(opts.outputs) == 0 {
+		var syncers []zapcore.WriteSyncer
+		if len(opts.w) == 0 {
+			// Fallback to stdout if no writers provided
+			syncers = append(syncers, zapcore.AddSync(os.Stdout))
+		} else {
+			for _, w := range opts.w {
+				if w != nil {
+					syncers = append(syncers, zapcore.AddSync(w))
+				}
 			}
 		}
+
+		// Create core with multi-writer support
+		cores = append(cores, zapcore.NewCore(
+			newZapEncoder(opts.encoder),
+			zapcore.NewMultiWriteSyncer(syncers...),
+			atomicLevel,
+		))
+	}
+
+	// Create a core per output, each with its own encoder and level
+	for _, out := range opts.outputs {
+		outLvl, ok := zapLevel[out.lvl]
+		if !ok {
+			outLvl = zapcore.InfoLevel
+		}
+		cores = append(cores, zapcore.NewCore(
+			newZapEncoder(out.encoder),
+			zapcore.AddSync(out.w),
+			zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+				return atomicLevel.Enabled(l) && l >= outLvl
+			}),
+		))
 	}
 
-	// Create core with multi-writer support
-	core := zapcore.NewCore(
-		encoder,
-		zapcore.NewMultiWriteSyncer(syncers...),
-		atomicLevel,
-	)
+	core := zapcore.NewTee(cores...)
 
 	// Configure Zap options
 	zapOpts := []zap.Option{
-		zap.AddCallerSkip(2), // Skip tslog wrapper functions
+		zap.AddCallerSkip(2 + opts.callerSkip), // Skip tslog wrapper functions
 	}
 
 	if opts.caller {
@@ -136,6 +135,34 @@ func NewZapDriver(opts *Options) Logger {
 	return &zapLogger{
 		zap:    z,
 		closed: false,
+		caller: opts.caller,
+	}
+}
+
+// newZapEncoder creates the Zap encoder for the named encoder type.
+// Unknown names get the JSON encoder.
+func newZapEncoder(name string) zapcore.Encoder {
+	// Configure encoder with production-ready settings
+	encoderConfig := zap.NewProductionEncoderConfig()
+	encoderConfig.TimeKey = "timestamp"
+	encoderConfig.EncodeTime = zapcore.RFC3339TimeEncoder
+	encoderConfig.EncodeLevel = zapcore.CapitalLevelEncoder
+	encoderConfig.EncodeDuration = zapcore.StringDurationEncoder
+	encoderConfig.FunctionKey = "func"
+	encoderConfig.MessageKey = "msg"
+	encoderConfig.StacktraceKey = "stacktrace"
+
+	// Choose encoder based on configuration
+	switch name {
+	case EncoderConsole:
+		// Console encoder for human-readable output
+		encoderConfig.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		return zapcore.NewConsoleEncoder(encoderConfig)
+	case EncoderJSON:
+		fallthrough
+	default:
+		// JSON encoder for structured logging (default)
+		return zapcore.NewJSONEncoder(encoderConfig)
 	}
 }
 
@@ -261,6 +288,35 @@ func (l *zapLogger) Errort(msg string, args T) {
 	l.z().Errorw(msg, l.keysAndValues(args)...)
 }
 
+// logCaller logs a message with structured fields at lvl, reporting caller
+// instead of the actual call site when caller information is enabled.
+func (l *zapLogger) logCaller(lvl Level, msg string, args T, caller Caller) {
+	zlvl, ok := zapLevel[lvl]
+	if !ok {
+		return
+	}
+
+	// The actual call site is of no use, so don't look it up
+	ce := l.z().Desugar().WithOptions(zap.WithCaller(false)).Check(zlvl, msg)
+	if ce == nil {
+		return
+	}
+	if l.caller {
+		ce.Caller = zapcore.EntryCaller{
+			Defined:  caller.Defined,
+			File:     caller.File,
+			Line:     caller.Line,
+			Function: caller.Function,
+		}
+	}
+
+	fields := make([]zap.Field, 0, len(args))
+	for k, v := range args {
+		fields = append(fields, zap.Any(k, v))
+	}
+	ce.Write(fields...)
+}
+
 // keysAndValues converts a T (map[string]any) to a slice of alternating
 // keys and values that Zap's structured logging methods expect.
 // This method is optimized for performance and minimal allocations.
@@ -513,3 +513,66 @@ func TestZapLevelMapping(t *testing.T) {
 		assert.NotNil(t, zapLvl)
 	}
 }
+
+// TestZapDriverOutputs tests per-output level thresholds and encoders
+func TestZapDriverOutputs(t *testing.T) {
+	t.Run("LevelsAndEncoders", func(t *testing.T) {
+		var console, jsonBuf bytes.Buffer
+		logger := NewLogger(
+			WithOutput(&console, DebugLevel, EncoderConsole),
+			WithOutput(&jsonBuf, WarnLevel, EncoderJSON),
+		)
+
+		logger.Debug("debug message")
+		logger.Warnt("warn message", T{"key": "value"})
+
+		assert.Contains(t, console.String(), "debug message")
+		assert.Contains(t, console.String(), "warn message")
+		assert.NotContains(t, console.String(), `"msg"`)
+
+		assert.NotContains(t, jsonBuf.String(), "debug message")
+		assert.Contains(t, jsonBuf.String(), `"msg":"warn message"`)
+		assert.Contains(t, jsonBuf.String(), `"key":"value"`)
+	})
+
+	t.Run("WithWriter", func(t *testing.T) {
+		var all, errs bytes.Buffer
+		logger := NewLogger(
+			WithLevel(InfoLevel),
+			WithWriter(&all),
+			WithOutput(&errs, ErrorLevel, EncoderJSON),
+		)
+
+		logger.Debug("filtered")
+		logger.Info("info message")
+		logger.Error("error message")
+
+		assert.NotContains(t, all.String(), "filtered")
+		assert.Contains(t, all.String(), "info message")
+		assert.Contains(t, all.String(), "error message")
+		assert.NotContains(t, errs.String(), "info message")
+		assert.Contains(t, errs.String(), "error message")
+	})
+
+	t.Run("GlobalLevelApplies", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger := NewLogger(
+			WithLevel(WarnLevel),
+			WithOutput(&buf, DebugLevel, EncoderJSON),
+		)
+
+		logger.Info("filtered")
+		logger.Warn("kept")
+
+		assert.NotContains(t, buf.String(), "filtered")
+		assert.Contains(t, buf.String(), "kept")
+	})
+
+	t.Run("DisabledOutput", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger := NewLogger(WithOutput(&buf, NoneLevel, EncoderJSON))
+
+		logger.Error("dropped")
+		assert.Empty(t, buf.String())
+	})
+}